@@ -4,18 +4,34 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/app"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/auth"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/graph"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/hunting"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/ingestion"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/query"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
 )
 
+// Router serves the REST API. Requests act as the user of their bearer
+// token, in the tenant resolved by auth.Middleware.Tenant, so tenant-scoped
+// data is read from storage directly rather than through the desktop
+// session of app.App.
 type Router struct {
-	app *app.App
-	mw  *auth.Middleware
+	app     *app.App
+	mw      *auth.Middleware
+	tail    *ingestion.Manager
+	store   *storage.Engine
+	hunting *hunting.Manager
+	graph   *graph.Manager
 }
 
-func NewRouter(a *app.App, mw *auth.Middleware) *Router {
-	return &Router{app: a, mw: mw}
+func NewRouter(a *app.App, mw *auth.Middleware, tail *ingestion.Manager, store *storage.Engine, hunt *hunting.Manager) *Router {
+	return &Router{app: a, mw: mw, tail: tail, store: store, hunting: hunt, graph: graph.NewManager()}
 }
 
 func (api *Router) Register(mux *http.ServeMux) {
@@ -26,12 +42,27 @@ func (api *Router) Register(mux *http.ServeMux) {
 	mux.Handle("/api/v1/compliance", api.mw.RequireAuth(api.mw.RequirePermission("rules:read", http.HandlerFunc(api.handleCompliance))))
 	mux.Handle("/api/v1/hunting/saved", api.mw.RequireAuth(api.mw.RequirePermission("logs:search", http.HandlerFunc(api.handleHuntingList))))
 	mux.Handle("/api/v1/graph", api.mw.RequireAuth(api.mw.RequirePermission("alerts:read", http.HandlerFunc(api.handleGraph))))
+	mux.Handle("/api/v1/livetail", tokenFromQuery(api.mw.RequireAuth(api.mw.RequirePermission("logs:search", api.liveTailHandler()))))
+}
+
+// tenant returns the tenant of r, or answers r with an error.
+func (api *Router) tenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tenant, err := api.mw.Tenant(r)
+	if err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return "", false
+	}
+	return tenant, true
 }
 
 func (api *Router) handleHuntingList(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := api.tenant(w, r)
+	if !ok {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
-		searches, err := api.app.ListSavedSearches()
+		searches, err := api.hunting.ListSearches(tenant)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -46,7 +77,12 @@ func (api *Router) handleHuntingList(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusBadRequest, "Invalid request body")
 			return
 		}
-		s, err := api.app.SaveSearch(req.Name, req.Query)
+		if _, err := query.Compile(req.Query, time.Now()); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		user, _ := r.Context().Value(auth.UserContextKey{}).(*sqlitestore.UserRecord)
+		s, err := api.hunting.SaveSearch(tenant, req.Name, req.Query, user.Username)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -63,14 +99,23 @@ func (api *Router) handleGraph(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tenant, ok := api.tenant(w, r)
+	if !ok {
+		return
+	}
 	alertID := r.URL.Query().Get("alert_id")
-	g, err := api.app.GetAlertGraph(alertID)
+	alert, err := api.store.SQLite.GetAlert(alertID)
+	if err != nil || models.TenantOrDefault(alert.Tenant) != tenant {
+		respondError(w, http.StatusNotFound, "alert "+alertID+" not found")
+		return
+	}
+	ev, err := api.store.GetEvent(alert.EventID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, err.Error())
+		respondError(w, http.StatusInternalServerError, "failed to retrieve trigger event: "+err.Error())
 		return
 	}
 
-	respondJSON(w, http.StatusOK, g)
+	respondJSON(w, http.StatusOK, api.graph.GenerateFromEvents([]*models.Event{ev}))
 }
 
 func (api *Router) handleCompliance(w http.ResponseWriter, r *http.Request) {
@@ -94,7 +139,11 @@ func (api *Router) handleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	counts, err := api.app.GetAlertCounts()
+	tenant, ok := api.tenant(w, r)
+	if !ok {
+		return
+	}
+	counts, err := api.store.SQLite.AlertCounts(tenant)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -109,13 +158,20 @@ func (api *Router) handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := r.URL.Query().Get("q")
+	tenant, ok := api.tenant(w, r)
+	if !ok {
+		return
+	}
 	// Using defaults for other filters in the REST API for now
-	severity := r.URL.Query().Get("severity")
-	source := r.URL.Query().Get("source")
-	host := r.URL.Query().Get("host")
-
-	results, err := api.app.SearchEvents(query, source, host, severity, 0, 0, 100)
+	params := r.URL.Query()
+	results, err := api.store.SearchEvents(r.Context(), &storage.SearchQuery{
+		Text:     params.Get("q"),
+		Source:   params.Get("source"),
+		Host:     params.Get("host"),
+		Severity: params.Get("severity"),
+		Tenant:   tenant,
+		Limit:    100,
+	})
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -125,10 +181,14 @@ func (api *Router) handleSearch(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *Router) handleCases(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := api.tenant(w, r)
+	if !ok {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		status := r.URL.Query().Get("status")
-		cases, err := api.app.ListCases(status, 100)
+		cases, err := api.store.SQLite.ListCases(tenant, status, 100)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -140,6 +200,10 @@ func (api *Router) handleCases(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *Router) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := api.tenant(w, r)
+	if !ok {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		limit := 100
@@ -148,7 +212,7 @@ func (api *Router) handleAlerts(w http.ResponseWriter, r *http.Request) {
 				limit = parsed
 			}
 		}
-		alerts, err := api.app.ListAlerts("", "", limit)
+		alerts, err := api.store.SQLite.ListAlerts(tenant, "", "", limit)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/auth"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/ingestion"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/query"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	liveTailInterval = 250 * time.Millisecond
+	liveTailBatch    = 500
+	liveTailWrite    = 10 * time.Second
+)
+
+// liveTailHandler streams events matching the "filter" query parameter over a
+// WebSocket as JSON ingestion.TailBatch messages. The subscription ends when
+// the client disconnects or stops reading.
+func (api *Router) liveTailHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := r.Context().Value(auth.UserContextKey{}).(*sqlitestore.UserRecord)
+		filter := r.URL.Query().Get("filter")
+		f, err := query.CompileFilter(filter)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		tenant, ok := api.tenant(w, r)
+		if !ok {
+			return
+		}
+		match := func(ev *models.Event) bool {
+			return models.TenantOrDefault(ev.Tenant) == tenant && f.Match(ev)
+		}
+		buffer, _ := strconv.Atoi(r.URL.Query().Get("buffer"))
+		sub, err := api.tail.Subscribe("api:"+user.Username, filter, match, buffer)
+		if err != nil {
+			respondError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		// The bearer token already authenticated the request, so any origin
+		// is accepted (websocket.Handler would reject clients without one).
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			defer api.tail.Unsubscribe(sub.ID)
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			// The client sends nothing; a failed read means it went away.
+			go func() {
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+				cancel()
+			}()
+
+			_ = sub.Batches(ctx, liveTailInterval, liveTailBatch, func(b ingestion.TailBatch) error {
+				ws.SetWriteDeadline(time.Now().Add(liveTailWrite))
+				return websocket.JSON.Send(ws, b)
+			})
+		}}.ServeHTTP(w, r)
+	})
+}
+
+// tokenFromQuery lets browsers, which cannot set headers on a WebSocket
+// handshake, pass the bearer token as the access_token query parameter.
+func tokenFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if tok := r.URL.Query().Get("access_token"); tok != "" {
+				r.Header.Set("Authorization", "Bearer "+tok)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
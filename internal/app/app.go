@@ -1,32 +1,50 @@
 package app
 
 import (
+	"bufio"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/agents"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/alerting"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/auth"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/compliance"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/dataset"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/deception"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/detection"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/detection/sigma"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/enrichment"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/fim"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/forensics"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/graph"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/hunting"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/ingestion"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/lookup"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/monitoring"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/netflow"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/pcap"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/query"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/ransomware"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/rbac"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/reports"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/response"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/archive"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App is the root application struct bound to the Wails runtime.
@@ -46,9 +64,12 @@ type App struct {
 	fim        *fim.Manager
 	auth       *auth.Manager
 	user       *sqlitestore.UserRecord
+	tenant     string // the session's active tenant; every query is scoped to it
 	reports    *reports.Manager
 	compliance *compliance.Manager
 	hunting    *hunting.Manager
+	scheduler  *hunting.Scheduler
+	lookups    *lookup.Manager
 	graph      *graph.Manager
 	monitoring *monitoring.Manager
 	netflow    *netflow.Collector
@@ -74,7 +95,11 @@ func (a *App) Startup(ctx context.Context) {
 		panic(fmt.Sprintf("OBLIVRA: storage open failed: %v", err))
 	}
 	a.storage = eng
-	a.storage.StartLifecycleManager(ctx, a.config.Storage.Retention)
+	a.storage.StartLifecycleManager(ctx, a.config.Storage)
+	a.storage.StartConsistencyScanner(ctx, a.config.Storage.Consistency)
+	if a.storage.IndexReset {
+		go a.runReindex()
+	}
 
 	// 2. Response (SOAR)
 	a.response = response.NewManager(a.storage.SQLite)
@@ -101,18 +126,37 @@ func (a *App) Startup(ctx context.Context) {
 
 	// 3. Alerting
 	a.alerting = alerting.NewManager(a.storage.SQLite, a.response)
+	a.storage.StartGuardian(ctx, a.config.Storage.Guardian, func(al *models.Alert) {
+		if err := a.alerting.HandleAlert(ctx, al); err != nil {
+			fmt.Printf("Warning: storage guardian alert: %v\n", err)
+		}
+	})
 
 	// 4. Compliance
 	a.compliance = compliance.NewManager()
 
 	// 5. Detection — seed built-in rules on first run, then load into engine
 	a.detection = detection.NewEngine(a.alerting.HandleAlert, a.compliance)
+	a.lookups = lookup.NewManager(a.storage.SQLite)
+	if err := a.lookups.Load(); err != nil {
+		fmt.Printf("Warning: Lookup tables failed to load: %v\n", err)
+	}
+	a.detection.SetLookups(a.lookups)
+	a.lookups.OnChange(func(name string) {
+		if err := a.detection.ReloadRules(a.storage.SQLite); err != nil {
+			fmt.Printf("Warning: Detection reload after lookup %s changed failed: %v\n", name, err)
+		}
+	})
 	if err := detection.SeedDefaultRules(a.storage.SQLite); err != nil {
 		fmt.Printf("Warning: Rule seeding failed: %v\n", err)
 	}
 	if err := a.detection.LoadRules(a.storage.SQLite); err != nil {
 		fmt.Printf("Warning: Detection Engine failed to load rules: %v\n", err)
 	}
+	if err := a.detection.PersistState(a.storage.SQLite); err != nil {
+		fmt.Printf("Warning: Detection state failed to restore: %v\n", err)
+	}
+	go a.detection.Run(ctx)
 
 	// 4. Enrichment
 	a.enrichment = enrichment.NewManager()
@@ -130,6 +174,9 @@ func (a *App) Startup(ctx context.Context) {
 
 	// 6. Forensics
 	a.forensics = forensics.NewManager(a.storage.SQLite)
+	if a.storage.Keyring != nil {
+		a.forensics.SetKeyWrapper(a.storage.Keyring)
+	}
 	if err := a.forensics.Start(ctx); err != nil {
 		fmt.Printf("Warning: Forensics Manager failed to start: %v\n", err)
 	}
@@ -162,6 +209,7 @@ func (a *App) Startup(ctx context.Context) {
 
 	// 8. FIM
 	fimMgr, err := fim.NewManager(func(ctx context.Context, ev *models.Event) error {
+		ev.SetRoute("fim")
 		a.ingestion.Ingest(ev)
 		return nil
 	})
@@ -185,11 +233,15 @@ func (a *App) Startup(ctx context.Context) {
 
 	// 12. Hunting & Investigation
 	a.hunting = hunting.NewManager(a.storage.SQLite)
+	a.scheduler = hunting.NewScheduler(a.hunting, a.storage, a.alerting.HandleAlert)
+	a.scheduler.SetLookups(a.lookups)
+	go a.scheduler.Start(ctx)
 	a.graph = graph.NewManager()
 
 	// 14. Network Analysis (Netflow) — runs its own UDP listener on port 2055.
 	// NOTE: netflow is NOT added to the ingestion pipeline; it calls ingestion.Ingest directly.
 	a.netflow = netflow.NewCollector(2055, func(c context.Context, ev *models.Event) error {
+		ev.SetRoute("netflow")
 		a.ingestion.Ingest(ev)
 		return nil
 	})
@@ -221,6 +273,11 @@ func (a *App) Shutdown(ctx context.Context) {
 	if a.ingestion != nil {
 		_ = a.ingestion.Stop()
 	}
+	if a.detection != nil {
+		if err := a.detection.Checkpoint(); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
 	if a.storage != nil {
 		_ = a.storage.Close()
 	}
@@ -242,13 +299,20 @@ func (a *App) Login(username, password string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	a.user = u
+	tenants, err := a.rbac.Tenants(u.ID)
+	if err != nil {
+		return false, err
+	}
+	a.user, a.tenant = u, tenants[0]
 	return true, nil
 }
 
 // Logout clears the current session.
 func (a *App) Logout() {
-	a.user = nil
+	if a.user != nil {
+		a.stopLiveTails(a.user.Username)
+	}
+	a.user, a.tenant = nil, ""
 }
 
 // GetCurrentUser returns the logged-in user details.
@@ -301,6 +365,144 @@ func (a *App) checkAuth(requiredRoles ...string) error {
 	return fmt.Errorf("permission denied: required roles: %v", requiredRoles)
 }
 
+// ─── TENANTS ──────────────────────────────────────────────────────────────────
+
+// ListTenants returns the tenants the user can switch to: every tenant for
+// holders of admin:system, otherwise those the user is a member of.
+func (a *App) ListTenants() ([]*sqlitestore.TenantRecord, error) {
+	if a.user == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+	all, err := a.storage.SQLite.ListTenants()
+	if err != nil {
+		return nil, err
+	}
+	var out []*sqlitestore.TenantRecord
+	for _, t := range all {
+		if ok, err := a.rbac.CanAccessTenant(a.ctx, a.user.ID, t.ID); err != nil {
+			return nil, err
+		} else if ok {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// GetActiveTenant returns the tenant the session's queries are scoped to.
+func (a *App) GetActiveTenant() (string, error) {
+	if a.user == nil {
+		return "", fmt.Errorf("authentication required")
+	}
+	return a.tenant, nil
+}
+
+// SetActiveTenant switches the session to another tenant the user may
+// access. Live tails of the previous tenant are stopped.
+func (a *App) SetActiveTenant(id string) error {
+	if a.user == nil {
+		return fmt.Errorf("authentication required")
+	}
+	if err := a.checkTenant(id); err != nil {
+		return err
+	}
+	if id != a.tenant {
+		a.stopLiveTails(a.user.Username)
+	}
+	a.tenant = id
+	return nil
+}
+
+// CreateTenant creates a tenant, or renames an existing one.
+func (a *App) CreateTenant(id, name string) (*sqlitestore.TenantRecord, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	if id == "" || strings.ContainsAny(id, " /\\") {
+		return nil, fmt.Errorf("invalid tenant ID %q", id)
+	}
+	if name == "" {
+		name = id
+	}
+	t := &sqlitestore.TenantRecord{ID: id, Name: name, CreatedAt: time.Now()}
+	if err := a.storage.SQLite.UpsertTenant(t); err != nil {
+		return nil, err
+	}
+	a.auditTenant("tenant_created", id, name)
+	return t, nil
+}
+
+// AddUserToTenant makes a user a member of a tenant.
+func (a *App) AddUserToTenant(username, tenant string) error {
+	return a.setTenantMember(username, tenant, true)
+}
+
+// RemoveUserFromTenant ends a user's membership of a tenant. A user left
+// without any membership falls back to the default tenant.
+func (a *App) RemoveUserFromTenant(username, tenant string) error {
+	return a.setTenantMember(username, tenant, false)
+}
+
+func (a *App) setTenantMember(username, tenant string, add bool) error {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return err
+	}
+	u, err := a.storage.SQLite.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return fmt.Errorf("user %s not found", username)
+	}
+	if t, err := a.storage.SQLite.GetTenant(tenant); err != nil {
+		return err
+	} else if t == nil {
+		return fmt.Errorf("tenant %s not found", tenant)
+	}
+	action := "tenant_member_added"
+	if add {
+		err = a.storage.SQLite.AddUserTenant(u.ID, tenant)
+	} else {
+		action = "tenant_member_removed"
+		err = a.storage.SQLite.RemoveUserTenant(u.ID, tenant)
+	}
+	if err != nil {
+		return err
+	}
+	a.rbac.ClearCache(u.ID)
+	a.auditTenant(action, tenant, username)
+	return nil
+}
+
+// checkTenant fails unless the user may access tenant.
+func (a *App) checkTenant(tenant string) error {
+	ok, err := a.rbac.CanAccessTenant(a.ctx, a.user.ID, tenant)
+	if err != nil {
+		return fmt.Errorf("rbac error: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("permission denied: no access to tenant %q", tenant)
+	}
+	return nil
+}
+
+// inTenant reports whether a record's tenant is the active one. Records of
+// other tenants are reported as not found by the callers.
+func (a *App) inTenant(tenant string) bool {
+	return models.TenantOrDefault(tenant) == a.tenant
+}
+
+func (a *App) auditTenant(action, id, details string) {
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     action,
+		TargetType: "tenant",
+		TargetID:   id,
+		Details:    details,
+		Timestamp:  time.Now(),
+	})
+}
+
 // ─── CONFIG ───────────────────────────────────────────────────────────────────
 
 // GetConfig returns the current configuration to the frontend.
@@ -316,17 +518,174 @@ func (a *App) SearchEvents(text, source, host, severity string, startNano, endNa
 	if a.storage == nil {
 		return nil, fmt.Errorf("storage not initialised")
 	}
+	if a.user == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
 	return a.storage.SearchEvents(a.ctx, &storage.SearchQuery{
 		Text:      text,
 		Source:    source,
 		Host:      host,
 		Severity:  severity,
+		Tenant:    a.tenant,
 		StartTime: startNano,
 		EndTime:   endNano,
 		Limit:     limit,
 	})
 }
 
+// SearchEventsPage runs a paginated, sorted search for the Log Explorer.
+// Pass the returned Next cursor back in q.After to fetch the following page.
+func (a *App) SearchEventsPage(q storage.SearchQuery) (*storage.SearchResult, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	q.Tenant = a.tenant
+	return a.storage.SearchEventsPage(a.ctx, &q)
+}
+
+// ExportSearchResults writes every event matching q to path as JSON lines
+// and returns the number of events written.
+func (a *App) ExportSearchResults(q storage.SearchQuery, path string) (int, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return 0, err
+	}
+	q.Tenant = a.tenant
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("export: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	n := 0
+	err = a.storage.ExportEvents(a.ctx, &q, func(ev *models.Event) error {
+		n++
+		return enc.Encode(ev)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "search_exported",
+		TargetType: "file",
+		TargetID:   path,
+		Details:    fmt.Sprintf("%d events", n),
+		Timestamp:  time.Now(),
+	})
+	return n, nil
+}
+
+// ExportDataset writes the events matching opts.Query to dir as NDJSON or
+// Parquet files with a manifest signed by the forensics key.
+func (a *App) ExportDataset(opts dataset.ExportOptions, dir string) (*dataset.Manifest, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	opts.CreatedBy = a.user.Username
+	opts.Query.Tenant = a.tenant
+	mf, err := dataset.Export(a.ctx, a.storage, dir, opts, a.forensics)
+	if err != nil {
+		return nil, err
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "dataset_exported",
+		TargetType: "file",
+		TargetID:   dir,
+		Details:    fmt.Sprintf("export %s: %d events in %d %s files", mf.ID, mf.Events, len(mf.Files), mf.Format),
+		Timestamp:  time.Now(),
+	})
+	return mf, nil
+}
+
+// VerifyDataset checks the signature and file hashes of the export in dir.
+// trustedKey, the hex public key of the exporting site, may be empty.
+func (a *App) VerifyDataset(dir, trustedKey string) (*dataset.Manifest, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	return dataset.Verify(dir, trustedKey)
+}
+
+// ImportDataset verifies the export in dir and writes its events to storage
+// under tenant (the active tenant if empty) without running detection on
+// them.
+func (a *App) ImportDataset(dir, trustedKey, tenant string) (*dataset.ImportResult, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	if tenant == "" {
+		tenant = a.tenant
+	}
+	if err := a.checkTenant(tenant); err != nil {
+		return nil, err
+	}
+	res, err := dataset.Import(a.ctx, a.storage, dir, trustedKey, tenant)
+	if res != nil && res.Manifest != nil {
+		details := fmt.Sprintf("export %s signed by %s into tenant %s: %d imported, %d skipped",
+			res.Manifest.ID, res.Manifest.PublicKey, res.Tenant, res.Imported, res.Skipped)
+		if err != nil {
+			details += "; failed: " + err.Error()
+		}
+		_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+			ID:         uuid.NewString(),
+			UserID:     a.user.Username,
+			Action:     "dataset_imported",
+			TargetType: "file",
+			TargetID:   dir,
+			Details:    details,
+			Timestamp:  time.Now(),
+		})
+	}
+	return res, err
+}
+
+// AggregateEvents computes facets, histograms and statistics over the events
+// matching q. Used by the search page sidebar and charts.
+func (a *App) AggregateEvents(q storage.SearchQuery, req storage.AggRequest) (*storage.AggResult, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	q.Tenant = a.tenant
+	return a.storage.Aggregate(a.ctx, &q, &req)
+}
+
+// GetEventOverview returns the dashboard's event volume histogram and top
+// hosts, sources and users for [startNano, endNano].
+func (a *App) GetEventOverview(startNano, endNano int64) (*storage.AggResult, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	return a.storage.Aggregate(a.ctx, &storage.SearchQuery{Tenant: a.tenant, StartTime: startNano, EndTime: endNano}, &storage.AggRequest{
+		Terms: []storage.TermsAgg{
+			{Field: "host", Size: 10},
+			{Field: "source", Size: 10},
+			{Field: "user", Size: 10},
+		},
+		Histogram:   &storage.HistogramAgg{},
+		Cardinality: []string{"host", "user"},
+	})
+}
+
+// RunQuery executes a piped query such as
+// `source=sshd "Failed password" | stats count by host | sort -count`.
+// Syntax errors are returned as *query.Error with the failing position.
+func (a *App) RunQuery(q string) (*query.Result, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	return query.Run(a.ctx, a.storage, q, query.Options{Lookups: a.lookups, Tenant: a.tenant})
+}
+
 // GetStorageStats returns on-disk size metrics for the System settings page.
 func (a *App) GetStorageStats() storage.StorageStats {
 	if a.storage == nil {
@@ -335,14 +694,358 @@ func (a *App) GetStorageStats() storage.StorageStats {
 	return a.storage.Stats()
 }
 
+// GetStorageGuardianStatus returns the disk pressure level, headroom and
+// spool usage from the storage guardian's last check.
+func (a *App) GetStorageGuardianStatus() (storage.GuardianStatus, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return storage.GuardianStatus{}, err
+	}
+	return a.storage.GuardianStatus(), nil
+}
+
+// ListArchiveSegments returns the sealed warm and cold archive segments.
+func (a *App) ListArchiveSegments() ([]archive.Segment, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	return a.storage.Archive.List(), nil
+}
+
+// VerifyArchiveSegment checks an archive segment against its manifest.
+func (a *App) VerifyArchiveSegment(id string) error {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return err
+	}
+	return a.storage.Archive.Verify(id)
+}
+
+// ─── LEGAL HOLDS ──────────────────────────────────────────────────────────────
+
+// ListLegalHolds returns the legal holds, including released ones if all
+// is set.
+func (a *App) ListLegalHolds(all bool) ([]*sqlitestore.LegalHoldRecord, error) {
+	if err := a.checkPermission("cases:read"); err != nil {
+		return nil, err
+	}
+	return a.storage.SQLite.ListLegalHolds(all)
+}
+
+// PlaceLegalHold protects events from retention until the hold is
+// released: those linked to caseID (its evidence and alerts) and/or every
+// event in [startNano, endNano], where 0 leaves that side open.
+func (a *App) PlaceLegalHold(name, caseID string, startNano, endNano int64, reason string) (*sqlitestore.LegalHoldRecord, error) {
+	if err := a.checkPermission("cases:write"); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("legal hold needs a name")
+	}
+	if caseID == "" && startNano == 0 && endNano == 0 {
+		return nil, fmt.Errorf("legal hold needs a case or a time range")
+	}
+	if startNano != 0 && endNano != 0 && startNano > endNano {
+		return nil, fmt.Errorf("legal hold starts after it ends")
+	}
+	if caseID != "" {
+		c, err := a.storage.SQLite.GetCase(caseID)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil || !a.inTenant(c.Tenant) {
+			return nil, fmt.Errorf("case %s not found", caseID)
+		}
+	}
+	h := &sqlitestore.LegalHoldRecord{
+		ID:        uuid.NewString(),
+		Name:      name,
+		CaseID:    caseID,
+		Reason:    reason,
+		CreatedBy: a.user.Username,
+		CreatedAt: time.Now(),
+	}
+	if startNano != 0 {
+		h.Start = time.Unix(0, startNano).Truncate(time.Second)
+	}
+	if endNano != 0 {
+		// Stored in seconds; round up so the whole range stays covered.
+		h.End = time.Unix(0, endNano).Add(time.Second - 1).Truncate(time.Second)
+	}
+	if err := a.storage.SQLite.InsertLegalHold(h); err != nil {
+		return nil, err
+	}
+	a.auditLegalHold("legal_hold_placed", h.ID, fmt.Sprintf("%s case=%q start=%s end=%s: %s",
+		name, caseID, h.Start.Format(time.RFC3339), h.End.Format(time.RFC3339), reason))
+	return h, nil
+}
+
+// ReleaseLegalHold ends a hold; its events become subject to retention
+// again on the next lifecycle run.
+func (a *App) ReleaseLegalHold(id string) error {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return err
+	}
+	if err := a.storage.SQLite.ReleaseLegalHold(id, a.user.Username, time.Now()); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no active legal hold %s", id)
+	} else if err != nil {
+		return err
+	}
+	a.auditLegalHold("legal_hold_released", id, "")
+	return nil
+}
+
+func (a *App) auditLegalHold(action, id, details string) {
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     action,
+		TargetType: "legal_hold",
+		TargetID:   id,
+		Details:    details,
+		Timestamp:  time.Now(),
+	})
+}
+
+// ─── ENCRYPTION AT REST ───────────────────────────────────────────────────────
+
+const minPassphraseLen = 12
+
+// ChangeEncryptionPassphrase re-seals the storage master key under a new
+// passphrase. Data is not re-encrypted; the passphrase variable must hold
+// the new value before the next start.
+func (a *App) ChangeEncryptionPassphrase(passphrase string) error {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return err
+	}
+	if a.storage.Keyring == nil {
+		return fmt.Errorf("encryption at rest is disabled")
+	}
+	if a.config.Storage.Encryption.KeyFile != "" {
+		return fmt.Errorf("the master key is unlocked with a key file")
+	}
+	if len(passphrase) < minPassphraseLen {
+		return fmt.Errorf("passphrase must be at least %d characters", minPassphraseLen)
+	}
+	if err := a.storage.Keyring.Rewrap(keyring.Secret{Passphrase: passphrase}); err != nil {
+		return err
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "encryption_passphrase_changed",
+		TargetType: "storage",
+		TargetID:   "master_key",
+		Timestamp:  time.Now(),
+	})
+	return nil
+}
+
+// ─── BACKUP & RESTORE ─────────────────────────────────────────────────────────
+
+// backupDir returns the configured backup directory.
+func (a *App) backupDir() string {
+	if a.config.Storage.BackupPath != "" {
+		return a.config.Storage.BackupPath
+	}
+	return filepath.Join(a.config.Storage.BasePath, "backups")
+}
+
+// CreateBackup takes an online backup of the whole data store, pausing
+// ingestion while the stores are captured. sinceBlock 0 takes a full
+// backup; otherwise the backup is incremental on the earlier backup that
+// ends at that integrity block.
+func (a *App) CreateBackup(sinceBlock int64) (*storage.BackupManifest, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	mf, err := a.storage.Backup(a.ctx, storage.BackupOptions{
+		Dir:        a.backupDir(),
+		SinceBlock: sinceBlock,
+		Pause:      a.ingestion.Pause,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "backup_created",
+		TargetType: "backup",
+		TargetID:   mf.ID,
+		Details:    fmt.Sprintf("parent=%s last_block=%d files=%d", mf.Parent, mf.LastBlock, len(mf.Files)),
+		Timestamp:  time.Now(),
+	})
+	return mf, nil
+}
+
+// ListBackups returns the backups in the backup directory, oldest first.
+func (a *App) ListBackups() ([]*storage.BackupManifest, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	return storage.ListBackups(a.backupDir())
+}
+
+// ScheduleRestore verifies a backup and its ancestors and restores it on
+// the next start, before the engines are opened. The current data is kept
+// next to the restored directories.
+func (a *App) ScheduleRestore(id string) (*storage.BackupManifest, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	if id == "" || filepath.Base(id) != id {
+		return nil, fmt.Errorf("invalid backup id %q", id)
+	}
+	mf, err := storage.ScheduleRestore(a.config, filepath.Join(a.backupDir(), id))
+	if err != nil {
+		return nil, err
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "restore_scheduled",
+		TargetType: "backup",
+		TargetID:   mf.ID,
+		Timestamp:  time.Now(),
+	})
+	return mf, nil
+}
+
+// ─── INDEX CONSISTENCY ────────────────────────────────────────────────────────
+
+// CheckIndexConsistency compares the hot events in BadgerDB with the search
+// index over the last hours and, with repair set, indexes the events
+// missing from it. Orphaned index documents are reported.
+func (a *App) CheckIndexConsistency(hours int, repair bool) (*storage.ConsistencyReport, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	if hours <= 0 {
+		return nil, fmt.Errorf("hours must be positive")
+	}
+	end := time.Now()
+	return a.storage.CheckConsistency(a.ctx, end.Add(-time.Duration(hours)*time.Hour), end, repair)
+}
+
+// StartReindex rebuilds the search index from BadgerDB in the background.
+// Progress is emitted as the Wails event "storage:reindex" and is also
+// available from ReindexStatus.
+func (a *App) StartReindex() error {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return err
+	}
+	if a.storage.ReindexStatus().Running {
+		return storage.ErrReindexRunning
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "reindex_started",
+		TargetType: "storage",
+		TargetID:   "bluge",
+		Timestamp:  time.Now(),
+	})
+	go a.runReindex()
+	return nil
+}
+
+// ReindexStatus returns the progress of the running or last reindex.
+func (a *App) ReindexStatus() (storage.ReindexProgress, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return storage.ReindexProgress{}, err
+	}
+	return a.storage.ReindexStatus(), nil
+}
+
+func (a *App) runReindex() {
+	rep, err := a.storage.Reindex(a.ctx, func(p storage.ReindexProgress) {
+		wailsruntime.EventsEmit(a.ctx, "storage:reindex", p)
+	})
+	if err != nil {
+		fmt.Printf("Warning: Reindex failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Reindex done: %d events indexed, %d orphaned documents removed\n", rep.Repaired, rep.Removed)
+}
+
+// ─── LIVE TAIL ────────────────────────────────────────────────────────────────
+
+const (
+	liveTailInterval = 250 * time.Millisecond
+	liveTailBatch    = 500
+)
+
+// StartLiveTail subscribes the session to events matching filter (search
+// syntax: the search clause plus where commands). Batches are emitted as
+// the Wails event "livetail:<id>" until StopLiveTail or logout; the id is
+// returned.
+func (a *App) StartLiveTail(filter string) (string, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return "", err
+	}
+	f, err := query.CompileFilter(filter)
+	if err != nil {
+		return "", err
+	}
+	tenant := a.tenant
+	match := func(ev *models.Event) bool {
+		return models.TenantOrDefault(ev.Tenant) == tenant && f.Match(ev)
+	}
+	sub, err := a.ingestion.Subscribe(a.user.Username, filter, match, 0)
+	if err != nil {
+		return "", err
+	}
+
+	// Unsubscribe closes the channel, which ends the loop with a final
+	// closed batch.
+	go func() {
+		defer a.ingestion.Unsubscribe(sub.ID)
+		_ = sub.Batches(a.ctx, liveTailInterval, liveTailBatch, func(b ingestion.TailBatch) error {
+			wailsruntime.EventsEmit(a.ctx, "livetail:"+sub.ID, b)
+			return nil
+		})
+	}()
+	return sub.ID, nil
+}
+
+// StopLiveTail ends a live tail the session started with StartLiveTail.
+func (a *App) StopLiveTail(id string) error {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return err
+	}
+	if !a.ingestion.UnsubscribeOwned(id, a.user.Username) {
+		return fmt.Errorf("live tail %s not found", id)
+	}
+	return nil
+}
+
+// ListLiveTails returns every active subscription with its delivery and drop
+// counters.
+func (a *App) ListLiveTails() ([]ingestion.SubscriptionStats, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	return a.ingestion.Subscriptions(), nil
+}
+
+// stopLiveTails ends every live tail owned by user.
+func (a *App) stopLiveTails(user string) {
+	if a.ingestion != nil {
+		a.ingestion.UnsubscribeOwner(user)
+	}
+}
+
 // ─── ALERTS ───────────────────────────────────────────────────────────────────
 
-// ListAlerts returns alerts from SQLite, optionally filtered.
+// ListAlerts returns the active tenant's alerts from SQLite, optionally
+// filtered.
 func (a *App) ListAlerts(status, severity string, limit int) ([]*models.Alert, error) {
 	if a.storage == nil {
 		return nil, fmt.Errorf("storage not initialised")
 	}
-	return a.storage.SQLite.ListAlerts(status, severity, limit)
+	if a.user == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+	return a.storage.SQLite.ListAlerts(a.tenant, status, severity, limit)
 }
 
 // UpdateAlertStatus changes an alert's status and assignee.
@@ -353,6 +1056,9 @@ func (a *App) UpdateAlertStatus(id, status, assignee string) error {
 	if a.storage == nil {
 		return fmt.Errorf("storage not initialised")
 	}
+	if al, err := a.storage.SQLite.GetAlert(id); err != nil || !a.inTenant(al.Tenant) {
+		return fmt.Errorf("alert %s not found", id)
+	}
 	return a.storage.SQLite.UpdateAlertStatus(id, status, assignee)
 }
 
@@ -361,17 +1067,23 @@ func (a *App) GetAlertCounts() (map[string]int, error) {
 	if a.storage == nil {
 		return nil, fmt.Errorf("storage not initialised")
 	}
-	return a.storage.SQLite.AlertCounts()
+	if a.user == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+	return a.storage.SQLite.AlertCounts(a.tenant)
 }
 
 // ─── CASES ───────────────────────────────────────────────────────────────────
 
-// ListCases returns cases from SQLite.
+// ListCases returns the active tenant's cases from SQLite.
 func (a *App) ListCases(status string, limit int) ([]*sqlitestore.CaseRecord, error) {
 	if a.storage == nil {
 		return nil, fmt.Errorf("storage not initialised")
 	}
-	return a.storage.SQLite.ListCases(status, limit)
+	if a.user == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+	return a.storage.SQLite.ListCases(a.tenant, status, limit)
 }
 
 // UpdateCaseStatus resolves or closes a case.
@@ -382,17 +1094,35 @@ func (a *App) UpdateCaseStatus(id, status string) error {
 	if a.storage == nil {
 		return fmt.Errorf("storage not initialised")
 	}
+	if _, err := a.tenantCase(id); err != nil {
+		return err
+	}
 	return a.storage.SQLite.UpdateCaseStatus(id, status)
 }
 
+// tenantCase returns a case of the active tenant.
+func (a *App) tenantCase(id string) (*sqlitestore.CaseRecord, error) {
+	c, err := a.storage.SQLite.GetCase(id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || !a.inTenant(c.Tenant) {
+		return nil, fmt.Errorf("case %s not found", id)
+	}
+	return c, nil
+}
+
 // ─── ASSETS ───────────────────────────────────────────────────────────────────
 
-// ListAssets returns assets from SQLite.
+// ListAssets returns the active tenant's assets and the global ones.
 func (a *App) ListAssets(limit int) ([]*sqlitestore.AssetRecord, error) {
 	if a.storage == nil {
 		return nil, fmt.Errorf("storage not initialised")
 	}
-	return a.storage.SQLite.ListAssets(limit)
+	if a.user == nil {
+		return nil, fmt.Errorf("authentication required")
+	}
+	return a.storage.SQLite.ListAssets(a.tenant, limit)
 }
 
 // ─── AGENTS ───────────────────────────────────────────────────────────────────
@@ -410,7 +1140,8 @@ func (a *App) ListAgents() ([]*sqlitestore.AgentRecord, error) {
 
 // ─── RULES ───────────────────────────────────────────────────────────────────
 
-// ListRules returns detection rules from SQLite.
+// ListRules returns the global detection rules and those of the active
+// tenant.
 func (a *App) ListRules(enabledOnly bool) ([]*sqlitestore.RuleRecord, error) {
 	if err := a.checkPermission("rules:read"); err != nil {
 		return nil, err
@@ -418,7 +1149,88 @@ func (a *App) ListRules(enabledOnly bool) ([]*sqlitestore.RuleRecord, error) {
 	if a.storage == nil {
 		return nil, fmt.Errorf("storage not initialised")
 	}
-	return a.storage.SQLite.ListRules(enabledOnly)
+	rules, err := a.storage.SQLite.ListRules(enabledOnly)
+	if err != nil {
+		return nil, err
+	}
+	out := rules[:0]
+	for _, r := range rules {
+		if r.Tenant == "" || r.Tenant == a.tenant {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// ImportSigmaRules converts the Sigma rules of a YAML file, or of every
+// .yml and .yaml file under a directory, and stores those that convert.
+// Rules imported by holders of admin:system are global; anyone else's
+// belong to the active tenant. The reports cover every rule found.
+func (a *App) ImportSigmaRules(path string) ([]*sigma.Report, error) {
+	if err := a.checkPermission("rules:write"); err != nil {
+		return nil, err
+	}
+	var files []string
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(p))
+		if !d.IsDir() && (p == path || ext == ".yml" || ext == ".yaml") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sigma: %w", err)
+	}
+	tenant := a.tenant
+	if a.checkPermission("admin:system") == nil {
+		tenant = ""
+	}
+
+	var reports []*sigma.Report
+	imported := 0
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return reports, fmt.Errorf("sigma: %w", err)
+		}
+		results, err := sigma.Convert(data, nil)
+		if err != nil {
+			reports = append(reports, &sigma.Report{File: f, Title: filepath.Base(f), Errors: []string{err.Error()}})
+		}
+		for _, r := range results {
+			r.Report.File = f
+			reports = append(reports, r.Report)
+			if r.Rule == nil {
+				continue
+			}
+			r.Rule.Tenant = tenant
+			if err := a.storage.SQLite.InsertRule(r.Rule); err != nil {
+				r.Report.Converted = false
+				r.Report.Errors = append(r.Report.Errors, fmt.Sprintf("store rule: %v", err))
+				continue
+			}
+			imported++
+		}
+	}
+
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "sigma_imported",
+		TargetType: "rule",
+		TargetID:   path,
+		Details:    fmt.Sprintf("%d of %d rules imported", imported, len(reports)),
+		Timestamp:  time.Now(),
+	})
+	if imported > 0 {
+		if err := a.detection.ReloadRules(a.storage.SQLite); err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
 }
 
 // ─── FORENSICS & REPORTING ───────────────────────────────────────────────────
@@ -429,23 +1241,65 @@ func (a *App) AddEvidence(caseID, eventID, reason string) error {
 		return err
 	}
 
+	if _, err := a.tenantCase(caseID); err != nil {
+		return err
+	}
+
 	// Retrieve raw event from Badger
-	ev, err := a.storage.Badger.GetEvent(eventID)
+	ev, err := a.storage.GetEvent(eventID)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve original event: %w", err)
 	}
-	if ev == nil {
+	if ev == nil || !a.inTenant(ev.Tenant) {
 		return fmt.Errorf("event %s not found in raw storage", eventID)
 	}
 
 	return a.forensics.CaptureEvidence(caseID, eventID, a.user.Username, reason, ev.Raw)
 }
 
+// ImportPCAP imports an offline packet capture (pcap or pcapng) into the
+// pipeline. Flows, DNS queries, HTTP requests and TLS ClientHellos are tagged
+// with caseID, and the file hash is written to the audit log.
+func (a *App) ImportPCAP(caseID, path string) (*pcap.Result, error) {
+	if err := a.checkPermission("cases:write"); err != nil {
+		return nil, err
+	}
+	c, err := a.tenantCase(caseID)
+	if err != nil {
+		return nil, err
+	}
+
+	ingest := func(ctx context.Context, ev *models.Event) error {
+		ev.SetRoute("pcap")
+		ev.Tenant = c.Tenant
+		return a.ingestion.IngestWait(ctx, ev)
+	}
+	res, err := pcap.NewImporter(ingest).Import(a.ctx, path, caseID)
+	if err != nil {
+		return res, fmt.Errorf("pcap import failed: %w", err)
+	}
+
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "pcap_imported",
+		TargetType: "case",
+		TargetID:   caseID,
+		Details: fmt.Sprintf("%s (%s) SHA-256: %s packets:%d flows:%d dns:%d http:%d tls:%d",
+			res.File, res.Format, res.SHA256, res.Packets, res.Flows, res.DNSEvents, res.HTTPEvents, res.TLSEvents),
+		Timestamp: time.Now(),
+	})
+	return res, nil
+}
+
 // GenerateReport returns a Markdown report for a case.
 func (a *App) GenerateReport(caseID string) (string, error) {
 	if err := a.checkPermission("cases:read"); err != nil {
 		return "", err
 	}
+	if _, err := a.tenantCase(caseID); err != nil {
+		return "", err
+	}
 	return a.reports.GenerateCaseReport(caseID)
 }
 
@@ -501,7 +1355,7 @@ func (a *App) ListFIMEvents(limit int) ([]*models.Alert, error) {
 		return nil, err
 	}
 	// FIM events emit as SIEM alerts with rule_id matching the FIM rule
-	alerts, err := a.storage.SQLite.ListAlerts("", "", limit)
+	alerts, err := a.storage.SQLite.ListAlerts(a.tenant, "", "", limit)
 	if err != nil {
 		return nil, err
 	}
@@ -516,15 +1370,27 @@ func (a *App) ListFIMEvents(limit int) ([]*models.Alert, error) {
 
 // ─── DECEPTION ───────────────────────────────────────────────────────────────
 
-// ListHoneytokens returns all active honeytokens.
+// ListHoneytokens returns the global honeytokens and those of the active
+// tenant.
 func (a *App) ListHoneytokens() ([]*models.Honeytoken, error) {
 	if err := a.checkPermission("admin:system"); err != nil {
 		return nil, err
 	}
-	return a.storage.SQLite.ListHoneytokens()
+	tokens, err := a.storage.SQLite.ListHoneytokens()
+	if err != nil {
+		return nil, err
+	}
+	out := tokens[:0]
+	for _, t := range tokens {
+		if t.Tenant == "" || t.Tenant == a.tenant {
+			out = append(out, t)
+		}
+	}
+	return out, nil
 }
 
-// AddHoneytoken creates a new honeytoken and hot-loads it into the deception engine.
+// AddHoneytoken creates a new honeytoken watching the active tenant's
+// events and hot-loads it into the deception engine.
 func (a *App) AddHoneytoken(tokenType, value, description string) error {
 	if err := a.checkPermission("admin:system"); err != nil {
 		return err
@@ -535,6 +1401,7 @@ func (a *App) AddHoneytoken(tokenType, value, description string) error {
 		Value:       value,
 		Description: description,
 		CreatedAt:   time.Now(),
+		Tenant:      a.tenant,
 	}
 	if err := a.storage.SQLite.InsertHoneytoken(token); err != nil {
 		return err
@@ -548,9 +1415,13 @@ func (a *App) AddHoneytoken(tokenType, value, description string) error {
 
 // DeleteHoneytoken removes a honeytoken and reloads the engine.
 func (a *App) DeleteHoneytoken(id string) error {
-	if err := a.checkPermission("admin:system"); err != nil {
+	tokens, err := a.ListHoneytokens()
+	if err != nil {
 		return err
 	}
+	if !slices.ContainsFunc(tokens, func(t *models.Honeytoken) bool { return t.ID == id }) {
+		return fmt.Errorf("honeytoken %s not found", id)
+	}
 	if err := a.storage.SQLite.DeleteHoneytoken(id); err != nil {
 		return err
 	}
@@ -565,7 +1436,7 @@ func (a *App) ListDeceptionAlerts(limit int) ([]*models.Alert, error) {
 	if err := a.checkPermission("alerts:read"); err != nil {
 		return nil, err
 	}
-	alerts, err := a.storage.SQLite.ListAlerts("", "CRITICAL", limit)
+	alerts, err := a.storage.SQLite.ListAlerts(a.tenant, "", "CRITICAL", limit)
 	if err != nil {
 		return nil, err
 	}
@@ -622,20 +1493,106 @@ func (a *App) GetComplianceCoverage() (map[string]int, error) {
 
 // ─── INVESTIGATION ────────────────────────────────────────────────────────────
 
-// ListSavedSearches returns all persisted hunting queries.
+// ListSavedSearches returns the active tenant's hunting queries.
 func (a *App) ListSavedSearches() ([]*models.SavedSearch, error) {
 	if err := a.checkPermission("logs:search"); err != nil {
 		return nil, err
 	}
-	return a.hunting.ListSearches()
+	return a.hunting.ListSearches(a.tenant)
 }
 
 // SaveSearch persists a new hunting query.
-func (a *App) SaveSearch(name, query string) (*models.SavedSearch, error) {
+func (a *App) SaveSearch(name, q string) (*models.SavedSearch, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	if _, err := query.Compile(q, time.Now()); err != nil {
+		return nil, err
+	}
+	return a.hunting.SaveSearch(a.tenant, name, q, a.user.Username)
+}
+
+// RunSavedSearch executes a saved search on demand.
+func (a *App) RunSavedSearch(id string) (*query.Result, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	s, err := a.tenantSearch(id)
+	if err != nil {
+		return nil, err
+	}
+	return query.Run(a.ctx, a.storage, s.Query, query.Options{Lookups: a.lookups, Tenant: a.tenant})
+}
+
+// tenantSearch returns a saved search of the active tenant.
+func (a *App) tenantSearch(id string) (*models.SavedSearch, error) {
+	s, err := a.hunting.GetSearch(id)
+	if err != nil {
+		return nil, err
+	}
+	if !a.inTenant(s.Tenant) {
+		return nil, fmt.Errorf("saved search %s not found", id)
+	}
+	return s, nil
+}
+
+// ScheduleSearch turns a saved search into a detection that runs on a
+// cron expression or interval and raises alerts when its trigger holds.
+func (a *App) ScheduleSearch(id string, sc models.SearchSchedule) (*models.SearchSchedule, error) {
+	if err := a.checkPermission("rules:write"); err != nil {
+		return nil, err
+	}
+	if _, err := a.tenantSearch(id); err != nil {
+		return nil, err
+	}
+	saved, err := a.hunting.SetSchedule(id, sc)
+	if err != nil {
+		return nil, err
+	}
+	details, _ := json.Marshal(saved)
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "search_scheduled",
+		TargetType: "saved_search",
+		TargetID:   id,
+		Details:    string(details),
+		Timestamp:  time.Now(),
+	})
+	return saved, nil
+}
+
+// UnscheduleSearch removes the schedule of a saved search.
+func (a *App) UnscheduleSearch(id string) error {
+	if err := a.checkPermission("rules:write"); err != nil {
+		return err
+	}
+	if _, err := a.tenantSearch(id); err != nil {
+		return err
+	}
+	if err := a.hunting.RemoveSchedule(id); err != nil {
+		return err
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "search_unscheduled",
+		TargetType: "saved_search",
+		TargetID:   id,
+		Timestamp:  time.Now(),
+	})
+	return nil
+}
+
+// ListSearchRuns returns the run history of a scheduled search.
+func (a *App) ListSearchRuns(id string, limit int) ([]*models.SearchRun, error) {
 	if err := a.checkPermission("logs:search"); err != nil {
 		return nil, err
 	}
-	return a.hunting.SaveSearch(name, query, a.user.Username)
+	if _, err := a.tenantSearch(id); err != nil {
+		return nil, err
+	}
+	return a.hunting.ListRuns(id, limit)
 }
 
 // GetAlertGraph generates a relationship graph for entities in an alert.
@@ -649,11 +1606,14 @@ func (a *App) GetAlertGraph(alertID string) (*graph.Graph, error) {
 	if err != nil {
 		return nil, err
 	}
+	if !a.inTenant(alert.Tenant) {
+		return nil, fmt.Errorf("alert %s not found", alertID)
+	}
 
 	// 2. Get related events (simplified: events from same rule/host around same time)
 	// In a real system, we'd use the event ID or search the indexer.
 	// For now, let's just use the single trigger event if possible.
-	triggerEvent, err := a.storage.Badger.GetEvent(alert.EventID)
+	triggerEvent, err := a.storage.GetEvent(alert.EventID)
 	if err != nil || triggerEvent == nil {
 		return nil, fmt.Errorf("failed to retrieve trigger event: %v", err)
 	}
@@ -661,6 +1621,147 @@ func (a *App) GetAlertGraph(alertID string) (*graph.Graph, error) {
 	return a.graph.GenerateFromEvents([]*models.Event{triggerEvent}), nil
 }
 
+// ─── LOOKUPS ──────────────────────────────────────────────────────────────────
+
+// ListLookups returns the global lookup tables and those of the active
+// tenant.
+func (a *App) ListLookups() ([]*lookup.Table, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	return a.lookups.List(a.tenant)
+}
+
+// ImportLookup loads a CSV file (header row first) as a new version of a
+// lookup table. keyColumn defaults to the first column. Tables imported by
+// holders of admin:system are global; anyone else's belong to the active
+// tenant. Detection rules are reloaded automatically.
+func (a *App) ImportLookup(name, description, keyColumn, path string) (*lookup.Table, error) {
+	if err := a.checkPermission("rules:write"); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("lookup: open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	tenant := a.tenant
+	if a.checkPermission("admin:system") == nil {
+		tenant = ""
+	}
+	t, err := a.lookups.Import(tenant, name, description, keyColumn, f, a.user.Username)
+	if err != nil {
+		return nil, err
+	}
+	a.auditLookup("lookup_imported", t, fmt.Sprintf("v%d from %s (%d rows)", t.Version, path, t.Rows))
+	return t, nil
+}
+
+// ExportLookup writes a version of a lookup table (0 for the current one) to
+// a CSV file.
+func (a *App) ExportLookup(name string, version int, path string) error {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return err
+	}
+	t, err := a.lookupTable(name, false)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("lookup: create %s: %w", path, err)
+	}
+	if err := a.lookups.Export(t.Tenant, t.Name, version, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.auditLookup("lookup_exported", t, path)
+	return nil
+}
+
+// ListLookupVersions returns the stored versions of a lookup table.
+func (a *App) ListLookupVersions(name string) ([]*lookup.Version, error) {
+	if err := a.checkPermission("logs:search"); err != nil {
+		return nil, err
+	}
+	t, err := a.lookupTable(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return a.lookups.Versions(t.Tenant, t.Name)
+}
+
+// RestoreLookupVersion makes an earlier version of a table current again.
+func (a *App) RestoreLookupVersion(name string, version int) (*lookup.Table, error) {
+	if err := a.checkPermission("rules:write"); err != nil {
+		return nil, err
+	}
+	t, err := a.lookupTable(name, true)
+	if err != nil {
+		return nil, err
+	}
+	t, err = a.lookups.Restore(t.Tenant, t.Name, version, a.user.Username)
+	if err != nil {
+		return nil, err
+	}
+	a.auditLookup("lookup_restored", t, fmt.Sprintf("v%d restored as v%d", version, t.Version))
+	return t, nil
+}
+
+// DeleteLookup removes a lookup table and its history.
+func (a *App) DeleteLookup(name string) error {
+	if err := a.checkPermission("rules:write"); err != nil {
+		return err
+	}
+	t, err := a.lookupTable(name, true)
+	if err != nil {
+		return err
+	}
+	if err := a.lookups.Delete(t.Tenant, t.Name); err != nil {
+		return err
+	}
+	a.auditLookup("lookup_deleted", t, "")
+	return nil
+}
+
+// lookupTable returns the table name as the active tenant sees it: its own,
+// or else the global one. Changing a global table takes admin:system.
+func (a *App) lookupTable(name string, write bool) (*lookup.Table, error) {
+	t, err := a.lookups.Get(a.tenant, name)
+	if err != nil {
+		return nil, err
+	}
+	if write && t.Tenant == "" {
+		if err := a.checkPermission("admin:system"); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (a *App) auditLookup(action string, t *lookup.Table, details string) {
+	scope := "global"
+	if t.Tenant != "" {
+		scope = "tenant " + t.Tenant
+	}
+	if details != "" {
+		scope += ": " + details
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     action,
+		TargetType: "lookup",
+		TargetID:   t.Name,
+		Details:    scope,
+		Timestamp:  time.Now(),
+	})
+}
+
 // ─── NOTIFICATIONS SETTINGS ─────────────────────────────────────────────────
 
 // GetNotificationSettings returns the current notification channel config.
@@ -697,11 +1798,18 @@ func (a *App) ToggleRule(id string, enabled bool) error {
 	if err := a.checkPermission("rules:write"); err != nil {
 		return err
 	}
+	rules, err := a.ListRules(false)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(rules, func(r *sqlitestore.RuleRecord) bool { return r.ID == id }) {
+		return fmt.Errorf("rule %s not found", id)
+	}
 	enabledInt := 0
 	if enabled {
 		enabledInt = 1
 	}
-	_, err := a.storage.SQLite.DB().Exec(
+	_, err = a.storage.SQLite.DB().Exec(
 		`UPDATE rules SET enabled=?, updated_at=? WHERE id=?`,
 		enabledInt, time.Now().Unix(), id,
 	)
@@ -712,6 +1820,42 @@ func (a *App) ToggleRule(id string, enabled bool) error {
 	return a.detection.ReloadRules(a.storage.SQLite)
 }
 
+// GetDetectionState lists the threshold counters, alert cooldowns and
+// partial sequence matches the detection engine holds for a rule, or for
+// every rule if ruleID is empty.
+func (a *App) GetDetectionState(ruleID string) ([]detection.StateEntry, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return nil, err
+	}
+	return a.detection.State(ruleID), nil
+}
+
+// ClearDetectionState drops a rule's detection state, so its thresholds
+// count from zero and its cooldowns lapse. It returns how many entries
+// were cleared.
+func (a *App) ClearDetectionState(ruleID string) (int, error) {
+	if err := a.checkPermission("admin:system"); err != nil {
+		return 0, err
+	}
+	if ruleID == "" {
+		return 0, fmt.Errorf("rule ID required")
+	}
+	n, err := a.detection.ClearState(ruleID)
+	if err != nil {
+		return n, err
+	}
+	_ = a.storage.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     a.user.Username,
+		Action:     "detection_state_cleared",
+		TargetType: "rule",
+		TargetID:   ruleID,
+		Details:    fmt.Sprintf("%d entries cleared", n),
+		Timestamp:  time.Now(),
+	})
+	return n, nil
+}
+
 // ─── FORENSICS ────────────────────────────────────────────────────────────────
 
 // GetForensicsPublicKey returns the Ed25519 public key used to sign integrity blocks.
@@ -51,6 +51,12 @@ func (m *Manager) Authenticate(username, password string) (*sqlitestore.UserReco
 
 // CreateToken generates a new API token for a user.
 func (m *Manager) CreateToken(userID string, duration time.Duration) (*sqlitestore.TokenRecord, error) {
+	return m.CreateTenantToken(userID, "", duration)
+}
+
+// CreateTenantToken generates a new API token whose ingested events belong
+// to tenant; with "" they are routed by sender address and listener.
+func (m *Manager) CreateTenantToken(userID, tenant string, duration time.Duration) (*sqlitestore.TokenRecord, error) {
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
 		return nil, err
@@ -63,6 +69,7 @@ func (m *Manager) CreateToken(userID string, duration time.Duration) (*sqlitesto
 		Token:     tokenStr,
 		ExpiresAt: time.Now().Add(duration),
 		CreatedAt: time.Now(),
+		Tenant:    tenant,
 	}
 
 	if err := m.store.InsertToken(t); err != nil {
@@ -74,19 +81,27 @@ func (m *Manager) CreateToken(userID string, duration time.Duration) (*sqlitesto
 
 // ValidateToken checks if a token is valid and returns the associated user.
 func (m *Manager) ValidateToken(tokenStr string) (*sqlitestore.UserRecord, error) {
+	u, _, err := m.ValidateTokenTenant(tokenStr)
+	return u, err
+}
+
+// ValidateTokenTenant is ValidateToken that also returns the tenant the
+// token's ingested events belong to.
+func (m *Manager) ValidateTokenTenant(tokenStr string) (*sqlitestore.UserRecord, string, error) {
 	t, err := m.store.GetToken(tokenStr)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if t == nil {
-		return nil, ErrUnauthorized
+		return nil, "", ErrUnauthorized
 	}
 
 	if time.Now().After(t.ExpiresAt) {
-		return nil, ErrExpired
+		return nil, "", ErrExpired
 	}
 
-	return m.store.GetUserByID(t.UserID)
+	u, err := m.store.GetUserByID(t.UserID)
+	return u, t.Tenant, err
 }
 
 // CreateUser is a helper to hash password and insert user.
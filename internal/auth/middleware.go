@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -67,6 +68,36 @@ func (mw *Middleware) RequireRole(role string, next http.Handler) http.Handler {
 	})
 }
 
+// ErrTenantDenied is returned by Tenant when the user is not a member of the
+// requested tenant.
+var ErrTenantDenied = errors.New("auth: no access to tenant")
+
+// Tenant returns the tenant an authenticated request works in: the
+// "tenant" query parameter, or else the user's first tenant. It fails
+// unless the user may access that tenant.
+func (mw *Middleware) Tenant(r *http.Request) (string, error) {
+	user, ok := r.Context().Value(UserContextKey{}).(*sqlitestore.UserRecord)
+	if !ok || user == nil {
+		return "", ErrUnauthorized
+	}
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		ids, err := mw.rbac.Tenants(user.ID)
+		if err != nil {
+			return "", err
+		}
+		tenant = ids[0]
+	}
+	allowed, err := mw.rbac.CanAccessTenant(r.Context(), user.ID, tenant)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return "", ErrTenantDenied
+	}
+	return tenant, nil
+}
+
 // RequirePermission enforces granular permissions.
 func (mw *Middleware) RequirePermission(perm string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -22,7 +22,7 @@ func TestCasesManager(t *testing.T) {
 	m := NewManager(store)
 
 	t.Run("Create and Retrieve Case", func(t *testing.T) {
-		caseRec, err := m.CreateCase("Malware Outbreak", "Infection detected on segment A", "high", "open", "analyst1")
+		caseRec, err := m.CreateCase("", "Malware Outbreak", "Infection detected on segment A", "high", "open", "analyst1")
 		if err != nil {
 			t.Fatalf("failed to create case: %v", err)
 		}
@@ -48,7 +48,7 @@ func TestCasesManager(t *testing.T) {
 	})
 
 	t.Run("Add Comments", func(t *testing.T) {
-		caseRec, _ := m.CreateCase("Test Comments", "", "low", "open", "")
+		caseRec, _ := m.CreateCase("", "Test Comments", "", "low", "open", "")
 
 		_, err := m.AddComment(caseRec.ID, "analyst1", "Analyzing traces...")
 		if err != nil {
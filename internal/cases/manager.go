@@ -22,9 +22,10 @@ func NewManager(store *sqlitestore.DB) *Manager {
 	}
 }
 
-// CreateCase initializes a new investigation.
-func (m *Manager) CreateCase(title, description, severity, status, assignee string) (*sqlitestore.CaseRecord, error) {
+// CreateCase initializes a new investigation owned by tenant.
+func (m *Manager) CreateCase(tenant, title, description, severity, status, assignee string) (*sqlitestore.CaseRecord, error) {
 	c := &sqlitestore.CaseRecord{
+		Tenant:      models.TenantOrDefault(tenant),
 		ID:          uuid.NewString(),
 		Title:       title,
 		Description: description,
@@ -63,17 +64,29 @@ func (m *Manager) AddComment(caseID, author, text string) (*sqlitestore.CaseComm
 	return comment, nil
 }
 
-// LinkAlert attaches an alert to a case.
+// LinkAlert attaches an alert to a case of the same tenant.
 func (m *Manager) LinkAlert(caseID, alertID string) error {
+	c, err := m.store.GetCase(caseID)
+	if err != nil {
+		return fmt.Errorf("cases: failed to link alert: %w", err)
+	}
+	alert, err := m.store.GetAlert(alertID)
+	if err != nil {
+		return fmt.Errorf("cases: failed to link alert: %w", err)
+	}
+	if c == nil || models.TenantOrDefault(alert.Tenant) != c.Tenant {
+		return fmt.Errorf("cases: alert %s and case %s belong to different tenants", alertID, caseID)
+	}
 	if err := m.store.LinkAlertToCase(caseID, alertID); err != nil {
 		return fmt.Errorf("cases: failed to link alert: %w", err)
 	}
 	return nil
 }
 
-// ListCases retrieves cases with optional filtering.
-func (m *Manager) ListCases(status string, limit int) ([]*sqlitestore.CaseRecord, error) {
-	return m.store.ListCases(status, limit)
+// ListCases retrieves the cases of tenant ("" for all) with optional
+// filtering.
+func (m *Manager) ListCases(tenant, status string, limit int) ([]*sqlitestore.CaseRecord, error) {
+	return m.store.ListCases(tenant, status, limit)
 }
 
 // GetCaseDetails returns a case with its linked alerts and comments.
@@ -15,15 +15,115 @@ type Config struct {
 }
 
 type IngestionConfig struct {
-	SyslogPort int    `yaml:"syslog_port" json:"syslog_port"`
-	HECPort    int    `yaml:"hec_port" json:"hec_port"`
-	HECToken   string `yaml:"hec_token" json:"hec_token"`
-	GRPCPort   int    `yaml:"grpc_port" json:"grpc_port"`
+	SyslogPort int           `yaml:"syslog_port" json:"syslog_port"`
+	HECPort    int           `yaml:"hec_port" json:"hec_port"`
+	HECToken   string        `yaml:"hec_token" json:"hec_token"`
+	GRPCPort   int           `yaml:"grpc_port" json:"grpc_port"`
+	Tenants    []TenantRoute `yaml:"tenants" json:"tenants"`
+}
+
+// TenantRoute assigns events to a tenant at ingest. The ingest token
+// decides first, then the source address, then the listener; events no
+// route matches belong to the default tenant.
+type TenantRoute struct {
+	Tenant    string   `yaml:"tenant" json:"tenant"`
+	HECTokens []string `yaml:"hec_tokens" json:"hec_tokens"` // extra HEC tokens accepted for this tenant
+	CIDRs     []string `yaml:"cidrs" json:"cidrs"`           // source networks, e.g. 10.1.0.0/16
+	Listeners []string `yaml:"listeners" json:"listeners"`   // ingest routes, e.g. syslog, hec, file, netflow
 }
 
 type StorageConfig struct {
-	BasePath  string `yaml:"base_path" json:"base_path"`
-	Retention int    `yaml:"retention_days" json:"retention_days"`
+	BasePath            string            `yaml:"base_path" json:"base_path"`
+	Retention           int               `yaml:"retention_days" json:"retention_days"` // default class; 0 → keep forever
+	RetentionClasses    []RetentionClass  `yaml:"retention_classes" json:"retention_classes"`
+	FieldIndex          FieldIndexConfig  `yaml:"field_index" json:"field_index"`
+	IndexPartitionHours int               `yaml:"index_partition_hours" json:"index_partition_hours"` // span of one live index partition; 0 → 24
+	Tiers               TierConfig        `yaml:"tiers" json:"tiers"`
+	Encryption          EncryptionConfig  `yaml:"encryption" json:"encryption"`
+	BackupPath          string            `yaml:"backup_path" json:"backup_path"` // "" → {base}/backups
+	Consistency         ConsistencyConfig `yaml:"consistency" json:"consistency"`
+	Guardian            GuardianConfig    `yaml:"guardian" json:"guardian"`
+}
+
+// GuardianConfig sets when the storage guardian acts on disk pressure.
+// Watermarks are percentages of headroom: the free space of the file
+// system under BasePath, or the unused part of an engine's quota. Below
+// each watermark the guardian takes one more action: raise an alert,
+// sample low-priority routes, purge the oldest data not under a legal
+// hold, and finally refuse writes, spooling events until space returns. A
+// negative watermark turns its step off.
+type GuardianConfig struct {
+	IntervalSeconds   int      `yaml:"interval_seconds" json:"interval_seconds"`       // 0 → 30; negative disables
+	WarnPercent       int      `yaml:"warn_percent" json:"warn_percent"`               // 0 → 15
+	SamplePercent     int      `yaml:"sample_percent" json:"sample_percent"`           // 0 → 10
+	PurgePercent      int      `yaml:"purge_percent" json:"purge_percent"`             // 0 → 5
+	RefusePercent     int      `yaml:"refuse_percent" json:"refuse_percent"`           // 0 → 2
+	LowPriorityRoutes []string `yaml:"low_priority_routes" json:"low_priority_routes"` // nil → netflow, pcap
+	SampleRate        int      `yaml:"sample_rate" json:"sample_rate"`                 // keep 1 in N low-priority events; 0 → 10
+	BadgerQuotaMB     int      `yaml:"badger_quota_mb" json:"badger_quota_mb"`         // 0 → no quota
+	BlugeQuotaMB      int      `yaml:"bluge_quota_mb" json:"bluge_quota_mb"`
+	SQLiteQuotaMB     int      `yaml:"sqlite_quota_mb" json:"sqlite_quota_mb"`
+	ArchiveQuotaMB    int      `yaml:"archive_quota_mb" json:"archive_quota_mb"`
+	SpoolPath         string   `yaml:"spool_path" json:"spool_path"`     // "" → {base}/spool
+	SpoolMaxMB        int      `yaml:"spool_max_mb" json:"spool_max_mb"` // 0 → 1024
+}
+
+// ConsistencyConfig schedules the scanner that compares BadgerDB with the
+// live index and indexes events missing from it.
+type ConsistencyConfig struct {
+	IntervalMinutes int `yaml:"interval_minutes" json:"interval_minutes"` // 0 → 60; negative disables
+	LookbackHours   int `yaml:"lookback_hours" json:"lookback_hours"`     // 0 → 24
+}
+
+// EncryptionConfig turns on encryption at rest for BadgerDB, the Bluge
+// indexes, the SQLite database, archive segments and the forensics signing
+// key. A master key kept in {base}/keys/master.json is unlocked at startup
+// with KeyFile, or else with the passphrase in the PassphraseEnv variable.
+// Existing plaintext data is converted when encryption is first enabled.
+// The SQLite database stays on disk, encrypted page by page.
+type EncryptionConfig struct {
+	Enabled         bool   `yaml:"enabled" json:"enabled"`
+	KeyFile         string `yaml:"key_file" json:"key_file"`                   // 32 raw bytes or 64 hex characters
+	PassphraseEnv   string `yaml:"passphrase_env" json:"passphrase_env"`       // "" → OBLIVRA_PASSPHRASE
+	KeyRotationDays int    `yaml:"key_rotation_days" json:"key_rotation_days"` // Badger data keys; 0 → 10
+}
+
+// RetentionClass keeps matching events for Days instead of the default
+// Retention. Each list holds * / ? wildcard patterns; an event matches when
+// every non-empty list has a matching pattern (case-insensitive). Routes
+// match the ingest route an input stamps on the event (syslog, hec, file,
+// netflow, fim, pcap). The first matching class wins.
+type RetentionClass struct {
+	Name       string   `yaml:"name" json:"name"`
+	Days       int      `yaml:"days" json:"days"` // 0 → keep forever
+	Sources    []string `yaml:"sources" json:"sources"`
+	Categories []string `yaml:"categories" json:"categories"`
+	Severities []string `yaml:"severities" json:"severities"`
+	Routes     []string `yaml:"routes" json:"routes"`
+}
+
+// TierConfig sets the hot/warm/cold boundaries. Events stay in the hot tier
+// (BadgerDB) for HotDays, then move to compressed archive segments on the
+// warm path; segments older than WarmDays move to the cold path. Retention
+// applies to all tiers.
+type TierConfig struct {
+	HotDays  int    `yaml:"hot_days" json:"hot_days"`   // 0 → no archiving
+	WarmDays int    `yaml:"warm_days" json:"warm_days"` // 0 → segments stay warm
+	WarmPath string `yaml:"warm_path" json:"warm_path"` // "" → {base}/archive/warm
+	ColdPath string `yaml:"cold_path" json:"cold_path"` // "" → {base}/archive/cold
+}
+
+// FieldIndexConfig controls how dynamic event fields are indexed in Bluge.
+type FieldIndexConfig struct {
+	MaxFields      int                       `yaml:"max_fields" json:"max_fields"`           // 0 → 1000
+	MaxCardinality int                       `yaml:"max_cardinality" json:"max_cardinality"` // 0 → 10000
+	Fields         map[string]FieldIndexRule `yaml:"fields" json:"fields"`
+}
+
+// FieldIndexRule overrides indexing for a single field.
+type FieldIndexRule struct {
+	Type           string `yaml:"type" json:"type"` // auto | keyword | numeric | ip | text | none
+	MaxCardinality int    `yaml:"max_cardinality" json:"max_cardinality"`
 }
 
 type UIConfig struct {
@@ -68,6 +168,10 @@ func DefaultConfig() *Config {
 		Storage: StorageConfig{
 			BasePath:  basePath,
 			Retention: 30,
+			Tiers: TierConfig{
+				HotDays:  7,
+				WarmDays: 14,
+			},
 		},
 		UI: UIConfig{
 			Port:  34115,
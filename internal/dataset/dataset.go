@@ -0,0 +1,223 @@
+// Package dataset exports events in bulk to files meant to leave OBLIVRA,
+// for external auditors or another (possibly air-gapped) site, and imports
+// them back.
+//
+// An export is a directory holding the event files, NDJSON or Parquet,
+// optionally one per UTC day and compressed, and a manifest.json listing
+// every file with its SHA-256. The manifest is signed with the forensics
+// Ed25519 key and carries the public key; Verify checks the signature and
+// every file before Import writes a single event.
+package dataset
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+)
+
+// Formats.
+const (
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+const (
+	// ManifestFile is the name of the manifest in an export directory.
+	ManifestFile = "manifest.json"
+
+	manifestVersion = 1
+)
+
+var (
+	// ErrUnsigned is returned by Verify for a manifest without a signature.
+	ErrUnsigned = errors.New("dataset: manifest is not signed")
+	// ErrBadSignature is returned by Verify when the signature does not
+	// match the manifest or the trusted key.
+	ErrBadSignature = errors.New("dataset: manifest signature is invalid")
+)
+
+// Signer signs manifests; *forensics.Manager implements it.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+	PublicKeyHex() string
+}
+
+// Manifest describes an export. Signature is the Ed25519 signature, by
+// PublicKey, of the manifest's JSON encoding with Signature empty.
+type Manifest struct {
+	Version    int                 `json:"version"`
+	ID         string              `json:"id"`
+	Created    time.Time           `json:"created"`
+	CreatedBy  string              `json:"created_by"`
+	Format     string              `json:"format"`
+	Compressed bool                `json:"compressed"`
+	SplitByDay bool                `json:"split_by_day"`
+	Query      storage.SearchQuery `json:"query"`
+	Events     int                 `json:"events"`
+	Start      time.Time           `json:"start"`            // earliest event
+	End        time.Time           `json:"end"`              // latest event
+	Fields     map[string]string   `json:"fields,omitempty"` // Parquet column type of each dynamic field
+	Files      []File              `json:"files"`
+	PublicKey  string              `json:"public_key"` // hex
+	Signature  string              `json:"signature"`  // hex
+}
+
+// File is one event file of an export.
+type File struct {
+	Name   string `json:"name"`
+	Day    string `json:"day,omitempty"` // YYYY-MM-DD when split by day
+	Events int    `json:"events"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// signedBytes returns what the signature covers.
+func (mf *Manifest) signedBytes() ([]byte, error) {
+	c := *mf
+	c.Signature = ""
+	return json.Marshal(&c)
+}
+
+func (mf *Manifest) sign(s Signer) error {
+	mf.PublicKey = s.PublicKeyHex()
+	if mf.PublicKey == "" {
+		return errors.New("dataset: no signing key available")
+	}
+	data, err := mf.signedBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := s.Sign(data)
+	if err != nil {
+		return fmt.Errorf("dataset: sign manifest: %w", err)
+	}
+	mf.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// ReadManifest reads the manifest of the export in dir without checking it.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("dataset: %w", err)
+	}
+	var mf Manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("dataset: manifest: %w", err)
+	}
+	if mf.Version != manifestVersion {
+		return nil, fmt.Errorf("dataset: unsupported manifest version %d", mf.Version)
+	}
+	return &mf, nil
+}
+
+// Verify checks the manifest of the export in dir: its signature, by
+// trustedKey (hex) unless empty, and the size and SHA-256 of every file.
+// An empty trustedKey accepts the key embedded in the manifest, which
+// proves integrity but not origin.
+func Verify(dir, trustedKey string) (*Manifest, error) {
+	mf, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if mf.Signature == "" || mf.PublicKey == "" {
+		return mf, ErrUnsigned
+	}
+	if trustedKey != "" && !strings.EqualFold(trustedKey, mf.PublicKey) {
+		return mf, fmt.Errorf("%w: signed by %s, not the trusted key", ErrBadSignature, mf.PublicKey)
+	}
+	pub, err := hex.DecodeString(mf.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return mf, fmt.Errorf("%w: malformed public key", ErrBadSignature)
+	}
+	sig, err := hex.DecodeString(mf.Signature)
+	if err != nil {
+		return mf, fmt.Errorf("%w: malformed signature", ErrBadSignature)
+	}
+	data, err := mf.signedBytes()
+	if err != nil {
+		return mf, err
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return mf, ErrBadSignature
+	}
+	for _, f := range mf.Files {
+		path, err := filePath(dir, f.Name)
+		if err != nil {
+			return mf, err
+		}
+		n, sum, err := hashFile(path)
+		if err != nil {
+			return mf, fmt.Errorf("dataset: %s: %w", f.Name, err)
+		}
+		if n != f.Bytes || sum != f.SHA256 {
+			return mf, fmt.Errorf("dataset: %s does not match the manifest", f.Name)
+		}
+	}
+	return mf, nil
+}
+
+// filePath resolves a file name from a manifest, refusing any that would
+// leave dir.
+func filePath(dir, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == ManifestFile {
+		return "", fmt.Errorf("dataset: invalid file name %q in manifest", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashingFile is an output file that hashes and counts what is written.
+type hashingFile struct {
+	f *os.File
+	h hash.Hash
+	n int64
+}
+
+func createFile(path string) (*hashingFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &hashingFile{f: f, h: sha256.New()}, nil
+}
+
+func (w *hashingFile) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.h.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// close syncs and closes the file and returns its size and hash.
+func (w *hashingFile) close() (int64, string, error) {
+	err := w.f.Sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return w.n, hex.EncodeToString(w.h.Sum(nil)), err
+}
@@ -0,0 +1,177 @@
+package dataset_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/dataset"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+type keySigner ed25519.PrivateKey
+
+func (k keySigner) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(k), data), nil
+}
+func (k keySigner) PublicKeyHex() string {
+	return hex.EncodeToString(ed25519.PrivateKey(k).Public().(ed25519.PublicKey))
+}
+
+func newSigner(t *testing.T) keySigner {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keySigner(priv)
+}
+
+func tmpEngine(t *testing.T) *storage.Engine {
+	t.Helper()
+	eng, err := storage.Open(context.Background(), &config.Config{Storage: config.StorageConfig{BasePath: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { eng.Close() })
+	return eng
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := tmpEngine(t)
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	var events []*models.Event
+	for i := range 6 {
+		ev := &models.Event{
+			ID:        uuid.NewString(),
+			Timestamp: day.Add(time.Duration(i) * 10 * time.Hour),
+			Source:    "fw",
+			Host:      "fw-01",
+			Severity:  models.SeverityHigh,
+			Message:   "blocked connection",
+			Raw:       "raw line",
+			Fields: map[string]interface{}{
+				"dst_port": float64(443 + i),
+				"ratio":    0.5 + float64(i),
+				"allowed":  i%2 == 0,
+				"proto":    "tcp",
+				"tags":     []interface{}{"a", "b"},
+			},
+		}
+		ev.SetRoute("syslog")
+		events = append(events, ev)
+	}
+	if err := src.WriteEventBatch(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	signer := newSigner(t)
+
+	for _, tc := range []struct {
+		name string
+		opts dataset.ExportOptions
+	}{
+		{"ndjson", dataset.ExportOptions{}},
+		{"ndjson gzip by day", dataset.ExportOptions{SplitByDay: true, Compress: true}},
+		{"parquet", dataset.ExportOptions{Format: dataset.FormatParquet}},
+		{"parquet zstd by day", dataset.ExportOptions{Format: dataset.FormatParquet, SplitByDay: true, Compress: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "export")
+			mf, err := dataset.Export(ctx, src, dir, tc.opts, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantFiles := 1
+			if tc.opts.SplitByDay {
+				wantFiles = 3 // events span 50 hours from midnight
+			}
+			if mf.Events != len(events) || len(mf.Files) != wantFiles {
+				t.Fatalf("manifest: %d events in %d files", mf.Events, len(mf.Files))
+			}
+			if tc.opts.Format == dataset.FormatParquet && mf.Fields["dst_port"] != "int64" {
+				t.Errorf("field types %v", mf.Fields)
+			}
+
+			if _, err := dataset.Verify(dir, signer.PublicKeyHex()); err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if _, err := dataset.Verify(dir, newSigner(t).PublicKeyHex()); !errors.Is(err, dataset.ErrBadSignature) {
+				t.Errorf("Verify with another trusted key: %v", err)
+			}
+
+			dst := tmpEngine(t)
+			res, err := dataset.Import(ctx, dst, dir, signer.PublicKeyHex(), "acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Imported != len(events) || res.Skipped != 0 || res.Tenant != "acme" {
+				t.Fatalf("import: %+v", res)
+			}
+			for _, want := range events {
+				got, err := dst.GetEvent(want.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !got.Timestamp.Equal(want.Timestamp) || got.Message != want.Message || got.Metadata[models.MetaRoute] != "syslog" || got.Tenant != "acme" {
+					t.Errorf("imported %+v, want %+v", got, want)
+				}
+				if got.Fields["dst_port"] != want.Fields["dst_port"] || got.Fields["allowed"] != want.Fields["allowed"] ||
+					got.Fields["ratio"] != want.Fields["ratio"] || len(got.Fields["tags"].([]interface{})) != 2 {
+					t.Errorf("fields %v, want %v", got.Fields, want.Fields)
+				}
+			}
+			hits, err := dst.SearchEvents(ctx, &storage.SearchQuery{Text: "blocked"})
+			if err != nil || len(hits) != len(events) {
+				t.Fatalf("imported events not indexed: %v, %d hits", err, len(hits))
+			}
+
+			res, err = dataset.Import(ctx, dst, dir, "", "acme")
+			if err != nil || res.Imported != 0 || res.Skipped != len(events) {
+				t.Fatalf("re-import: %+v, %v", res, err)
+			}
+
+			// Events archived out of the hot tier are not imported again.
+			dst.RunLifecycle(config.StorageConfig{Tiers: config.TierConfig{HotDays: 7}}, time.Now())
+			if n, _ := dst.Archive.Usage(); n != int64(len(events)) {
+				t.Fatalf("archived %d events", n)
+			}
+			res, err = dataset.Import(ctx, dst, dir, "", "acme")
+			if err != nil || res.Imported != 0 || res.Skipped != len(events) {
+				t.Fatalf("import into archive: %+v, %v", res, err)
+			}
+		})
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "export")
+		mf, err := dataset.Export(ctx, src, dir, dataset.ExportOptions{}, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, mf.Files[0].Name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[10] ^= 1
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dataset.Import(ctx, tmpEngine(t), dir, "", ""); err == nil {
+			t.Fatal("import of a tampered file succeeded")
+		}
+		if _, err := dataset.Export(ctx, src, dir, dataset.ExportOptions{}, signer); err == nil {
+			t.Error("export into a non-empty directory succeeded")
+		}
+	})
+}
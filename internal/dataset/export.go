@@ -0,0 +1,234 @@
+package dataset
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// ExportOptions selects the events of an export and the shape of its files.
+type ExportOptions struct {
+	Query      storage.SearchQuery `json:"query"`        // Limit, Sort and After are ignored
+	Format     string              `json:"format"`       // ndjson (default) or parquet
+	SplitByDay bool                `json:"split_by_day"` // one file per UTC day
+	Compress   bool                `json:"compress"`     // gzip NDJSON files, zstd Parquet pages
+	CreatedBy  string              `json:"created_by"`
+}
+
+// eventWriter writes the events of one export file.
+type eventWriter interface {
+	write(ev *models.Event) error
+	close() error
+}
+
+type ndjsonWriter struct {
+	gz  *gzip.Writer
+	bw  *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(f *hashingFile, compress bool) *ndjsonWriter {
+	w := &ndjsonWriter{}
+	if compress {
+		w.gz = gzip.NewWriter(f)
+		w.bw = bufio.NewWriter(w.gz)
+	} else {
+		w.bw = bufio.NewWriter(f)
+	}
+	w.enc = json.NewEncoder(w.bw)
+	return w
+}
+
+func (w *ndjsonWriter) write(ev *models.Event) error { return w.enc.Encode(ev) }
+
+func (w *ndjsonWriter) close() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// Export writes the events matching opts.Query to dir, oldest first, and
+// signs the manifest with signer. dir is created if needed and must be
+// empty. Parquet exports read the events twice, first to type the
+// dynamic field columns.
+func Export(ctx context.Context, eng *storage.Engine, dir string, opts ExportOptions, signer Signer) (*Manifest, error) {
+	format := opts.Format
+	if format == "" {
+		format = FormatNDJSON
+	}
+	if format != FormatNDJSON && format != FormatParquet {
+		return nil, fmt.Errorf("dataset: unknown format %q", opts.Format)
+	}
+	if signer == nil || signer.PublicKeyHex() == "" {
+		return nil, fmt.Errorf("dataset: no signing key available")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("dataset: %w", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		return nil, fmt.Errorf("dataset: %w", err)
+	} else if len(entries) > 0 {
+		return nil, fmt.Errorf("dataset: export directory %s is not empty", dir)
+	}
+
+	q := opts.Query
+	q.Sort, q.After, q.Limit = []string{"timestamp"}, "", 0
+	mf := &Manifest{
+		Version:    manifestVersion,
+		ID:         uuid.NewString(),
+		Created:    time.Now().UTC(),
+		CreatedBy:  opts.CreatedBy,
+		Format:     format,
+		Compressed: opts.Compress,
+		SplitByDay: opts.SplitByDay,
+		Query:      q,
+	}
+	if format == FormatParquet {
+		types := make(map[string]string)
+		if err := eng.ExportEvents(ctx, &q, func(ev *models.Event) error {
+			collectFields(types, ev)
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("dataset: %w", err)
+		}
+		mf.Fields = types
+	}
+
+	x := &exporter{dir: dir, mf: mf}
+	err := eng.ExportEvents(ctx, &q, func(ev *models.Event) error {
+		day := ""
+		if opts.SplitByDay {
+			day = ev.Timestamp.UTC().Format("2006-01-02")
+		}
+		if x.w == nil || day != x.file.Day {
+			if err := x.next(day); err != nil {
+				return err
+			}
+		}
+		if err := x.w.write(ev); err != nil {
+			return err
+		}
+		x.file.Events++
+		mf.Events++
+		if mf.Start.IsZero() || ev.Timestamp.Before(mf.Start) {
+			mf.Start = ev.Timestamp
+		}
+		if ev.Timestamp.After(mf.End) {
+			mf.End = ev.Timestamp
+		}
+		return nil
+	})
+	if err == nil && x.w == nil && !opts.SplitByDay {
+		err = x.next("") // an empty file keeps the format's schema
+	}
+	if err == nil {
+		err = x.finish()
+	}
+	if err == nil {
+		err = mf.sign(signer)
+	}
+	if err == nil {
+		err = writeManifest(dir, mf)
+	}
+	if err != nil {
+		x.abort()
+		return nil, fmt.Errorf("dataset: export: %w", err)
+	}
+	return mf, nil
+}
+
+// exporter holds the export file being written.
+type exporter struct {
+	dir  string
+	mf   *Manifest
+	f    *hashingFile
+	w    eventWriter
+	file File
+}
+
+// next finishes the current file and starts the one for day.
+func (x *exporter) next(day string) error {
+	if err := x.finish(); err != nil {
+		return err
+	}
+	name := "events"
+	if day != "" {
+		name += "-" + day
+	}
+	if x.mf.Format == FormatParquet {
+		name += ".parquet"
+	} else if name += ".ndjson"; x.mf.Compressed {
+		name += ".gz"
+	}
+	f, err := createFile(filepath.Join(x.dir, name))
+	if err != nil {
+		return err
+	}
+	x.f, x.file = f, File{Name: name, Day: day}
+	if x.mf.Format == FormatParquet {
+		x.w = newParquetWriter(f, x.mf.Fields, x.mf.Compressed)
+	} else {
+		x.w = newNDJSONWriter(f, x.mf.Compressed)
+	}
+	return nil
+}
+
+// finish closes the current file and adds it to the manifest.
+func (x *exporter) finish() error {
+	if x.w == nil {
+		return nil
+	}
+	err := x.w.close()
+	n, sum, cerr := x.f.close()
+	x.w = nil
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filepath.Join(x.dir, x.file.Name))
+		return fmt.Errorf("%s: %w", x.file.Name, err)
+	}
+	x.file.Bytes, x.file.SHA256 = n, sum
+	x.mf.Files = append(x.mf.Files, x.file)
+	return nil
+}
+
+// abort removes the files of a failed export.
+func (x *exporter) abort() {
+	if x.w != nil {
+		x.f.close()
+		os.Remove(filepath.Join(x.dir, x.file.Name))
+	}
+	for _, f := range x.mf.Files {
+		os.Remove(filepath.Join(x.dir, f.Name))
+	}
+}
+
+func writeManifest(dir string, mf *Manifest) error {
+	data, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := createFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if _, _, cerr := f.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,358 @@
+package dataset
+
+// parquet.go maps events to Parquet rows. The core event fields are fixed
+// columns; each dynamic field becomes an optional column of the group
+// "fields", typed after the values it holds across the export.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// Dynamic field column types, as recorded in Manifest.Fields.
+const (
+	typeInt64   = "int64"
+	typeDouble  = "double"
+	typeBoolean = "boolean"
+	typeString  = "string"
+	typeJSON    = "json" // nested or mixed values, JSON encoded
+)
+
+// coreColumns are the string columns of the event fields other than
+// timestamp and metadata.
+var coreColumns = []string{"id", "source", "host", "user", "severity", "category", "message", "raw"}
+
+// valueType returns the column type for v, or "" for nil.
+func valueType(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return typeBoolean
+	case string:
+		return typeString
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return typeInt64
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return typeInt64
+		}
+		return typeDouble
+	case float32:
+		return valueType(float64(x))
+	}
+	return typeJSON
+}
+
+// mergeType returns the type of a column holding values of types a and b.
+func mergeType(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	case (a == typeInt64 && b == typeDouble) || (a == typeDouble && b == typeInt64):
+		return typeDouble
+	}
+	return typeJSON
+}
+
+// collectFields adds the type of each dynamic field of ev to types.
+func collectFields(types map[string]string, ev *models.Event) {
+	for k, v := range ev.Fields {
+		if t := valueType(v); t != "" {
+			types[k] = mergeType(types[k], t)
+		}
+	}
+}
+
+// parquetSchema returns the schema for events with the dynamic fields
+// types.
+func parquetSchema(types map[string]string) *parquet.Schema {
+	root := parquet.Group{
+		"timestamp": parquet.Timestamp(parquet.Nanosecond),
+		"metadata":  parquet.Optional(parquet.JSON()),
+	}
+	for _, c := range coreColumns {
+		root[c] = parquet.String()
+	}
+	if len(types) > 0 {
+		fields := parquet.Group{}
+		for name, t := range types {
+			var n parquet.Node
+			switch t {
+			case typeInt64:
+				n = parquet.Int(64)
+			case typeDouble:
+				n = parquet.Leaf(parquet.DoubleType)
+			case typeBoolean:
+				n = parquet.Leaf(parquet.BooleanType)
+			case typeString:
+				n = parquet.String()
+			default:
+				n = parquet.JSON()
+			}
+			fields[name] = parquet.Optional(n)
+		}
+		root["fields"] = fields
+	}
+	return parquet.NewSchema("event", root)
+}
+
+// parquetWriter writes events as Parquet rows.
+type parquetWriter struct {
+	w       *parquet.Writer
+	b       *parquet.RowBuilder
+	columns map[string]int // "id", "fields.<name>", ... → column index
+	types   map[string]string
+	rows    []parquet.Row
+}
+
+const parquetRowBatch = 1024
+
+func newParquetWriter(out io.Writer, types map[string]string, compress bool) *parquetWriter {
+	schema := parquetSchema(types)
+	opts := []parquet.WriterOption{schema, parquet.KeyValueMetadata("oblivra.dataset", "events")}
+	if compress {
+		opts = append(opts, parquet.Compression(&zstd.Codec{}))
+	}
+	return &parquetWriter{
+		w:       parquet.NewWriter(out, opts...),
+		b:       parquet.NewRowBuilder(schema),
+		columns: columnIndexes(schema),
+		types:   types,
+	}
+}
+
+func columnIndexes(schema *parquet.Schema) map[string]int {
+	out := make(map[string]int)
+	for _, path := range schema.Columns() {
+		leaf, _ := schema.Lookup(path...)
+		name := path[0]
+		if len(path) == 2 {
+			name = path[0] + "." + path[1]
+		}
+		out[name] = leaf.ColumnIndex
+	}
+	return out
+}
+
+func (p *parquetWriter) write(ev *models.Event) error {
+	b := p.b
+	b.Reset()
+	for _, c := range coreColumns {
+		b.Add(p.columns[c], parquet.ByteArrayValue([]byte(coreValue(ev, c))))
+	}
+	b.Add(p.columns["timestamp"], parquet.Int64Value(ev.Timestamp.UnixNano()))
+	if len(ev.Metadata) > 0 {
+		data, err := json.Marshal(ev.Metadata)
+		if err != nil {
+			return err
+		}
+		b.Add(p.columns["metadata"], parquet.ByteArrayValue(data))
+	}
+	for k, v := range ev.Fields {
+		t, ok := p.types[k]
+		if !ok || v == nil {
+			continue
+		}
+		val, err := fieldValue(t, v)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", k, err)
+		}
+		b.Add(p.columns["fields."+k], val)
+	}
+	p.rows = append(p.rows, b.AppendRow(nil))
+	if len(p.rows) >= parquetRowBatch {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *parquetWriter) flush() error {
+	_, err := p.w.WriteRows(p.rows)
+	p.rows = p.rows[:0]
+	return err
+}
+
+func (p *parquetWriter) close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.w.Close()
+}
+
+func coreValue(ev *models.Event, column string) string {
+	switch column {
+	case "id":
+		return ev.ID
+	case "source":
+		return ev.Source
+	case "host":
+		return ev.Host
+	case "user":
+		return ev.User
+	case "severity":
+		return string(ev.Severity)
+	case "category":
+		return ev.Category
+	case "message":
+		return ev.Message
+	}
+	return ev.Raw
+}
+
+func setCoreValue(ev *models.Event, column, v string) {
+	switch column {
+	case "id":
+		ev.ID = v
+	case "source":
+		ev.Source = v
+	case "host":
+		ev.Host = v
+	case "user":
+		ev.User = v
+	case "severity":
+		ev.Severity = models.Severity(v)
+	case "category":
+		ev.Category = v
+	case "message":
+		ev.Message = v
+	case "raw":
+		ev.Raw = v
+	}
+}
+
+// fieldValue converts v to a value of a column of type t.
+func fieldValue(t string, v any) (parquet.Value, error) {
+	switch t {
+	case typeInt64:
+		if x, ok := v.(int64); ok {
+			return parquet.Int64Value(x), nil
+		}
+		return parquet.Int64Value(int64(toFloat(v))), nil
+	case typeDouble:
+		return parquet.DoubleValue(toFloat(v)), nil
+	case typeBoolean:
+		return parquet.BooleanValue(v.(bool)), nil
+	case typeString:
+		return parquet.ByteArrayValue([]byte(v.(string))), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return parquet.Value{}, err
+	}
+	return parquet.ByteArrayValue(data), nil
+}
+
+// toFloat converts a value valueType classed as int64 or double.
+func toFloat(v any) float64 {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case int8:
+		return float64(x)
+	case int16:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case uint8:
+		return float64(x)
+	case uint16:
+		return float64(x)
+	case uint32:
+		return float64(x)
+	case float32:
+		return float64(x)
+	case float64:
+		return x
+	}
+	return 0
+}
+
+// readParquet reads the events of a Parquet export file. types are the
+// dynamic field types from the manifest.
+func readParquet(r io.ReaderAt, size int64, types map[string]string, fn func(*models.Event) error) error {
+	f, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return err
+	}
+	schema := f.Schema()
+	names := make(map[int]string)
+	for name, i := range columnIndexes(schema) {
+		names[i] = name
+	}
+	reader := parquet.NewReader(f)
+	defer reader.Close()
+	rows := make([]parquet.Row, parquetRowBatch)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			ev, derr := decodeRow(row, names, types)
+			if derr != nil {
+				return derr
+			}
+			if ferr := fn(ev); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decodeRow(row parquet.Row, names map[int]string, types map[string]string) (*models.Event, error) {
+	ev := &models.Event{}
+	for _, v := range row {
+		if v.IsNull() {
+			continue
+		}
+		name := names[v.Column()]
+		field, isField := strings.CutPrefix(name, "fields.")
+		switch {
+		case name == "timestamp":
+			ev.Timestamp = time.Unix(0, v.Int64())
+		case name == "metadata":
+			if err := json.Unmarshal(v.ByteArray(), &ev.Metadata); err != nil {
+				return nil, fmt.Errorf("metadata: %w", err)
+			}
+		case isField:
+			if ev.Fields == nil {
+				ev.Fields = make(map[string]interface{})
+			}
+			switch types[field] {
+			case typeInt64:
+				ev.Fields[field] = v.Int64()
+			case typeDouble:
+				ev.Fields[field] = v.Double()
+			case typeBoolean:
+				ev.Fields[field] = v.Boolean()
+			case typeString:
+				ev.Fields[field] = string(v.ByteArray())
+			default:
+				var x any
+				if err := json.Unmarshal(v.ByteArray(), &x); err != nil {
+					return nil, fmt.Errorf("field %s: %w", field, err)
+				}
+				ev.Fields[field] = x
+			}
+		default:
+			setCoreValue(ev, name, string(v.ByteArray()))
+		}
+	}
+	return ev, nil
+}
@@ -83,7 +83,11 @@ func (m *Manager) checkMatch(ctx context.Context, ev *models.Event, value string
 	// For performance in high-frequency fields we might use a prefix tree or regex,
 	// but for deception exact match or simple contains is often better to avoid noise.
 
+	tenant := models.TenantOrDefault(ev.Tenant)
 	for _, token := range m.tokens {
+		if token.Tenant != "" && token.Tenant != tenant {
+			continue // planted for another tenant
+		}
 		if strings.Contains(lowerVal, strings.ToLower(token.Value)) {
 			// Trigger alert
 			alert := &models.Alert{
@@ -95,6 +99,7 @@ func (m *Manager) checkMatch(ctx context.Context, ev *models.Event, value string
 				Title:     fmt.Sprintf("Deception Triggered: %s", token.Type),
 				Summary:   fmt.Sprintf("Honeytoken '%s' (%s) accessed in field '%s'", token.Value, token.Description, field),
 				Status:    "open",
+				Tenant:    tenant,
 			}
 
 			if err := m.handler(ctx, alert); err != nil {
@@ -0,0 +1,85 @@
+package detection
+
+// ahoCorasick finds every occurrence of a fixed set of literals in one pass
+// over the text. It is built as a DFA over byte classes: bytes that occur in
+// no literal share class 0, so the transition table stays small however
+// many distinct bytes the text contains.
+type ahoCorasick struct {
+	class  [256]uint16
+	nclass int
+	delta  []int32 // state*nclass + class → next state
+	out    [][]int // literal indices recognised on entering each state
+}
+
+func newAhoCorasick(literals []string) *ahoCorasick {
+	a := &ahoCorasick{nclass: 1}
+	for _, lit := range literals {
+		for i := 0; i < len(lit); i++ {
+			if a.class[lit[i]] == 0 {
+				a.class[lit[i]] = uint16(a.nclass)
+				a.nclass++
+			}
+		}
+	}
+
+	// Trie, with -1 for a missing edge.
+	newState := func() int32 {
+		for i := 0; i < a.nclass; i++ {
+			a.delta = append(a.delta, -1)
+		}
+		a.out = append(a.out, nil)
+		return int32(len(a.out) - 1)
+	}
+	newState()
+	for id, lit := range literals {
+		var s int32
+		for i := 0; i < len(lit); i++ {
+			c := int32(a.class[lit[i]])
+			next := a.delta[s*int32(a.nclass)+c]
+			if next < 0 {
+				next = newState()
+				a.delta[s*int32(a.nclass)+c] = next
+			}
+			s = next
+		}
+		a.out[s] = append(a.out[s], id)
+	}
+
+	// Breadth-first, resolve failure links into the transition table so
+	// scanning never backtracks.
+	fail := make([]int32, len(a.out))
+	queue := []int32{0}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		row := s * int32(a.nclass)
+		for c := int32(0); c < int32(a.nclass); c++ {
+			next := a.delta[row+c]
+			var viaFail int32
+			if s != 0 {
+				viaFail = a.delta[fail[s]*int32(a.nclass)+c]
+			}
+			if next < 0 {
+				a.delta[row+c] = viaFail
+				continue
+			}
+			fail[next] = viaFail
+			a.out[next] = append(a.out[next], a.out[viaFail]...)
+			queue = append(queue, next)
+		}
+	}
+	return a
+}
+
+// scan calls found with the index of each literal occurring in text, once
+// per occurrence.
+func (a *ahoCorasick) scan(text string, found func(id int)) {
+	var s int32
+	n := int32(a.nclass)
+	for i := 0; i < len(text); i++ {
+		s = a.delta[s*n+int32(a.class[text[i]])]
+		for _, id := range a.out[s] {
+			found(id)
+		}
+	}
+}
@@ -0,0 +1,754 @@
+package detection
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// predicate is a compiled condition.
+type predicate func(s *evalState) bool
+
+// evalState carries one event through the compiled rules. Literal scans run
+// at most once per event, on the first rule that needs them.
+type evalState struct {
+	ev      *models.Event
+	lookups LookupSource
+	lits    *literalTable
+	scanned []bool   // per literal scanner
+	hits    []uint64 // bit set of literal IDs found in the event
+	cand    []int    // candidate rule buffer
+}
+
+// ─── Compiler ─────────────────────────────────────────────────────────────────
+
+// compiler turns validated conditions into predicates. With a literal table,
+// contains conditions register their literal with it and test the table's
+// scan results instead of searching the field themselves.
+type compiler struct {
+	regex func(pattern string) (*regexp.Regexp, error)
+	lits  *literalTable
+}
+
+// compile validates cond and returns its predicate along with the literal
+// IDs at least one of which must be present for it to match (nil if no
+// literal is required).
+func (c *compiler) compile(cond Condition) (predicate, []int, error) {
+	if err := cond.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c.node(cond)
+}
+
+func (c *compiler) node(cond Condition) (predicate, []int, error) {
+	if len(cond.Nested) == 0 {
+		return c.leaf(cond)
+	}
+
+	nested := slices.Clone(cond.Nested)
+	logical := strings.ToLower(cond.Logical)
+	if logical != "or" {
+		// Conjunctions short-circuit, so test the cheap conditions first.
+		slices.SortStableFunc(nested, func(a, b Condition) int { return cost(a) - cost(b) })
+	}
+	preds := make([]predicate, len(nested))
+	needs := make([][]int, len(nested))
+	for i, n := range nested {
+		var err error
+		if preds[i], needs[i], err = c.node(n); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch logical {
+	case "or":
+		// A disjunction needs one of its branches' literals, if every
+		// branch needs one.
+		var need []int
+		for _, n := range needs {
+			if n == nil {
+				need = nil
+				break
+			}
+			need = append(need, n...)
+		}
+		return func(s *evalState) bool {
+			for _, p := range preds {
+				if p(s) {
+					return true
+				}
+			}
+			return false
+		}, need, nil
+	case "not":
+		all := allOf(preds)
+		return func(s *evalState) bool { return !all(s) }, nil, nil
+	}
+	// A conjunction needs whatever its most selective branch needs.
+	var need []int
+	for _, n := range needs {
+		if n != nil && (need == nil || len(n) < len(need)) {
+			need = n
+		}
+	}
+	return allOf(preds), need, nil
+}
+
+func allOf(preds []predicate) predicate {
+	return func(s *evalState) bool {
+		for _, p := range preds {
+			if !p(s) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// cost ranks conditions by evaluation cost for ordering conjunctions.
+func cost(c Condition) int {
+	if len(c.Nested) > 0 {
+		n := 0
+		for _, sub := range c.Nested {
+			n += cost(sub)
+		}
+		return n
+	}
+	switch strings.ToLower(c.Operator) {
+	case "regex":
+		return 10
+	case "cidr", "in_lookup", "not_in_lookup":
+		return 3
+	}
+	return 1
+}
+
+func (c *compiler) leaf(cond Condition) (predicate, []int, error) {
+	field, op := cond.Field, strings.ToLower(cond.Operator)
+	switch op {
+	case "exists":
+		return func(s *evalState) bool { return fieldValue(s.ev, field) != "" }, nil, nil
+	case "missing":
+		return func(s *evalState) bool { return fieldValue(s.ev, field) == "" }, nil, nil
+	}
+
+	var test func(s *evalState, val string) bool
+	var need []int
+	if cond.FieldRef != "" {
+		ref, cmp := cond.FieldRef, refComparison(op, cond.caseSensitive(op == "eq" || op == "neq"))
+		test = func(s *evalState, val string) bool {
+			target := fieldValue(s.ev, ref)
+			return target != "" && cmp(val, target)
+		}
+	} else {
+		var err error
+		if test, need, err = c.valueTest(op, cond); err != nil {
+			return nil, nil, fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return func(s *evalState) bool {
+		val := fieldValue(s.ev, field)
+		return val != "" && test(s, val)
+	}, need, nil
+}
+
+// valueTest compiles a comparison against the condition's constant value.
+func (c *compiler) valueTest(op string, cond Condition) (func(*evalState, string) bool, []int, error) {
+	target, _ := cond.Value.(string)
+	switch op {
+	case "eq", "neq":
+		eq := equalTo(cond.Value, cond.caseSensitive(true))
+		if op == "neq" {
+			return func(_ *evalState, val string) bool { return !eq(val) }, nil, nil
+		}
+		return func(_ *evalState, val string) bool { return eq(val) }, nil, nil
+
+	case "contains":
+		cs := cond.caseSensitive(false)
+		if !cs {
+			target = strings.ToLower(target)
+		}
+		if target == "" {
+			return func(*evalState, string) bool { return true }, nil, nil
+		}
+		if c.lits != nil {
+			mode := matchLower
+			if cs {
+				mode = matchExact
+			}
+			id := c.lits.add(cond.Field, mode, target)
+			return func(s *evalState, _ string) bool { return s.hit(id) }, []int{id}, nil
+		}
+		if cs {
+			return func(_ *evalState, val string) bool { return strings.Contains(val, target) }, nil, nil
+		}
+		return func(_ *evalState, val string) bool {
+			return strings.Contains(strings.ToLower(val), target)
+		}, nil, nil
+
+	case "startswith", "endswith":
+		cmp := refComparison(op, cond.caseSensitive(false))
+		return func(_ *evalState, val string) bool { return cmp(val, target) }, nil, nil
+
+	case "regex":
+		if !cond.caseSensitive(true) {
+			target = "(?i)" + target
+		}
+		re, err := c.regex(target)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.lits != nil {
+			// Skip the regex unless one of the literals it needs occurs.
+			if need := c.regexLiterals(cond.Field, target); need != nil {
+				return func(s *evalState, val string) bool {
+					return s.anyHit(need) && re.MatchString(val)
+				}, need, nil
+			}
+		}
+		return func(_ *evalState, val string) bool { return re.MatchString(val) }, nil, nil
+
+	case "gt", "gte", "lt", "lte":
+		b, _ := toFloat(cond.Value)
+		cmp := numericComparison(op)
+		return func(_ *evalState, val string) bool {
+			a, ok := toFloat(val)
+			return ok && cmp(a, b)
+		}, nil, nil
+
+	case "in", "not_in":
+		cs := cond.caseSensitive(true)
+		list, _ := toStrings(cond.Value)
+		set := make(map[string]struct{}, len(list))
+		for _, v := range list {
+			if !cs {
+				v = strings.ToLower(v)
+			}
+			set[v] = struct{}{}
+		}
+		want := op == "in"
+		return func(_ *evalState, val string) bool {
+			if !cs {
+				val = strings.ToLower(val)
+			}
+			_, found := set[val]
+			return found == want
+		}, nil, nil
+
+	case "cidr":
+		nets, _ := cidrList(cond.Value)
+		prefixes := make([]netip.Prefix, 0, len(nets))
+		for _, n := range nets {
+			p, err := parsePrefix(n)
+			if err != nil {
+				return nil, nil, err
+			}
+			prefixes = append(prefixes, p)
+		}
+		return func(_ *evalState, val string) bool {
+			addr, err := netip.ParseAddr(val)
+			if err != nil {
+				return false
+			}
+			addr = addr.Unmap()
+			for _, p := range prefixes {
+				if p.Contains(addr) {
+					return true
+				}
+			}
+			return false
+		}, nil, nil
+
+	case "in_lookup":
+		return func(s *evalState, val string) bool {
+			return s.lookups != nil && s.lookups.Contains(models.TenantOrDefault(s.ev.Tenant), target, val)
+		}, nil, nil
+	case "not_in_lookup":
+		return func(s *evalState, val string) bool {
+			return s.lookups != nil && !s.lookups.Contains(models.TenantOrDefault(s.ev.Tenant), target, val)
+		}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unknown operator %q", cond.Operator)
+}
+
+// equalTo returns the eq test against a condition value: numeric when the
+// value is a number, otherwise a string comparison.
+func equalTo(v interface{}, caseSensitive bool) func(string) bool {
+	switch t := v.(type) {
+	case string:
+		if caseSensitive {
+			return func(val string) bool { return val == t }
+		}
+		return func(val string) bool { return strings.EqualFold(val, t) }
+	case bool:
+		s := fmt.Sprint(t)
+		return func(val string) bool { return strings.EqualFold(val, s) }
+	}
+	b, ok := toFloat(v)
+	return func(val string) bool {
+		a, ok2 := toFloat(val)
+		return ok && ok2 && a == b
+	}
+}
+
+// refComparison compares a field value with another field's value, or a
+// string condition value.
+func refComparison(op string, caseSensitive bool) func(val, target string) bool {
+	fold := func(val, target string) (string, string) {
+		if caseSensitive {
+			return val, target
+		}
+		return strings.ToLower(val), strings.ToLower(target)
+	}
+	switch op {
+	case "eq", "neq":
+		want := op == "eq"
+		return func(val, target string) bool {
+			if caseSensitive {
+				return (val == target) == want
+			}
+			return strings.EqualFold(val, target) == want
+		}
+	case "contains":
+		return func(val, target string) bool { return strings.Contains(fold(val, target)) }
+	case "startswith":
+		return func(val, target string) bool { return strings.HasPrefix(fold(val, target)) }
+	case "endswith":
+		return func(val, target string) bool { return strings.HasSuffix(fold(val, target)) }
+	case "gt", "gte", "lt", "lte":
+		cmp := numericComparison(op)
+		return func(val, target string) bool {
+			a, ok := toFloat(val)
+			b, ok2 := toFloat(target)
+			return ok && ok2 && cmp(a, b)
+		}
+	}
+	return func(string, string) bool { return false }
+}
+
+func numericComparison(op string) func(a, b float64) bool {
+	switch op {
+	case "gt":
+		return func(a, b float64) bool { return a > b }
+	case "gte":
+		return func(a, b float64) bool { return a >= b }
+	case "lt":
+		return func(a, b float64) bool { return a < b }
+	}
+	return func(a, b float64) bool { return a <= b }
+}
+
+// regexLiterals registers the literals one of which occurs in every match of
+// pattern, returning their IDs, or nil if the pattern has no such set.
+func (c *compiler) regexLiterals(field, pattern string) []int {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	lits := requiredLiterals(re.Simplify())
+	if lits == nil {
+		return nil
+	}
+	ids := make([]int, len(lits))
+	for i, l := range lits {
+		if l.fold {
+			ids[i] = c.lits.add(field, matchFold, foldCase(l.text))
+		} else {
+			ids[i] = c.lits.add(field, matchExact, l.text)
+		}
+	}
+	return ids
+}
+
+type regexLiteral struct {
+	text string
+	fold bool // matched case-insensitively
+}
+
+// requiredLiterals returns literals one of which occurs in every string re
+// matches, or nil if it cannot tell. Literals shorter than two bytes are not
+// worth a prefilter and count as unknown.
+func requiredLiterals(re *syntax.Regexp) []regexLiteral {
+	switch re.Op {
+	case syntax.OpLiteral:
+		text := string(re.Rune)
+		if len(text) < 2 {
+			return nil
+		}
+		return []regexLiteral{{text, re.Flags&syntax.FoldCase != 0}}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Any part will do; prefer the one whose shortest literal is longest.
+		var best []regexLiteral
+		for _, sub := range re.Sub {
+			if l := requiredLiterals(sub); l != nil && (best == nil || shortest(l) > shortest(best)) {
+				best = l
+			}
+		}
+		return best
+	case syntax.OpAlternate:
+		var all []regexLiteral
+		for _, sub := range re.Sub {
+			l := requiredLiterals(sub)
+			if l == nil {
+				return nil
+			}
+			all = append(all, l...)
+		}
+		return all
+	}
+	return nil
+}
+
+func shortest(lits []regexLiteral) int {
+	n := len(lits[0].text)
+	for _, l := range lits[1:] {
+		n = min(n, len(l.text))
+	}
+	return n
+}
+
+// foldCase maps s to a canonical case, such that strings equal under the
+// Unicode simple case folding (?i) regexes use map to the same text.
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf {
+			if 'a' <= r && r <= 'z' {
+				r -= 'a' - 'A'
+			}
+			return r
+		}
+		canon := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			canon = min(canon, f)
+		}
+		return canon
+	}, s)
+}
+
+// ─── Literal prefilter ────────────────────────────────────────────────────────
+
+// literalTable collects the literals of every rule's contains and regex
+// conditions, grouped into one Aho-Corasick scanner per field and match
+// mode, so an event's field is searched for all literals in a single pass.
+type literalTable struct {
+	scanners []*literalScanner
+	byKey    map[scannerKey]int
+	owner    []int // literal ID → scanner
+}
+
+// matchMode is how a scanner compares literals with the field.
+type matchMode int
+
+const (
+	matchExact matchMode = iota
+	matchLower           // both sides lower-cased, as contains compares
+	matchFold            // both sides case-folded, as (?i) regexes compare
+)
+
+type scannerKey struct {
+	field string
+	mode  matchMode
+}
+
+type literalScanner struct {
+	scannerKey
+	literals []string
+	ids      []int // literal index → literal ID
+	index    map[string]int
+	ac       *ahoCorasick
+}
+
+func newLiteralTable() *literalTable {
+	return &literalTable{byKey: make(map[scannerKey]int)}
+}
+
+// add registers lit, already transformed for mode, and returns its ID.
+func (t *literalTable) add(field string, mode matchMode, lit string) int {
+	k := scannerKey{field, mode}
+	si, ok := t.byKey[k]
+	if !ok {
+		si = len(t.scanners)
+		t.byKey[k] = si
+		t.scanners = append(t.scanners, &literalScanner{scannerKey: k, index: make(map[string]int)})
+	}
+	sc := t.scanners[si]
+	if i, ok := sc.index[lit]; ok {
+		return sc.ids[i]
+	}
+	id := len(t.owner)
+	t.owner = append(t.owner, si)
+	sc.index[lit] = len(sc.literals)
+	sc.literals = append(sc.literals, lit)
+	sc.ids = append(sc.ids, id)
+	return id
+}
+
+// build compiles the scanners once every literal is registered.
+func (t *literalTable) build() {
+	for _, sc := range t.scanners {
+		sc.ac = newAhoCorasick(sc.literals)
+	}
+}
+
+// hit reports whether literal id occurs in the event, scanning its field on
+// first use.
+func (s *evalState) hit(id int) bool {
+	si := s.lits.owner[id]
+	if !s.scanned[si] {
+		s.scanned[si] = true
+		sc := s.lits.scanners[si]
+		text := fieldValue(s.ev, sc.field)
+		switch sc.mode {
+		case matchLower:
+			text = strings.ToLower(text)
+		case matchFold:
+			text = foldCase(text)
+		}
+		sc.ac.scan(text, func(i int) {
+			lid := sc.ids[i]
+			s.hits[lid/64] |= 1 << (lid % 64)
+		})
+	}
+	return s.hits[id/64]&(1<<(id%64)) != 0
+}
+
+func (s *evalState) anyHit(ids []int) bool {
+	for _, id := range ids {
+		if s.hit(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// ─── Rule set ─────────────────────────────────────────────────────────────────
+
+// ruleSet is the compiled form of the loaded rules. Rules that can only
+// match one source or category are indexed by it, so an event is tested
+// against the rules of its own source and category and the unindexed rest.
+type ruleSet struct {
+	rules      []compiledRule
+	sequences  []compiledSequence
+	lits       *literalTable
+	general    []int
+	bySource   map[string][]int
+	byCategory map[string][]int
+	regexes    map[string]*regexp.Regexp
+	pool       sync.Pool
+}
+
+type compiledRule struct {
+	Rule
+	match predicate
+	need  []int // literal IDs one of which must occur; nil if none
+}
+
+func newRuleSet() *ruleSet {
+	rs := &ruleSet{
+		lits:       newLiteralTable(),
+		bySource:   make(map[string][]int),
+		byCategory: make(map[string][]int),
+		regexes:    make(map[string]*regexp.Regexp),
+	}
+	rs.pool.New = func() any {
+		return &evalState{
+			lits:    rs.lits,
+			scanned: make([]bool, len(rs.lits.scanners)),
+			hits:    make([]uint64, len(rs.lits.owner)/64+1),
+		}
+	}
+	return rs
+}
+
+// add compiles r into the set. Call build once every rule is added.
+func (rs *ruleSet) add(r Rule) error {
+	c := &compiler{regex: rs.regex, lits: rs.lits}
+	if r.Sequence != nil {
+		if r.Aggregate != nil {
+			return fmt.Errorf("a sequence rule cannot aggregate")
+		}
+		return rs.addSequence(c, r)
+	}
+	if r.Aggregate != nil {
+		if err := r.Aggregate.Validate(); err != nil {
+			return err
+		}
+		if r.TimeWindow <= 0 {
+			return fmt.Errorf("aggregate needs a window")
+		}
+	}
+	match, need, err := c.compile(r.Condition)
+	if err != nil {
+		return err
+	}
+	i := len(rs.rules)
+	rs.rules = append(rs.rules, compiledRule{Rule: r, match: match, need: need})
+	if vals, ok := requiredValues(r.Condition, "source"); ok {
+		for _, v := range vals {
+			rs.bySource[v] = append(rs.bySource[v], i)
+		}
+	} else if vals, ok := requiredValues(r.Condition, "category"); ok {
+		for _, v := range vals {
+			rs.byCategory[v] = append(rs.byCategory[v], i)
+		}
+	} else {
+		rs.general = append(rs.general, i)
+	}
+	return nil
+}
+
+// addSequence compiles the steps of a correlation rule. Its tracker starts
+// empty; build hands it over from the previous set if the rule is unchanged.
+func (rs *ruleSet) addSequence(c *compiler, r Rule) error {
+	if err := r.Sequence.Validate(); err != nil {
+		return err
+	}
+	cs := compiledSequence{Rule: r, steps: make([]compiledStep, len(r.Sequence.Steps)), tracker: newSequenceTracker(r)}
+	for i, st := range r.Sequence.Steps {
+		match, need, err := c.node(st.Condition)
+		if err != nil {
+			return fmt.Errorf("step %s: %w", r.Sequence.label(i), err)
+		}
+		cs.steps[i] = compiledStep{match: match, need: need}
+	}
+	rs.sequences = append(rs.sequences, cs)
+	return nil
+}
+
+// adoptState carries the partial matches of sequence rules whose definition
+// did not change over from prev.
+func (rs *ruleSet) adoptState(prev *ruleSet) {
+	trackers := make(map[string]*sequenceTracker, len(prev.sequences))
+	for _, cs := range prev.sequences {
+		trackers[cs.ID] = cs.tracker
+	}
+	for i := range rs.sequences {
+		cs := &rs.sequences[i]
+		if t, ok := trackers[cs.ID]; ok && t.def == cs.tracker.def {
+			cs.tracker = t
+		}
+	}
+}
+
+func (rs *ruleSet) regex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := rs.regexes[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rs.regexes[pattern] = re
+	return re, nil
+}
+
+// build finishes the set; it is read-only afterwards.
+func (rs *ruleSet) build() {
+	rs.lits.build()
+	rs.regexes = nil
+}
+
+// state returns a clean evaluation state for ev; hand it back with release.
+func (rs *ruleSet) state(ev *models.Event, lookups LookupSource) *evalState {
+	s := rs.pool.Get().(*evalState)
+	s.ev, s.lookups = ev, lookups
+	return s
+}
+
+func (rs *ruleSet) release(s *evalState) {
+	s.ev, s.lookups = nil, nil
+	clear(s.scanned)
+	clear(s.hits)
+	rs.pool.Put(s)
+}
+
+// candidates returns, in load order, the indices of the rules ev can match.
+func (rs *ruleSet) candidates(s *evalState) []int {
+	s.cand = mergeSorted(s.cand[:0], rs.general,
+		rs.bySource[strings.ToLower(s.ev.Source)], rs.byCategory[strings.ToLower(s.ev.Category)])
+	return s.cand
+}
+
+// matches evaluates rule i, skipping it when none of its literals occur.
+func (rs *ruleSet) matches(s *evalState, i int) bool {
+	r := &rs.rules[i]
+	if r.need != nil && !s.anyHit(r.need) {
+		return false
+	}
+	return r.match(s)
+}
+
+func mergeSorted(dst []int, lists ...[]int) []int {
+	for {
+		best := -1
+		for i, l := range lists {
+			if len(l) > 0 && (best < 0 || l[0] < lists[best][0]) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return dst
+		}
+		dst = append(dst, lists[best][0])
+		lists[best] = lists[best][1:]
+	}
+}
+
+// requiredValues returns the lower-cased values field must hold for cond to
+// match, if cond constrains it to a fixed set through eq or in.
+func requiredValues(cond Condition, field string) ([]string, bool) {
+	if len(cond.Nested) > 0 {
+		switch strings.ToLower(cond.Logical) {
+		case "not":
+			return nil, false
+		case "or":
+			var all []string
+			for _, n := range cond.Nested {
+				vals, ok := requiredValues(n, field)
+				if !ok {
+					return nil, false
+				}
+				all = append(all, vals...)
+			}
+			return all, true
+		}
+		for _, n := range cond.Nested {
+			if vals, ok := requiredValues(n, field); ok {
+				return vals, true
+			}
+		}
+		return nil, false
+	}
+	if !strings.EqualFold(cond.Field, field) || cond.FieldRef != "" {
+		return nil, false
+	}
+	switch strings.ToLower(cond.Operator) {
+	case "eq":
+		if v, ok := cond.Value.(string); ok {
+			return []string{strings.ToLower(v)}, true
+		}
+	case "in":
+		if list, ok := toStrings(cond.Value); ok {
+			out := make([]string, len(list))
+			for i, v := range list {
+				out[i] = strings.ToLower(v)
+			}
+			return out, true
+		}
+	}
+	return nil, false
+}
@@ -6,8 +6,10 @@ import (
 )
 
 // dedupKey uniquely identifies an alert instance.
-// We combine ruleID + host so the same rule can still fire on different hosts.
+// We combine tenant + ruleID + host so the same rule can still fire on
+// different hosts, and one tenant's alert never suppresses another's.
 type dedupKey struct {
+	tenant string
 	ruleID string
 	host   string
 }
@@ -33,15 +35,15 @@ func NewDeduplicator(cooldown time.Duration) *Deduplicator {
 	return d
 }
 
-// Allow returns true when an alert for this rule+host should be emitted.
-// It returns false if an identical alert was already emitted within the
-// cooldown window.
-func (d *Deduplicator) Allow(ruleID, host string) bool {
+// Allow returns true when an alert for this rule+host of tenant should be
+// emitted. It returns false if an identical alert was already emitted
+// within the cooldown window.
+func (d *Deduplicator) Allow(tenant, ruleID, host string) bool {
 	if d.cooldown == 0 {
 		return true
 	}
 
-	key := dedupKey{ruleID: ruleID, host: host}
+	key := dedupKey{tenant: tenant, ruleID: ruleID, host: host}
 	now := time.Now()
 
 	d.mu.Lock()
@@ -57,12 +59,13 @@ func (d *Deduplicator) Allow(ruleID, host string) bool {
 	return true
 }
 
-// Reset forces the cooldown for a specific rule+host to expire immediately,
-// allowing the next matching event to fire regardless of the window.
-// Useful after a manual "acknowledge" so analysts can track recurrence.
-func (d *Deduplicator) Reset(ruleID, host string) {
+// Reset forces the cooldown for a specific rule+host of tenant to expire
+// immediately, allowing the next matching event to fire regardless of the
+// window. Useful after a manual "acknowledge" so analysts can track
+// recurrence.
+func (d *Deduplicator) Reset(tenant, ruleID, host string) {
 	d.mu.Lock()
-	delete(d.lastSeen, dedupKey{ruleID: ruleID, host: host})
+	delete(d.lastSeen, dedupKey{tenant: tenant, ruleID: ruleID, host: host})
 	d.mu.Unlock()
 }
 
@@ -85,3 +88,47 @@ func (d *Deduplicator) gc() {
 		}
 	}
 }
+
+// state returns the running cooldowns of ruleID, or of every rule if
+// ruleID is empty, as of now.
+func (d *Deduplicator) state(ruleID string, now time.Time) []stateItem {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var items []stateItem
+	for k, last := range d.lastSeen {
+		expires := last.Add(d.cooldown)
+		if ruleID != "" && k.ruleID != ruleID || !expires.After(now) {
+			continue
+		}
+		items = append(items, stateItem{
+			StateEntry: StateEntry{RuleID: k.ruleID, Kind: StateCooldown, Tenant: k.tenant, Key: []string{k.host},
+				Expires: expires, Detail: "alerts suppressed until " + expires.Format(time.RFC3339)},
+			data: cooldownState{Last: last},
+		})
+	}
+	return items
+}
+
+type cooldownState struct {
+	Last time.Time `json:"last"`
+}
+
+func (d *Deduplicator) restore(tenant, ruleID, host string, last time.Time) {
+	d.mu.Lock()
+	d.lastSeen[dedupKey{tenant: tenant, ruleID: ruleID, host: host}] = last
+	d.mu.Unlock()
+}
+
+// clearRule ends the cooldowns of ruleID and returns how many there were.
+func (d *Deduplicator) clearRule(ruleID string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for k := range d.lastSeen {
+		if k.ruleID == ruleID {
+			delete(d.lastSeen, k)
+			n++
+		}
+	}
+	return n
+}
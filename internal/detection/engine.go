@@ -3,8 +3,11 @@ package detection
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,13 +21,16 @@ type AlertHandler func(ctx context.Context, alert *models.Alert) error
 
 // Engine is the core detection processing unit.
 type Engine struct {
-	rules      []Rule
-	matcher    *Matcher
+	rules      *ruleSet
+	lookups    LookupSource
 	thresholds *ThresholdTracker
 	dedup      *Deduplicator
 	handler    AlertHandler
 	compliance *compliance.Manager
+	store      *sqlitestore.DB // where state is checkpointed; nil if it is not
 	mu         sync.RWMutex
+
+	checkpointMu sync.Mutex // orders checkpoints and clears
 }
 
 // NewEngine creates a new Detection Engine.
@@ -32,7 +38,7 @@ type Engine struct {
 // A good default is 5 minutes for high-volume rules; pass 0 to disable.
 func NewEngine(handler AlertHandler, comp *compliance.Manager) *Engine {
 	return &Engine{
-		matcher:    NewMatcher(),
+		rules:      newRuleSet(),
 		thresholds: NewThresholdTracker(),
 		// 5-minute cooldown: same rule won't spam alerts on the same host
 		dedup:      NewDeduplicator(5 * time.Minute),
@@ -41,21 +47,30 @@ func NewEngine(handler AlertHandler, comp *compliance.Manager) *Engine {
 	}
 }
 
-// LoadRules fetches enabled rules from the SQLite store.
+// SetLookups attaches the lookup tables used by in_lookup conditions.
+func (e *Engine) SetLookups(l LookupSource) {
+	e.mu.Lock()
+	e.lookups = l
+	e.mu.Unlock()
+}
+
+// LoadRules fetches enabled rules from the SQLite store and compiles them.
+// Rules whose condition does not parse or validate are left out; the error
+// lists them once the remaining rules are loaded.
 func (e *Engine) LoadRules(store *sqlitestore.DB) error {
 	records, err := store.ListRules(true)
 	if err != nil {
 		return fmt.Errorf("detection: list rules: %w", err)
 	}
 
-	var rules []Rule
+	rules := newRuleSet()
+	var rejected []error
 	for _, r := range records {
-		var cond Condition
+		var cond RuleCondition
 		if err := json.Unmarshal([]byte(r.Condition), &cond); err != nil {
-			log.Printf("detection: failed to parse rule %s condition: %v", r.ID, err)
+			rejected = append(rejected, fmt.Errorf("detection: rule %s: parse condition: %w", r.ID, err))
 			continue
 		}
-
 		threshold := r.Threshold
 		window := r.Window
 		if threshold < 1 {
@@ -65,46 +80,76 @@ func (e *Engine) LoadRules(store *sqlitestore.DB) error {
 			window = 0
 		}
 
-		rules = append(rules, Rule{
+		rule := Rule{
 			ID:             r.ID,
 			Name:           r.Name,
 			Severity:       models.Severity(r.Severity),
-			Condition:      cond,
+			Condition:      cond.Condition,
+			Sequence:       cond.Sequence,
+			Aggregate:      cond.Aggregate,
 			Threshold:      threshold,
 			TimeWindow:     window,
 			MITRE:          r.MITRE,
 			ResponseAction: r.ResponseAction,
 			ResponseParams: r.ResponseParams,
-		})
+			Tenant:         r.Tenant,
+		}
+		if err := rules.add(rule); err != nil {
+			rejected = append(rejected, fmt.Errorf("detection: rule %s: %w", r.ID, err))
+		}
 	}
+	rules.build()
 
 	e.mu.Lock()
+	rules.adoptState(e.rules)
 	e.rules = rules
 	e.mu.Unlock()
 
-	log.Printf("Detection Engine loaded %d rules", len(rules))
-	return nil
+	log.Printf("Detection Engine loaded %d rules and %d sequence rules", len(rules.rules), len(rules.sequences))
+	for _, err := range rejected {
+		log.Print(err)
+	}
+	return errors.Join(rejected...)
 }
 
-// ProcessEvent checks an event against all loaded rules.
+// ProcessEvent checks an event against the global rules and those of its
+// tenant, testing only the rules its source and category can match.
 func (e *Engine) ProcessEvent(ctx context.Context, ev *models.Event) {
 	e.mu.RLock()
-	defer e.mu.RUnlock()
+	rules, lookups := e.rules, e.lookups
+	e.mu.RUnlock()
+
+	s := rules.state(ev, lookups)
+	defer rules.release(s)
 
-	for _, rule := range e.rules {
-		if !e.matcher.Matches(ev, rule.Condition) {
+	tenant := models.TenantOrDefault(ev.Tenant)
+	for _, i := range rules.candidates(s) {
+		rule := &rules.rules[i].Rule
+		if rule.Tenant != "" && rule.Tenant != tenant {
+			continue
+		}
+		if !rules.matches(s, i) {
 			continue
 		}
 
 		// ── Threshold check ──────────────────────────────────────────────────
 		// Rules with threshold > 1 only fire once N hits accumulate within
 		// their time window.  After firing we clear the counter so the next
-		// wave also requires N hits.
-		if rule.Threshold > 1 && rule.TimeWindow > 0 {
-			if !e.thresholds.Record(rule.ID+ev.Host, rule.Threshold, time.Duration(rule.TimeWindow)*time.Second) {
+		// wave also requires N hits.  Aggregating rules measure their own
+		// value per group instead of counting hits per host.
+		var group []string
+		var value float64
+		if rule.Aggregate != nil {
+			var ok bool
+			if group, value, ok = e.aggregate(rule, tenant, ev); !ok {
+				continue
+			}
+		} else if rule.Threshold > 1 && rule.TimeWindow > 0 {
+			key := thresholdKey(rule.ID, tenant, ev.Host)
+			if !e.thresholds.Record(key, rule.Threshold, time.Duration(rule.TimeWindow)*time.Second) {
 				continue // not yet reached threshold
 			}
-			e.thresholds.Clear(rule.ID + ev.Host)
+			e.thresholds.Clear(key)
 		}
 
 		// ── Deduplication ────────────────────────────────────────────────────
@@ -112,8 +157,8 @@ func (e *Engine) ProcessEvent(ctx context.Context, ev *models.Event) {
 		// so one noisy log source can't generate thousands of identical alerts.
 		// Threshold-based rules skip dedup because the threshold itself already
 		// acts as the rate limiter.
-		if rule.Threshold <= 1 {
-			if !e.dedup.Allow(rule.ID, ev.Host) {
+		if rule.Threshold <= 1 && rule.Aggregate == nil {
+			if !e.dedup.Allow(tenant, rule.ID, ev.Host) {
 				continue // suppressed within cooldown window
 			}
 		}
@@ -130,6 +175,7 @@ func (e *Engine) ProcessEvent(ctx context.Context, ev *models.Event) {
 			Summary:   fmt.Sprintf("Rule '%s' triggered on host %s", rule.Name, ev.Host),
 			Status:    "open",
 			Metadata:  make(map[string]string),
+			Tenant:    tenant,
 		}
 
 		// Copy relevant event fields into alert metadata
@@ -139,14 +185,116 @@ func (e *Engine) ProcessEvent(ctx context.Context, ev *models.Event) {
 		if ev.Source != "" {
 			alert.Metadata["source"] = ev.Source
 		}
+		if agg := rule.Aggregate; agg != nil {
+			var on []string
+			for i, f := range agg.GroupBy {
+				alert.Metadata[f] = group[i]
+				on = append(on, f+"="+group[i])
+			}
+			alert.Metadata["aggregate"] = fmt.Sprintf("%s=%g", agg, value)
+			alert.Summary = fmt.Sprintf("Rule '%s': %s reached %g within %s", rule.Name, agg, value,
+				time.Duration(rule.TimeWindow)*time.Second)
+			if len(on) > 0 {
+				alert.Summary += " for " + strings.Join(on, ", ")
+			}
+		}
+
+		e.emit(ctx, rule, alert)
+	}
+
+	// ── Sequences ────────────────────────────────────────────────────────────
+	// Correlation rules keep their own state per join key and need neither
+	// thresholds nor dedup: an instance fires once and is then discarded.
+	for i := range rules.sequences {
+		cs := &rules.sequences[i]
+		if cs.Tenant != "" && cs.Tenant != tenant {
+			continue
+		}
+		for _, in := range cs.observe(s, tenant) {
+			e.emit(ctx, &cs.Rule, sequenceAlert(cs, in))
+		}
+	}
+}
+
+// aggregate records ev for an aggregating rule and returns the group and
+// its value once the value reaches the rule's threshold, clearing the group
+// for the next wave.
+func (e *Engine) aggregate(rule *Rule, tenant string, ev *models.Event) ([]string, float64, bool) {
+	agg := rule.Aggregate
+	group := make([]string, len(agg.GroupBy))
+	for i, f := range agg.GroupBy {
+		if group[i] = fieldValue(ev, f); group[i] == "" {
+			return nil, 0, false
+		}
+	}
+	var v string
+	if agg.Field != "" {
+		if v = fieldValue(ev, agg.Field); v == "" {
+			return nil, 0, false
+		}
+		if fn := agg.function(); fn == AggSum || fn == AggMax {
+			x, ok := toFloat(v)
+			if !ok {
+				return nil, 0, false
+			}
+			v = strconv.FormatFloat(x, 'g', -1, 64)
+		}
+	}
+	key := thresholdKey(rule.ID, tenant, group...)
+	value := e.thresholds.Add(key, agg.window(rule.TimeWindow), v, time.Now())
+	if value < float64(rule.Threshold) {
+		return nil, 0, false
+	}
+	e.thresholds.Clear(key)
+	return group, value, true
+}
+
+// emit enriches alert and hands it to the handler.
+func (e *Engine) emit(ctx context.Context, rule *Rule, alert *models.Alert) {
+	// MITRE enrichment via compliance manager
+	if rule.MITRE != "" && e.compliance != nil {
+		e.compliance.EnrichAlert(alert, rule.MITRE)
+	}
+
+	if err := e.handler(ctx, alert); err != nil {
+		log.Printf("detection: alert handler failed for rule %s: %v", rule.ID, err)
+	}
+}
 
-		// MITRE enrichment via compliance manager
-		if rule.MITRE != "" && e.compliance != nil {
-			e.compliance.EnrichAlert(alert, rule.MITRE)
+// Run fires the absence conditions of sequence rules as their windows close,
+// and checkpoints the detection state if it is persisted, until ctx is
+// done. Without it an absence is only noticed when the next event for the
+// same key arrives.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	checkpoint := time.NewTicker(stateCheckpointInterval)
+	defer checkpoint.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.sweepSequences(ctx, now)
+		case <-checkpoint.C:
+			if err := e.Checkpoint(); err != nil {
+				log.Print(err)
+			}
 		}
+	}
+}
+
+// sweepSequences drops the sequence instances that expired by now and
+// fires the absences among them.
+func (e *Engine) sweepSequences(ctx context.Context, now time.Time) {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
 
-		if err := e.handler(ctx, alert); err != nil {
-			log.Printf("detection: alert handler failed for rule %s: %v", rule.ID, err)
+	for i := range rules.sequences {
+		cs := &rules.sequences[i]
+		for _, in := range cs.tracker.sweep(now) {
+			e.emit(ctx, &cs.Rule, sequenceAlert(cs, in))
 		}
 	}
 }
@@ -160,5 +308,5 @@ func (e *Engine) ReloadRules(store *sqlitestore.DB) error {
 func (e *Engine) RuleCount() int {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
-	return len(e.rules)
+	return len(e.rules.rules) + len(e.rules.sequences)
 }
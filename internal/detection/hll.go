@@ -0,0 +1,128 @@
+package detection
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// HyperLogLog parameters: 2^10 registers give a standard error of about 3%
+// in 1 KiB. Small sketches hold the hashes themselves instead, counting up
+// to 128 distinct values exactly in the same space.
+const (
+	hllP         = 10
+	hllM         = 1 << hllP
+	hllSparseMax = hllM / 8
+)
+
+// hyperLogLog estimates the number of distinct strings added to it.
+type hyperLogLog struct {
+	sparse []uint64 // distinct hashes, while dense is nil
+	dense  []uint8  // rank per register
+}
+
+// hllHash hashes s with FNV-1a and a 64-bit finalizer, which spreads
+// similar strings across registers. It does not vary between processes, so
+// sketches can be saved and restored.
+func hllHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h *hyperLogLog) add(s string) {
+	x := hllHash(s)
+	if h.dense != nil {
+		h.set(x)
+		return
+	}
+	if slices.Contains(h.sparse, x) {
+		return
+	}
+	if len(h.sparse) < hllSparseMax {
+		h.sparse = append(h.sparse, x)
+		return
+	}
+	h.dense = make([]uint8, hllM)
+	for _, y := range h.sparse {
+		h.set(y)
+	}
+	h.sparse = nil
+	h.set(x)
+}
+
+// register returns the register and rank of hash x.
+func register(x uint64) (int, uint8) {
+	return int(x >> (64 - hllP)), uint8(bits.LeadingZeros64(x<<hllP|1<<(hllP-1))) + 1
+}
+
+func (h *hyperLogLog) set(x uint64) {
+	i, rank := register(x)
+	h.dense[i] = max(h.dense[i], rank)
+}
+
+// mergeInto raises regs to the sketch's registers.
+func (h *hyperLogLog) mergeInto(regs *[hllM]uint8) {
+	for i, r := range h.dense {
+		regs[i] = max(regs[i], r)
+	}
+	for _, x := range h.sparse {
+		i, rank := register(x)
+		regs[i] = max(regs[i], rank)
+	}
+}
+
+func (h *hyperLogLog) estimate() float64 {
+	return hllUnion([]*hyperLogLog{h})
+}
+
+// hllUnion estimates the distinct values added to any of sketches: exactly
+// while they are all sparse.
+func hllUnion(sketches []*hyperLogLog) float64 {
+	if len(sketches) == 1 && sketches[0].dense == nil {
+		return float64(len(sketches[0].sparse))
+	}
+	exact := make(map[uint64]struct{})
+	for _, h := range sketches {
+		if h.dense != nil {
+			exact = nil
+			break
+		}
+		for _, x := range h.sparse {
+			exact[x] = struct{}{}
+		}
+	}
+	if exact != nil {
+		return float64(len(exact))
+	}
+	var regs [hllM]uint8
+	for _, h := range sketches {
+		h.mergeInto(&regs)
+	}
+	return hllEstimate(&regs)
+}
+
+// hllEstimate is the HyperLogLog estimate with linear counting for small
+// cardinalities; a 64-bit hash needs no large-range correction.
+func hllEstimate(regs *[hllM]uint8) float64 {
+	sum, zeros := 0.0, 0
+	for _, r := range regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	const m = float64(hllM)
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return math.Round(e)
+}
@@ -1,22 +1,137 @@
 package detection
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/netip"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
 )
 
 // Condition represents a single rule condition.
+//
+// A leaf compares the event field Field against Value, or against the event
+// field FieldRef when set. Operators:
+//
+//	eq, neq                 equality; numerically when Value is a number
+//	gt, gte, lt, lte        numeric comparison, both sides coerced to numbers
+//	contains, startswith,
+//	endswith, regex         string matching
+//	in, not_in              membership of the array Value
+//	cidr                    the field is an address inside Value, a CIDR,
+//	                        single address or array of either
+//	exists, missing         the field is set (non-empty) or not; no Value
+//	in_lookup,
+//	not_in_lookup           membership of the lookup table named by Value
+//
+// Case is "sensitive" or "insensitive" and overrides the operator's default:
+// eq, neq, in and not_in are case-sensitive, contains, startswith and
+// endswith are not, and regex follows its pattern.
+//
+// A group combines Nested with Logical: "and" (the default), "or", or "not",
+// which matches when the AND of its nested conditions does not.
 type Condition struct {
 	Field    string      `json:"field"`
-	Operator string      `json:"operator"` // eq, contains, regex, gt, lt
+	Operator string      `json:"operator"`
 	Value    interface{} `json:"value"`
-	Logical  string      `json:"logical"` // and, or (for nested conditions)
+	FieldRef string      `json:"field_ref,omitempty"`
+	Case     string      `json:"case,omitempty"`
+	Logical  string      `json:"logical"` // and, or, not (for nested conditions)
 	Nested   []Condition `json:"nested"`
 }
 
+// Validate reports the first problem that would keep the condition from
+// matching as written: an unknown operator or combinator, or a value the
+// operator cannot use.
+func (c Condition) Validate() error {
+	if len(c.Nested) > 0 {
+		switch strings.ToLower(c.Logical) {
+		case "", "and", "or", "not":
+		default:
+			return fmt.Errorf("unknown logical %q", c.Logical)
+		}
+		for _, n := range c.Nested {
+			if err := n.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if c.Field == "" {
+		return fmt.Errorf("condition without a field")
+	}
+	switch strings.ToLower(c.Case) {
+	case "", "sensitive", "insensitive":
+	default:
+		return fmt.Errorf("field %s: unknown case %q", c.Field, c.Case)
+	}
+
+	op := strings.ToLower(c.Operator)
+	_, isString := c.Value.(string)
+	var err error
+	switch op {
+	case "exists", "missing":
+		return nil
+	case "eq", "neq":
+		if c.FieldRef == "" && c.Value == nil {
+			err = fmt.Errorf("requires a value")
+		}
+	case "contains", "startswith", "endswith":
+		if c.FieldRef == "" && !isString {
+			err = fmt.Errorf("requires a string value")
+		}
+	case "regex":
+		if !isString {
+			err = fmt.Errorf("requires a string pattern")
+		} else {
+			_, err = regexp.Compile(c.Value.(string))
+		}
+	case "gt", "gte", "lt", "lte":
+		if _, ok := toFloat(c.Value); c.FieldRef == "" && !ok {
+			err = fmt.Errorf("requires a numeric value, got %v", c.Value)
+		}
+	case "in", "not_in":
+		if _, ok := toStrings(c.Value); !ok || c.FieldRef != "" {
+			err = fmt.Errorf("requires an array value")
+		}
+	case "cidr":
+		var nets []string
+		if nets, err = cidrList(c.Value); err == nil {
+			for _, n := range nets {
+				if _, err = parsePrefix(n); err != nil {
+					break
+				}
+			}
+		}
+	case "in_lookup", "not_in_lookup":
+		if !isString {
+			err = fmt.Errorf("requires a lookup table name")
+		}
+	default:
+		return fmt.Errorf("field %s: unknown operator %q", c.Field, c.Operator)
+	}
+	if err != nil {
+		return fmt.Errorf("field %s: %s: %w", c.Field, op, err)
+	}
+	return nil
+}
+
+// caseSensitive reports whether string comparison honours case, given the
+// operator's default.
+func (c *Condition) caseSensitive(def bool) bool {
+	switch strings.ToLower(c.Case) {
+	case "sensitive":
+		return true
+	case "insensitive":
+		return false
+	}
+	return def
+}
+
 // Rule is the internal representation of a detection rule.
 type Rule struct {
 	ID             string
@@ -28,11 +143,34 @@ type Rule struct {
 	MITRE          string
 	ResponseAction string
 	ResponseParams string
+	Tenant         string     // "" applies the rule to every tenant's events
+	Sequence       *Sequence  // set for correlation rules, which have no Condition
+	Aggregate      *Aggregate // what Threshold applies to; nil counts matches per host
+}
+
+// RuleCondition is the stored form of a rule's condition: the condition
+// itself, optionally with an aggregate for the rule's threshold, or a
+// sequence in place of both for correlation rules.
+type RuleCondition struct {
+	Condition
+	Aggregate *Aggregate `json:"aggregate,omitempty"`
+	Sequence  *Sequence  `json:"sequence,omitempty"`
 }
 
-// Matcher evaluates conditions against events.
+// LookupSource answers in_lookup conditions: whether value is a key of the
+// named lookup table as tenant sees it, its own table or else the global
+// one.
+type LookupSource interface {
+	Contains(tenant, table, value string) bool
+}
+
+// Matcher evaluates conditions against events one at a time, compiling
+// each condition on the fly. The engine evaluates its loaded rules through
+// a compiled rule set instead.
 type Matcher struct {
+	mu         sync.Mutex
 	regexCache map[string]*regexp.Regexp
+	lookups    LookupSource
 }
 
 func NewMatcher() *Matcher {
@@ -41,55 +179,18 @@ func NewMatcher() *Matcher {
 	}
 }
 
-// Matches returns true if the event satisfies the rule's condition.
+// Matches returns true if the event satisfies the rule's condition. An
+// invalid condition matches nothing.
 func (m *Matcher) Matches(ev *models.Event, cond Condition) bool {
-	// If nested conditions exist, handle logical grouping
-	if len(cond.Nested) > 0 {
-		if strings.ToLower(cond.Logical) == "or" {
-			for _, n := range cond.Nested {
-				if m.Matches(ev, n) {
-					return true
-				}
-			}
-			return false
-		}
-		// Default to AND
-		for _, n := range cond.Nested {
-			if !m.Matches(ev, n) {
-				return false
-			}
-		}
-		return true
-	}
-
-	// Basic field matching
-	val := m.getFieldValue(ev, cond.Field)
-	if val == "" {
-		return false
-	}
-
-	targetValue, ok := cond.Value.(string)
-	if !ok {
+	c := &compiler{regex: m.getRegex}
+	match, _, err := c.compile(cond)
+	if err != nil {
 		return false
 	}
-
-	switch strings.ToLower(cond.Operator) {
-	case "eq":
-		return val == targetValue
-	case "contains":
-		return strings.Contains(strings.ToLower(val), strings.ToLower(targetValue))
-	case "regex":
-		re, err := m.getRegex(targetValue)
-		if err != nil {
-			return false
-		}
-		return re.MatchString(val)
-	}
-
-	return false
+	return match(&evalState{ev: ev, lookups: m.lookups})
 }
 
-func (m *Matcher) getFieldValue(ev *models.Event, field string) string {
+func fieldValue(ev *models.Event, field string) string {
 	switch strings.ToLower(field) {
 	case "message":
 		return ev.Message
@@ -125,6 +226,8 @@ func (m *Matcher) getFieldValue(ev *models.Event, field string) string {
 }
 
 func (m *Matcher) getRegex(pattern string) (*regexp.Regexp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if re, ok := m.regexCache[pattern]; ok {
 		return re, nil
 	}
@@ -135,3 +238,69 @@ func (m *Matcher) getRegex(pattern string) (*regexp.Regexp, error) {
 	m.regexCache[pattern] = re
 	return re, nil
 }
+
+// toFloat coerces a number or numeric string to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// toStrings converts an array value, as decoded from JSON, to strings.
+func toStrings(v interface{}) ([]string, bool) {
+	switch list := v.(type) {
+	case []string:
+		return list, true
+	case []interface{}:
+		out := make([]string, len(list))
+		for i, e := range list {
+			switch e := e.(type) {
+			case string:
+				out[i] = e
+			case float64:
+				out[i] = strconv.FormatFloat(e, 'f', -1, 64)
+			default:
+				out[i] = fmt.Sprint(e)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// cidrList returns the networks of a cidr condition value.
+func cidrList(v interface{}) ([]string, error) {
+	if s, ok := v.(string); ok {
+		return []string{s}, nil
+	}
+	if list, ok := toStrings(v); ok && len(list) > 0 {
+		return list, nil
+	}
+	return nil, fmt.Errorf("requires a network or array of networks")
+}
+
+// parsePrefix accepts a CIDR or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network %q", s)
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
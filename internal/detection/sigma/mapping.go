@@ -0,0 +1,156 @@
+package sigma
+
+import (
+	"strings"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/detection"
+)
+
+// Logsource is the logsource section of a Sigma rule.
+type Logsource struct {
+	Product  string `yaml:"product" json:"product,omitempty"`
+	Category string `yaml:"category" json:"category,omitempty"`
+	Service  string `yaml:"service" json:"service,omitempty"`
+}
+
+// LogsourceMapping restricts the rules of a Sigma logsource to OBLIVRA
+// events of the given sources and categories. Empty Logsource fields match
+// any value; when both Sources and Categories are set an event must match
+// both.
+type LogsourceMapping struct {
+	Logsource
+	Sources    []string `json:"sources,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// Mapping tells the converter how Sigma logsources and field names
+// correspond to OBLIVRA events.
+type Mapping struct {
+	Logsources []LogsourceMapping `json:"logsources"`
+	// Fields maps Sigma field names to event fields; fields not listed
+	// keep their name.
+	Fields map[string]string `json:"fields"`
+	// KeywordField is the field keyword selections search, "message" if
+	// empty.
+	KeywordField string `json:"keyword_field,omitempty"`
+}
+
+// DefaultMapping returns the mapping for events as OBLIVRA's collectors and
+// agents produce them.
+func DefaultMapping() *Mapping {
+	ls := func(product, service, category string, sources, categories []string) LogsourceMapping {
+		return LogsourceMapping{
+			Logsource:  Logsource{Product: product, Service: service, Category: category},
+			Sources:    sources,
+			Categories: categories,
+		}
+	}
+	return &Mapping{
+		Logsources: []LogsourceMapping{
+			ls("linux", "sshd", "", []string{"sshd"}, nil),
+			ls("linux", "auth", "", []string{"sshd", "sudo", "su", "login"}, nil),
+			ls("linux", "sudo", "", []string{"sudo"}, nil),
+			ls("linux", "auditd", "", []string{"auditd"}, nil),
+			ls("linux", "cron", "", []string{"cron", "crond"}, nil),
+			ls("linux", "syslog", "", []string{"syslog"}, nil),
+			ls("windows", "powershell", "", []string{"powershell"}, nil),
+			ls("windows", "sysmon", "", []string{"sysmon"}, nil),
+			ls("", "", "process_creation", nil, []string{"Process_Create"}),
+			ls("", "", "process_access", nil, []string{"Process_Access"}),
+			ls("", "", "network_connection", nil, []string{"Network_Connect", "network"}),
+			ls("", "", "file_event", nil, []string{"File_Write", "File_Modify", "File Integrity"}),
+			ls("", "", "file_change", nil, []string{"File_Modify", "File Integrity"}),
+			ls("", "", "file_delete", nil, []string{"File_Delete"}),
+			ls("", "", "authentication", nil, []string{"Authentication_Success", "Authentication_Failure"}),
+			ls("", "", "firewall", []string{"netflow", "firewall"}, nil),
+			ls("", "", "webserver", []string{"nginx", "apache", "httpd", "iis"}, nil),
+		},
+		Fields: map[string]string{
+			"Image":             "image",
+			"CommandLine":       "command_line",
+			"ParentImage":       "parent_image",
+			"ParentCommandLine": "parent_command_line",
+			"TargetImage":       "target",
+			"TargetFilename":    "target_filename",
+			"User":              "user",
+			"Computer":          "host",
+			"ComputerName":      "host",
+			"Hostname":          "host",
+			"SourceIp":          "src_ip",
+			"SourcePort":        "src_port",
+			"DestinationIp":     "dest_ip",
+			"DestinationPort":   "dest_port",
+			"dst_ip":            "dest_ip",
+			"dst_port":          "dest_port",
+			"EventID":           "event_id",
+			"Hashes":            "hashes",
+			"Message":           "message",
+		},
+	}
+}
+
+// field returns the event field for a Sigma field name.
+func (m *Mapping) field(name string) string {
+	if f, ok := m.Fields[name]; ok {
+		return f
+	}
+	return name
+}
+
+func (m *Mapping) keywordField() string {
+	if m.KeywordField != "" {
+		return m.KeywordField
+	}
+	return "message"
+}
+
+// logsource returns the condition restricting a rule to its logsource's
+// events: from the matching entry naming the most logsource fields, nil if
+// that entry restricts nothing. ok is false if no entry matches.
+func (m *Mapping) logsource(ls Logsource) (cond *detection.Condition, ok bool) {
+	var best *LogsourceMapping
+	bestScore := -1
+	for i := range m.Logsources {
+		e := &m.Logsources[i]
+		score := 0
+		matches := true
+		for _, f := range [][2]string{{e.Product, ls.Product}, {e.Service, ls.Service}, {e.Category, ls.Category}} {
+			if f[0] == "" {
+				continue
+			}
+			if !strings.EqualFold(f[0], f[1]) {
+				matches = false
+				break
+			}
+			score++
+		}
+		if matches && score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best == nil || bestScore == 0 {
+		return nil, false
+	}
+
+	in := func(field string, values []string) detection.Condition {
+		list := make([]interface{}, len(values))
+		for i, v := range values {
+			list[i] = v
+		}
+		return detection.Condition{Field: field, Operator: "in", Value: list, Case: "insensitive"}
+	}
+	var parts []detection.Condition
+	if len(best.Sources) > 0 {
+		parts = append(parts, in("source", best.Sources))
+	}
+	if len(best.Categories) > 0 {
+		parts = append(parts, in("category", best.Categories))
+	}
+	switch len(parts) {
+	case 0:
+		return nil, true
+	case 1:
+		return &parts[0], true
+	}
+	return &detection.Condition{Logical: "and", Nested: parts}, true
+}
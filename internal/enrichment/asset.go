@@ -21,8 +21,8 @@ func NewAssetEnricher(store *sqlitestore.DB) *AssetEnricher {
 func (e *AssetEnricher) Name() string { return "Asset" }
 
 func (e *AssetEnricher) Enrich(ctx context.Context, ev *models.Event) error {
-	// Lookup host in assets table
-	asset, err := e.store.GetAssetByHost(ev.Host)
+	// Lookup host in the tenant's assets, then the global ones
+	asset, err := e.store.GetAssetByHost(models.TenantOrDefault(ev.Tenant), ev.Host)
 	if err != nil {
 		return nil // Ignore lookup errors
 	}
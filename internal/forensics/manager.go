@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
 )
@@ -35,6 +36,7 @@ type Manager struct {
 	// Ed25519 signing key — generated once, persisted to disk
 	privKey ed25519.PrivateKey
 	pubKey  ed25519.PublicKey
+	wrapper KeyWrapper // seals privKey at rest when set
 }
 
 // NewManager creates a new Forensics Manager.
@@ -192,6 +194,15 @@ func (m *Manager) VerifyBlock(block *sqlitestore.IntegrityBlockRecord) (bool, er
 	return true, nil
 }
 
+// Sign signs data with the Ed25519 key, for artefacts that leave the
+// system such as dataset export manifests.
+func (m *Manager) Sign(data []byte) ([]byte, error) {
+	if m.privKey == nil {
+		return nil, fmt.Errorf("forensics: no signing key loaded")
+	}
+	return ed25519.Sign(m.privKey, data), nil
+}
+
 // PublicKeyHex returns the hex-encoded Ed25519 public key for external auditors.
 func (m *Manager) PublicKeyHex() string {
 	if m.pubKey == nil {
@@ -202,49 +213,116 @@ func (m *Manager) PublicKeyHex() string {
 
 // ── Key management ────────────────────────────────────────────────────────────
 
+// KeyWrapper seals the signing key at rest; *keyring.Keyring implements it.
+type KeyWrapper interface {
+	Seal(purpose string, plaintext []byte) ([]byte, error)
+	Open(purpose string, sealed []byte) ([]byte, error)
+}
+
+// SetKeyWrapper stores the signing key sealed by w instead of as a
+// plaintext PEM file. It must be called before Start; an existing PEM key
+// is sealed and removed on Start.
+func (m *Manager) SetKeyWrapper(w KeyWrapper) {
+	m.wrapper = w
+}
+
 func (m *Manager) loadOrGenerateKey() error {
 	home, _ := os.UserHomeDir()
 	keyDir := filepath.Join(home, ".oblivra", "keys")
 	privPath := filepath.Join(keyDir, "forensics_ed25519.pem")
+	sealedPath := filepath.Join(keyDir, "forensics_ed25519.key.enc")
 	pubPath := filepath.Join(keyDir, "forensics_ed25519_pub.pem")
 
 	if err := os.MkdirAll(keyDir, 0o700); err != nil {
 		return err
 	}
 
+	// Sealed key, when storage encryption is on
+	if data, err := os.ReadFile(sealedPath); err == nil {
+		if m.wrapper == nil {
+			return fmt.Errorf("%s is sealed but storage encryption is disabled", sealedPath)
+		}
+		raw, err := m.wrapper.Open(keyring.PurposeForensics, data)
+		if err != nil {
+			return fmt.Errorf("unseal signing key: %w", err)
+		}
+		if len(raw) != ed25519.PrivateKeySize {
+			return fmt.Errorf("%s holds a malformed key", sealedPath)
+		}
+		m.setKey(ed25519.PrivateKey(raw))
+		log.Printf("Forensics: loaded sealed Ed25519 signing key from %s", sealedPath)
+		return nil
+	}
+
 	// Try loading existing key
 	if data, err := os.ReadFile(privPath); err == nil {
 		block, _ := pem.Decode(data)
 		if block != nil && block.Type == "ED25519 PRIVATE KEY" && len(block.Bytes) == ed25519.PrivateKeySize {
-			m.privKey = ed25519.PrivateKey(block.Bytes)
-			m.pubKey = m.privKey.Public().(ed25519.PublicKey)
+			m.setKey(ed25519.PrivateKey(block.Bytes))
 			log.Printf("Forensics: loaded Ed25519 signing key from %s", privPath)
+			if m.wrapper != nil {
+				if err := m.sealKey(sealedPath); err != nil {
+					return err
+				}
+				if err := os.Remove(privPath); err != nil {
+					return fmt.Errorf("remove plaintext key: %w", err)
+				}
+				log.Printf("Forensics: sealed signing key to %s", sealedPath)
+			}
 			return nil
 		}
 	}
 
 	// Generate new key pair
-	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return fmt.Errorf("generate ed25519: %w", err)
 	}
-	m.privKey = priv
-	m.pubKey = pub
+	m.setKey(priv)
 
 	// Persist private key
-	privBlock := &pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: []byte(priv)}
-	if err := os.WriteFile(privPath, pem.EncodeToMemory(privBlock), 0o600); err != nil {
-		return fmt.Errorf("write private key: %w", err)
+	if m.wrapper != nil {
+		if err := m.sealKey(sealedPath); err != nil {
+			return err
+		}
+		privPath = sealedPath
+	} else {
+		privBlock := &pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: []byte(priv)}
+		if err := os.WriteFile(privPath, pem.EncodeToMemory(privBlock), 0o600); err != nil {
+			return fmt.Errorf("write private key: %w", err)
+		}
 	}
 
 	// Persist public key (for external auditors)
-	pubBlock := &pem.Block{Type: "ED25519 PUBLIC KEY", Bytes: []byte(pub)}
+	pubBlock := &pem.Block{Type: "ED25519 PUBLIC KEY", Bytes: []byte(m.pubKey)}
 	if err := os.WriteFile(pubPath, pem.EncodeToMemory(pubBlock), 0o644); err != nil {
 		log.Printf("forensics: warning — could not write public key: %v", err)
 	}
 
 	log.Printf("Forensics: generated new Ed25519 signing key at %s", privPath)
-	log.Printf("Forensics: public key for auditors: %s", hex.EncodeToString(pub))
+	log.Printf("Forensics: public key for auditors: %s", hex.EncodeToString(m.pubKey))
+	return nil
+}
+
+func (m *Manager) setKey(priv ed25519.PrivateKey) {
+	m.privKey = priv
+	m.pubKey = priv.Public().(ed25519.PublicKey)
+}
+
+// sealKey writes the signing key sealed by the wrapper to path.
+func (m *Manager) sealKey(path string) error {
+	sealed, err := m.wrapper.Seal(keyring.PurposeForensics, m.privKey)
+	if err != nil {
+		return fmt.Errorf("seal signing key: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0o600); err != nil {
+		return fmt.Errorf("write sealed key: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write sealed key: %w", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,140 @@
+package hunting_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/hunting"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+func tmpEngine(t *testing.T) (*storage.Engine, func()) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "hunting-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Storage: config.StorageConfig{BasePath: dir, Retention: 30}}
+	eng, err := storage.Open(context.Background(), cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return eng, func() {
+		eng.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	base := time.Date(2026, 3, 6, 10, 7, 30, 0, time.UTC) // a Friday
+	cases := []struct {
+		sc   models.SearchSchedule
+		want time.Time
+	}{
+		{models.SearchSchedule{Interval: 300}, base.Add(5 * time.Minute)},
+		{models.SearchSchedule{Cron: "*/15 * * * *"}, time.Date(2026, 3, 6, 10, 15, 0, 0, time.UTC)},
+		{models.SearchSchedule{Cron: "@daily"}, time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{models.SearchSchedule{Cron: "30 9 * * mon-fri"}, time.Date(2026, 3, 9, 9, 30, 0, 0, time.UTC)},
+		{models.SearchSchedule{Cron: "0 0 1 jan *"}, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		got, err := hunting.NextRun(&c.sc, base)
+		if err != nil {
+			t.Fatalf("%+v: %v", c.sc, err)
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("%q/%d: next = %s, want %s", c.sc.Cron, c.sc.Interval, got, c.want)
+		}
+	}
+	if _, err := hunting.NextRun(&models.SearchSchedule{Cron: "61 * * * *"}, base); err == nil {
+		t.Error("expected error for out-of-range minute")
+	}
+}
+
+func TestScheduledSearchRaisesAlert(t *testing.T) {
+	eng, cleanup := tmpEngine(t)
+	defer cleanup()
+	ctx := context.Background()
+	now := time.Now()
+
+	for i, host := range []string{"web-01", "web-01", "web-01", "web-02"} {
+		ev := &models.Event{ID: uuid.NewString(), Timestamp: now.Add(-time.Duration(i+1) * time.Minute),
+			Host: host, Source: "sshd", Severity: models.SeverityMedium, Message: "Failed password for root"}
+		if err := eng.WriteEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Outside the 10 minute window.
+	old := &models.Event{ID: uuid.NewString(), Timestamp: now.Add(-time.Hour),
+		Host: "web-02", Source: "sshd", Severity: models.SeverityMedium, Message: "Failed password for root"}
+	if err := eng.WriteEvent(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	m := hunting.NewManager(eng.SQLite)
+	ss, err := m.SaveSearch("", "brute force", `source=sshd "Failed password" | stats count by host`, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.SetSchedule(ss.ID, models.SearchSchedule{Interval: 30}); err == nil {
+		t.Error("expected interval below one minute to be rejected")
+	}
+	if _, err := m.SetSchedule(ss.ID, models.SearchSchedule{Cron: "0 0 31 2 *"}); err == nil {
+		t.Error("expected a cron expression that never fires to be rejected")
+	}
+	_, err = m.SetSchedule(ss.ID, models.SearchSchedule{
+		Interval: 300, Window: 600, Throttle: 3600, Severity: models.SeverityHigh, Enabled: true,
+		Trigger: models.SearchTrigger{Type: "field", Field: "count", Op: "gte", Threshold: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var alerts []*models.Alert
+	s := hunting.NewScheduler(m, eng, func(_ context.Context, a *models.Alert) error {
+		alerts = append(alerts, a)
+		return nil
+	})
+
+	ss, err = m.GetSearch(ss.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := s.Execute(ctx, ss, now)
+	if run.Error != "" || !run.Triggered || run.Hits != 2 {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("want 1 alert, got %d", len(alerts))
+	}
+	a := alerts[0]
+	if a.Severity != models.SeverityHigh || a.Host != "web-01" || a.ID != run.AlertID {
+		t.Errorf("unexpected alert %+v", a)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal([]byte(a.Metadata["results"]), &rows); err != nil || len(rows) != 1 || rows[0]["count"] != float64(3) {
+		t.Errorf("results = %s (%v)", a.Metadata["results"], err)
+	}
+
+	// The second run falls inside the throttle.
+	run = s.Execute(ctx, ss, now.Add(5*time.Minute))
+	if !run.Triggered || !run.Suppressed || len(alerts) != 1 {
+		t.Errorf("expected throttled run, got %+v", run)
+	}
+
+	runs, err := m.ListRuns(ss.ID, 10)
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("ListRuns: %d runs, %v", len(runs), err)
+	}
+	ss, _ = m.GetSearch(ss.ID)
+	if ss.Schedule.LastAlertAt.Unix() != now.Unix() {
+		t.Errorf("LastAlertAt = %s", ss.Schedule.LastAlertAt)
+	}
+}
@@ -19,17 +19,18 @@ func NewManager(store *sqlitestore.DB) *Manager {
 	return &Manager{store: store}
 }
 
-// SaveSearch persists a new hunting query.
-func (m *Manager) SaveSearch(name, query, user string) (*models.SavedSearch, error) {
+// SaveSearch persists a new hunting query over the events of tenant.
+func (m *Manager) SaveSearch(tenant, name, query, user string) (*models.SavedSearch, error) {
 	s := &models.SavedSearch{
 		ID:        uuid.NewString(),
 		Name:      name,
 		Query:     query,
 		CreatedBy: user,
 		CreatedAt: time.Now(),
+		Tenant:    models.TenantOrDefault(tenant),
 	}
 
-	err := m.store.InsertSavedSearch(s.ID, s.Name, s.Query, s.CreatedBy, s.CreatedAt.Unix())
+	err := m.store.InsertSavedSearch(s)
 	if err != nil {
 		return nil, fmt.Errorf("hunting: failed to save search: %w", err)
 	}
@@ -37,7 +38,59 @@ func (m *Manager) SaveSearch(name, query, user string) (*models.SavedSearch, err
 	return s, nil
 }
 
-// ListSearches returns all saved hunting queries.
-func (m *Manager) ListSearches() ([]*models.SavedSearch, error) {
-	return m.store.ListSavedSearches()
+// ListSearches returns the saved hunting queries of tenant ("" for all)
+// with their schedules.
+func (m *Manager) ListSearches(tenant string) ([]*models.SavedSearch, error) {
+	searches, err := m.store.ListSavedSearches(tenant)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := m.store.ListSearchSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("hunting: failed to load schedules: %w", err)
+	}
+	for _, s := range searches {
+		s.Schedule = schedules[s.ID]
+	}
+	return searches, nil
+}
+
+// GetSearch returns one saved search with its schedule.
+func (m *Manager) GetSearch(id string) (*models.SavedSearch, error) {
+	s, err := m.store.GetSavedSearch(id)
+	if err != nil {
+		return nil, fmt.Errorf("hunting: search %s: %w", id, err)
+	}
+	schedules, err := m.store.ListSearchSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("hunting: failed to load schedules: %w", err)
+	}
+	s.Schedule = schedules[id]
+	return s, nil
+}
+
+// SetSchedule validates and stores the schedule of a saved search. Run
+// state (last run, last alert) survives edits.
+func (m *Manager) SetSchedule(id string, sc models.SearchSchedule) (*models.SearchSchedule, error) {
+	if _, err := m.store.GetSavedSearch(id); err != nil {
+		return nil, fmt.Errorf("hunting: search %s: %w", id, err)
+	}
+	if err := validateSchedule(&sc); err != nil {
+		return nil, err
+	}
+	sc.UpdatedAt = time.Now()
+	if err := m.store.UpsertSearchSchedule(id, &sc); err != nil {
+		return nil, fmt.Errorf("hunting: failed to save schedule: %w", err)
+	}
+	return &sc, nil
+}
+
+// RemoveSchedule turns a scheduled search back into a plain saved search.
+func (m *Manager) RemoveSchedule(id string) error {
+	return m.store.DeleteSearchSchedule(id)
+}
+
+// ListRuns returns the run history of a scheduled search, newest first.
+func (m *Manager) ListRuns(id string, limit int) ([]*models.SearchRun, error) {
+	return m.store.ListSearchRuns(id, limit)
 }
@@ -0,0 +1,355 @@
+package hunting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/query"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+const (
+	schedulerTick     = 15 * time.Second
+	minInterval       = time.Minute
+	runRetention      = 30 * 24 * time.Hour
+	maxAlertRows      = 20
+	defaultTriggerOp  = "gt"
+	triggerTypeCount  = "count"
+	triggerTypeField  = "field"
+	searchAlertPrefix = "SEARCH_"
+)
+
+var triggerOps = map[string]func(a, b float64) bool{
+	"gt":  func(a, b float64) bool { return a > b },
+	"gte": func(a, b float64) bool { return a >= b },
+	"lt":  func(a, b float64) bool { return a < b },
+	"lte": func(a, b float64) bool { return a <= b },
+	"eq":  func(a, b float64) bool { return a == b },
+	"ne":  func(a, b float64) bool { return a != b },
+}
+
+// Scheduler executes scheduled saved searches against storage and turns
+// triggering results into alerts.
+type Scheduler struct {
+	m       *Manager
+	src     query.Source
+	handler func(ctx context.Context, alert *models.Alert) error
+	lookups query.Lookups
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewScheduler creates a scheduler for the searches of m. Alerts go to
+// handler (normally alerting.Manager.HandleAlert).
+func NewScheduler(m *Manager, src query.Source, handler func(ctx context.Context, alert *models.Alert) error) *Scheduler {
+	return &Scheduler{m: m, src: src, handler: handler, running: make(map[string]bool)}
+}
+
+// SetLookups makes lookup tables available to scheduled queries.
+func (s *Scheduler) SetLookups(l query.Lookups) {
+	s.lookups = l
+}
+
+// Start checks for due searches until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.RunDue(ctx, now)
+			if now.Sub(lastPrune) > time.Hour {
+				if err := s.m.store.PruneSearchRuns(now.Add(-runRetention)); err != nil {
+					log.Printf("hunting: prune run history: %v", err)
+				}
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// RunDue starts every enabled search whose next run is at or before now and
+// that is not already running. It returns the number of runs started.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) int {
+	searches, err := s.m.ListSearches("")
+	if err != nil {
+		log.Printf("hunting: list scheduled searches: %v", err)
+		return 0
+	}
+	started := 0
+	for _, ss := range searches {
+		sc := ss.Schedule
+		if sc == nil || !sc.Enabled {
+			continue
+		}
+		base := sc.LastRun
+		if base.IsZero() {
+			base = sc.UpdatedAt
+		}
+		next, err := NextRun(sc, base)
+		if err != nil || next.IsZero() || next.After(now) {
+			continue
+		}
+		if !s.claim(ss.ID) {
+			continue
+		}
+		started++
+		go func(ss *models.SavedSearch) {
+			defer s.release(ss.ID)
+			s.Execute(ctx, ss, now)
+		}(ss)
+	}
+	return started
+}
+
+func (s *Scheduler) claim(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id] {
+		return false
+	}
+	s.running[id] = true
+	return true
+}
+
+func (s *Scheduler) release(id string) {
+	s.mu.Lock()
+	delete(s.running, id)
+	s.mu.Unlock()
+}
+
+// Execute runs one scheduled search as of now, raises an alert if the
+// trigger holds and the throttle allows it, and records the run.
+func (s *Scheduler) Execute(ctx context.Context, ss *models.SavedSearch, now time.Time) *models.SearchRun {
+	sc := ss.Schedule
+	run := &models.SearchRun{ID: uuid.NewString(), SearchID: ss.ID, StartedAt: now}
+	lastAlert := sc.LastAlertAt
+
+	res, err := s.run(ctx, ss, now)
+	if err != nil {
+		run.Error = err.Error()
+	} else {
+		run.Hits = len(res.Rows)
+		run.Scanned = res.Scanned
+		matched := evaluateTrigger(sc.Trigger, res.Rows)
+		run.Triggered = matched != nil
+		if run.Triggered {
+			throttle := time.Duration(sc.Throttle) * time.Second
+			if throttle > 0 && !lastAlert.IsZero() && now.Sub(lastAlert) < throttle {
+				run.Suppressed = true
+			} else {
+				alert := buildAlert(ss, res, matched, now)
+				if err := s.handler(ctx, alert); err != nil {
+					run.Error = err.Error()
+				} else {
+					run.AlertID = alert.ID
+					lastAlert = now
+				}
+			}
+		}
+	}
+	run.DurationMs = time.Since(now).Milliseconds()
+	if run.DurationMs < 0 {
+		run.DurationMs = 0
+	}
+
+	if err := s.m.store.InsertSearchRun(run); err != nil {
+		log.Printf("hunting: record run of %s: %v", ss.ID, err)
+	}
+	if err := s.m.store.UpdateSearchScheduleState(ss.ID, now, lastAlert); err != nil {
+		log.Printf("hunting: update schedule of %s: %v", ss.ID, err)
+	}
+	sc.LastRun, sc.LastAlertAt = now, lastAlert
+	return run
+}
+
+// run executes the query of ss over the schedule window ending at now,
+// within the search's tenant. A query that sets its own earliest keeps it.
+func (s *Scheduler) run(ctx context.Context, ss *models.SavedSearch, now time.Time) (*query.Result, error) {
+	sc := ss.Schedule
+	p, err := query.Compile(ss.Query, now)
+	if err != nil {
+		return nil, err
+	}
+	if p.Search.StartTime == 0 && sc.Window > 0 {
+		p.Search.StartTime = now.Add(-time.Duration(sc.Window) * time.Second).UnixNano()
+		if p.Search.EndTime == 0 {
+			p.Search.EndTime = now.UnixNano()
+		}
+	}
+	return p.Run(ctx, s.src, query.Options{Now: now, Lookups: s.lookups, Tenant: models.TenantOrDefault(ss.Tenant)})
+}
+
+// NextRun returns the first run time after the given instant.
+func NextRun(sc *models.SearchSchedule, after time.Time) (time.Time, error) {
+	if sc.Cron != "" {
+		spec, err := parseCron(sc.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return spec.next(after), nil
+	}
+	if sc.Interval <= 0 {
+		return time.Time{}, fmt.Errorf("hunting: schedule needs a cron expression or an interval")
+	}
+	return after.Add(time.Duration(sc.Interval) * time.Second), nil
+}
+
+// validateSchedule checks sc and fills in defaults.
+func validateSchedule(sc *models.SearchSchedule) error {
+	if sc.Cron != "" {
+		spec, err := parseCron(sc.Cron)
+		if err != nil {
+			return err
+		}
+		if spec.next(time.Now()).IsZero() {
+			return fmt.Errorf("hunting: cron %q never fires", sc.Cron)
+		}
+	} else if time.Duration(sc.Interval)*time.Second < minInterval {
+		return fmt.Errorf("hunting: interval must be at least %s", minInterval)
+	}
+	if sc.Window < 0 || sc.Throttle < 0 {
+		return fmt.Errorf("hunting: window and throttle must not be negative")
+	}
+
+	t := &sc.Trigger
+	if t.Type == "" {
+		t.Type = triggerTypeCount
+	}
+	if t.Op == "" {
+		t.Op = defaultTriggerOp
+	}
+	switch t.Type {
+	case triggerTypeCount:
+		t.Field = ""
+	case triggerTypeField:
+		if t.Field == "" {
+			return fmt.Errorf("hunting: field trigger needs a field")
+		}
+	default:
+		return fmt.Errorf("hunting: unknown trigger type %q", t.Type)
+	}
+	if _, ok := triggerOps[t.Op]; !ok {
+		return fmt.Errorf("hunting: unknown trigger operator %q", t.Op)
+	}
+
+	switch sc.Severity {
+	case "":
+		sc.Severity = models.SeverityMedium
+	case models.SeverityCritical, models.SeverityHigh, models.SeverityMedium, models.SeverityLow, models.SeverityInfo:
+	default:
+		return fmt.Errorf("hunting: unknown severity %q", sc.Severity)
+	}
+	return nil
+}
+
+// evaluateTrigger returns the rows that satisfy the trigger, or nil when it
+// does not fire. A count trigger returns all rows.
+func evaluateTrigger(t models.SearchTrigger, rows []map[string]interface{}) []map[string]interface{} {
+	op := triggerOps[t.Op]
+	if op == nil {
+		op = triggerOps[defaultTriggerOp]
+	}
+	if t.Type != triggerTypeField {
+		if op(float64(len(rows)), t.Threshold) {
+			if rows == nil {
+				return []map[string]interface{}{}
+			}
+			return rows
+		}
+		return nil
+	}
+	var matched []map[string]interface{}
+	for _, r := range rows {
+		if v, ok := number(r[t.Field]); ok && op(v, t.Threshold) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func buildAlert(ss *models.SavedSearch, res *query.Result, matched []map[string]interface{}, now time.Time) *models.Alert {
+	sc := ss.Schedule
+	alert := &models.Alert{
+		ID:        fmt.Sprintf("sch_%s_%d", shortID(ss.ID), now.UnixNano()),
+		RuleID:    searchAlertPrefix + ss.ID,
+		Timestamp: now,
+		Severity:  sc.Severity,
+		Title:     "Scheduled search: " + ss.Name,
+		Summary:   fmt.Sprintf("Search '%s' returned %d rows, %d matching trigger %s", ss.Name, len(res.Rows), len(matched), describeTrigger(sc.Trigger)),
+		Status:    "open",
+		Tenant:    models.TenantOrDefault(ss.Tenant),
+		Metadata: map[string]string{
+			"search_id": ss.ID,
+			"query":     ss.Query,
+			"hits":      strconv.Itoa(len(res.Rows)),
+			"matched":   strconv.Itoa(len(matched)),
+		},
+	}
+	if sc.Window > 0 {
+		alert.Metadata["window"] = (time.Duration(sc.Window) * time.Second).String()
+	}
+
+	// A single host across all matched rows becomes the alert host.
+	host := ""
+	for i, r := range matched {
+		h, _ := r["host"].(string)
+		if i > 0 && h != host {
+			host = ""
+			break
+		}
+		host = h
+	}
+	alert.Host = host
+
+	rows := matched
+	if len(rows) > maxAlertRows {
+		rows = rows[:maxAlertRows]
+		alert.Metadata["results_truncated"] = "true"
+	}
+	if b, err := json.Marshal(rows); err == nil {
+		alert.Metadata["results"] = string(b)
+	}
+	return alert
+}
+
+func describeTrigger(t models.SearchTrigger) string {
+	subject := "count"
+	if t.Type == triggerTypeField {
+		subject = t.Field
+	}
+	return fmt.Sprintf("%s %s %s", subject, t.Op, strconv.FormatFloat(t.Threshold, 'f', -1, 64))
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
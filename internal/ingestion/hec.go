@@ -1,9 +1,11 @@
 package ingestion
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -42,12 +44,23 @@ func (m *Manager) StartHECServer() error {
 	return nil
 }
 
+// hecTenantKey holds the tenant named by a HEC request's token in its
+// context.
+type hecTenantKey struct{}
+
 func (m *Manager) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 		expected := "Splunk " + m.cfg.HECToken
+		token := strings.TrimPrefix(auth, "Splunk ")
+		if q := r.URL.Query().Get("token"); q != "" {
+			token = q
+		}
+		tenant := ""
 
-		if auth != expected && r.URL.Query().Get("token") != m.cfg.HECToken {
+		if t, ok := m.tenants.hecToken(token); ok {
+			tenant = t
+		} else if auth != expected && r.URL.Query().Get("token") != m.cfg.HECToken {
 			// Try OBLIVRA IAM token
 			bearerToken := ""
 			if strings.HasPrefix(auth, "Bearer ") {
@@ -59,13 +72,14 @@ func (m *Manager) authMiddleware(next http.Handler) http.Handler {
 				return
 			}
 
-			user, err := m.auth.ValidateToken(bearerToken)
+			user, tokenTenant, err := m.auth.ValidateTokenTenant(bearerToken)
 			if err != nil || user == nil {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+			tenant = tokenTenant
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), hecTenantKey{}, tenant)))
 	})
 }
 
@@ -83,6 +97,10 @@ func (m *Manager) handleHECEvent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if err := m.admit(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 
 	var payload hecEvent
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -106,6 +124,8 @@ func (m *Manager) handleHECEvent(w http.ResponseWriter, r *http.Request) {
 		ev.Timestamp = time.Unix(0, int64(payload.Time*1e9))
 	}
 
+	ev.SetRoute("hec")
+	m.assignHECTenant(ev, r)
 	m.Ingest(ev)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -113,6 +133,10 @@ func (m *Manager) handleHECEvent(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Manager) handleHECRaw(w http.ResponseWriter, r *http.Request) {
+	if err := m.admit(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	// Basic raw implementation
 	host := r.URL.Query().Get("host")
 	source := r.URL.Query().Get("source")
@@ -131,6 +155,19 @@ func (m *Manager) handleHECRaw(w http.ResponseWriter, r *http.Request) {
 	ev.Message = string(buf[:n])
 	ev.Raw = ev.Message
 
+	ev.SetRoute("hec")
+	m.assignHECTenant(ev, r)
 	m.Ingest(ev)
 	w.WriteHeader(http.StatusOK)
 }
+
+// assignHECTenant assigns ev the tenant of the request's token or, failing
+// that, of its sender.
+func (m *Manager) assignHECTenant(ev *models.Event, r *http.Request) {
+	tenant, _ := r.Context().Value(hecTenantKey{}).(string)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	m.assignTenant(ev, tenant, net.ParseIP(host))
+}
@@ -0,0 +1,250 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+const (
+	maxSubscribers       = 64
+	defaultSubscriberBuf = 1000
+	maxSubscriberBuf     = 10000
+)
+
+// ErrTooManySubscribers is returned when the live tail subscriber limit is
+// reached.
+var ErrTooManySubscribers = errors.New("ingestion: too many live tail subscribers")
+
+// EventFilter selects the events a subscription receives.
+type EventFilter func(ev *models.Event) bool
+
+// Subscription is a live tail of the events passing through the pipeline.
+// Events arrive on C; when the subscriber falls behind, events are dropped
+// and counted rather than slowing ingestion.
+type Subscription struct {
+	ID      string
+	Owner   string
+	Filter  string
+	Created time.Time
+	C       <-chan *models.Event
+
+	ch        chan *models.Event
+	match     EventFilter
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	closeOnce sync.Once
+}
+
+// SubscriptionStats describes a subscription for monitoring.
+type SubscriptionStats struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Filter    string    `json:"filter"`
+	Created   time.Time `json:"created"`
+	Delivered uint64    `json:"delivered"`
+	Dropped   uint64    `json:"dropped"`
+	Buffered  int       `json:"buffered"`
+}
+
+// Dropped returns how many matching events were discarded because the
+// subscriber's buffer was full.
+func (s *Subscription) Dropped() uint64 { return s.dropped.Load() }
+
+// Stats returns the subscription's counters.
+func (s *Subscription) Stats() SubscriptionStats {
+	return SubscriptionStats{
+		ID:        s.ID,
+		Owner:     s.Owner,
+		Filter:    s.Filter,
+		Created:   s.Created,
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+		Buffered:  len(s.ch),
+	}
+}
+
+func (s *Subscription) offer(ev *models.Event) {
+	if !s.match(ev) {
+		return
+	}
+	select {
+	case s.ch <- ev:
+		s.delivered.Add(1)
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *Subscription) close() {
+	s.closeOnce.Do(func() { close(s.ch) })
+}
+
+// TailBatch is one delivery of a live tail to a client.
+type TailBatch struct {
+	SubscriptionID string          `json:"subscription_id"`
+	Events         []*models.Event `json:"events"`
+	Dropped        uint64          `json:"dropped"` // total dropped so far
+	Closed         bool            `json:"closed"`  // last batch; the subscription has ended
+}
+
+// Batches drains the subscription, calling fn with up to max events at
+// most every interval, until the subscription is closed, ctx ends or fn
+// fails. Empty intervals are skipped unless the drop count changed.
+func (s *Subscription) Batches(ctx context.Context, interval time.Duration, max int, fn func(TailBatch) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var pending []*models.Event
+	var reported uint64
+	send := func(closed bool) error {
+		dropped := s.dropped.Load()
+		if len(pending) == 0 && dropped == reported && !closed {
+			return nil
+		}
+		b := TailBatch{SubscriptionID: s.ID, Events: pending, Dropped: dropped, Closed: closed}
+		pending, reported = nil, dropped
+		return fn(b)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-s.C:
+			if !ok {
+				return send(true)
+			}
+			pending = append(pending, ev)
+			if len(pending) >= max {
+				if err := send(false); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := send(false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// subscribers is the live tail registry.
+type subscribers struct {
+	mu   sync.RWMutex
+	subs map[string]*Subscription
+}
+
+// Subscribe registers a live tail. owner identifies the session so all of
+// its tails can be dropped together; filter describes match for display.
+// buffer bounds the events held for a slow reader (0 → 1000).
+func (m *Manager) Subscribe(owner, filter string, match EventFilter, buffer int) (*Subscription, error) {
+	if buffer <= 0 {
+		buffer = defaultSubscriberBuf
+	}
+	if buffer > maxSubscriberBuf {
+		buffer = maxSubscriberBuf
+	}
+	if match == nil {
+		match = func(*models.Event) bool { return true }
+	}
+	ch := make(chan *models.Event, buffer)
+	s := &Subscription{
+		ID:      uuid.NewString(),
+		Owner:   owner,
+		Filter:  filter,
+		Created: time.Now(),
+		C:       ch,
+		ch:      ch,
+		match:   match,
+	}
+
+	m.tails.mu.Lock()
+	defer m.tails.mu.Unlock()
+	if len(m.tails.subs) >= maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+	if m.tails.subs == nil {
+		m.tails.subs = make(map[string]*Subscription)
+	}
+	m.tails.subs[s.ID] = s
+	return s, nil
+}
+
+// Unsubscribe removes a live tail and closes its channel.
+func (m *Manager) Unsubscribe(id string) {
+	m.tails.mu.Lock()
+	s, ok := m.tails.subs[id]
+	delete(m.tails.subs, id)
+	m.tails.mu.Unlock()
+	if ok {
+		s.close()
+	}
+}
+
+// UnsubscribeOwned removes live tail id if owner holds it, and reports
+// whether it did.
+func (m *Manager) UnsubscribeOwned(id, owner string) bool {
+	m.tails.mu.Lock()
+	s, ok := m.tails.subs[id]
+	if ok && s.Owner == owner {
+		delete(m.tails.subs, id)
+	}
+	m.tails.mu.Unlock()
+	if !ok || s.Owner != owner {
+		return false
+	}
+	s.close()
+	return true
+}
+
+// UnsubscribeOwner removes every live tail of owner, e.g. on logout.
+func (m *Manager) UnsubscribeOwner(owner string) int {
+	m.tails.mu.Lock()
+	var gone []*Subscription
+	for id, s := range m.tails.subs {
+		if s.Owner == owner {
+			gone = append(gone, s)
+			delete(m.tails.subs, id)
+		}
+	}
+	m.tails.mu.Unlock()
+	for _, s := range gone {
+		s.close()
+	}
+	return len(gone)
+}
+
+// Subscriptions returns the stats of every live tail.
+func (m *Manager) Subscriptions() []SubscriptionStats {
+	m.tails.mu.RLock()
+	defer m.tails.mu.RUnlock()
+	out := make([]SubscriptionStats, 0, len(m.tails.subs))
+	for _, s := range m.tails.subs {
+		out = append(out, s.Stats())
+	}
+	return out
+}
+
+// publish hands ev to every matching subscriber without blocking.
+func (m *Manager) publish(ev *models.Event) {
+	m.tails.mu.RLock()
+	defer m.tails.mu.RUnlock()
+	for _, s := range m.tails.subs {
+		s.offer(ev)
+	}
+}
+
+// closeSubscriptions ends every live tail when the pipeline stops.
+func (m *Manager) closeSubscriptions() {
+	m.tails.mu.Lock()
+	subs := m.tails.subs
+	m.tails.subs = nil
+	m.tails.mu.Unlock()
+	for _, s := range subs {
+		s.close()
+	}
+}
@@ -0,0 +1,135 @@
+package ingestion_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/ingestion"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+type nopStorage struct{}
+
+func (nopStorage) WriteEvent(context.Context, *models.Event) error        { return nil }
+func (nopStorage) WriteEventBatch(context.Context, []*models.Event) error { return nil }
+
+func startManager(t *testing.T) *ingestion.Manager {
+	t.Helper()
+	m := ingestion.NewManager(&config.IngestionConfig{}, nopStorage{}, nil)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func sshd(msg string) *models.Event {
+	return &models.Event{ID: msg, Timestamp: time.Now(), Source: "sshd", Host: "web-01", Message: msg}
+}
+
+func TestLiveTailDelivery(t *testing.T) {
+	m := startManager(t)
+	defer m.Stop()
+
+	match := func(ev *models.Event) bool { return strings.Contains(ev.Message, "Failed") }
+	sub, err := m.Subscribe("alice", "Failed", match, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Ingest(sshd("Accepted password for root"))
+	m.Ingest(sshd("Failed password for root"))
+
+	select {
+	case ev := <-sub.C:
+		if ev.Message != "Failed password for root" {
+			t.Errorf("got %q", ev.Message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event delivered")
+	}
+
+	if m.UnsubscribeOwned(sub.ID, "mallory") {
+		t.Error("another user unsubscribed alice's live tail")
+	}
+	if n := m.UnsubscribeOwner("alice"); n != 1 {
+		t.Errorf("UnsubscribeOwner removed %d, want 1", n)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+	if len(m.Subscriptions()) != 0 {
+		t.Error("subscription still registered")
+	}
+}
+
+func TestLiveTailDropsForSlowConsumer(t *testing.T) {
+	m := startManager(t)
+	sub, err := m.Subscribe("bob", "", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		m.Ingest(sshd("event"))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for sub.Stats().Delivered+sub.Dropped() < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := sub.Stats(); st.Delivered != 1 || st.Dropped != 4 || st.Buffered != 1 {
+		t.Errorf("stats = %+v, want 1 delivered, 4 dropped", st)
+	}
+
+	// Stopping the pipeline ends every tail; Batches reports the drops and
+	// a final closed batch.
+	var batches []ingestion.TailBatch
+	done := make(chan error)
+	go func() {
+		done <- sub.Batches(context.Background(), time.Hour, 100, func(b ingestion.TailBatch) error {
+			batches = append(batches, b)
+			return nil
+		})
+	}()
+	m.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || !batches[0].Closed || len(batches[0].Events) != 1 || batches[0].Dropped != 4 {
+		t.Errorf("batches = %+v", batches)
+	}
+}
+
+func TestTenantAssignmentByListener(t *testing.T) {
+	m := ingestion.NewManager(&config.IngestionConfig{
+		Tenants: []config.TenantRoute{{Tenant: "acme", Listeners: []string{"netflow"}}},
+	}, nopStorage{}, nil)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	sub, err := m.Subscribe("carol", "", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routed := sshd("routed")
+	routed.SetRoute("netflow")
+	owned := sshd("owned")
+	owned.Tenant = "globex"
+	m.Ingest(routed)
+	m.Ingest(sshd("unrouted"))
+	m.Ingest(owned)
+
+	want := map[string]string{"routed": "acme", "unrouted": models.DefaultTenant, "owned": "globex"}
+	for range want {
+		select {
+		case ev := <-sub.C:
+			if ev.Tenant != want[ev.Message] {
+				t.Errorf("%s: tenant %q, want %q", ev.Message, ev.Tenant, want[ev.Message])
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+}
@@ -17,6 +17,12 @@ type Storage interface {
 	WriteEventBatch(ctx context.Context, events []*models.Event) error
 }
 
+// Admitter is implemented by storage that can refuse new events, e.g. when
+// its disk is full. HTTP inputs ask it before accepting a request.
+type Admitter interface {
+	Admit() error
+}
+
 // Processor defines an interface for components that process events in real-time.
 type Processor interface {
 	ProcessEvent(ctx context.Context, ev *models.Event)
@@ -32,6 +38,15 @@ type Manager struct {
 	bgCtx      context.Context
 	cancel     context.CancelFunc
 	auth       *auth.Manager
+	tails      subscribers
+	pause      chan pauseRequest
+	tenants    *tenantRouter
+}
+
+// pauseRequest asks the pipeline worker to flush and then wait.
+type pauseRequest struct {
+	paused  chan struct{} // closed once the batch is flushed
+	release chan struct{} // closed to resume
 }
 
 // NewManager creates a new Ingestion Manager.
@@ -42,6 +57,8 @@ func NewManager(cfg *config.IngestionConfig, storage Storage, auth *auth.Manager
 		auth:       auth,
 		processors: []Processor{},
 		events:     make(chan *models.Event, 10000), // Buffer for 10k events
+		pause:      make(chan pauseRequest),
+		tenants:    newTenantRouter(cfg.Tenants),
 	}
 }
 
@@ -69,12 +86,25 @@ func (m *Manager) Stop() error {
 	}
 	m.wg.Wait()
 	close(m.events)
+	m.closeSubscriptions()
 	log.Printf("Ingestion Manager stopped")
 	return nil
 }
 
-// Ingest submits an event to the ingestion pipeline.
+// admit returns the storage's reason to refuse new events, if any.
+func (m *Manager) admit() error {
+	if a, ok := m.storage.(Admitter); ok {
+		return a.Admit()
+	}
+	return nil
+}
+
+// Ingest submits an event to the ingestion pipeline. An event without a
+// tenant is assigned one by its route.
 func (m *Manager) Ingest(ev *models.Event) {
+	if ev.Tenant == "" {
+		m.assignTenant(ev, "", nil)
+	}
 	select {
 	case m.events <- ev:
 	default:
@@ -83,6 +113,43 @@ func (m *Manager) Ingest(ev *models.Event) {
 	}
 }
 
+// IngestWait submits an event and blocks until the pipeline accepts it or ctx
+// is cancelled. Bulk importers use it instead of Ingest so large inputs are
+// throttled by the pipeline rather than dropped.
+func (m *Manager) IngestWait(ctx context.Context, ev *models.Event) error {
+	if ev.Tenant == "" {
+		m.assignTenant(ev, "", nil)
+	}
+	select {
+	case m.events <- ev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause flushes the events the pipeline has batched and holds it until
+// resume is called, so storage sees no new events in between; incoming
+// events queue in the buffer meanwhile. Backups use it to capture every
+// store at the same point. Pause does nothing if the pipeline is not
+// running.
+func (m *Manager) Pause(ctx context.Context) (resume func(), err error) {
+	if m.bgCtx == nil {
+		return func() {}, nil
+	}
+	req := pauseRequest{paused: make(chan struct{}), release: make(chan struct{})}
+	select {
+	case m.pause <- req:
+	case <-m.bgCtx.Done():
+		return func() {}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	<-req.paused
+	var once sync.Once
+	return func() { once.Do(func() { close(req.release) }) }, nil
+}
+
 func (m *Manager) pipelineWorker() {
 	defer m.wg.Done()
 	batchSize := 100
@@ -103,6 +170,7 @@ func (m *Manager) pipelineWorker() {
 			for _, p := range m.processors {
 				p.ProcessEvent(m.bgCtx, ev)
 			}
+			m.publish(ev)
 
 			batch = append(batch, ev)
 			if len(batch) >= batchSize {
@@ -115,6 +183,18 @@ func (m *Manager) pipelineWorker() {
 				m.flush(batch)
 				batch = make([]*models.Event, 0, batchSize)
 			}
+		case req := <-m.pause:
+			if len(batch) > 0 {
+				m.flush(batch)
+				batch = make([]*models.Event, 0, batchSize)
+			}
+			close(req.paused)
+			select {
+			case <-req.release:
+			case <-m.bgCtx.Done():
+				return
+			}
+			ticker.Reset(flushInterval)
 		}
 	}
 }
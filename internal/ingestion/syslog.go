@@ -124,5 +124,7 @@ func (m *Manager) processRaw(raw string, host string) {
 		}
 	}
 
+	ev.SetRoute("syslog")
+	m.assignTenant(ev, "", net.ParseIP(host))
 	m.Ingest(ev)
 }
@@ -81,6 +81,7 @@ func (m *Manager) tailFile(ctx context.Context, path string) {
 						Message:   line,
 						Raw:       line,
 					}
+					ev.SetRoute("file")
 					m.Ingest(ev)
 				}
 				offset, _ = f.Seek(0, io.SeekCurrent)
@@ -0,0 +1,99 @@
+package ingestion
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// tenantRouter resolves the tenant of an incoming event from the
+// configured tenant routes.
+type tenantRouter struct {
+	tokens    map[string]string // HEC token → tenant
+	networks  []tenantNet       // in configuration order
+	listeners map[string]string // route → tenant
+}
+
+type tenantNet struct {
+	prefix netip.Prefix
+	tenant string
+}
+
+// newTenantRouter compiles routes. Routes without a tenant and malformed
+// networks are reported and skipped.
+func newTenantRouter(routes []config.TenantRoute) *tenantRouter {
+	r := &tenantRouter{tokens: make(map[string]string), listeners: make(map[string]string)}
+	for _, route := range routes {
+		if route.Tenant == "" {
+			log.Printf("ingestion: tenant route without a tenant ignored")
+			continue
+		}
+		for _, t := range route.HECTokens {
+			if t != "" {
+				r.tokens[t] = route.Tenant
+			}
+		}
+		for _, c := range route.CIDRs {
+			p, err := parsePrefix(c)
+			if err != nil {
+				log.Printf("ingestion: tenant %s: %v", route.Tenant, err)
+				continue
+			}
+			r.networks = append(r.networks, tenantNet{prefix: p, tenant: route.Tenant})
+		}
+		for _, l := range route.Listeners {
+			r.listeners[strings.ToLower(l)] = route.Tenant
+		}
+	}
+	return r
+}
+
+// parsePrefix accepts a CIDR or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network %q", s)
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// hecToken returns the tenant of an extra HEC token, if it is one.
+func (r *tenantRouter) hecToken(token string) (string, bool) {
+	t, ok := r.tokens[token]
+	return t, ok && token != ""
+}
+
+// resolve returns the tenant for an event received on route from src (nil
+// if unknown). tokenTenant, the tenant named by the ingest token, wins.
+func (r *tenantRouter) resolve(tokenTenant, route string, src net.IP) string {
+	if tokenTenant != "" {
+		return tokenTenant
+	}
+	if a, ok := netip.AddrFromSlice(src); ok {
+		a = a.Unmap()
+		for _, n := range r.networks {
+			if n.prefix.Contains(a) {
+				return n.tenant
+			}
+		}
+	}
+	if t, ok := r.listeners[strings.ToLower(route)]; ok {
+		return t
+	}
+	return models.DefaultTenant
+}
+
+// assignTenant sets the tenant of ev, overriding any the sender supplied.
+// tokenTenant is the tenant named by the request's ingest token, if any;
+// src the sender's address, if known.
+func (m *Manager) assignTenant(ev *models.Event, tokenTenant string, src net.IP) {
+	ev.Tenant = m.tenants.resolve(tokenTenant, ev.Metadata[models.MetaRoute], src)
+}
@@ -0,0 +1,181 @@
+package lookup_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/lookup"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+)
+
+func tmpStore(t *testing.T) (*sqlitestore.DB, func()) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "lookup-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlitestore.Open(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+const crownJewels = `host,owner,tier
+DC-01,infra,0
+10.20.0.0/16,finance,1
+10.20.5.0/24,payments,0
+`
+
+func TestImportLookupAndVersions(t *testing.T) {
+	db, cleanup := tmpStore(t)
+	defer cleanup()
+
+	m := lookup.NewManager(db)
+	var changed []string
+	m.OnChange(func(name string) { changed = append(changed, name) })
+
+	tbl, err := m.Import("", "crown_jewels", "critical servers", "", strings.NewReader(crownJewels), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl.Version != 1 || tbl.Rows != 3 || tbl.KeyColumn != "host" {
+		t.Fatalf("unexpected table %+v", tbl)
+	}
+
+	row, found, err := m.Lookup("", "crown_jewels", "dc-01")
+	if err != nil || !found || row["owner"] != "infra" {
+		t.Errorf("case-insensitive key: %v %v %v", row, found, err)
+	}
+	if row, _, _ := m.Lookup("", "crown_jewels", "10.20.5.9"); row["owner"] != "payments" {
+		t.Errorf("most specific CIDR should win, got %v", row)
+	}
+	if !m.Contains("", "crown_jewels", "10.20.99.1") || m.Contains("", "crown_jewels", "10.21.0.1") {
+		t.Error("CIDR membership wrong")
+	}
+	if _, _, err := m.Lookup("", "nope", "x"); err == nil {
+		t.Error("expected error for unknown table")
+	}
+
+	if _, err := m.Import("", "crown_jewels", "", "host", strings.NewReader("host,owner\nweb-01,web\n"), "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Contains("", "crown_jewels", "dc-01") {
+		t.Error("v2 should replace v1")
+	}
+	tbl, err = m.Restore("", "crown_jewels", 1, "alice")
+	if err != nil || tbl.Version != 3 || tbl.Description != "critical servers" {
+		t.Fatalf("Restore: %+v, %v", tbl, err)
+	}
+	if !m.Contains("", "crown_jewels", "DC-01") {
+		t.Error("restored version not active")
+	}
+
+	versions, err := m.Versions("", "crown_jewels")
+	if err != nil || len(versions) != 3 || versions[0].Version != 3 {
+		t.Fatalf("Versions: %v, %v", versions, err)
+	}
+	var buf bytes.Buffer
+	if err := m.Export("", "crown_jewels", 2, &buf); err != nil || !strings.Contains(buf.String(), "web-01") {
+		t.Errorf("Export v2: %q, %v", buf.String(), err)
+	}
+
+	// A fresh manager sees the current version after Load.
+	m2 := lookup.NewManager(db)
+	if err := m2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !m2.Contains("", "crown_jewels", "dc-01") {
+		t.Error("Load did not restore current version")
+	}
+
+	if err := m.Delete("", "crown_jewels"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Exists("", "crown_jewels") {
+		t.Error("table still loaded after Delete")
+	}
+	if len(changed) != 4 {
+		t.Errorf("OnChange called %d times, want 4", len(changed))
+	}
+}
+
+func TestImportLookupErrors(t *testing.T) {
+	db, cleanup := tmpStore(t)
+	defer cleanup()
+	m := lookup.NewManager(db)
+
+	cases := map[string]struct{ name, key, csv string }{
+		"bad name":    {"../etc", "", "a\n1\n"},
+		"empty":       {"t", "", ""},
+		"missing key": {"t", "hash", "sha256,name\nabc,x\n"},
+		"dup column":  {"t", "", "a,a\n1,2\n"},
+	}
+	for desc, c := range cases {
+		if _, err := m.Import("", c.name, "", c.key, strings.NewReader(c.csv), "x"); err == nil {
+			t.Errorf("%s: expected error", desc)
+		}
+	}
+}
+
+func TestLookupTenants(t *testing.T) {
+	db, cleanup := tmpStore(t)
+	defer cleanup()
+	m := lookup.NewManager(db)
+
+	for _, c := range []struct{ tenant, csv string }{
+		{"", "user\nglobal-admin\n"},
+		{"acme", "user\nacme-ceo\n"},
+		{"globex", "user\nglobex-ceo\n"},
+	} {
+		if _, err := m.Import(c.tenant, "vip_users", "", "", strings.NewReader(c.csv), "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Import("", "countries", "", "", strings.NewReader("code\nly\n"), "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tenant's own table shadows the global one of the same name.
+	if !m.Contains("acme", "vip_users", "acme-ceo") || m.Contains("acme", "vip_users", "globex-ceo") || m.Contains("acme", "vip_users", "global-admin") {
+		t.Error("acme resolves vip_users to the wrong table")
+	}
+	if !m.Contains("initech", "vip_users", "global-admin") || m.Contains("initech", "vip_users", "acme-ceo") {
+		t.Error("a tenant without its own table should see the global one")
+	}
+	if !m.Contains("acme", "countries", "LY") {
+		t.Error("global table not visible to a tenant")
+	}
+
+	tables, err := m.List("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	for _, tbl := range tables {
+		seen = append(seen, tbl.Tenant+"/"+tbl.Name)
+	}
+	if strings.Join(seen, ",") != "/countries,/vip_users,acme/vip_users" {
+		t.Errorf("List(acme) = %v", seen)
+	}
+	if tbl, err := m.Get("globex", "vip_users"); err != nil || tbl.Tenant != "globex" {
+		t.Errorf("Get(globex) = %+v, %v", tbl, err)
+	}
+	if tbl, err := m.Get("globex", "countries"); err != nil || tbl.Tenant != "" {
+		t.Errorf("Get(globex, countries) = %+v, %v", tbl, err)
+	}
+
+	if err := m.Delete("acme", "vip_users"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Contains("acme", "vip_users", "global-admin") || !m.Contains("globex", "vip_users", "globex-ceo") {
+		t.Error("deleting acme's table touched another")
+	}
+}
@@ -0,0 +1,411 @@
+// Package lookup manages CSV-backed lookup tables (VIP users, crown-jewel
+// servers, sanctioned countries, known-bad hashes, ...). Tables are versioned
+// in SQLite and held in memory for use by search and detection. A table is
+// global or belongs to one tenant; a tenant sees its own tables and the
+// global ones, its own taking precedence when both have the same name.
+package lookup
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+)
+
+const (
+	maxRows    = 500_000
+	maxColumns = 64
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,63}$`)
+
+// ErrNotFound is returned for unknown tables.
+var ErrNotFound = errors.New("lookup: table not found")
+
+// Table describes the current version of a lookup table.
+type Table struct {
+	Tenant      string    `json:"tenant"` // "" for a global table
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	KeyColumn   string    `json:"key_column"`
+	Columns     []string  `json:"columns"`
+	Version     int       `json:"version"`
+	Rows        int       `json:"rows"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UpdatedBy   string    `json:"updated_by"`
+}
+
+// Version is one stored version of a table.
+type Version struct {
+	Version   int       `json:"version"`
+	KeyColumn string    `json:"key_column"`
+	Rows      int       `json:"rows"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+// data is the in-memory form of a table. Keys are matched case-insensitively;
+// keys that are CIDR blocks match any address inside them.
+type data struct {
+	columns []string
+	rows    map[string]map[string]string
+	nets    []netRow
+}
+
+type netRow struct {
+	n   *net.IPNet
+	row map[string]string
+}
+
+// tableKey identifies a loaded table; an empty tenant is a global table.
+type tableKey struct{ tenant, name string }
+
+// Manager loads, versions and serves lookup tables.
+type Manager struct {
+	store *sqlitestore.DB
+
+	mu       sync.RWMutex
+	tables   map[tableKey]*data
+	onChange []func(name string)
+}
+
+// NewManager creates a Manager; call Load to read the stored tables.
+func NewManager(store *sqlitestore.DB) *Manager {
+	return &Manager{store: store, tables: make(map[tableKey]*data)}
+}
+
+// OnChange registers fn to be called after a table is imported, restored or
+// deleted.
+func (m *Manager) OnChange(fn func(name string)) {
+	m.mu.Lock()
+	m.onChange = append(m.onChange, fn)
+	m.mu.Unlock()
+}
+
+// Load reads the current version of every table into memory.
+func (m *Manager) Load() error {
+	recs, err := m.store.ListLookups("")
+	if err != nil {
+		return fmt.Errorf("lookup: list tables: %w", err)
+	}
+	tables := make(map[tableKey]*data, len(recs))
+	for _, rec := range recs {
+		raw, err := m.store.GetLookupData(rec.Tenant, rec.Name, rec.Version)
+		if err != nil {
+			log.Printf("lookup: load %s v%d: %v", rec.Name, rec.Version, err)
+			continue
+		}
+		d, err := parse(strings.NewReader(raw), rec.KeyColumn)
+		if err != nil {
+			log.Printf("lookup: parse %s v%d: %v", rec.Name, rec.Version, err)
+			continue
+		}
+		tables[tableKey{rec.Tenant, rec.Name}] = d
+	}
+	m.mu.Lock()
+	m.tables = tables
+	m.mu.Unlock()
+	return nil
+}
+
+// Import parses CSV from r (first row is the header) and stores it as a new
+// version of the table name of tenant ("" for a global table). keyColumn
+// defaults to the first column.
+func (m *Manager) Import(tenant, name, description, keyColumn string, r io.Reader, user string) (*Table, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("lookup: invalid table name %q", name)
+	}
+	var buf bytes.Buffer
+	d, err := parse(io.TeeReader(r, &buf), keyColumn)
+	if err != nil {
+		return nil, err
+	}
+	if keyColumn == "" {
+		keyColumn = d.columns[0]
+	}
+	if description == "" {
+		if cur, err := m.store.GetLookup(tenant, name); err == nil {
+			description = cur.Description
+		}
+	}
+	return m.save(tenant, name, description, keyColumn, d, buf.String(), user)
+}
+
+func (m *Manager) save(tenant, name, description, keyColumn string, d *data, raw, user string) (*Table, error) {
+	rec := &sqlitestore.LookupRecord{
+		Tenant:      tenant,
+		Name:        name,
+		Description: description,
+		KeyColumn:   keyColumn,
+		Columns:     d.columns,
+		Rows:        len(d.rows) + len(d.nets),
+		UpdatedAt:   time.Now(),
+		UpdatedBy:   user,
+	}
+	if _, err := m.store.SaveLookupVersion(rec, raw); err != nil {
+		return nil, fmt.Errorf("lookup: save %s: %w", name, err)
+	}
+	m.mu.Lock()
+	m.tables[tableKey{tenant, name}] = d
+	m.mu.Unlock()
+	m.changed(name)
+	return toTable(rec), nil
+}
+
+// Export writes a version of the table as CSV (version 0 is the current one).
+func (m *Manager) Export(tenant, name string, version int, w io.Writer) error {
+	if version <= 0 {
+		rec, err := m.store.GetLookup(tenant, name)
+		if err != nil {
+			return ErrNotFound
+		}
+		version = rec.Version
+	}
+	raw, err := m.store.GetLookupData(tenant, name, version)
+	if err != nil {
+		return fmt.Errorf("lookup: %s v%d: %w", name, version, err)
+	}
+	_, err = io.WriteString(w, raw)
+	return err
+}
+
+// Restore makes an earlier version current again by saving it as a new
+// version, so history stays linear.
+func (m *Manager) Restore(tenant, name string, version int, user string) (*Table, error) {
+	cur, err := m.store.GetLookup(tenant, name)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	versions, err := m.store.ListLookupVersions(tenant, name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup: versions of %s: %w", name, err)
+	}
+	keyColumn := ""
+	for _, v := range versions {
+		if v.Version == version {
+			keyColumn = v.KeyColumn
+		}
+	}
+	raw, err := m.store.GetLookupData(tenant, name, version)
+	if err != nil || keyColumn == "" {
+		return nil, fmt.Errorf("lookup: %s has no version %d", name, version)
+	}
+	d, err := parse(strings.NewReader(raw), keyColumn)
+	if err != nil {
+		return nil, err
+	}
+	return m.save(tenant, name, cur.Description, keyColumn, d, raw, user)
+}
+
+// Delete removes a table and its history.
+func (m *Manager) Delete(tenant, name string) error {
+	if err := m.store.DeleteLookup(tenant, name); err != nil {
+		return fmt.Errorf("lookup: delete %s: %w", name, err)
+	}
+	m.mu.Lock()
+	delete(m.tables, tableKey{tenant, name})
+	m.mu.Unlock()
+	m.changed(name)
+	return nil
+}
+
+// List returns the global tables and those of tenant; an empty tenant
+// lists every table.
+func (m *Manager) List(tenant string) ([]*Table, error) {
+	recs, err := m.store.ListLookups(tenant)
+	if err != nil {
+		return nil, fmt.Errorf("lookup: list tables: %w", err)
+	}
+	out := make([]*Table, len(recs))
+	for i, rec := range recs {
+		out[i] = toTable(rec)
+	}
+	return out, nil
+}
+
+// Get returns the table name as tenant sees it: its own, or else the
+// global one.
+func (m *Manager) Get(tenant, name string) (*Table, error) {
+	rec, err := m.store.GetLookup(tenant, name)
+	if errors.Is(err, sql.ErrNoRows) && tenant != "" {
+		rec, err = m.store.GetLookup("", name)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("lookup: get %s: %w", name, err)
+	}
+	return toTable(rec), nil
+}
+
+// Versions returns the stored versions of a table, newest first.
+func (m *Manager) Versions(tenant, name string) ([]*Version, error) {
+	recs, err := m.store.ListLookupVersions(tenant, name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup: versions of %s: %w", name, err)
+	}
+	out := make([]*Version, len(recs))
+	for i, v := range recs {
+		out[i] = &Version{Version: v.Version, KeyColumn: v.KeyColumn, Rows: v.Rows, CreatedAt: v.CreatedAt, CreatedBy: v.CreatedBy}
+	}
+	return out, nil
+}
+
+// Lookup returns the row whose key matches value in table as tenant sees
+// it. It fails only for unknown tables.
+func (m *Manager) Lookup(tenant, table, value string) (map[string]string, bool, error) {
+	d, ok := m.find(tenant, table)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: %s", ErrNotFound, table)
+	}
+	row, found := d.get(value)
+	return row, found, nil
+}
+
+// Contains reports whether value is a key of table as tenant sees it;
+// unknown tables contain nothing.
+func (m *Manager) Contains(tenant, table, value string) bool {
+	_, found, _ := m.Lookup(tenant, table, value)
+	return found
+}
+
+// Exists reports whether tenant sees a loaded table of that name.
+func (m *Manager) Exists(tenant, table string) bool {
+	_, ok := m.find(tenant, table)
+	return ok
+}
+
+// find returns the tenant's table, or else the global one.
+func (m *Manager) find(tenant, table string) (*data, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if d, ok := m.tables[tableKey{tenant, table}]; ok {
+		return d, true
+	}
+	d, ok := m.tables[tableKey{"", table}]
+	return d, ok
+}
+
+func (m *Manager) changed(name string) {
+	m.mu.RLock()
+	fns := append([]func(string){}, m.onChange...)
+	m.mu.RUnlock()
+	for _, fn := range fns {
+		fn(name)
+	}
+}
+
+func (d *data) get(value string) (map[string]string, bool) {
+	key := normalize(value)
+	if row, ok := d.rows[key]; ok {
+		return row, true
+	}
+	if len(d.nets) > 0 {
+		if ip := net.ParseIP(key); ip != nil {
+			for _, n := range d.nets {
+				if n.n.Contains(ip) {
+					return n.row, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// parse reads a CSV table keyed by keyColumn (first column when empty).
+func parse(r io.Reader, keyColumn string) (*data, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("lookup: empty CSV")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup: read header: %w", err)
+	}
+	if len(header) > maxColumns {
+		return nil, fmt.Errorf("lookup: %d columns exceeds the limit of %d", len(header), maxColumns)
+	}
+	keyIdx := -1
+	seen := make(map[string]bool)
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if h == "" || seen[h] {
+			return nil, fmt.Errorf("lookup: column %d has an empty or duplicate name", i+1)
+		}
+		seen[h] = true
+		header[i] = h
+		if h == keyColumn || (keyColumn == "" && i == 0) {
+			keyIdx = i
+		}
+	}
+	if keyIdx < 0 {
+		return nil, fmt.Errorf("lookup: key column %q not in header", keyColumn)
+	}
+
+	d := &data{columns: header, rows: make(map[string]map[string]string)}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lookup: line %d: %w", line, err)
+		}
+		if len(rec) <= keyIdx || strings.TrimSpace(rec[keyIdx]) == "" {
+			continue
+		}
+		if len(d.rows)+len(d.nets) >= maxRows {
+			return nil, fmt.Errorf("lookup: more than %d rows", maxRows)
+		}
+		row := make(map[string]string, len(header))
+		for i, h := range header {
+			if i < len(rec) {
+				row[h] = strings.TrimSpace(rec[i])
+			}
+		}
+		key := normalize(rec[keyIdx])
+		if _, n, err := net.ParseCIDR(key); err == nil {
+			d.nets = append(d.nets, netRow{n: n, row: row})
+			continue
+		}
+		d.rows[key] = row
+	}
+	// Most specific network first.
+	sort.SliceStable(d.nets, func(i, j int) bool {
+		a, _ := d.nets[i].n.Mask.Size()
+		b, _ := d.nets[j].n.Mask.Size()
+		return a > b
+	})
+	return d, nil
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+func toTable(rec *sqlitestore.LookupRecord) *Table {
+	return &Table{
+		Tenant:      rec.Tenant,
+		Name:        rec.Name,
+		Description: rec.Description,
+		KeyColumn:   rec.KeyColumn,
+		Columns:     rec.Columns,
+		Version:     rec.Version,
+		Rows:        rec.Rows,
+		UpdatedAt:   rec.UpdatedAt,
+		UpdatedBy:   rec.UpdatedBy,
+	}
+}
@@ -110,58 +110,96 @@ func (c *Collector) handlePacket(ctx context.Context, data []byte, sourceHost st
 		}
 
 		record := data[offset : offset+48]
-		srcIP := net.IP(record[0:4]).String()
-		dstIP := net.IP(record[4:8]).String()
-		srcPort := binary.BigEndian.Uint16(record[32:34])
-		dstPort := binary.BigEndian.Uint16(record[34:36])
-		proto := record[38]
-		bytes := uint64(binary.BigEndian.Uint32(record[20:24]))
-		packets := uint64(binary.BigEndian.Uint32(record[16:20]))
-
-		// Update counters
-		c.totalFlows.Add(1)
-		srcIsPrivate := isPrivateIP(srcIP)
-		dstIsPrivate := isPrivateIP(dstIP)
-		if srcIsPrivate && !dstIsPrivate {
-			c.bytesOut.Add(int64(bytes))
-		} else if !srcIsPrivate && dstIsPrivate {
-			c.bytesIn.Add(int64(bytes))
+		f := &Flow{
+			SrcIP:   net.IP(record[0:4]).String(),
+			DstIP:   net.IP(record[4:8]).String(),
+			SrcPort: binary.BigEndian.Uint16(record[32:34]),
+			DstPort: binary.BigEndian.Uint16(record[34:36]),
+			Proto:   record[38],
+			Bytes:   uint64(binary.BigEndian.Uint32(record[20:24])),
+			Packets: uint64(binary.BigEndian.Uint32(record[16:20])),
 		}
+		_ = c.RecordFlow(ctx, f, sourceHost, nil)
+	}
+}
 
-		// Append to ring buffer
-		c.mu.Lock()
-		c.recent = append(c.recent, &flowRecord{
-			SrcIP: srcIP, DstIP: dstIP,
-			SrcPort: srcPort, DstPort: dstPort,
-			Proto: proto, Bytes: bytes, Packets: packets,
-			SeenAt: time.Now(),
-		})
-		if len(c.recent) > c.ringCap {
-			c.recent = c.recent[len(c.recent)-c.ringCap:]
-		}
-		c.mu.Unlock()
-
-		ev := &models.Event{
-			ID:        uuid.NewString(),
-			Timestamp: time.Now(),
-			Source:    "netflow",
-			Host:      sourceHost,
-			Severity:  models.SeverityInfo,
-			Category:  "network",
-			Message:   fmt.Sprintf("Flow: %s:%d -> %s:%d (Proto: %d)", srcIP, srcPort, dstIP, dstPort, proto),
-			Fields: map[string]interface{}{
-				"src_ip":   srcIP,
-				"dst_ip":   dstIP,
-				"src_port": srcPort,
-				"dst_port": dstPort,
-				"proto":    proto,
-				"bytes":    bytes,
-				"packets":  packets,
-			},
-		}
+// Flow is a single unidirectional flow as seen by an exporter or rebuilt from
+// a packet capture. Start is the time of the first packet; a zero Start means
+// "now" (live Netflow records carry router uptime, not wall-clock time).
+type Flow struct {
+	SrcIP   string
+	DstIP   string
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+	Bytes   uint64
+	Packets uint64
+	Start   time.Time
+	End     time.Time
+}
+
+// RecordFlow updates the collector counters and top-talker ring, then emits
+// the flow as a "netflow" event. Every flow source (UDP exporter, offline PCAP
+// import) goes through here so flow events have one shape regardless of origin.
+// meta is copied into the event metadata (e.g. case_id for imported captures).
+func (c *Collector) RecordFlow(ctx context.Context, f *Flow, sourceHost string, meta map[string]string) error {
+	seenAt := f.Start
+	if seenAt.IsZero() {
+		seenAt = time.Now()
+	}
 
-		_ = c.ingest(ctx, ev)
+	// Update counters
+	c.totalFlows.Add(1)
+	srcIsPrivate := isPrivateIP(f.SrcIP)
+	dstIsPrivate := isPrivateIP(f.DstIP)
+	if srcIsPrivate && !dstIsPrivate {
+		c.bytesOut.Add(int64(f.Bytes))
+	} else if !srcIsPrivate && dstIsPrivate {
+		c.bytesIn.Add(int64(f.Bytes))
 	}
+
+	// Append to ring buffer
+	c.mu.Lock()
+	c.recent = append(c.recent, &flowRecord{
+		SrcIP: f.SrcIP, DstIP: f.DstIP,
+		SrcPort: f.SrcPort, DstPort: f.DstPort,
+		Proto: f.Proto, Bytes: f.Bytes, Packets: f.Packets,
+		SeenAt: seenAt,
+	})
+	if len(c.recent) > c.ringCap {
+		c.recent = c.recent[len(c.recent)-c.ringCap:]
+	}
+	c.mu.Unlock()
+
+	ev := &models.Event{
+		ID:        uuid.NewString(),
+		Timestamp: seenAt,
+		Source:    "netflow",
+		Host:      sourceHost,
+		Severity:  models.SeverityInfo,
+		Category:  "network",
+		Message:   fmt.Sprintf("Flow: %s:%d -> %s:%d (Proto: %d)", f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto),
+		Fields: map[string]interface{}{
+			"src_ip":   f.SrcIP,
+			"dst_ip":   f.DstIP,
+			"src_port": f.SrcPort,
+			"dst_port": f.DstPort,
+			"proto":    f.Proto,
+			"bytes":    f.Bytes,
+			"packets":  f.Packets,
+		},
+	}
+	if !f.End.IsZero() {
+		ev.Fields["duration_ms"] = f.End.Sub(seenAt).Milliseconds()
+	}
+	if len(meta) > 0 {
+		ev.Metadata = make(map[string]string, len(meta))
+		for k, v := range meta {
+			ev.Metadata[k] = v
+		}
+	}
+
+	return c.ingest(ctx, ev)
 }
 
 // Stats returns high-level counters for the dashboard.
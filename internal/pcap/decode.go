@@ -0,0 +1,198 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// IP protocol numbers we decode transport headers for.
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// TCP flag bits.
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+)
+
+var errNotIP = errors.New("pcap: not an IP packet")
+
+// decoded is the network/transport view of one packet.
+type decoded struct {
+	SrcIP    string
+	DstIP    string
+	Proto    uint8
+	SrcPort  uint16
+	DstPort  uint16
+	IPLen    int   // IP total length, used for flow byte accounting
+	TCPFlags uint8 // zero for non-TCP
+	Payload  []byte
+}
+
+// decodePacket strips the link layer and parses IPv4/IPv6 plus TCP/UDP.
+// Non-first IP fragments are decoded to the IP layer only (ports are zero).
+func decodePacket(p *Packet) (*decoded, error) {
+	ip, err := stripLink(p.LinkType, p.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(ip) < 1 {
+		return nil, errNotIP
+	}
+	switch ip[0] >> 4 {
+	case 4:
+		return decodeIPv4(ip, p.OrigLen)
+	case 6:
+		return decodeIPv6(ip)
+	}
+	return nil, errNotIP
+}
+
+func stripLink(linkType uint16, data []byte) ([]byte, error) {
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(data) < 14 {
+			return nil, errNotIP
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		// 802.1Q / 802.1ad VLAN tags, possibly stacked (QinQ).
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		if etherType != 0x0800 && etherType != 0x86DD {
+			return nil, errNotIP
+		}
+		return data, nil
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		return data, nil
+	case LinkTypeNull, LinkTypeLoop:
+		// 4-byte address family in the capturing host's byte order; the IP
+		// version nibble tells us everything we need.
+		if len(data) < 4 {
+			return nil, errNotIP
+		}
+		return data[4:], nil
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, errNotIP
+		}
+		return data[16:], nil
+	case LinkTypeSLL2:
+		if len(data) < 20 {
+			return nil, errNotIP
+		}
+		return data[20:], nil
+	}
+	return nil, errNotIP
+}
+
+func decodeIPv4(b []byte, origLen int) (*decoded, error) {
+	if len(b) < 20 {
+		return nil, errNotIP
+	}
+	ihl := int(b[0]&0x0F) * 4
+	if ihl < 20 || len(b) < ihl {
+		return nil, errNotIP
+	}
+	total := int(binary.BigEndian.Uint16(b[2:4]))
+	d := &decoded{
+		SrcIP: net.IP(b[12:16]).String(),
+		DstIP: net.IP(b[16:20]).String(),
+		Proto: b[9],
+		IPLen: total,
+	}
+	if total == 0 {
+		// TSO/LRO captures often carry a zero total length.
+		d.IPLen = origLen
+	}
+	end := len(b)
+	if total >= ihl && total < end {
+		end = total
+	}
+	fragOffset := binary.BigEndian.Uint16(b[6:8]) & 0x1FFF
+	if fragOffset != 0 {
+		return d, nil
+	}
+	decodeTransport(d, b[ihl:end])
+	return d, nil
+}
+
+func decodeIPv6(b []byte) (*decoded, error) {
+	if len(b) < 40 {
+		return nil, errNotIP
+	}
+	payloadLen := int(binary.BigEndian.Uint16(b[4:6]))
+	d := &decoded{
+		SrcIP: net.IP(b[8:24]).String(),
+		DstIP: net.IP(b[24:40]).String(),
+		IPLen: 40 + payloadLen,
+	}
+	next := b[6]
+	rest := b[40:]
+	if payloadLen > 0 && payloadLen < len(rest) {
+		rest = rest[:payloadLen]
+	}
+	// Skip extension headers.
+	for {
+		switch next {
+		case 0, 43, 60: // hop-by-hop, routing, destination options
+			if len(rest) < 8 {
+				return d, nil
+			}
+			hlen := (int(rest[1]) + 1) * 8
+			if hlen > len(rest) {
+				return d, nil
+			}
+			next, rest = rest[0], rest[hlen:]
+			continue
+		case 44: // fragment
+			if len(rest) < 8 {
+				return d, nil
+			}
+			offset := binary.BigEndian.Uint16(rest[2:4]) >> 3
+			next, rest = rest[0], rest[8:]
+			if offset != 0 {
+				d.Proto = next
+				return d, nil
+			}
+			continue
+		}
+		break
+	}
+	d.Proto = next
+	decodeTransport(d, rest)
+	return d, nil
+}
+
+func decodeTransport(d *decoded, b []byte) {
+	switch d.Proto {
+	case protoTCP:
+		if len(b) < 20 {
+			return
+		}
+		d.SrcPort = binary.BigEndian.Uint16(b[0:2])
+		d.DstPort = binary.BigEndian.Uint16(b[2:4])
+		d.TCPFlags = b[13]
+		off := int(b[12]>>4) * 4
+		if off >= 20 && off <= len(b) {
+			d.Payload = b[off:]
+		}
+	case protoUDP:
+		if len(b) < 8 {
+			return
+		}
+		d.SrcPort = binary.BigEndian.Uint16(b[0:2])
+		d.DstPort = binary.BigEndian.Uint16(b[2:4])
+		end := int(binary.BigEndian.Uint16(b[4:6]))
+		if end < 8 || end > len(b) {
+			end = len(b)
+		}
+		d.Payload = b[8:end]
+	}
+}
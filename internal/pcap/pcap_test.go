@@ -0,0 +1,286 @@
+package pcap_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/pcap"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// ─── packet builders ─────────────────────────────────────────────────────────
+
+func ipv4(src, dst string, proto byte, l4 []byte) []byte {
+	h := make([]byte, 20)
+	h[0] = 0x45
+	binary.BigEndian.PutUint16(h[2:4], uint16(20+len(l4)))
+	h[8] = 64
+	h[9] = proto
+	copy(h[12:16], net.ParseIP(src).To4())
+	copy(h[16:20], net.ParseIP(dst).To4())
+	return append(h, l4...)
+}
+
+func ether(ip []byte) []byte {
+	f := make([]byte, 14)
+	binary.BigEndian.PutUint16(f[12:14], 0x0800)
+	return append(f, ip...)
+}
+
+func udp(sport, dport uint16, payload []byte) []byte {
+	h := make([]byte, 8)
+	binary.BigEndian.PutUint16(h[0:2], sport)
+	binary.BigEndian.PutUint16(h[2:4], dport)
+	binary.BigEndian.PutUint16(h[4:6], uint16(8+len(payload)))
+	return append(h, payload...)
+}
+
+func tcp(sport, dport uint16, flags byte, payload []byte) []byte {
+	h := make([]byte, 20)
+	binary.BigEndian.PutUint16(h[0:2], sport)
+	binary.BigEndian.PutUint16(h[2:4], dport)
+	h[12] = 5 << 4
+	h[13] = flags
+	return append(h, payload...)
+}
+
+func dnsQuery(name string) []byte {
+	b := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range bytes.Split([]byte(name), []byte(".")) {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0, 0, 1, 0, 1) // root, type A, class IN
+}
+
+func clientHello(sni string) []byte {
+	var hs []byte
+	hs = append(hs, 0x03, 0x03)                               // client_version TLS 1.2
+	hs = append(hs, make([]byte, 32)...)                      // random
+	hs = append(hs, 0)                                        // session id
+	hs = append(hs, 0, 6, 0x0a, 0x0a, 0x13, 0x01, 0xc0, 0x2f) // GREASE + 2 suites
+	hs = append(hs, 1, 0)                                     // compression: null
+
+	var ext []byte
+	// server_name
+	name := []byte(sni)
+	sn := []byte{0, byte(len(name) + 3), 0, 0, byte(len(name))}
+	sn = append(sn, name...)
+	ext = append(ext, 0, 0, 0, byte(len(sn)))
+	ext = append(ext, sn...)
+	// supported_groups: x25519, secp256r1
+	ext = append(ext, 0, 10, 0, 6, 0, 4, 0, 29, 0, 23)
+	// ec_point_formats: uncompressed
+	ext = append(ext, 0, 11, 0, 2, 1, 0)
+
+	hs = append(hs, byte(len(ext)>>8), byte(len(ext)))
+	hs = append(hs, ext...)
+
+	msg := []byte{0x01, 0, byte(len(hs) >> 8), byte(len(hs))}
+	msg = append(msg, hs...)
+	rec := []byte{0x16, 0x03, 0x01, byte(len(msg) >> 8), byte(len(msg))}
+	return append(rec, msg...)
+}
+
+func testFrames() [][]byte {
+	return [][]byte{
+		ether(ipv4("10.0.0.5", "10.0.0.53", 17, udp(40000, 53, dnsQuery("evil.example.com")))),
+		ether(ipv4("10.0.0.5", "93.184.216.34", 6, tcp(50000, 80, 0x18,
+			[]byte("GET /payload.bin HTTP/1.1\r\nHost: cdn.example.net\r\nUser-Agent: curl/8.0\r\n\r\n")))),
+		ether(ipv4("10.0.0.5", "93.184.216.34", 6, tcp(50000, 80, 0x11, nil))), // FIN
+		ether(ipv4("10.0.0.5", "198.51.100.7", 6, tcp(50001, 443, 0x18, clientHello("c2.example.org")))),
+		{0xde, 0xad}, // runt frame
+	}
+}
+
+func writePCAP(frames [][]byte, base time.Time) []byte {
+	var buf bytes.Buffer
+	gh := make([]byte, 24)
+	binary.LittleEndian.PutUint32(gh[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(gh[4:6], 2)
+	binary.LittleEndian.PutUint16(gh[6:8], 4)
+	binary.LittleEndian.PutUint32(gh[16:20], 65535)
+	binary.LittleEndian.PutUint32(gh[20:24], 1)
+	buf.Write(gh)
+	for i, f := range frames {
+		ts := base.Add(time.Duration(i) * time.Second)
+		rh := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rh[0:4], uint32(ts.Unix()))
+		binary.LittleEndian.PutUint32(rh[4:8], uint32(ts.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rh[8:12], uint32(len(f)))
+		binary.LittleEndian.PutUint32(rh[12:16], uint32(len(f)))
+		buf.Write(rh)
+		buf.Write(f)
+	}
+	return buf.Bytes()
+}
+
+func ngBlock(typ uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	total := uint32(12 + len(body))
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[0:4], typ)
+	binary.LittleEndian.PutUint32(b[4:8], total)
+	b = append(b, body...)
+	tail := make([]byte, 4)
+	binary.LittleEndian.PutUint32(tail, total)
+	return append(b, tail...)
+}
+
+func writePCAPNG(frames [][]byte, base time.Time) []byte {
+	var buf bytes.Buffer
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], 0x1A2B3C4D)
+	binary.LittleEndian.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint64(shb[8:16], ^uint64(0))
+	buf.Write(ngBlock(0x0A0D0D0A, shb))
+
+	// IDB with if_tsresol = 9 (nanoseconds)
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:2], 1)
+	idb = append(idb, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0)
+	buf.Write(ngBlock(1, idb))
+
+	for i, f := range frames {
+		ts := uint64(base.Add(time.Duration(i) * time.Second).UnixNano())
+		epb := make([]byte, 20)
+		binary.LittleEndian.PutUint32(epb[4:8], uint32(ts>>32))
+		binary.LittleEndian.PutUint32(epb[8:12], uint32(ts))
+		binary.LittleEndian.PutUint32(epb[12:16], uint32(len(f)))
+		binary.LittleEndian.PutUint32(epb[16:20], uint32(len(f)))
+		buf.Write(ngBlock(6, append(epb, f...)))
+	}
+	return buf.Bytes()
+}
+
+// ─── tests ───────────────────────────────────────────────────────────────────
+
+type sink struct {
+	mu     sync.Mutex
+	events []*models.Event
+}
+
+func (s *sink) ingest(ctx context.Context, ev *models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *sink) byCategory(cat string) []*models.Event {
+	var out []*models.Event
+	for _, ev := range s.events {
+		if ev.Category == cat {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestImportFormats(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string][]byte{
+		"pcap":   writePCAP(testFrames(), base),
+		"pcapng": writePCAPNG(testFrames(), base),
+	}
+
+	for format, data := range cases {
+		t.Run(format, func(t *testing.T) {
+			s := &sink{}
+			res, err := pcap.NewImporter(s.ingest).ImportReader(context.Background(), bytes.NewReader(data), "incident."+format, "case-42")
+			if err != nil {
+				t.Fatalf("ImportReader: %v", err)
+			}
+			if res.Format != format {
+				t.Errorf("format = %q, want %q", res.Format, format)
+			}
+			if res.Packets != 5 || res.Skipped != 1 {
+				t.Errorf("packets=%d skipped=%d, want 5/1", res.Packets, res.Skipped)
+			}
+			if res.Flows != 3 {
+				t.Errorf("flows = %d, want 3", res.Flows)
+			}
+			if !res.FirstPacket.Equal(base) {
+				t.Errorf("first packet = %v, want %v", res.FirstPacket, base)
+			}
+
+			for _, ev := range s.events {
+				if ev.Metadata["case_id"] != "case-42" {
+					t.Errorf("event %s (%s) missing case tag", ev.ID, ev.Category)
+				}
+			}
+
+			dns := s.byCategory("dns")
+			if len(dns) != 1 || dns[0].Fields["dns_query"] != "evil.example.com" || dns[0].Fields["dns_qtype"] != "A" {
+				t.Fatalf("unexpected DNS events: %+v", dns)
+			}
+			if !dns[0].Timestamp.Equal(base) {
+				t.Errorf("DNS timestamp = %v, want capture time %v", dns[0].Timestamp, base)
+			}
+
+			http := s.byCategory("http")
+			if len(http) != 1 || http[0].Fields["http_host"] != "cdn.example.net" || http[0].Fields["http_uri"] != "/payload.bin" {
+				t.Fatalf("unexpected HTTP events: %+v", http)
+			}
+
+			tls := s.byCategory("tls")
+			if len(tls) != 1 {
+				t.Fatalf("want 1 TLS event, got %d", len(tls))
+			}
+			if tls[0].Fields["tls_sni"] != "c2.example.org" {
+				t.Errorf("sni = %v", tls[0].Fields["tls_sni"])
+			}
+			wantJA3 := "771,4865-49199,0-10-11,29-23,0"
+			if tls[0].Fields["ja3"] != wantJA3 {
+				t.Errorf("ja3 = %v, want %s", tls[0].Fields["ja3"], wantJA3)
+			}
+			sum := md5.Sum([]byte(wantJA3))
+			if tls[0].Fields["ja3_hash"] != hex.EncodeToString(sum[:]) {
+				t.Errorf("ja3_hash = %v", tls[0].Fields["ja3_hash"])
+			}
+
+			flows := s.byCategory("network")
+			if len(flows) != 3 {
+				t.Fatalf("want 3 flow events, got %d", len(flows))
+			}
+			for _, f := range flows {
+				if f.Source != "netflow" || f.Host != "pcap:incident."+format {
+					t.Errorf("flow event source/host = %s/%s", f.Source, f.Host)
+				}
+				if f.Fields["dst_port"] == uint16(80) && f.Fields["packets"] != uint64(2) {
+					t.Errorf("HTTP flow packets = %v, want 2", f.Fields["packets"])
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := pcap.NewReader(bytes.NewReader([]byte("not a capture file")))
+	if err != pcap.ErrUnknownFormat {
+		t.Fatalf("want ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestImportCancelled(t *testing.T) {
+	frames := make([][]byte, 0, 3000)
+	for i := 0; i < 3000; i++ {
+		frames = append(frames, ether(ipv4("10.0.0.1", "10.0.0.2", 17, udp(uint16(1000+i), 9999, nil))))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := &sink{}
+	_, err := pcap.NewImporter(s.ingest).ImportReader(ctx, bytes.NewReader(writePCAP(frames, time.Now())), "big.pcap", "c")
+	if err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
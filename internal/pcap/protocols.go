@@ -0,0 +1,323 @@
+package pcap
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ─── DNS ─────────────────────────────────────────────────────────────────────
+
+// dnsMessage is the subset of a DNS message we surface as an event.
+type dnsMessage struct {
+	ID       uint16
+	Response bool
+	RCode    int
+	Query    string
+	QType    string
+	Answers  []string
+}
+
+var dnsTypes = map[uint16]string{
+	1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX",
+	16: "TXT", 28: "AAAA", 33: "SRV", 65: "HTTPS", 255: "ANY",
+}
+
+func dnsTypeName(t uint16) string {
+	if n, ok := dnsTypes[t]; ok {
+		return n
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// parseDNS decodes the first question and A/AAAA/CNAME answers.
+func parseDNS(b []byte) (*dnsMessage, bool) {
+	if len(b) < 12 {
+		return nil, false
+	}
+	flags := binary.BigEndian.Uint16(b[2:4])
+	if (flags>>11)&0x0F != 0 { // only standard queries
+		return nil, false
+	}
+	qd := binary.BigEndian.Uint16(b[4:6])
+	an := binary.BigEndian.Uint16(b[6:8])
+	if qd == 0 {
+		return nil, false
+	}
+	m := &dnsMessage{
+		ID:       binary.BigEndian.Uint16(b[0:2]),
+		Response: flags&0x8000 != 0,
+		RCode:    int(flags & 0x0F),
+	}
+	name, off, ok := readDNSName(b, 12)
+	if !ok || off+4 > len(b) {
+		return nil, false
+	}
+	m.Query = name
+	m.QType = dnsTypeName(binary.BigEndian.Uint16(b[off : off+2]))
+	off += 4
+	// Skip any further questions.
+	for i := 1; i < int(qd); i++ {
+		if _, off, ok = readDNSName(b, off); !ok || off+4 > len(b) {
+			return m, true
+		}
+		off += 4
+	}
+	for i := 0; i < int(an) && len(m.Answers) < 16; i++ {
+		if _, off, ok = readDNSName(b, off); !ok || off+10 > len(b) {
+			break
+		}
+		typ := binary.BigEndian.Uint16(b[off : off+2])
+		rdlen := int(binary.BigEndian.Uint16(b[off+8 : off+10]))
+		off += 10
+		if off+rdlen > len(b) {
+			break
+		}
+		rdata := b[off : off+rdlen]
+		switch typ {
+		case 1:
+			if rdlen == 4 {
+				m.Answers = append(m.Answers, net.IP(rdata).String())
+			}
+		case 28:
+			if rdlen == 16 {
+				m.Answers = append(m.Answers, net.IP(rdata).String())
+			}
+		case 5:
+			if cname, _, ok := readDNSName(b, off); ok {
+				m.Answers = append(m.Answers, cname)
+			}
+		}
+		off += rdlen
+	}
+	return m, true
+}
+
+// readDNSName decodes a (possibly compressed) domain name starting at off and
+// returns the name and the offset just past it in the original position.
+func readDNSName(b []byte, off int) (string, int, bool) {
+	var labels []string
+	end := -1
+	for hops := 0; hops < 32; hops++ {
+		if off >= len(b) {
+			return "", 0, false
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, true
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(b) {
+				return "", 0, false
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:off+2]) & 0x3FFF)
+		case l > 63:
+			return "", 0, false
+		default:
+			if off+1+l > len(b) {
+				return "", 0, false
+			}
+			labels = append(labels, string(b[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+	return "", 0, false
+}
+
+// ─── HTTP ────────────────────────────────────────────────────────────────────
+
+type httpRequest struct {
+	Method    string
+	URI       string
+	Host      string
+	UserAgent string
+}
+
+var httpMethods = []string{"GET ", "POST ", "PUT ", "DELETE ", "HEAD ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// parseHTTPRequest recognises a request line and headers at the start of a
+// TCP segment. Requests split across segments are reported with whatever
+// headers fit in the first one.
+func parseHTTPRequest(b []byte) (*httpRequest, bool) {
+	isReq := false
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(b, []byte(m)) {
+			isReq = true
+			break
+		}
+	}
+	if !isReq {
+		return nil, false
+	}
+	lineEnd := bytes.Index(b, []byte("\r\n"))
+	if lineEnd < 0 {
+		return nil, false
+	}
+	parts := strings.SplitN(string(b[:lineEnd]), " ", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		return nil, false
+	}
+	req := &httpRequest{Method: parts[0], URI: parts[1]}
+	for _, line := range strings.Split(string(b[lineEnd+2:]), "\r\n") {
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "host":
+			req.Host = strings.TrimSpace(v)
+		case "user-agent":
+			req.UserAgent = strings.TrimSpace(v)
+		}
+	}
+	return req, true
+}
+
+// ─── TLS ─────────────────────────────────────────────────────────────────────
+
+type tlsClientHello struct {
+	Version uint16
+	SNI     string
+	JA3     string // the JA3 string before hashing
+	JA3Hash string
+}
+
+// isGREASE reports RFC 8701 GREASE values, which JA3 ignores.
+func isGREASE(v uint16) bool {
+	return v&0x0F0F == 0x0A0A && v>>8 == v&0xFF
+}
+
+// parseClientHello decodes a TLS ClientHello from the start of a TCP segment
+// and computes its JA3 fingerprint.
+func parseClientHello(b []byte) (*tlsClientHello, bool) {
+	// Record header: type(1)=handshake, version(2), length(2)
+	if len(b) < 9 || b[0] != 0x16 || b[1] != 0x03 {
+		return nil, false
+	}
+	recLen := int(binary.BigEndian.Uint16(b[3:5]))
+	body := b[5:]
+	if recLen < len(body) {
+		body = body[:recLen]
+	}
+	// Handshake header: type(1)=client_hello, length(3)
+	if body[0] != 0x01 {
+		return nil, false
+	}
+	hsLen := int(body[1])<<16 | int(body[2])<<8 | int(body[3])
+	hs := body[4:]
+	if hsLen < len(hs) {
+		hs = hs[:hsLen]
+	}
+	if len(hs) < 38 {
+		return nil, false
+	}
+	ch := &tlsClientHello{Version: binary.BigEndian.Uint16(hs[0:2])}
+	off := 34 // version + random
+	sidLen := int(hs[off])
+	off += 1 + sidLen
+	if off+2 > len(hs) {
+		return nil, false
+	}
+	csLen := int(binary.BigEndian.Uint16(hs[off : off+2]))
+	off += 2
+	if off+csLen > len(hs) {
+		return nil, false
+	}
+	var ciphers []string
+	for i := 0; i+1 < csLen; i += 2 {
+		v := binary.BigEndian.Uint16(hs[off+i : off+i+2])
+		if !isGREASE(v) {
+			ciphers = append(ciphers, strconv.Itoa(int(v)))
+		}
+	}
+	off += csLen
+	if off >= len(hs) {
+		return nil, false
+	}
+	off += 1 + int(hs[off]) // compression methods
+
+	var exts, curves, points []string
+	if off+2 <= len(hs) {
+		extTotal := int(binary.BigEndian.Uint16(hs[off : off+2]))
+		off += 2
+		extEnd := off + extTotal
+		if extEnd > len(hs) {
+			extEnd = len(hs)
+		}
+		for off+4 <= extEnd {
+			typ := binary.BigEndian.Uint16(hs[off : off+2])
+			elen := int(binary.BigEndian.Uint16(hs[off+2 : off+4]))
+			off += 4
+			if off+elen > extEnd {
+				break
+			}
+			data := hs[off : off+elen]
+			off += elen
+			if isGREASE(typ) {
+				continue
+			}
+			exts = append(exts, strconv.Itoa(int(typ)))
+			switch typ {
+			case 0: // server_name
+				ch.SNI = parseSNI(data)
+			case 10: // supported_groups
+				if len(data) >= 2 {
+					n := int(binary.BigEndian.Uint16(data[0:2]))
+					for i := 2; i+1 < 2+n && i+1 < len(data); i += 2 {
+						v := binary.BigEndian.Uint16(data[i : i+2])
+						if !isGREASE(v) {
+							curves = append(curves, strconv.Itoa(int(v)))
+						}
+					}
+				}
+			case 11: // ec_point_formats
+				if len(data) >= 1 {
+					n := int(data[0])
+					for i := 1; i <= n && i < len(data); i++ {
+						points = append(points, strconv.Itoa(int(data[i])))
+					}
+				}
+			}
+		}
+	}
+
+	ch.JA3 = fmt.Sprintf("%d,%s,%s,%s,%s", ch.Version,
+		strings.Join(ciphers, "-"), strings.Join(exts, "-"),
+		strings.Join(curves, "-"), strings.Join(points, "-"))
+	sum := md5.Sum([]byte(ch.JA3))
+	ch.JA3Hash = hex.EncodeToString(sum[:])
+	return ch, true
+}
+
+func parseSNI(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	list := data[2:]
+	for len(list) >= 3 {
+		typ := list[0]
+		l := int(binary.BigEndian.Uint16(list[1:3]))
+		if 3+l > len(list) {
+			return ""
+		}
+		if typ == 0 {
+			return string(list[3 : 3+l])
+		}
+		list = list[3+l:]
+	}
+	return ""
+}
@@ -0,0 +1,369 @@
+// Package pcap imports offline packet captures (libpcap and pcapng) into
+// OBLIVRA. It is pure Go: captures are decoded without libpcap so the importer
+// works on air-gapped analyst workstations. Packets are rebuilt into flows
+// that go through the same netflow.Collector path as live exporters, and
+// DNS queries, HTTP requests and TLS ClientHellos (SNI + JA3) are emitted as
+// protocol events. Everything is tagged with the case the capture belongs to.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Link-layer header types (https://www.tcpdump.org/linktypes.html).
+const (
+	LinkTypeNull     uint16 = 0
+	LinkTypeEthernet uint16 = 1
+	LinkTypeRaw      uint16 = 101
+	LinkTypeLoop     uint16 = 108
+	LinkTypeLinuxSLL uint16 = 113
+	LinkTypeIPv4     uint16 = 228
+	LinkTypeIPv6     uint16 = 229
+	LinkTypeSLL2     uint16 = 276
+)
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+
+	ngBlockSHB  = 0x0A0D0D0A
+	ngBlockIDB  = 0x00000001
+	ngBlockOPB  = 0x00000002 // obsolete packet block
+	ngBlockSPB  = 0x00000003
+	ngBlockEPB  = 0x00000006
+	ngByteMagic = 0x1A2B3C4D
+
+	// maxPacketLen bounds a single captured packet so a corrupt length field
+	// cannot make us allocate gigabytes.
+	maxPacketLen = 256 << 10
+	// maxBlockLen bounds a single pcapng block for the same reason.
+	maxBlockLen = 16 << 20
+)
+
+// ErrUnknownFormat is returned when the input is neither pcap nor pcapng.
+var ErrUnknownFormat = errors.New("pcap: unknown capture format")
+
+// Packet is one captured frame.
+type Packet struct {
+	Timestamp time.Time
+	LinkType  uint16
+	Data      []byte // captured bytes (may be truncated to snaplen)
+	OrigLen   int    // length on the wire
+}
+
+// Reader yields packets from a pcap or pcapng stream.
+type Reader interface {
+	// Next returns the next packet, or io.EOF at the end of the capture.
+	Next() (*Packet, error)
+	// Format returns "pcap" or "pcapng".
+	Format() string
+}
+
+// NewReader sniffs the capture format from the first four bytes.
+func NewReader(r io.Reader) (Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	head, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("pcap: read header: %w", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(head) == ngBlockSHB:
+		return newNGReader(br)
+	case binary.LittleEndian.Uint32(head) == pcapMagicMicro,
+		binary.BigEndian.Uint32(head) == pcapMagicMicro,
+		binary.LittleEndian.Uint32(head) == pcapMagicNano,
+		binary.BigEndian.Uint32(head) == pcapMagicNano:
+		return newClassicReader(br)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// ─── libpcap ─────────────────────────────────────────────────────────────────
+
+type classicReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint16
+	hdr      [16]byte
+}
+
+func newClassicReader(r io.Reader) (*classicReader, error) {
+	var gh [24]byte
+	if _, err := io.ReadFull(r, gh[:]); err != nil {
+		return nil, fmt.Errorf("pcap: global header: %w", err)
+	}
+	c := &classicReader{r: r}
+	switch binary.LittleEndian.Uint32(gh[0:4]) {
+	case pcapMagicMicro:
+		c.order = binary.LittleEndian
+	case pcapMagicNano:
+		c.order, c.nano = binary.LittleEndian, true
+	default:
+		c.order = binary.BigEndian
+		c.nano = binary.BigEndian.Uint32(gh[0:4]) == pcapMagicNano
+	}
+	// The upper 16 bits of the network field carry FCS flags; only the low
+	// 16 bits are the link type.
+	c.linkType = uint16(c.order.Uint32(gh[20:24]) & 0xFFFF)
+	return c, nil
+}
+
+func (c *classicReader) Format() string { return "pcap" }
+
+func (c *classicReader) Next() (*Packet, error) {
+	if _, err := io.ReadFull(c.r, c.hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF // truncated trailer — treat as end of capture
+		}
+		return nil, err
+	}
+	sec := int64(c.order.Uint32(c.hdr[0:4]))
+	frac := int64(c.order.Uint32(c.hdr[4:8]))
+	capLen := c.order.Uint32(c.hdr[8:12])
+	origLen := c.order.Uint32(c.hdr[12:16])
+	if capLen > maxPacketLen {
+		return nil, fmt.Errorf("pcap: packet length %d exceeds limit", capLen)
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, io.EOF
+	}
+	if !c.nano {
+		frac *= 1000
+	}
+	return &Packet{
+		Timestamp: time.Unix(sec, frac).UTC(),
+		LinkType:  c.linkType,
+		Data:      data,
+		OrigLen:   int(origLen),
+	}, nil
+}
+
+// ─── pcapng ──────────────────────────────────────────────────────────────────
+
+type ngInterface struct {
+	linkType uint16
+	// tsUnit is the duration of one timestamp tick (if_tsresol, default µs).
+	tsUnit time.Duration
+	// tsPerSec is used when the resolution is finer than a nanosecond.
+	tsPerSec uint64
+}
+
+type ngReader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	ifaces []ngInterface
+}
+
+func newNGReader(r io.Reader) (*ngReader, error) {
+	ng := &ngReader{r: r}
+	typ, _, err := ng.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if typ != ngBlockSHB {
+		return nil, ErrUnknownFormat
+	}
+	ng.handleSHB()
+	return ng, nil
+}
+
+func (ng *ngReader) Format() string { return "pcapng" }
+
+// readBlock reads one block and returns its type and body (without the
+// leading type/length and trailing length). The section header's byte-order
+// magic is inspected before the length is interpreted.
+func (ng *ngReader) readBlock() (uint32, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(ng.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, err
+	}
+	// Block type 0x0A0D0D0A is a palindrome, so it reads the same either way.
+	if binary.LittleEndian.Uint32(hdr[0:4]) == ngBlockSHB {
+		var magic [4]byte
+		if _, err := io.ReadFull(ng.r, magic[:]); err != nil {
+			return 0, nil, fmt.Errorf("pcapng: section header: %w", err)
+		}
+		if binary.LittleEndian.Uint32(magic[:]) == ngByteMagic {
+			ng.order = binary.LittleEndian
+		} else if binary.BigEndian.Uint32(magic[:]) == ngByteMagic {
+			ng.order = binary.BigEndian
+		} else {
+			return 0, nil, fmt.Errorf("pcapng: bad byte-order magic %x", magic)
+		}
+		total := ng.order.Uint32(hdr[4:8])
+		if total < 16 || total > maxBlockLen {
+			return 0, nil, fmt.Errorf("pcapng: bad section header length %d", total)
+		}
+		rest := make([]byte, total-12)
+		if _, err := io.ReadFull(ng.r, rest); err != nil {
+			return 0, nil, io.EOF
+		}
+		body := append(magic[:], rest[:len(rest)-4]...)
+		return ngBlockSHB, body, nil
+	}
+
+	if ng.order == nil {
+		return 0, nil, ErrUnknownFormat
+	}
+	typ := ng.order.Uint32(hdr[0:4])
+	total := ng.order.Uint32(hdr[4:8])
+	if total < 12 || total > maxBlockLen {
+		return 0, nil, fmt.Errorf("pcapng: bad block length %d", total)
+	}
+	rest := make([]byte, total-8)
+	if _, err := io.ReadFull(ng.r, rest); err != nil {
+		return 0, nil, io.EOF
+	}
+	return typ, rest[:len(rest)-4], nil
+}
+
+// handleSHB resets the interface table: interface IDs are per section.
+func (ng *ngReader) handleSHB() {
+	ng.ifaces = ng.ifaces[:0]
+}
+
+func (ng *ngReader) handleIDB(body []byte) {
+	if len(body) < 8 {
+		return
+	}
+	iface := ngInterface{
+		linkType: ng.order.Uint16(body[0:2]),
+		tsUnit:   time.Microsecond,
+	}
+	// Walk options looking for if_tsresol (code 9).
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code := ng.order.Uint16(opts[0:2])
+		olen := int(ng.order.Uint16(opts[2:4]))
+		if code == 0 || 4+olen > len(opts) {
+			break
+		}
+		if code == 9 && olen >= 1 {
+			iface.tsUnit, iface.tsPerSec = tsResolution(opts[4])
+		}
+		opts = opts[4+((olen+3)&^3):]
+	}
+	ng.ifaces = append(ng.ifaces, iface)
+}
+
+// tsResolution decodes if_tsresol: MSB clear means a power of 10, set means a
+// power of 2. Resolutions finer than 1ns are returned as ticks per second.
+func tsResolution(v byte) (time.Duration, uint64) {
+	exp := uint(v & 0x7F)
+	if v&0x80 != 0 {
+		if exp > 63 {
+			exp = 63
+		}
+		return 0, 1 << exp
+	}
+	if exp <= 9 {
+		unit := time.Second
+		for i := uint(0); i < exp; i++ {
+			unit /= 10
+		}
+		return unit, 0
+	}
+	if exp > 19 {
+		exp = 19
+	}
+	per := uint64(1)
+	for i := uint(0); i < exp; i++ {
+		per *= 10
+	}
+	return 0, per
+}
+
+func (iface ngInterface) timestamp(ticks uint64) time.Time {
+	if iface.tsPerSec > 0 {
+		sec := ticks / iface.tsPerSec
+		rem := ticks % iface.tsPerSec
+		nsec := rem * 1_000_000_000 / iface.tsPerSec
+		return time.Unix(int64(sec), int64(nsec)).UTC()
+	}
+	unit := uint64(iface.tsUnit)
+	return time.Unix(0, 0).Add(time.Duration(ticks * unit)).UTC()
+}
+
+func (ng *ngReader) Next() (*Packet, error) {
+	for {
+		typ, body, err := ng.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case ngBlockSHB:
+			ng.handleSHB()
+		case ngBlockIDB:
+			ng.handleIDB(body)
+		case ngBlockEPB:
+			if len(body) < 20 {
+				continue
+			}
+			ifID := ng.order.Uint32(body[0:4])
+			if int(ifID) >= len(ng.ifaces) {
+				continue
+			}
+			iface := ng.ifaces[ifID]
+			ticks := uint64(ng.order.Uint32(body[4:8]))<<32 | uint64(ng.order.Uint32(body[8:12]))
+			capLen := int(ng.order.Uint32(body[12:16]))
+			origLen := int(ng.order.Uint32(body[16:20]))
+			if capLen > len(body)-20 {
+				capLen = len(body) - 20
+			}
+			return &Packet{
+				Timestamp: iface.timestamp(ticks),
+				LinkType:  iface.linkType,
+				Data:      body[20 : 20+capLen],
+				OrigLen:   origLen,
+			}, nil
+		case ngBlockOPB:
+			if len(body) < 20 {
+				continue
+			}
+			ifID := ng.order.Uint16(body[0:2])
+			if int(ifID) >= len(ng.ifaces) {
+				continue
+			}
+			iface := ng.ifaces[ifID]
+			ticks := uint64(ng.order.Uint32(body[4:8]))<<32 | uint64(ng.order.Uint32(body[8:12]))
+			capLen := int(ng.order.Uint32(body[12:16]))
+			origLen := int(ng.order.Uint32(body[16:20]))
+			if capLen > len(body)-20 {
+				capLen = len(body) - 20
+			}
+			return &Packet{
+				Timestamp: iface.timestamp(ticks),
+				LinkType:  iface.linkType,
+				Data:      body[20 : 20+capLen],
+				OrigLen:   origLen,
+			}, nil
+		case ngBlockSPB:
+			// Simple packets carry no timestamp and always belong to interface 0.
+			if len(body) < 4 || len(ng.ifaces) == 0 {
+				continue
+			}
+			origLen := int(ng.order.Uint32(body[0:4]))
+			data := body[4:]
+			if origLen < len(data) {
+				data = data[:origLen]
+			}
+			return &Packet{
+				LinkType: ng.ifaces[0].linkType,
+				Data:     data,
+				OrigLen:  origLen,
+			}, nil
+		default:
+			// Name resolution, statistics, custom blocks — not needed.
+		}
+	}
+}
@@ -0,0 +1,807 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+const (
+	maxGroups      = 100_000
+	maxValuesItems = 100
+)
+
+// defaultColumns orders the fixed event attributes when no command defines
+// the output columns.
+var defaultColumns = []string{"_time", "host", "source", "severity", "category", "user", "message"}
+
+// stage is one pipeline step. push hands a row downstream; flush is called
+// once after the last row so buffering stages can emit. Either may return
+// errStop to end the scan early.
+type stage interface {
+	push(r Row) error
+	flush() error
+}
+
+// Run executes the plan against src.
+func (p *Plan) Run(ctx context.Context, src Source, opts Options) (*Result, error) {
+	started := time.Now()
+	maxRows := opts.MaxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+
+	for _, cmd := range p.commands {
+		if lc, ok := cmd.(*lookupCmd); ok {
+			if opts.Lookups == nil {
+				return nil, errorf(lc.at, "lookup tables are not available")
+			}
+			if _, _, err := opts.Lookups.Lookup(opts.Tenant, lc.table, ""); err != nil {
+				return nil, errorf(lc.at, "unknown lookup table %q", lc.table)
+			}
+		}
+	}
+
+	out := &sinkStage{max: maxRows}
+	hint := func() []string { return defaultColumns }
+	var first stage = out
+	// Build the chain back to front so each stage knows its successor, and
+	// the column hint front to back.
+	stages := make([]stage, len(p.commands))
+	for i := len(p.commands) - 1; i >= 0; i-- {
+		stages[i] = newStage(p.commands[i], first, opts)
+		first = stages[i]
+	}
+	for i, cmd := range p.commands {
+		hint = columnHint(cmd, stages[i], hint)
+	}
+
+	res := &Result{}
+	q := p.Search
+	if opts.Tenant != "" {
+		q.Tenant = opts.Tenant
+	}
+	err := src.ExportEvents(ctx, &q, func(ev *models.Event) error {
+		res.Scanned++
+		return first.push(eventRow(ev))
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return nil, err
+	}
+	if err := first.flush(); err != nil && !errors.Is(err, errStop) {
+		return nil, err
+	}
+
+	res.Rows = make([]map[string]interface{}, len(out.rows))
+	for i, r := range out.rows {
+		res.Rows[i] = r
+	}
+	res.Truncated = out.truncated
+	res.Columns = columns(out.rows, hint())
+	res.Elapsed = time.Since(started)
+	return res, nil
+}
+
+func newStage(cmd command, next stage, opts Options) stage {
+	switch c := cmd.(type) {
+	case *whereCmd:
+		return &whereStage{cond: c.cond, next: next}
+	case *evalCmd:
+		return &evalStage{assigns: c.assigns, next: next}
+	case *rexCmd:
+		return &rexStage{field: c.field, re: c.re, next: next}
+	case *statsCmd:
+		return &statsStage{aggs: c.aggs, by: c.by, groups: make(map[string]*group), next: next}
+	case *timechartCmd:
+		return &timechartStage{cmd: c, buckets: make(map[int64]map[string][]aggState), next: next}
+	case *sortCmd:
+		return &sortStage{keys: c.keys, limit: c.limit, next: next}
+	case *headCmd:
+		return &headStage{n: c.n, next: next}
+	case *dedupCmd:
+		return &dedupStage{fields: c.fields, seen: make(map[string]struct{}), next: next}
+	case *tableCmd:
+		return &tableStage{patterns: c.fields, next: next}
+	case *renameCmd:
+		return &renameStage{pairs: c.pairs, next: next}
+	case *lookupCmd:
+		return &lookupStage{cmd: c, src: opts.Lookups, tenant: opts.Tenant, next: next}
+	}
+	panic(fmt.Sprintf("query: no stage for %T", cmd))
+}
+
+// columnHint returns the preferred column order after cmd.
+func columnHint(cmd command, st stage, prev func() []string) func() []string {
+	switch c := cmd.(type) {
+	case *tableCmd:
+		return func() []string { return c.fields }
+	case *statsCmd:
+		cols := append([]string{}, c.by...)
+		for _, a := range c.aggs {
+			cols = append(cols, a.alias)
+		}
+		return func() []string { return cols }
+	case *timechartCmd:
+		tc := st.(*timechartStage)
+		return func() []string { return tc.columns }
+	case *renameCmd:
+		return func() []string {
+			cols := append([]string{}, prev()...)
+			for i, col := range cols {
+				for _, p := range c.pairs {
+					if col == p[0] {
+						cols[i] = p[1]
+					}
+				}
+			}
+			return cols
+		}
+	}
+	return prev
+}
+
+// columns expands the hint (which may contain globs) against the keys
+// present in rows and appends any remaining keys in sorted order.
+func columns(rows []Row, hint []string) []string {
+	present := make(map[string]bool)
+	for _, r := range rows {
+		for k := range r {
+			present[k] = true
+		}
+	}
+	var keys []string
+	for k := range present {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	isDefault := len(hint) > 0 && &hint[0] == &defaultColumns[0]
+	used := make(map[string]bool)
+	cols := []string{}
+	for _, h := range hint {
+		if strings.ContainsAny(h, "*?") {
+			for _, k := range keys {
+				if ok, _ := path.Match(h, k); ok && !used[k] {
+					used[k] = true
+					cols = append(cols, k)
+				}
+			}
+			continue
+		}
+		if used[h] || (!present[h] && (isDefault || len(rows) > 0)) {
+			continue
+		}
+		used[h] = true
+		cols = append(cols, h)
+	}
+	for _, k := range keys {
+		if !used[k] && k != "_raw" && k != "_id" {
+			cols = append(cols, k)
+		}
+	}
+	return cols
+}
+
+// ─── streaming stages ────────────────────────────────────────────────────────
+
+type sinkStage struct {
+	max       int
+	rows      []Row
+	truncated bool
+}
+
+func (s *sinkStage) push(r Row) error {
+	if len(s.rows) >= s.max {
+		s.truncated = true
+		return errStop
+	}
+	s.rows = append(s.rows, r)
+	return nil
+}
+
+func (s *sinkStage) flush() error { return nil }
+
+type whereStage struct {
+	cond expr
+	next stage
+}
+
+func (s *whereStage) push(r Row) error {
+	if truthy(s.cond.eval(r)) {
+		return s.next.push(r)
+	}
+	return nil
+}
+
+func (s *whereStage) flush() error { return s.next.flush() }
+
+type evalStage struct {
+	assigns []assign
+	next    stage
+}
+
+func (s *evalStage) push(r Row) error {
+	for _, a := range s.assigns {
+		if v := a.x.eval(r); v != nil {
+			r[a.name] = v
+		} else {
+			delete(r, a.name)
+		}
+	}
+	return s.next.push(r)
+}
+
+func (s *evalStage) flush() error { return s.next.flush() }
+
+type rexStage struct {
+	field string
+	re    *regexp.Regexp
+	next  stage
+}
+
+func (s *rexStage) push(r Row) error {
+	if v, ok := r[s.field]; ok && v != nil {
+		if m := s.re.FindStringSubmatch(toString(v)); m != nil {
+			for i, name := range s.re.SubexpNames() {
+				if name != "" && m[i] != "" {
+					r[name] = m[i]
+				}
+			}
+		}
+	}
+	return s.next.push(r)
+}
+
+func (s *rexStage) flush() error { return s.next.flush() }
+
+type renameStage struct {
+	pairs [][2]string
+	next  stage
+}
+
+func (s *renameStage) push(r Row) error {
+	for _, p := range s.pairs {
+		if v, ok := r[p[0]]; ok {
+			delete(r, p[0])
+			r[p[1]] = v
+		}
+	}
+	return s.next.push(r)
+}
+
+func (s *renameStage) flush() error { return s.next.flush() }
+
+type lookupStage struct {
+	cmd    *lookupCmd
+	src    Lookups
+	tenant string
+	next   stage
+}
+
+func (s *lookupStage) push(r Row) error {
+	v := r[s.cmd.key]
+	if v == nil {
+		return s.next.push(r)
+	}
+	row, found, err := s.src.Lookup(s.tenant, s.cmd.table, toString(v))
+	if err != nil {
+		return fmt.Errorf("query: lookup %s: %w", s.cmd.table, err)
+	}
+	if found {
+		if len(s.cmd.outputs) == 0 {
+			for col, val := range row {
+				if col != s.cmd.column {
+					r[col] = val
+				}
+			}
+		} else {
+			for _, o := range s.cmd.outputs {
+				if val, ok := row[o[0]]; ok {
+					r[o[1]] = val
+				}
+			}
+		}
+	}
+	return s.next.push(r)
+}
+
+func (s *lookupStage) flush() error { return s.next.flush() }
+
+type tableStage struct {
+	patterns []string
+	next     stage
+}
+
+func (s *tableStage) push(r Row) error {
+	out := make(Row, len(s.patterns))
+	for _, p := range s.patterns {
+		if !strings.ContainsAny(p, "*?") {
+			if v, ok := r[p]; ok {
+				out[p] = v
+			}
+			continue
+		}
+		for k, v := range r {
+			if ok, _ := path.Match(p, k); ok {
+				out[k] = v
+			}
+		}
+	}
+	return s.next.push(out)
+}
+
+func (s *tableStage) flush() error { return s.next.flush() }
+
+type headStage struct {
+	n, seen int
+	next    stage
+}
+
+func (s *headStage) push(r Row) error {
+	if s.seen >= s.n {
+		return errStop
+	}
+	s.seen++
+	if err := s.next.push(r); err != nil {
+		return err
+	}
+	if s.seen == s.n {
+		return errStop
+	}
+	return nil
+}
+
+func (s *headStage) flush() error { return s.next.flush() }
+
+type dedupStage struct {
+	fields []string
+	seen   map[string]struct{}
+	next   stage
+}
+
+func (s *dedupStage) push(r Row) error {
+	parts := make([]string, len(s.fields))
+	for i, f := range s.fields {
+		v, ok := r[f]
+		if !ok || v == nil {
+			return nil
+		}
+		parts[i] = toString(v)
+	}
+	key := strings.Join(parts, "\x00")
+	if _, dup := s.seen[key]; dup {
+		return nil
+	}
+	s.seen[key] = struct{}{}
+	return s.next.push(r)
+}
+
+func (s *dedupStage) flush() error { return s.next.flush() }
+
+// ─── buffering stages ────────────────────────────────────────────────────────
+
+// emit pushes rows downstream, stopping at the first errStop, then flushes.
+func emit(next stage, rows []Row) error {
+	for _, r := range rows {
+		if err := next.push(r); err != nil {
+			if errors.Is(err, errStop) {
+				break
+			}
+			return err
+		}
+	}
+	return next.flush()
+}
+
+type sortStage struct {
+	keys  []sortKey
+	limit int
+	rows  []Row
+	next  stage
+}
+
+func (s *sortStage) push(r Row) error {
+	s.rows = append(s.rows, r)
+	return nil
+}
+
+func (s *sortStage) flush() error {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		for _, k := range s.keys {
+			a, b := s.rows[i][k.field], s.rows[j][k.field]
+			c := compareValues(a, b)
+			if c == 0 {
+				continue
+			}
+			// Missing values go last in either direction.
+			if k.desc && a != nil && b != nil {
+				c = -c
+			}
+			return c < 0
+		}
+		return false
+	})
+	if s.limit > 0 && len(s.rows) > s.limit {
+		s.rows = s.rows[:s.limit]
+	}
+	return emit(s.next, s.rows)
+}
+
+type group struct {
+	by     []interface{}
+	states []aggState
+}
+
+type statsStage struct {
+	aggs   []aggSpec
+	by     []string
+	groups map[string]*group
+	order  []*group
+	next   stage
+}
+
+func (s *statsStage) push(r Row) error {
+	byVals := make([]interface{}, len(s.by))
+	parts := make([]string, len(s.by))
+	for i, f := range s.by {
+		v := r[f]
+		if v == nil {
+			return nil // rows without every by field are not grouped
+		}
+		byVals[i], parts[i] = v, toString(v)
+	}
+	key := strings.Join(parts, "\x00")
+	g, ok := s.groups[key]
+	if !ok {
+		if len(s.groups) >= maxGroups {
+			return fmt.Errorf("query: stats: more than %d groups", maxGroups)
+		}
+		g = &group{by: byVals, states: newStates(s.aggs)}
+		s.groups[key] = g
+		s.order = append(s.order, g)
+	}
+	addRow(s.aggs, g.states, r)
+	return nil
+}
+
+func (s *statsStage) flush() error {
+	if len(s.by) == 0 && len(s.order) == 0 {
+		s.order = append(s.order, &group{states: newStates(s.aggs)})
+	}
+	sort.SliceStable(s.order, func(i, j int) bool {
+		for k := range s.by {
+			if c := compareValues(s.order[i].by[k], s.order[j].by[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	rows := make([]Row, 0, len(s.order))
+	for _, g := range s.order {
+		r := make(Row, len(s.by)+len(s.aggs))
+		for i, f := range s.by {
+			r[f] = g.by[i]
+		}
+		for i, a := range s.aggs {
+			if v := g.states[i].value(); v != nil {
+				r[a.alias] = v
+			}
+		}
+		rows = append(rows, r)
+	}
+	return emit(s.next, rows)
+}
+
+type timechartStage struct {
+	cmd     *timechartCmd
+	buckets map[int64]map[string][]aggState // bucket start → series → states
+	columns []string
+	next    stage
+}
+
+func (s *timechartStage) push(r Row) error {
+	t, ok := r["_time"].(time.Time)
+	if !ok {
+		return nil
+	}
+	b := t.UTC().Truncate(s.cmd.span).UnixNano()
+	series := ""
+	if s.cmd.by != "" {
+		series = "NULL"
+		if v := r[s.cmd.by]; v != nil {
+			series = toString(v)
+		}
+	}
+	bucket, ok := s.buckets[b]
+	if !ok {
+		if len(s.buckets) >= maxGroups {
+			return fmt.Errorf("query: timechart: more than %d buckets, use a larger span", maxGroups)
+		}
+		bucket = make(map[string][]aggState)
+		s.buckets[b] = bucket
+	}
+	states, ok := bucket[series]
+	if !ok {
+		states = newStates(s.cmd.aggs)
+		bucket[series] = states
+	}
+	addRow(s.cmd.aggs, states, r)
+	return nil
+}
+
+func (s *timechartStage) flush() error {
+	if len(s.buckets) == 0 {
+		s.columns = []string{"_time"}
+		return s.next.flush()
+	}
+
+	// With by, keep the most active series and fold the rest into OTHER.
+	var series []string
+	rename := map[string]string{}
+	if s.cmd.by != "" {
+		weight := map[string]int{}
+		for _, bucket := range s.buckets {
+			for name, st := range bucket {
+				weight[name] += st[0].rows()
+			}
+		}
+		for name := range weight {
+			series = append(series, name)
+		}
+		sort.Slice(series, func(i, j int) bool {
+			if weight[series[i]] != weight[series[j]] {
+				return weight[series[i]] > weight[series[j]]
+			}
+			return series[i] < series[j]
+		})
+		if len(series) > s.cmd.limit {
+			for _, name := range series[s.cmd.limit:] {
+				rename[name] = "OTHER"
+			}
+			series = append(series[:s.cmd.limit], "OTHER")
+		}
+	}
+
+	var starts []int64
+	for b := range s.buckets {
+		starts = append(starts, b)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	s.columns = []string{"_time"}
+	if s.cmd.by == "" {
+		for _, a := range s.cmd.aggs {
+			s.columns = append(s.columns, a.alias)
+		}
+	} else {
+		s.columns = append(s.columns, series...)
+	}
+
+	var rows []Row
+	step := s.cmd.span.Nanoseconds()
+	for b := starts[0]; b <= starts[len(starts)-1]; b += step {
+		r := Row{"_time": time.Unix(0, b).UTC()}
+		bucket := s.buckets[b]
+		if s.cmd.by == "" {
+			for i, a := range s.cmd.aggs {
+				r[a.alias] = fillValue(a, bucket[""], i)
+			}
+		} else {
+			merged := map[string][]aggState{}
+			for name, st := range bucket {
+				if to, ok := rename[name]; ok {
+					name = to
+				}
+				if cur, ok := merged[name]; ok {
+					cur[0].merge(st[0])
+				} else {
+					merged[name] = st
+				}
+			}
+			for _, name := range series {
+				r[name] = fillValue(s.cmd.aggs[0], merged[name], 0)
+			}
+		}
+		rows = append(rows, r)
+	}
+	return emit(s.next, rows)
+}
+
+// fillValue is the value of states[i], with empty count-like buckets as 0.
+func fillValue(a aggSpec, states []aggState, i int) interface{} {
+	if states != nil {
+		if v := states[i].value(); v != nil {
+			return v
+		}
+	}
+	if a.fn == "count" || a.fn == "dc" {
+		return float64(0)
+	}
+	return nil
+}
+
+// ─── aggregation state ───────────────────────────────────────────────────────
+
+type aggState interface {
+	add(v interface{})
+	merge(o aggState)
+	value() interface{}
+	rows() int
+}
+
+func newStates(aggs []aggSpec) []aggState {
+	out := make([]aggState, len(aggs))
+	for i, a := range aggs {
+		out[i] = newState(a.fn)
+	}
+	return out
+}
+
+func addRow(aggs []aggSpec, states []aggState, r Row) {
+	for i, a := range aggs {
+		if a.field == "" {
+			states[i].add(true)
+			continue
+		}
+		if v := r[a.field]; v != nil {
+			states[i].add(v)
+		}
+	}
+}
+
+func newState(fn string) aggState {
+	switch fn {
+	case "dc", "values":
+		return &setState{fn: fn, set: make(map[string]struct{})}
+	case "first", "last":
+		return &pickState{last: fn == "last"}
+	case "min", "max":
+		return &extremeState{max: fn == "max"}
+	}
+	return &numState{fn: fn}
+}
+
+// numState covers count, sum and avg.
+type numState struct {
+	fn      string
+	n, nums int
+	sum     float64
+}
+
+func (s *numState) add(v interface{}) {
+	s.n++
+	if f, ok := toNumber(v); ok {
+		s.nums++
+		s.sum += f
+	}
+}
+
+func (s *numState) merge(o aggState) {
+	if o, ok := o.(*numState); ok {
+		s.n += o.n
+		s.nums += o.nums
+		s.sum += o.sum
+	}
+}
+
+func (s *numState) value() interface{} {
+	switch s.fn {
+	case "count":
+		return float64(s.n)
+	case "sum":
+		if s.nums > 0 {
+			return s.sum
+		}
+	case "avg":
+		if s.nums > 0 {
+			return s.sum / float64(s.nums)
+		}
+	}
+	return nil
+}
+
+func (s *numState) rows() int { return s.n }
+
+type setState struct {
+	fn  string
+	n   int
+	set map[string]struct{}
+}
+
+func (s *setState) add(v interface{}) {
+	s.n++
+	if s.fn == "values" && len(s.set) >= maxValuesItems {
+		return
+	}
+	s.set[toString(v)] = struct{}{}
+}
+
+func (s *setState) merge(o aggState) {
+	if o, ok := o.(*setState); ok {
+		s.n += o.n
+		for k := range o.set {
+			s.set[k] = struct{}{}
+		}
+	}
+}
+
+func (s *setState) value() interface{} {
+	if s.fn == "dc" {
+		return float64(len(s.set))
+	}
+	if len(s.set) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(s.set))
+	for k := range s.set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *setState) rows() int { return s.n }
+
+type pickState struct {
+	last bool
+	n    int
+	v    interface{}
+}
+
+func (s *pickState) add(v interface{}) {
+	s.n++
+	if s.v == nil || s.last {
+		s.v = v
+	}
+}
+
+func (s *pickState) merge(o aggState) {
+	if o, ok := o.(*pickState); ok {
+		s.n += o.n
+		if s.v == nil || (s.last && o.v != nil) {
+			s.v = o.v
+		}
+	}
+}
+
+func (s *pickState) value() interface{} { return s.v }
+func (s *pickState) rows() int          { return s.n }
+
+type extremeState struct {
+	max bool
+	n   int
+	v   interface{}
+}
+
+func (s *extremeState) add(v interface{}) {
+	s.n++
+	if f, ok := toNumber(v); ok {
+		v = f
+	}
+	if s.v == nil {
+		s.v = v
+		return
+	}
+	c := compareValues(v, s.v)
+	if (s.max && c > 0) || (!s.max && c < 0) {
+		s.v = v
+	}
+}
+
+func (s *extremeState) merge(o aggState) {
+	if o, ok := o.(*extremeState); ok && o.v != nil {
+		n := s.n
+		s.add(o.v)
+		s.n = n + o.n
+	}
+}
+
+func (s *extremeState) value() interface{} { return s.v }
+func (s *extremeState) rows() int          { return s.n }
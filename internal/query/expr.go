@@ -0,0 +1,597 @@
+package query
+
+import (
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Row is one record flowing through the pipeline.
+type Row map[string]interface{}
+
+// expr is an eval/where expression. Evaluation never fails: type mismatches
+// and missing fields yield nil, as in SPL.
+type expr interface {
+	eval(r Row) interface{}
+}
+
+type (
+	literal  struct{ v interface{} }
+	fieldRef struct {
+		name string
+		at   int
+	}
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		op   string
+		l, r expr
+	}
+	callExpr struct {
+		fn   *function
+		args []expr
+	}
+)
+
+// ─── expression parser ───────────────────────────────────────────────────────
+
+// exprUntilEnd parses an expression that must span the rest of the command.
+func (p *parser) exprUntilEnd() (expr, error) {
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	t, err := p.peek(modeExpr)
+	if err != nil {
+		return nil, err
+	}
+	if !atCommandEnd(t) {
+		return nil, errorf(t.pos, "unexpected %s in expression", describe(t))
+	}
+	return x, nil
+}
+
+func (p *parser) expr() (expr, error) { return p.exprOr() }
+
+func (p *parser) exprOr() (expr, error) {
+	l, err := p.exprAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, err := p.peek(modeExpr)
+		if err != nil {
+			return nil, err
+		}
+		if !t.keyword("or") {
+			return l, nil
+		}
+		_, _ = p.next(modeExpr)
+		r, err := p.exprAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "or", l: l, r: r}
+	}
+}
+
+func (p *parser) exprAnd() (expr, error) {
+	l, err := p.exprNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, err := p.peek(modeExpr)
+		if err != nil {
+			return nil, err
+		}
+		if !t.keyword("and") {
+			return l, nil
+		}
+		_, _ = p.next(modeExpr)
+		r, err := p.exprNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "and", l: l, r: r}
+	}
+}
+
+func (p *parser) exprNot() (expr, error) {
+	t, err := p.peek(modeExpr)
+	if err != nil {
+		return nil, err
+	}
+	if t.keyword("not") {
+		_, _ = p.next(modeExpr)
+		x, err := p.exprNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "not", x: x}, nil
+	}
+	return p.exprCmp()
+}
+
+func (p *parser) exprCmp() (expr, error) {
+	l, err := p.exprConcat()
+	if err != nil {
+		return nil, err
+	}
+	t, err := p.peek(modeExpr)
+	if err != nil {
+		return nil, err
+	}
+	if t.kind == tOp {
+		switch t.text {
+		case "=", "==", "!=", "<", "<=", ">", ">=":
+			_, _ = p.next(modeExpr)
+			r, err := p.exprConcat()
+			if err != nil {
+				return nil, err
+			}
+			op := t.text
+			if op == "==" {
+				op = "="
+			}
+			return &binaryExpr{op: op, l: l, r: r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) exprConcat() (expr, error) {
+	return p.binaryLevel([]string{"."}, p.exprAdd)
+}
+
+func (p *parser) exprAdd() (expr, error) {
+	return p.binaryLevel([]string{"+", "-"}, p.exprMul)
+}
+
+func (p *parser) exprMul() (expr, error) {
+	return p.binaryLevel([]string{"*", "/", "%"}, p.exprUnary)
+}
+
+func (p *parser) binaryLevel(ops []string, sub func() (expr, error)) (expr, error) {
+	l, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, err := p.peek(modeExpr)
+		if err != nil {
+			return nil, err
+		}
+		matched := ""
+		for _, op := range ops {
+			if t.is(tOp, op) {
+				matched = op
+			}
+		}
+		if matched == "" {
+			return l, nil
+		}
+		_, _ = p.next(modeExpr)
+		r, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: matched, l: l, r: r}
+	}
+}
+
+func (p *parser) exprUnary() (expr, error) {
+	t, err := p.peek(modeExpr)
+	if err != nil {
+		return nil, err
+	}
+	if t.is(tOp, "-") {
+		_, _ = p.next(modeExpr)
+		x, err := p.exprUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "-", x: x}, nil
+	}
+	return p.exprPrimary()
+}
+
+func (p *parser) exprPrimary() (expr, error) {
+	t, err := p.next(modeExpr)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t.is(tOp, "("):
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expectOp(")", modeExpr); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case t.kind == tString:
+		return &literal{v: t.text}, nil
+	case t.kind != tWord:
+		return nil, errorf(t.pos, "expected expression, found %s", describe(t))
+	case isDigit(t.text[0]) || t.text[0] == '.':
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errorf(t.pos, "invalid number %q", t.text)
+		}
+		return &literal{v: f}, nil
+	}
+
+	switch strings.ToLower(t.text) {
+	case "true":
+		return &literal{v: true}, nil
+	case "false":
+		return &literal{v: false}, nil
+	case "null":
+		return &literal{v: nil}, nil
+	}
+
+	if next, _ := p.peek(modeExpr); next.is(tOp, "(") {
+		return p.call(t)
+	}
+	return &fieldRef{name: t.text, at: t.pos}, nil
+}
+
+func (p *parser) call(name token) (expr, error) {
+	fn, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, errorf(name.pos, "unknown function %q", name.text)
+	}
+	_, _ = p.next(modeExpr) // (
+	var args []expr
+	if t, _ := p.peek(modeExpr); t.is(tOp, ")") {
+		_, _ = p.next(modeExpr)
+	} else {
+		for {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, x)
+			t, err := p.next(modeExpr)
+			if err != nil {
+				return nil, err
+			}
+			if t.is(tOp, ")") {
+				break
+			}
+			if !t.is(tOp, ",") {
+				return nil, errorf(t.pos, "expected ',' or ')' in call to %s, found %s", fn.name, describe(t))
+			}
+		}
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, errorf(name.pos, "%s: wrong number of arguments (%d)", fn.name, len(args))
+	}
+	c := &callExpr{fn: fn, args: args}
+	if fn.prepare != nil {
+		if err := fn.prepare(c); err != nil {
+			return nil, errorf(name.pos, "%s: %v", fn.name, err)
+		}
+	}
+	return c, nil
+}
+
+// ─── evaluation ──────────────────────────────────────────────────────────────
+
+func (l *literal) eval(Row) interface{}    { return l.v }
+func (f *fieldRef) eval(r Row) interface{} { return r[f.name] }
+
+func (u *unaryExpr) eval(r Row) interface{} {
+	v := u.x.eval(r)
+	switch u.op {
+	case "not":
+		if b, ok := v.(bool); ok {
+			return !b
+		}
+		return nil
+	case "-":
+		if n, ok := toNumber(v); ok {
+			return -n
+		}
+	}
+	return nil
+}
+
+func (b *binaryExpr) eval(r Row) interface{} {
+	switch b.op {
+	case "and":
+		return truthy(b.l.eval(r)) && truthy(b.r.eval(r))
+	case "or":
+		return truthy(b.l.eval(r)) || truthy(b.r.eval(r))
+	}
+
+	l, rv := b.l.eval(r), b.r.eval(r)
+	switch b.op {
+	case ".":
+		if l == nil && rv == nil {
+			return nil
+		}
+		return toString(l) + toString(rv)
+	case "=", "!=", "<", "<=", ">", ">=":
+		if l == nil || rv == nil {
+			return false
+		}
+		c := compareValues(l, rv)
+		switch b.op {
+		case "=":
+			return c == 0
+		case "!=":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}
+
+	x, ok1 := toNumber(l)
+	y, ok2 := toNumber(rv)
+	if !ok1 || !ok2 {
+		if b.op == "+" {
+			if s1, ok := l.(string); ok {
+				if s2, ok := rv.(string); ok {
+					return s1 + s2
+				}
+			}
+		}
+		return nil
+	}
+	switch b.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return nil
+		}
+		return x / y
+	case "%":
+		if y == 0 {
+			return nil
+		}
+		return math.Mod(x, y)
+	}
+	return nil
+}
+
+func (c *callExpr) eval(r Row) interface{} {
+	return c.fn.eval(c, r)
+}
+
+// ─── functions ───────────────────────────────────────────────────────────────
+
+type function struct {
+	name             string
+	minArgs, maxArgs int // maxArgs -1 = variadic
+	eval             func(c *callExpr, r Row) interface{}
+	// prepare validates literal arguments at parse time (e.g. compiles a
+	// regular expression once).
+	prepare func(c *callExpr) error
+}
+
+var functions map[string]*function
+
+func init() {
+	fns := []*function{
+		{name: "if", minArgs: 3, maxArgs: 3, eval: func(c *callExpr, r Row) interface{} {
+			if truthy(c.args[0].eval(r)) {
+				return c.args[1].eval(r)
+			}
+			return c.args[2].eval(r)
+		}},
+		{name: "case", minArgs: 2, maxArgs: -1, eval: func(c *callExpr, r Row) interface{} {
+			for i := 0; i+1 < len(c.args); i += 2 {
+				if truthy(c.args[i].eval(r)) {
+					return c.args[i+1].eval(r)
+				}
+			}
+			return nil
+		}},
+		{name: "coalesce", minArgs: 1, maxArgs: -1, eval: func(c *callExpr, r Row) interface{} {
+			for _, a := range c.args {
+				if v := a.eval(r); v != nil && v != "" {
+					return v
+				}
+			}
+			return nil
+		}},
+		{name: "isnull", minArgs: 1, maxArgs: 1, eval: func(c *callExpr, r Row) interface{} {
+			return c.args[0].eval(r) == nil
+		}},
+		{name: "isnotnull", minArgs: 1, maxArgs: 1, eval: func(c *callExpr, r Row) interface{} {
+			return c.args[0].eval(r) != nil
+		}},
+		{name: "len", minArgs: 1, maxArgs: 1, eval: func(c *callExpr, r Row) interface{} {
+			v := c.args[0].eval(r)
+			if v == nil {
+				return nil
+			}
+			return float64(len(toString(v)))
+		}},
+		{name: "lower", minArgs: 1, maxArgs: 1, eval: stringFn(strings.ToLower)},
+		{name: "upper", minArgs: 1, maxArgs: 1, eval: stringFn(strings.ToUpper)},
+		{name: "trim", minArgs: 1, maxArgs: 1, eval: stringFn(strings.TrimSpace)},
+		{name: "substr", minArgs: 2, maxArgs: 3, eval: func(c *callExpr, r Row) interface{} {
+			v := c.args[0].eval(r)
+			start, ok := toNumber(c.args[1].eval(r))
+			if v == nil || !ok {
+				return nil
+			}
+			s := []rune(toString(v))
+			from := int(start) - 1 // SPL is 1-based
+			if from < 0 {
+				from = 0
+			}
+			if from > len(s) {
+				return ""
+			}
+			to := len(s)
+			if len(c.args) == 3 {
+				n, ok := toNumber(c.args[2].eval(r))
+				if !ok {
+					return nil
+				}
+				if from+int(n) < to {
+					to = from + int(n)
+				}
+			}
+			if to < from {
+				return ""
+			}
+			return string(s[from:to])
+		}},
+		{name: "replace", minArgs: 3, maxArgs: 3, prepare: prepareRegex(1), eval: func(c *callExpr, r Row) interface{} {
+			v := c.args[0].eval(r)
+			if v == nil {
+				return nil
+			}
+			return argRegex(c, r, 1).ReplaceAllString(toString(v), toString(c.args[2].eval(r)))
+		}},
+		{name: "match", minArgs: 2, maxArgs: 2, prepare: prepareRegex(1), eval: func(c *callExpr, r Row) interface{} {
+			v := c.args[0].eval(r)
+			if v == nil {
+				return false
+			}
+			return argRegex(c, r, 1).MatchString(toString(v))
+		}},
+		{name: "like", minArgs: 2, maxArgs: 2, eval: func(c *callExpr, r Row) interface{} {
+			v := c.args[0].eval(r)
+			if v == nil {
+				return false
+			}
+			return likeMatch(toString(v), toString(c.args[1].eval(r)))
+		}},
+		{name: "cidrmatch", minArgs: 2, maxArgs: 2, eval: func(c *callExpr, r Row) interface{} {
+			_, n, err := net.ParseCIDR(toString(c.args[0].eval(r)))
+			ip := net.ParseIP(toString(c.args[1].eval(r)))
+			return err == nil && ip != nil && n.Contains(ip)
+		}},
+		{name: "tonumber", minArgs: 1, maxArgs: 1, eval: func(c *callExpr, r Row) interface{} {
+			if n, ok := toNumber(c.args[0].eval(r)); ok {
+				return n
+			}
+			return nil
+		}},
+		{name: "tostring", minArgs: 1, maxArgs: 1, eval: func(c *callExpr, r Row) interface{} {
+			v := c.args[0].eval(r)
+			if v == nil {
+				return nil
+			}
+			return toString(v)
+		}},
+		{name: "round", minArgs: 1, maxArgs: 2, eval: func(c *callExpr, r Row) interface{} {
+			x, ok := toNumber(c.args[0].eval(r))
+			if !ok {
+				return nil
+			}
+			digits := 0.0
+			if len(c.args) == 2 {
+				digits, _ = toNumber(c.args[1].eval(r))
+			}
+			p := math.Pow(10, digits)
+			return math.Round(x*p) / p
+		}},
+		{name: "abs", minArgs: 1, maxArgs: 1, eval: numberFn(math.Abs)},
+		{name: "floor", minArgs: 1, maxArgs: 1, eval: numberFn(math.Floor)},
+		{name: "ceil", minArgs: 1, maxArgs: 1, eval: numberFn(math.Ceil)},
+		{name: "now", minArgs: 0, maxArgs: 0, eval: func(*callExpr, Row) interface{} {
+			return float64(time.Now().Unix())
+		}},
+	}
+	functions = make(map[string]*function, len(fns))
+	for _, f := range fns {
+		functions[f.name] = f
+	}
+}
+
+func stringFn(f func(string) string) func(*callExpr, Row) interface{} {
+	return func(c *callExpr, r Row) interface{} {
+		v := c.args[0].eval(r)
+		if v == nil {
+			return nil
+		}
+		return f(toString(v))
+	}
+}
+
+func numberFn(f func(float64) float64) func(*callExpr, Row) interface{} {
+	return func(c *callExpr, r Row) interface{} {
+		if x, ok := toNumber(c.args[0].eval(r)); ok {
+			return f(x)
+		}
+		return nil
+	}
+}
+
+// prepareRegex compiles argument i once when it is a string literal.
+func prepareRegex(i int) func(*callExpr) error {
+	return func(c *callExpr) error {
+		lit, ok := c.args[i].(*literal)
+		if !ok {
+			return nil
+		}
+		re, err := regexp.Compile(toString(lit.v))
+		if err != nil {
+			return err
+		}
+		c.args[i] = &regexLiteral{literal: *lit, re: re}
+		return nil
+	}
+}
+
+// regexLiteral is a string literal with its compiled regular expression.
+type regexLiteral struct {
+	literal
+	re *regexp.Regexp
+}
+
+// argRegex returns the compiled pattern of argument i, compiling dynamic
+// patterns on each call. An invalid pattern matches nothing.
+func argRegex(c *callExpr, r Row, i int) *regexp.Regexp {
+	if rl, ok := c.args[i].(*regexLiteral); ok {
+		return rl.re
+	}
+	re, err := regexp.Compile(toString(c.args[i].eval(r)))
+	if err != nil {
+		return regexp.MustCompile(`$^`)
+	}
+	return re
+}
+
+// likeMatch implements SQL LIKE: % matches any run, _ one character.
+func likeMatch(s, pattern string) bool {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, ch := range pattern {
+		switch ch {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	return err == nil && re.MatchString(s)
+}
@@ -0,0 +1,339 @@
+package query
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// Filter is a query compiled for matching single events in memory, as live
+// tail does with events still in the ingestion pipeline. It accepts the
+// search clause and where commands; time bounds and other commands need
+// stored data and are rejected.
+type Filter struct {
+	match func(ev *models.Event) bool
+	where []expr
+}
+
+// CompileFilter compiles q into a Filter. An empty query matches everything.
+func CompileFilter(q string) (*Filter, error) {
+	f, err := compileFilter(q)
+	if err != nil {
+		var qe *Error
+		if errors.As(err, &qe) {
+			qe.Query = q
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileFilter(q string) (*Filter, error) {
+	ast, err := parse(q)
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{match: func(*models.Event) bool { return true }}
+	if ast.search != nil {
+		if f.match, err = eventMatcher(ast.search); err != nil {
+			return nil, err
+		}
+	}
+	for i, cmd := range ast.commands {
+		w, ok := cmd.(*whereCmd)
+		if !ok {
+			return nil, errorf(ast.cmdPos[i], "live filters support only the search clause and where")
+		}
+		f.where = append(f.where, w.cond)
+	}
+	return f, nil
+}
+
+// Match reports whether ev satisfies the filter.
+func (f *Filter) Match(ev *models.Event) bool {
+	if !f.match(ev) {
+		return false
+	}
+	if len(f.where) > 0 {
+		r := eventRow(ev)
+		for _, w := range f.where {
+			if !truthy(w.eval(r)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// eventMatcher mirrors the index semantics of the search clause: bare words
+// match whole message tokens case-insensitively, "phrases" match a token
+// sequence, and field comparisons follow matchCond.
+func eventMatcher(n sNode) (func(*models.Event) bool, error) {
+	switch n := n.(type) {
+	case *sAnd:
+		items, err := eventMatchers(n.items)
+		if err != nil {
+			return nil, err
+		}
+		return func(ev *models.Event) bool {
+			for _, m := range items {
+				if !m(ev) {
+					return false
+				}
+			}
+			return true
+		}, nil
+
+	case *sOr:
+		items, err := eventMatchers(n.items)
+		if err != nil {
+			return nil, err
+		}
+		return func(ev *models.Event) bool {
+			for _, m := range items {
+				if m(ev) {
+					return true
+				}
+			}
+			return false
+		}, nil
+
+	case *sNot:
+		m, err := eventMatcher(n.item)
+		if err != nil {
+			return nil, err
+		}
+		return func(ev *models.Event) bool { return !m(ev) }, nil
+
+	case *sText:
+		return textMatcher(n), nil
+
+	case *sField:
+		return fieldMatcher(n)
+	}
+	return nil, errorf(n.pos(), "unsupported search term")
+}
+
+func eventMatchers(nodes []sNode) ([]func(*models.Event) bool, error) {
+	out := make([]func(*models.Event) bool, len(nodes))
+	for i, it := range nodes {
+		m, err := eventMatcher(it)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = m
+	}
+	return out, nil
+}
+
+func textMatcher(n *sText) func(*models.Event) bool {
+	want := tokenize(n.text)
+	if !n.phrase && strings.Contains(n.text, "*") {
+		re := globRegexp(strings.ToLower(n.text))
+		return func(ev *models.Event) bool {
+			for _, tok := range tokenize(ev.Message) {
+				if re.MatchString(tok) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if len(want) == 0 {
+		return func(*models.Event) bool { return true }
+	}
+	if n.phrase {
+		return func(ev *models.Event) bool { return containsSeq(tokenize(ev.Message), want) }
+	}
+	// A bare word that splits into several tokens matches if any of them is
+	// present, as the index's match query does.
+	return func(ev *models.Event) bool {
+		for _, tok := range tokenize(ev.Message) {
+			for _, w := range want {
+				if tok == w {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func fieldMatcher(n *sField) (func(*models.Event) bool, error) {
+	switch strings.ToLower(n.field) {
+	case "earliest", "latest":
+		return nil, errorf(n.at, "%s is not supported in live filters", n.field)
+	}
+	field := n.field
+
+	switch n.op {
+	case "=", "!=":
+		m := valueMatcher(field, n.value)
+		if n.op == "!=" {
+			return func(ev *models.Event) bool { return !m(ev) }, nil
+		}
+		return m, nil
+	}
+
+	if coreFields[field] {
+		return nil, errorf(n.at, "field %s does not support %s", field, n.op)
+	}
+	limit, err := strconv.ParseFloat(n.value, 64)
+	if err != nil {
+		return nil, errorf(n.valueAt, "%s%s expects a number, found %q", field, n.op, n.value)
+	}
+	op := n.op
+	return func(ev *models.Event) bool {
+		v, ok := toNumber(fieldValue(ev, field))
+		if !ok {
+			return false
+		}
+		switch op {
+		case "<":
+			return v < limit
+		case "<=":
+			return v <= limit
+		case ">":
+			return v > limit
+		}
+		return v >= limit
+	}, nil
+}
+
+// valueMatcher implements field=value with the same forms as matchCond.
+func valueMatcher(field, value string) func(*models.Event) bool {
+	present := func(ev *models.Event) (string, bool) {
+		v := fieldValue(ev, field)
+		if v == nil {
+			return "", false
+		}
+		s := toString(v)
+		return s, s != ""
+	}
+	switch {
+	case value == "*":
+		return func(ev *models.Event) bool { _, ok := present(ev); return ok }
+	case strings.ContainsAny(value, "*?"):
+		re := globRegexp(value)
+		return func(ev *models.Event) bool { s, ok := present(ev); return ok && re.MatchString(s) }
+	case strings.Contains(value, "/"):
+		if _, n, err := net.ParseCIDR(value); err == nil {
+			return func(ev *models.Event) bool {
+				s, ok := present(ev)
+				ip := net.ParseIP(s)
+				return ok && ip != nil && n.Contains(ip)
+			}
+		}
+	}
+	num, isNum := toNumber(value)
+	return func(ev *models.Event) bool {
+		v := fieldValue(ev, field)
+		if v == nil {
+			return false
+		}
+		if field == "message" {
+			return strings.EqualFold(toString(v), value)
+		}
+		if s := toString(v); s == value {
+			return true
+		}
+		if isNum {
+			n, ok := toNumber(v)
+			return ok && n == num
+		}
+		return false
+	}
+}
+
+// fieldValue reads a named attribute of ev without building a full Row.
+func fieldValue(ev *models.Event, name string) interface{} {
+	switch name {
+	case "message":
+		return ev.Message
+	case "source":
+		return ev.Source
+	case "host":
+		return ev.Host
+	case "user":
+		return ev.User
+	case "severity":
+		return string(ev.Severity)
+	case "category":
+		return ev.Category
+	case "_raw":
+		return ev.Raw
+	case "_id":
+		return ev.ID
+	}
+	if v, ok := ev.Fields[name]; ok {
+		return v
+	}
+	// Dotted names reach into nested field maps.
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		var cur interface{} = ev.Fields[name[:i]]
+		for _, part := range strings.Split(name[i+1:], ".") {
+			switch m := cur.(type) {
+			case map[string]interface{}:
+				cur = m[part]
+			case map[string]string:
+				cur = m[part]
+			default:
+				cur = nil
+			}
+		}
+		if cur != nil {
+			return cur
+		}
+	}
+	if v, ok := ev.Metadata[name]; ok {
+		return v
+	}
+	return nil
+}
+
+// tokenize lower-cases s and splits it on anything but letters and digits,
+// close to the index analyzer.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func containsSeq(toks, want []string) bool {
+	for i := 0; i+len(want) <= len(toks); i++ {
+		match := true
+		for j, w := range want {
+			if toks[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp turns a * / ? wildcard pattern into an anchored regexp.
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, ch := range pattern {
+		switch ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
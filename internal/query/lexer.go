@@ -0,0 +1,153 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tWord
+	tString
+	tOp
+)
+
+// token is one lexical element. pos is the byte offset in the query.
+type token struct {
+	kind tokenKind
+	text string // raw text (operators, words) or unquoted value (strings)
+	pos  int
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// keyword reports whether t is the case-insensitive keyword kw.
+func (t token) keyword(kw string) bool {
+	return t.kind == tWord && strings.EqualFold(t.text, kw)
+}
+
+// scanMode selects how bare words are delimited. The search clause accepts
+// liberal words (10.0.0.0/8, *.exe, -24h); expressions use strict identifiers
+// so that - * / . can act as operators.
+type scanMode int
+
+const (
+	modeSearch scanMode = iota
+	modeExpr
+)
+
+// lexer is a cursor over the query string; the parser chooses the scan mode
+// per call.
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+}
+
+// peek returns the next token without consuming it.
+func (l *lexer) peek(mode scanMode) (token, error) {
+	save := l.pos
+	t, err := l.next(mode)
+	l.pos = save
+	return t, err
+}
+
+func (l *lexer) next(mode scanMode) (token, error) {
+	l.skipSpace()
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+
+	switch {
+	case c == '"' || c == '\'':
+		return l.scanString(c)
+	case strings.HasPrefix(l.src[l.pos:], "!="), strings.HasPrefix(l.src[l.pos:], "<="),
+		strings.HasPrefix(l.src[l.pos:], ">="), strings.HasPrefix(l.src[l.pos:], "=="):
+		l.pos += 2
+		return token{kind: tOp, text: l.src[start:l.pos], pos: start}, nil
+	case strings.IndexByte("|()=<>,", c) >= 0:
+		l.pos++
+		return token{kind: tOp, text: string(c), pos: start}, nil
+	}
+
+	if mode == modeSearch {
+		for l.pos < len(l.src) {
+			ch := l.src[l.pos]
+			if unicode.IsSpace(rune(ch)) || strings.IndexByte("|()=<>,\"'", ch) >= 0 ||
+				(ch == '!' && strings.HasPrefix(l.src[l.pos:], "!=")) {
+				break
+			}
+			l.pos++
+		}
+		if l.pos == start {
+			return token{}, errorf(start, "unexpected character %q", c)
+		}
+		return token{kind: tWord, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	// Expression mode.
+	switch {
+	case isDigit(c) || (c == '.' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1])):
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tWord, text: l.src[start:l.pos], pos: start}, nil
+	case isIdentStart(c):
+		for l.pos < len(l.src) {
+			ch := l.src[l.pos]
+			// A dot continues an identifier (geo.country) only when another
+			// identifier character follows; otherwise it is concatenation.
+			if ch == '.' && l.pos+1 < len(l.src) && isIdentChar(l.src[l.pos+1]) {
+				l.pos++
+				continue
+			}
+			if !isIdentChar(ch) {
+				break
+			}
+			l.pos++
+		}
+		return token{kind: tWord, text: l.src[start:l.pos], pos: start}, nil
+	case strings.IndexByte("+-*/%.", c) >= 0:
+		l.pos++
+		return token{kind: tOp, text: string(c), pos: start}, nil
+	}
+	return token{}, errorf(start, "unexpected character %q", c)
+}
+
+func (l *lexer) scanString(quote byte) (token, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\\' && l.pos+1 < len(l.src) && (l.src[l.pos+1] == quote || l.src[l.pos+1] == '\\'):
+			// Only quotes and backslashes are escapes; other backslashes
+			// stay so regex classes like \d survive.
+			b.WriteByte(l.src[l.pos+1])
+			l.pos += 2
+		case c == quote:
+			l.pos++
+			return token{kind: tString, text: b.String(), pos: start}, nil
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{}, errorf(start, "unterminated string")
+}
+
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isIdentStart(c byte) bool { return c == '_' || c == '@' || unicode.IsLetter(rune(c)) }
+func isIdentChar(c byte) bool  { return isIdentStart(c) || isDigit(c) }
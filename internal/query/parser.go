@@ -0,0 +1,816 @@
+package query
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ─── AST ─────────────────────────────────────────────────────────────────────
+
+type pipeline struct {
+	search   sNode // nil when the query starts with a pipe
+	commands []command
+	cmdPos   []int // position of each command name
+}
+
+// Search clause nodes.
+type sNode interface{ pos() int }
+
+type (
+	sAnd  struct{ items []sNode }
+	sOr   struct{ items []sNode }
+	sNot  struct{ item sNode }
+	sText struct {
+		text   string
+		phrase bool
+		at     int
+	}
+	sField struct {
+		field, op, value string
+		at, valueAt      int
+	}
+)
+
+func (n *sAnd) pos() int   { return n.items[0].pos() }
+func (n *sOr) pos() int    { return n.items[0].pos() }
+func (n *sNot) pos() int   { return n.item.pos() }
+func (n *sText) pos() int  { return n.at }
+func (n *sField) pos() int { return n.at }
+
+// Commands.
+type command interface{}
+
+type (
+	whereCmd struct{ cond expr }
+	evalCmd  struct{ assigns []assign }
+	rexCmd   struct {
+		field string
+		re    *regexp.Regexp
+	}
+	statsCmd struct {
+		aggs []aggSpec
+		by   []string
+	}
+	timechartCmd struct {
+		span  time.Duration
+		limit int
+		aggs  []aggSpec
+		by    string
+	}
+	sortCmd struct {
+		keys  []sortKey
+		limit int
+	}
+	headCmd   struct{ n int }
+	dedupCmd  struct{ fields []string }
+	tableCmd  struct{ fields []string }
+	renameCmd struct{ pairs [][2]string }
+	lookupCmd struct {
+		table   string
+		column  string      // key column of the table
+		key     string      // event field holding the lookup key
+		outputs [][2]string // table column → event field; empty means all
+		at      int
+	}
+)
+
+type assign struct {
+	name string
+	x    expr
+}
+
+type aggSpec struct {
+	fn, field, alias string
+}
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// ─── parser ──────────────────────────────────────────────────────────────────
+
+type parser struct {
+	lx lexer
+}
+
+func parse(q string) (*pipeline, error) {
+	p := &parser{lx: lexer{src: q}}
+	return p.pipeline()
+}
+
+func (p *parser) peek(mode scanMode) (token, error) { return p.lx.peek(mode) }
+func (p *parser) next(mode scanMode) (token, error) { return p.lx.next(mode) }
+
+// expectOp consumes the operator op or fails.
+func (p *parser) expectOp(op string, mode scanMode) (token, error) {
+	t, err := p.next(mode)
+	if err != nil {
+		return t, err
+	}
+	if !t.is(tOp, op) {
+		return t, errorf(t.pos, "expected %q, found %s", op, describe(t))
+	}
+	return t, nil
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tEOF:
+		return "end of query"
+	case tString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// atCommandEnd reports whether the next token ends the current command.
+func atCommandEnd(t token) bool {
+	return t.kind == tEOF || t.is(tOp, "|")
+}
+
+func (p *parser) pipeline() (*pipeline, error) {
+	pl := &pipeline{}
+	t, err := p.peek(modeSearch)
+	if err != nil {
+		return nil, err
+	}
+	if t.keyword("search") {
+		_, _ = p.next(modeSearch)
+		t, err = p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !atCommandEnd(t) {
+		if pl.search, err = p.searchOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		t, err := p.next(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tEOF {
+			return pl, nil
+		}
+		if !t.is(tOp, "|") {
+			return nil, errorf(t.pos, "unexpected %s", describe(t))
+		}
+		name, err := p.next(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if name.kind != tWord {
+			return nil, errorf(name.pos, "expected command after '|', found %s", describe(name))
+		}
+		cmd, err := p.command(name)
+		if err != nil {
+			return nil, err
+		}
+		pl.commands = append(pl.commands, cmd)
+		pl.cmdPos = append(pl.cmdPos, name.pos)
+	}
+}
+
+// ─── search clause ───────────────────────────────────────────────────────────
+
+func (p *parser) searchOr() (sNode, error) {
+	first, err := p.searchAnd()
+	if err != nil {
+		return nil, err
+	}
+	items := []sNode{first}
+	for {
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if !t.is(tWord, "OR") {
+			break
+		}
+		_, _ = p.next(modeSearch)
+		n, err := p.searchAnd()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+	if len(items) == 1 {
+		return first, nil
+	}
+	return &sOr{items: items}, nil
+}
+
+func (p *parser) searchAnd() (sNode, error) {
+	var items []sNode
+	for {
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if atCommandEnd(t) || t.is(tOp, ")") || t.is(tWord, "OR") {
+			break
+		}
+		if t.is(tWord, "AND") {
+			_, _ = p.next(modeSearch)
+			continue
+		}
+		n, err := p.searchNot()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+	switch len(items) {
+	case 0:
+		t, _ := p.peek(modeSearch)
+		return nil, errorf(t.pos, "expected search term, found %s", describe(t))
+	case 1:
+		return items[0], nil
+	}
+	return &sAnd{items: items}, nil
+}
+
+func (p *parser) searchNot() (sNode, error) {
+	t, err := p.peek(modeSearch)
+	if err != nil {
+		return nil, err
+	}
+	if t.is(tWord, "NOT") {
+		_, _ = p.next(modeSearch)
+		n, err := p.searchNot()
+		if err != nil {
+			return nil, err
+		}
+		return &sNot{item: n}, nil
+	}
+	return p.searchPrimary()
+}
+
+func (p *parser) searchPrimary() (sNode, error) {
+	t, err := p.next(modeSearch)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t.is(tOp, "("):
+		n, err := p.searchOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expectOp(")", modeSearch); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case t.kind == tString:
+		return &sText{text: t.text, phrase: true, at: t.pos}, nil
+	case t.kind != tWord:
+		return nil, errorf(t.pos, "expected search term, found %s", describe(t))
+	}
+
+	op, err := p.peek(modeSearch)
+	if err != nil {
+		return nil, err
+	}
+	if op.kind == tOp {
+		switch op.text {
+		case "=", "==", "!=", "<", "<=", ">", ">=":
+			_, _ = p.next(modeSearch)
+			v, err := p.next(modeSearch)
+			if err != nil {
+				return nil, err
+			}
+			if v.kind != tWord && v.kind != tString {
+				return nil, errorf(v.pos, "expected value after %s%s, found %s", t.text, op.text, describe(v))
+			}
+			o := op.text
+			if o == "==" {
+				o = "="
+			}
+			return &sField{field: t.text, op: o, value: v.text, at: t.pos, valueAt: v.pos}, nil
+		}
+	}
+	return &sText{text: t.text, at: t.pos}, nil
+}
+
+// ─── commands ────────────────────────────────────────────────────────────────
+
+func (p *parser) command(name token) (command, error) {
+	switch strings.ToLower(name.text) {
+	case "where":
+		x, err := p.exprUntilEnd()
+		if err != nil {
+			return nil, err
+		}
+		return &whereCmd{cond: x}, nil
+	case "eval":
+		return p.evalCommand()
+	case "rex":
+		return p.rexCommand(name)
+	case "stats":
+		aggs, by, err := p.aggregations(name, false)
+		if err != nil {
+			return nil, err
+		}
+		return &statsCmd{aggs: aggs, by: by}, nil
+	case "timechart":
+		return p.timechartCommand(name)
+	case "sort":
+		return p.sortCommand(name)
+	case "head":
+		n := 10
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if !atCommandEnd(t) {
+			if n, err = p.count(); err != nil {
+				return nil, err
+			}
+		}
+		return &headCmd{n: n}, p.expectEnd()
+	case "dedup":
+		fields, err := p.fieldList(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dedupCmd{fields: fields}, nil
+	case "table":
+		fields, err := p.fieldList(name)
+		if err != nil {
+			return nil, err
+		}
+		return &tableCmd{fields: fields}, nil
+	case "rename":
+		return p.renameCommand(name)
+	case "lookup":
+		return p.lookupCommand(name)
+	}
+	return nil, errorf(name.pos, "unknown command %q", name.text)
+}
+
+func (p *parser) expectEnd() error {
+	t, err := p.peek(modeSearch)
+	if err != nil {
+		return err
+	}
+	if !atCommandEnd(t) {
+		return errorf(t.pos, "unexpected %s", describe(t))
+	}
+	return nil
+}
+
+func (p *parser) count() (int, error) {
+	t, err := p.next(modeSearch)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(t.text)
+	if t.kind != tWord || err != nil || n < 0 {
+		return 0, errorf(t.pos, "expected a count, found %s", describe(t))
+	}
+	return n, nil
+}
+
+// fieldName reads one field name (a word or quoted string).
+func (p *parser) fieldName() (token, error) {
+	t, err := p.next(modeSearch)
+	if err != nil {
+		return t, err
+	}
+	if t.kind != tWord && t.kind != tString {
+		return t, errorf(t.pos, "expected field name, found %s", describe(t))
+	}
+	return t, nil
+}
+
+// fieldList reads field names separated by spaces or commas.
+func (p *parser) fieldList(cmd token) ([]string, error) {
+	var out []string
+	for {
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if atCommandEnd(t) {
+			break
+		}
+		if t.is(tOp, ",") {
+			_, _ = p.next(modeSearch)
+			continue
+		}
+		f, err := p.fieldName()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f.text)
+	}
+	if len(out) == 0 {
+		return nil, errorf(cmd.pos, "%s requires at least one field", cmd.text)
+	}
+	return out, nil
+}
+
+func (p *parser) evalCommand() (command, error) {
+	cmd := &evalCmd{}
+	for {
+		name, err := p.next(modeExpr)
+		if err != nil {
+			return nil, err
+		}
+		if name.kind != tWord && name.kind != tString {
+			return nil, errorf(name.pos, "expected field name, found %s", describe(name))
+		}
+		if _, err := p.expectOp("=", modeExpr); err != nil {
+			return nil, err
+		}
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		cmd.assigns = append(cmd.assigns, assign{name: name.text, x: x})
+
+		t, err := p.peek(modeExpr)
+		if err != nil {
+			return nil, err
+		}
+		if atCommandEnd(t) {
+			return cmd, nil
+		}
+		if _, err := p.expectOp(",", modeExpr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) rexCommand(name token) (command, error) {
+	cmd := &rexCmd{field: "message"}
+	t, err := p.peek(modeSearch)
+	if err != nil {
+		return nil, err
+	}
+	if t.keyword("field") {
+		_, _ = p.next(modeSearch)
+		if _, err := p.expectOp("=", modeSearch); err != nil {
+			return nil, err
+		}
+		f, err := p.fieldName()
+		if err != nil {
+			return nil, err
+		}
+		cmd.field = f.text
+	}
+	pat, err := p.next(modeSearch)
+	if err != nil {
+		return nil, err
+	}
+	if pat.kind != tString {
+		return nil, errorf(pat.pos, "rex expects a quoted regular expression, found %s", describe(pat))
+	}
+	re, err := regexp.Compile(pat.text)
+	if err != nil {
+		return nil, errorf(pat.pos, "invalid regular expression: %v", err)
+	}
+	named := false
+	for _, n := range re.SubexpNames() {
+		if n != "" {
+			named = true
+		}
+	}
+	if !named {
+		return nil, errorf(pat.pos, "rex pattern has no named groups (?P<name>...)")
+	}
+	cmd.re = re
+	return cmd, p.expectEnd()
+}
+
+func (p *parser) timechartCommand(name token) (command, error) {
+	cmd := &timechartCmd{limit: 10}
+	for {
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if !t.keyword("span") && !t.keyword("limit") {
+			break
+		}
+		_, _ = p.next(modeSearch)
+		if _, err := p.expectOp("=", modeSearch); err != nil {
+			return nil, err
+		}
+		v, err := p.next(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if t.keyword("span") {
+			d, ok := parseSpan(v.text)
+			if !ok {
+				return nil, errorf(v.pos, "invalid span %s (use e.g. 30s, 5m, 1h, 1d)", describe(v))
+			}
+			cmd.span = d
+		} else {
+			n, err := strconv.Atoi(v.text)
+			if err != nil || n <= 0 {
+				return nil, errorf(v.pos, "invalid limit %s", describe(v))
+			}
+			cmd.limit = n
+		}
+	}
+	aggs, by, err := p.aggregations(name, true)
+	if err != nil {
+		return nil, err
+	}
+	cmd.aggs = aggs
+	if len(by) > 0 {
+		if len(aggs) > 1 {
+			return nil, errorf(name.pos, "timechart with by supports a single aggregation")
+		}
+		cmd.by = by[0]
+	}
+	return cmd, nil
+}
+
+var aggFuncs = map[string]string{
+	"count": "count", "c": "count",
+	"dc": "dc", "distinct_count": "dc",
+	"sum": "sum", "avg": "avg", "mean": "avg",
+	"min": "min", "max": "max",
+	"values": "values", "first": "first", "last": "last",
+}
+
+// aggregations parses "fn(field) [as alias] ... [by f1, f2]".
+func (p *parser) aggregations(cmd token, singleBy bool) ([]aggSpec, []string, error) {
+	var aggs []aggSpec
+	var by []string
+	for {
+		t, err := p.peek(modeExpr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if atCommandEnd(t) {
+			break
+		}
+		if t.is(tOp, ",") {
+			_, _ = p.next(modeExpr)
+			continue
+		}
+		if t.keyword("by") {
+			_, _ = p.next(modeExpr)
+			if by, err = p.fieldList(t); err != nil {
+				return nil, nil, err
+			}
+			if singleBy && len(by) > 1 {
+				return nil, nil, errorf(t.pos, "%s supports a single by field", cmd.text)
+			}
+			break
+		}
+		fnTok, err := p.next(modeExpr)
+		if err != nil {
+			return nil, nil, err
+		}
+		fn, ok := aggFuncs[strings.ToLower(fnTok.text)]
+		if fnTok.kind != tWord || !ok {
+			return nil, nil, errorf(fnTok.pos, "unknown aggregation %s", describe(fnTok))
+		}
+		spec := aggSpec{fn: fn, alias: fn}
+		if t, _ := p.peek(modeExpr); t.is(tOp, "(") {
+			_, _ = p.next(modeExpr)
+			f, err := p.next(modeExpr)
+			if err != nil {
+				return nil, nil, err
+			}
+			if f.kind != tWord && f.kind != tString {
+				return nil, nil, errorf(f.pos, "expected field name, found %s", describe(f))
+			}
+			if _, err := p.expectOp(")", modeExpr); err != nil {
+				return nil, nil, err
+			}
+			spec.field = f.text
+			spec.alias = fn + "(" + f.text + ")"
+		} else if fn != "count" {
+			return nil, nil, errorf(fnTok.pos, "%s requires a field, e.g. %s(bytes)", fn, fn)
+		}
+		if t, _ := p.peek(modeExpr); t.keyword("as") {
+			_, _ = p.next(modeExpr)
+			a, err := p.next(modeExpr)
+			if err != nil {
+				return nil, nil, err
+			}
+			if a.kind != tWord && a.kind != tString {
+				return nil, nil, errorf(a.pos, "expected alias after AS, found %s", describe(a))
+			}
+			spec.alias = a.text
+		}
+		aggs = append(aggs, spec)
+	}
+	if len(aggs) == 0 {
+		return nil, nil, errorf(cmd.pos, "%s requires at least one aggregation", cmd.text)
+	}
+	return aggs, by, nil
+}
+
+func (p *parser) sortCommand(name token) (command, error) {
+	cmd := &sortCmd{}
+	if t, err := p.peek(modeSearch); err == nil && t.kind == tWord {
+		if n, err := strconv.Atoi(t.text); err == nil && n > 0 {
+			_, _ = p.next(modeSearch)
+			cmd.limit = n
+		}
+	}
+	desc := false
+	for {
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if atCommandEnd(t) {
+			break
+		}
+		if t.is(tOp, ",") {
+			_, _ = p.next(modeSearch)
+			continue
+		}
+		f, err := p.fieldName()
+		if err != nil {
+			return nil, err
+		}
+		field := f.text
+		if f.kind == tWord {
+			switch field {
+			case "-", "+":
+				desc = field == "-"
+				continue
+			}
+			if strings.HasPrefix(field, "-") {
+				desc, field = true, field[1:]
+			} else if strings.HasPrefix(field, "+") {
+				field = field[1:]
+			}
+		}
+		cmd.keys = append(cmd.keys, sortKey{field: field, desc: desc})
+		desc = false
+	}
+	if len(cmd.keys) == 0 {
+		return nil, errorf(name.pos, "sort requires at least one field")
+	}
+	return cmd, nil
+}
+
+func (p *parser) renameCommand(name token) (command, error) {
+	cmd := &renameCmd{}
+	for {
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if atCommandEnd(t) {
+			break
+		}
+		if t.is(tOp, ",") {
+			_, _ = p.next(modeSearch)
+			continue
+		}
+		from, err := p.fieldName()
+		if err != nil {
+			return nil, err
+		}
+		as, err := p.next(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if !as.keyword("as") {
+			return nil, errorf(as.pos, "expected AS after %q, found %s", from.text, describe(as))
+		}
+		to, err := p.fieldName()
+		if err != nil {
+			return nil, err
+		}
+		cmd.pairs = append(cmd.pairs, [2]string{from.text, to.text})
+	}
+	if len(cmd.pairs) == 0 {
+		return nil, errorf(name.pos, "rename requires old AS new")
+	}
+	return cmd, nil
+}
+
+// lookupCommand parses
+// lookup <table> <key-column> [AS <event-field>] [OUTPUT <column> [AS <field>], ...].
+func (p *parser) lookupCommand(name token) (command, error) {
+	table, err := p.fieldName()
+	if err != nil {
+		return nil, errorf(name.pos, "lookup requires a table name and key field")
+	}
+	key, err := p.fieldName()
+	if err != nil {
+		return nil, err
+	}
+	cmd := &lookupCmd{table: table.text, column: key.text, key: key.text, at: table.pos}
+
+	t, err := p.peek(modeSearch)
+	if err != nil {
+		return nil, err
+	}
+	if t.keyword("as") {
+		_, _ = p.next(modeSearch)
+		field, err := p.fieldName()
+		if err != nil {
+			return nil, err
+		}
+		cmd.key = field.text
+		if t, err = p.peek(modeSearch); err != nil {
+			return nil, err
+		}
+	}
+	if atCommandEnd(t) {
+		return cmd, nil
+	}
+	if !t.keyword("output") && !t.keyword("outputnew") {
+		return nil, errorf(t.pos, "expected AS or OUTPUT, found %s", describe(t))
+	}
+	_, _ = p.next(modeSearch)
+	for {
+		t, err := p.peek(modeSearch)
+		if err != nil {
+			return nil, err
+		}
+		if atCommandEnd(t) {
+			break
+		}
+		if t.is(tOp, ",") {
+			_, _ = p.next(modeSearch)
+			continue
+		}
+		col, err := p.fieldName()
+		if err != nil {
+			return nil, err
+		}
+		dest := col.text
+		if t, err = p.peek(modeSearch); err != nil {
+			return nil, err
+		}
+		if t.keyword("as") {
+			_, _ = p.next(modeSearch)
+			f, err := p.fieldName()
+			if err != nil {
+				return nil, err
+			}
+			dest = f.text
+		}
+		cmd.outputs = append(cmd.outputs, [2]string{col.text, dest})
+	}
+	if len(cmd.outputs) == 0 {
+		return nil, errorf(t.pos, "OUTPUT requires at least one column")
+	}
+	return cmd, nil
+}
+
+// ─── time helpers ────────────────────────────────────────────────────────────
+
+var spanUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "m": time.Minute, "min": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour,
+}
+
+// parseSpan parses "30s", "5m", "1h", "1d", "2w".
+func parseSpan(s string) (time.Duration, bool) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	unit, ok := spanUnits[strings.ToLower(s[i:])]
+	if err != nil || !ok || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// parseTime resolves an earliest/latest value: "now", a relative offset
+// ("-15m", "-7d"), an RFC 3339 timestamp or unix seconds.
+func parseTime(s string, now time.Time) (time.Time, bool) {
+	switch {
+	case strings.EqualFold(s, "now"):
+		return now, true
+	case strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+"):
+		d, ok := parseSpan(s[1:])
+		if !ok {
+			return time.Time{}, false
+		}
+		if s[0] == '-' {
+			d = -d
+		}
+		return now.Add(d), true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,278 @@
+package query
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+)
+
+// Plan is a compiled query: the filter pushed down to the index plus the
+// commands evaluated over its results.
+type Plan struct {
+	// Search is what Bluge evaluates. Its Cond holds the whole search clause
+	// and the index-friendly comparisons of a leading where.
+	Search   storage.SearchQuery
+	commands []command
+}
+
+// coreFields are the fixed event attributes; they are plain keywords in the
+// index, so range comparisons on them cannot be pushed down.
+var coreFields = map[string]bool{
+	"message": true, "source": true, "host": true,
+	"user": true, "severity": true, "category": true,
+}
+
+func plan(pl *pipeline, now time.Time) (*Plan, error) {
+	p := &Plan{commands: pl.commands}
+	var conds []*storage.Cond
+
+	if pl.search != nil {
+		c, err := p.searchCond(pl.search, true, now)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case c == nil:
+		case c.And != nil:
+			conds = append(conds, c.And...)
+		default:
+			conds = append(conds, c)
+		}
+	}
+	if p.Search.StartTime > 0 && p.Search.EndTime > 0 && p.Search.StartTime > p.Search.EndTime {
+		return nil, errorf(0, "earliest is after latest")
+	}
+
+	// Comparisons of a where that directly follows the search clause only
+	// see raw event fields, so they can pre-filter in the index too. The
+	// where itself still runs, which keeps the result exact.
+	for _, cmd := range pl.commands {
+		w, ok := cmd.(*whereCmd)
+		if !ok {
+			break
+		}
+		conds = append(conds, pushdownWhere(w.cond)...)
+	}
+
+	switch len(conds) {
+	case 0:
+	case 1:
+		p.Search.Cond = conds[0]
+	default:
+		p.Search.Cond = &storage.Cond{And: conds}
+	}
+
+	for _, cmd := range pl.commands {
+		if tc, ok := cmd.(*timechartCmd); ok && tc.span == 0 {
+			tc.span = p.autoSpan(now)
+		}
+	}
+	return p, nil
+}
+
+// spanSteps are the bucket widths timechart picks from when no span is given.
+var spanSteps = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// autoSpan picks the smallest step giving at most ~60 buckets over the
+// searched time range, or one hour when the range is open.
+func (p *Plan) autoSpan(now time.Time) time.Duration {
+	if p.Search.StartTime == 0 {
+		return time.Hour
+	}
+	end := now.UnixNano()
+	if p.Search.EndTime > 0 {
+		end = p.Search.EndTime
+	}
+	width := time.Duration(end - p.Search.StartTime)
+	for _, s := range spanSteps {
+		if width/s <= 60 {
+			return s
+		}
+	}
+	return spanSteps[len(spanSteps)-1]
+}
+
+// searchCond translates a search clause node. top is true while n is part of
+// the top-level conjunction, the only place earliest/latest may appear.
+func (p *Plan) searchCond(n sNode, top bool, now time.Time) (*storage.Cond, error) {
+	switch n := n.(type) {
+	case *sAnd:
+		var items []*storage.Cond
+		for _, it := range n.items {
+			c, err := p.searchCond(it, top, now)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				items = append(items, c)
+			}
+		}
+		switch len(items) {
+		case 0:
+			return nil, nil
+		case 1:
+			return items[0], nil
+		}
+		return &storage.Cond{And: items}, nil
+
+	case *sOr:
+		items := make([]*storage.Cond, 0, len(n.items))
+		for _, it := range n.items {
+			c, err := p.searchCond(it, false, now)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, c)
+		}
+		return &storage.Cond{Or: items}, nil
+
+	case *sNot:
+		c, err := p.searchCond(n.item, false, now)
+		if err != nil {
+			return nil, err
+		}
+		return &storage.Cond{Not: c}, nil
+
+	case *sText:
+		if !n.phrase && strings.Contains(n.text, "*") {
+			return fieldCond(storage.FieldFilter{Field: "message", Op: "wildcard", Value: n.text}), nil
+		}
+		return &storage.Cond{Text: n.text, Phrase: n.phrase}, nil
+
+	case *sField:
+		return p.fieldTerm(n, top, now)
+	}
+	return nil, errorf(n.pos(), "unsupported search term")
+}
+
+func (p *Plan) fieldTerm(n *sField, top bool, now time.Time) (*storage.Cond, error) {
+	switch strings.ToLower(n.field) {
+	case "earliest", "latest":
+		if !top {
+			return nil, errorf(n.at, "%s must not be used inside OR or NOT", n.field)
+		}
+		if n.op != "=" {
+			return nil, errorf(n.at, "%s only supports =", n.field)
+		}
+		t, ok := parseTime(n.value, now)
+		if !ok {
+			return nil, errorf(n.valueAt, "invalid time %q (use now, -15m, -24h, -7d or RFC 3339)", n.value)
+		}
+		if strings.EqualFold(n.field, "earliest") {
+			p.Search.StartTime = t.UnixNano()
+		} else {
+			p.Search.EndTime = t.UnixNano()
+		}
+		return nil, nil
+	}
+
+	switch n.op {
+	case "=", "!=":
+		c := matchCond(n.field, n.value)
+		if n.op == "!=" {
+			return &storage.Cond{Not: c}, nil
+		}
+		return c, nil
+	}
+
+	if coreFields[n.field] {
+		return nil, errorf(n.at, "field %s does not support %s", n.field, n.op)
+	}
+	if _, err := strconv.ParseFloat(n.value, 64); err != nil {
+		return nil, errorf(n.valueAt, "%s%s expects a number, found %q", n.field, n.op, n.value)
+	}
+	return fieldCond(storage.FieldFilter{Field: n.field, Op: rangeOps[n.op], Value: n.value}), nil
+}
+
+var rangeOps = map[string]string{"<": "lt", "<=": "lte", ">": "gt", ">=": "gte"}
+
+// matchCond builds field=value: * tests presence, a trailing * is a prefix,
+// other * are wildcards, and a CIDR block matches addresses inside it.
+func matchCond(field, value string) *storage.Cond {
+	f := storage.FieldFilter{Field: field, Op: "eq", Value: value}
+	switch {
+	case value == "*":
+		f.Op, f.Value = "exists", ""
+	case strings.Count(value, "*") == 1 && strings.HasSuffix(value, "*") && !strings.Contains(value, "?"):
+		f.Op, f.Value = "prefix", strings.TrimSuffix(value, "*")
+	case strings.ContainsAny(value, "*?"):
+		f.Op = "wildcard"
+	case strings.Contains(value, "/"):
+		if _, _, err := net.ParseCIDR(value); err == nil {
+			f.Op = "cidr"
+		}
+	}
+	return fieldCond(f)
+}
+
+func fieldCond(f storage.FieldFilter) *storage.Cond {
+	return &storage.Cond{Field: &f}
+}
+
+// pushdownWhere extracts the conjuncts of a where condition that compare a
+// raw field with a literal.
+func pushdownWhere(x expr) []*storage.Cond {
+	b, ok := x.(*binaryExpr)
+	if !ok {
+		return nil
+	}
+	if b.op == "and" {
+		return append(pushdownWhere(b.l), pushdownWhere(b.r)...)
+	}
+
+	field, lit, op := comparison(b)
+	if field == "" || strings.HasPrefix(field, "_") {
+		return nil
+	}
+	n, isNum := lit.(float64)
+	switch op {
+	case "=":
+		if isNum {
+			value := strconv.FormatFloat(n, 'f', -1, 64)
+			eq := matchCond(field, value)
+			if coreFields[field] {
+				return []*storage.Cond{eq}
+			}
+			// Also match numeric values whose keyword form differs ("1.0").
+			rng := &storage.Cond{And: []*storage.Cond{
+				fieldCond(storage.FieldFilter{Field: field, Op: "gte", Value: value}),
+				fieldCond(storage.FieldFilter{Field: field, Op: "lte", Value: value}),
+			}}
+			return []*storage.Cond{{Or: []*storage.Cond{eq, rng}}}
+		}
+		if s, ok := lit.(string); ok && !strings.ContainsAny(s, "*?") && s != "" {
+			return []*storage.Cond{fieldCond(storage.FieldFilter{Field: field, Op: "eq", Value: s})}
+		}
+	case "<", "<=", ">", ">=":
+		if isNum && !coreFields[field] {
+			value := strconv.FormatFloat(n, 'f', -1, 64)
+			return []*storage.Cond{fieldCond(storage.FieldFilter{Field: field, Op: rangeOps[op], Value: value})}
+		}
+	}
+	return nil
+}
+
+// comparison matches "field op literal" or "literal op field", returning the
+// operator oriented with the field on the left.
+func comparison(b *binaryExpr) (string, interface{}, string) {
+	if f, ok := b.l.(*fieldRef); ok {
+		if l, ok := b.r.(*literal); ok {
+			return f.name, l.v, b.op
+		}
+	}
+	if f, ok := b.r.(*fieldRef); ok {
+		if l, ok := b.l.(*literal); ok {
+			flip := map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<=", "=": "=", "!=": "!="}
+			return f.name, l.v, flip[b.op]
+		}
+	}
+	return "", nil, ""
+}
@@ -0,0 +1,120 @@
+// Package query implements OBLIVRA's SPL-style piped query language.
+//
+// A query is a search clause followed by commands separated by pipes:
+//
+//	failed password host=srv-* earliest=-24h
+//	  | rex "from (?P<src_ip>\d+\.\d+\.\d+\.\d+)"
+//	  | stats count by src_ip
+//	  | where count > 5
+//	  | sort -count | head 10
+//
+// The search clause (bare words, "phrases", field=value, field!=value,
+// field>n, field=10.0.0.0/8, wildcards, AND/OR/NOT and parentheses) is
+// compiled in full into an index query and evaluated by Bluge, as are the
+// simple field comparisons of a leading where command. The remaining
+// commands run in a streaming pipeline over the matching events; only stats,
+// timechart and sort buffer their input.
+//
+// Supported commands: where, eval, rex, stats, timechart, sort, head, dedup,
+// table, rename and lookup.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+const defaultMaxRows = 10_000
+
+// Source is the event store a query runs against (satisfied by
+// *storage.Engine).
+type Source interface {
+	ExportEvents(ctx context.Context, q *storage.SearchQuery, fn func(*models.Event) error) error
+}
+
+// Lookups resolves keys against lookup tables for the lookup command, using
+// the tenant's table of that name or else the global one. It returns an
+// error only for unknown tables.
+type Lookups interface {
+	Lookup(tenant, table, key string) (row map[string]string, found bool, err error)
+}
+
+// Options tune a query run.
+type Options struct {
+	MaxRows int       // cap on returned rows (0 → 10000)
+	Now     time.Time // reference time for relative earliest/latest (zero → time.Now())
+	Lookups Lookups   // tables for the lookup command
+	Tenant  string    // restricts the search to one tenant ("" = all tenants)
+}
+
+// Result is the output table of a query.
+type Result struct {
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Scanned   int                      `json:"scanned"`   // events read from storage
+	Truncated bool                     `json:"truncated"` // more rows than Options.MaxRows
+	Elapsed   time.Duration            `json:"elapsed"`
+}
+
+// Error is a parse or planning error pointing at a byte offset in the query.
+type Error struct {
+	Pos   int    `json:"pos"`
+	Msg   string `json:"message"`
+	Query string `json:"query,omitempty"`
+}
+
+func errorf(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	if e.Query == "" {
+		return fmt.Sprintf("query: %s at position %d", e.Msg, e.Pos)
+	}
+	// Show the query with a caret under the failing position.
+	line := strings.ReplaceAll(e.Query, "\n", " ")
+	return fmt.Sprintf("query: %s at position %d\n  %s\n  %s^", e.Msg, e.Pos, line, strings.Repeat(" ", e.Pos))
+}
+
+// errStop ends the event scan early (head reached its count, row cap hit).
+var errStop = errors.New("query: stop")
+
+// Run compiles and executes q against src.
+func Run(ctx context.Context, src Source, q string, opts Options) (*Result, error) {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	p, err := Compile(q, now)
+	if err != nil {
+		return nil, err
+	}
+	return p.Run(ctx, src, opts)
+}
+
+// Compile parses q and plans it. Relative times are resolved against now.
+func Compile(q string, now time.Time) (*Plan, error) {
+	ast, err := parse(q)
+	if err != nil {
+		var qe *Error
+		if errors.As(err, &qe) {
+			qe.Query = q
+		}
+		return nil, err
+	}
+	p, err := plan(ast, now)
+	if err != nil {
+		var qe *Error
+		if errors.As(err, &qe) {
+			qe.Query = q
+		}
+		return nil, err
+	}
+	return p, nil
+}
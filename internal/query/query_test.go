@@ -0,0 +1,299 @@
+package query_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/query"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+func tmpEngine(t *testing.T) (*storage.Engine, func()) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "query-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Storage: config.StorageConfig{BasePath: dir, Retention: 30}}
+	eng, err := storage.Open(context.Background(), cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return eng, func() {
+		eng.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// seed writes six events: four auth failures spread over two hosts and two
+// firewall drops, one minute apart, ending at base.
+func seed(t *testing.T, eng *storage.Engine, base time.Time) []*models.Event {
+	t.Helper()
+	events := []*models.Event{
+		{Host: "web-01", Source: "sshd", Message: "Failed password for root from 10.0.0.5 port 2201",
+			Fields: map[string]interface{}{"action": "failure", "bytes": 100}},
+		{Host: "web-01", Source: "sshd", Message: "Failed password for admin from 10.0.0.6 port 2202",
+			Fields: map[string]interface{}{"action": "failure", "bytes": 200}},
+		{Host: "web-02", Source: "sshd", Message: "Failed password for root from 10.0.0.5 port 2203",
+			Fields: map[string]interface{}{"action": "failure", "bytes": 300}},
+		{Host: "web-01", Source: "sshd", Message: "Failed password for bob from 192.168.1.9 port 2204",
+			Fields: map[string]interface{}{"action": "failure", "bytes": 400}},
+		{Host: "fw-01", Source: "firewall", Message: "Dropped packet from 203.0.113.7",
+			Fields: map[string]interface{}{"action": "drop", "bytes": 1500}},
+		{Host: "fw-01", Source: "firewall", Message: "Dropped packet from 203.0.113.8",
+			Fields: map[string]interface{}{"action": "drop", "bytes": 60}},
+	}
+	for i, ev := range events {
+		ev.ID = uuid.NewString()
+		ev.Timestamp = base.Add(time.Duration(i-len(events)+1) * time.Minute)
+		ev.Severity = models.SeverityMedium
+		if err := eng.WriteEvent(context.Background(), ev); err != nil {
+			t.Fatalf("WriteEvent: %v", err)
+		}
+	}
+	return events
+}
+
+func run(t *testing.T, eng *storage.Engine, q string) *query.Result {
+	t.Helper()
+	res, err := query.Run(context.Background(), eng, q, query.Options{})
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	return res
+}
+
+func TestCompile_Pushdown(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	p, err := query.Compile(`source=sshd host=web-* earliest=-1h | where bytes > 150 | stats count`, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Search.StartTime != now.Add(-time.Hour).UnixNano() {
+		t.Errorf("StartTime = %d", p.Search.StartTime)
+	}
+	c := p.Search.Cond
+	if c == nil || len(c.And) != 3 {
+		t.Fatalf("want search terms and where comparison ANDed, got %+v", c)
+	}
+	if f := c.And[2].Field; f == nil || f.Field != "bytes" || f.Op != "gt" || f.Value != "150" {
+		t.Errorf("where not pushed down: %+v", c.And[2])
+	}
+}
+
+func TestRun_StatsPipeline(t *testing.T) {
+	eng, cleanup := tmpEngine(t)
+	defer cleanup()
+	seed(t, eng, time.Now())
+
+	res := run(t, eng, `source=sshd | where bytes >= 200 | stats count, sum(bytes) as total by host | sort -count | head 1`)
+	if len(res.Rows) != 1 {
+		t.Fatalf("want 1 row, got %v", res.Rows)
+	}
+	row := res.Rows[0]
+	if row["host"] != "web-01" || row["count"] != float64(2) || row["total"] != float64(600) {
+		t.Errorf("unexpected row %v", row)
+	}
+	if want := []string{"host", "count", "total"}; !equal(res.Columns, want) {
+		t.Errorf("columns = %v, want %v", res.Columns, want)
+	}
+
+	res = run(t, eng, `"Failed password" NOT host=web-02 | stats dc(host) as hosts, values(user) as users`)
+	if res.Rows[0]["hosts"] != float64(1) {
+		t.Errorf("dc(host) = %v", res.Rows[0]["hosts"])
+	}
+}
+
+func TestRun_RexEvalTable(t *testing.T) {
+	eng, cleanup := tmpEngine(t)
+	defer cleanup()
+	seed(t, eng, time.Now())
+
+	res := run(t, eng, `source=sshd
+		| rex field=message "for (?P<account>\w+) from (?P<src_ip>[\d.]+)"
+		| where cidrmatch("10.0.0.0/8", src_ip)
+		| eval kb = round(bytes / 100, 1), who = upper(account)
+		| dedup account
+		| sort account
+		| rename who as USER
+		| table account, USER, kb`)
+	if len(res.Rows) != 2 {
+		t.Fatalf("want 2 rows, got %v", res.Rows)
+	}
+	if res.Rows[0]["account"] != "admin" || res.Rows[0]["USER"] != "ADMIN" || res.Rows[0]["kb"] != float64(2) {
+		t.Errorf("row 0 = %v", res.Rows[0])
+	}
+	if res.Rows[1]["account"] != "root" {
+		t.Errorf("row 1 = %v", res.Rows[1])
+	}
+	if want := []string{"account", "USER", "kb"}; !equal(res.Columns, want) {
+		t.Errorf("columns = %v, want %v", res.Columns, want)
+	}
+}
+
+func TestRun_Timechart(t *testing.T) {
+	eng, cleanup := tmpEngine(t)
+	defer cleanup()
+	base := time.Now().Truncate(time.Hour).Add(31 * time.Minute)
+	seed(t, eng, base)
+
+	res := run(t, eng, `* | timechart span=2m limit=1 count by source`)
+	if want := []string{"_time", "sshd", "OTHER"}; !equal(res.Columns, want) {
+		t.Fatalf("columns = %v, want %v", res.Columns, want)
+	}
+	var sshd, other float64
+	for _, r := range res.Rows {
+		sshd += r["sshd"].(float64)
+		other += r["OTHER"].(float64)
+	}
+	if sshd != 4 || other != 2 {
+		t.Errorf("sshd=%v OTHER=%v", sshd, other)
+	}
+	if len(res.Rows) != 3 {
+		t.Errorf("want 3 two-minute buckets, got %d", len(res.Rows))
+	}
+}
+
+func TestRun_HeadStopsScan(t *testing.T) {
+	eng, cleanup := tmpEngine(t)
+	defer cleanup()
+	seed(t, eng, time.Now())
+
+	res := run(t, eng, `* | head 2`)
+	if len(res.Rows) != 2 || res.Scanned != 2 {
+		t.Errorf("rows=%d scanned=%d", len(res.Rows), res.Scanned)
+	}
+	res, err := query.Run(context.Background(), eng, `*`, query.Options{MaxRows: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 3 || !res.Truncated {
+		t.Errorf("rows=%d truncated=%v", len(res.Rows), res.Truncated)
+	}
+}
+
+type staticLookups map[string]map[string]map[string]string
+
+func (l staticLookups) Lookup(_, table, key string) (map[string]string, bool, error) {
+	t, ok := l[table]
+	if !ok {
+		return nil, false, errors.New("no such table")
+	}
+	row, found := t[key]
+	return row, found, nil
+}
+
+func TestRun_Lookup(t *testing.T) {
+	eng, cleanup := tmpEngine(t)
+	defer cleanup()
+	seed(t, eng, time.Now())
+
+	opts := query.Options{Lookups: staticLookups{"assets": {
+		"web-01": {"name": "web-01", "owner": "shop", "tier": "1"},
+		"fw-01":  {"name": "fw-01", "owner": "netops", "tier": "0"},
+	}}}
+	res, err := query.Run(context.Background(), eng,
+		`* | lookup assets name AS host OUTPUT owner, tier AS asset_tier | stats count by owner, asset_tier`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 2 || res.Rows[0]["owner"] != "netops" || res.Rows[1]["count"] != float64(3) {
+		t.Errorf("unexpected rows %v", res.Rows)
+	}
+
+	res, err = query.Run(context.Background(), eng, `host=fw-01 | lookup assets name AS host | table host, owner, tier, name`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := res.Rows[0]; r["owner"] != "netops" || r["tier"] != "0" || r["name"] != nil {
+		t.Errorf("lookup without OUTPUT should add all non-key columns, got %v", r)
+	}
+
+	if _, err := query.Run(context.Background(), eng, `* | lookup missing host`, opts); err == nil {
+		t.Error("expected error for unknown table")
+	}
+}
+
+// TestCompileFilter checks that in-memory filters select the same events as
+// the index does for the same query.
+func TestCompileFilter(t *testing.T) {
+	eng, cleanup := tmpEngine(t)
+	defer cleanup()
+	events := seed(t, eng, time.Now())
+
+	queries := []string{
+		`"Failed password" root`,
+		`source=sshd NOT host=web-02`,
+		`host=web-0* OR action=drop`,
+		`packet | where bytes > 100`,
+		`fail*`,
+		`source=firewall bytes>=100`,
+		``,
+	}
+	for _, q := range queries {
+		f, err := query.CompileFilter(q)
+		if err != nil {
+			t.Errorf("%s: %v", q, err)
+			continue
+		}
+		matched := 0
+		for _, ev := range events {
+			if f.Match(ev) {
+				matched++
+			}
+		}
+		want := run(t, eng, q+" | stats count").Rows[0]["count"]
+		if float64(matched) != want {
+			t.Errorf("%s: filter matched %d, search counted %v", q, matched, want)
+		}
+	}
+
+	for _, q := range []string{`host=a | stats count`, `earliest=-1h host=a`} {
+		if _, err := query.CompileFilter(q); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	cases := []struct {
+		q   string
+		pos int
+	}{
+		{`host=a | bogus x`, 9},
+		{`host=a | stats median(x)`, 15},
+		{`(host=a OR earliest=-1h)`, 11},
+		{`host=a | head x`, 14},
+		{`host=a | lookup t k OUTPUT`, 20},
+	}
+	for _, c := range cases {
+		_, err := query.Compile(c.q, time.Now())
+		var qe *query.Error
+		if !errors.As(err, &qe) {
+			t.Errorf("%s: want *query.Error, got %v", c.q, err)
+			continue
+		}
+		if qe.Pos != c.pos {
+			t.Errorf("%s: error at %d, want %d (%v)", c.q, qe.Pos, c.pos, err)
+		}
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
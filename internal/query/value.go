@@ -0,0 +1,159 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// eventRow flattens an event into a Row: the fixed attributes under their
+// own names (_time, _id, _raw, message, source, host, user, severity,
+// category), then Fields and Metadata, with nested maps as dotted names.
+func eventRow(ev *models.Event) Row {
+	r := Row{
+		"_time":    ev.Timestamp,
+		"_id":      ev.ID,
+		"_raw":     ev.Raw,
+		"message":  ev.Message,
+		"source":   ev.Source,
+		"host":     ev.Host,
+		"user":     ev.User,
+		"severity": string(ev.Severity),
+		"category": ev.Category,
+	}
+	for k, v := range ev.Fields {
+		flattenInto(r, k, v, 0)
+	}
+	for k, v := range ev.Metadata {
+		if _, ok := r[k]; !ok {
+			r[k] = v
+		}
+	}
+	return r
+}
+
+func flattenInto(r Row, name string, v interface{}, depth int) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		if depth < 3 {
+			for k, sub := range m {
+				flattenInto(r, name+"."+k, sub, depth+1)
+			}
+		}
+		return
+	case map[string]string:
+		for k, sub := range m {
+			flattenInto(r, name+"."+k, sub, depth+1)
+		}
+		return
+	}
+	if _, ok := r[name]; !ok {
+		r[name] = v
+	}
+}
+
+// toNumber converts numbers, numeric strings and times (as unix seconds).
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return 0, false
+		}
+		return f, true
+	case time.Time:
+		return float64(n.UnixNano()) / 1e9, true
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(s)
+	case time.Time:
+		return s.UTC().Format(time.RFC3339Nano)
+	case []string:
+		return strings.Join(s, ",")
+	}
+	if n, ok := toNumber(v); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// truthy reports whether v is boolean true; anything else (including nil)
+// is false, so where drops rows whose condition cannot be evaluated.
+func truthy(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// compareValues orders two values: numerically when both are numbers (or
+// numeric strings), chronologically for times, otherwise as strings. nil
+// sorts after everything.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(toString(a), toString(b))
+}
@@ -3,15 +3,18 @@ package rbac
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
 )
 
-// RBACManager handles granular permission checks.
+// RBACManager handles granular permission checks and tenant membership.
 type RBACManager struct {
-	store *sqlitestore.DB
-	cache sync.Map // Simple cache for user permissions
+	store   *sqlitestore.DB
+	cache   sync.Map // Simple cache for user permissions
+	tenants sync.Map // userID → []string of member tenants
 }
 
 func NewManager(store *sqlitestore.DB) *RBACManager {
@@ -47,7 +50,43 @@ func (m *RBACManager) HasPermission(ctx context.Context, userID string, permissi
 	return permMap[permission] || permMap["admin:system"], nil
 }
 
-// ClearCache removes a user's permissions from the cache.
+// ClearCache removes a user's permissions and tenants from the cache.
 func (m *RBACManager) ClearCache(userID string) {
 	m.cache.Delete(userID)
+	m.tenants.Delete(userID)
+}
+
+// Tenants returns the tenants whose data a user may see. A user with no
+// membership at all belongs to the default tenant, so single-tenant
+// deployments need no setup.
+func (m *RBACManager) Tenants(userID string) ([]string, error) {
+	if val, ok := m.tenants.Load(userID); ok {
+		return val.([]string), nil
+	}
+	ids, err := m.store.GetUserTenants(userID)
+	if err != nil {
+		return nil, fmt.Errorf("rbac: failed to load tenants: %w", err)
+	}
+	if len(ids) == 0 {
+		ids = []string{models.DefaultTenant}
+	}
+	m.tenants.Store(userID, ids)
+	return ids, nil
+}
+
+// CanAccessTenant reports whether a user may see the data of tenant:
+// members of it can, and so can holders of admin:system, who see every
+// tenant.
+func (m *RBACManager) CanAccessTenant(ctx context.Context, userID, tenant string) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+	if admin, err := m.HasPermission(ctx, userID, "admin:system"); err != nil || admin {
+		return admin, err
+	}
+	ids, err := m.Tenants(userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(ids, models.TenantOrDefault(tenant)), nil
 }
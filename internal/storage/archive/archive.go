@@ -0,0 +1,872 @@
+// Package archive keeps aged events in immutable, compressed segments on the
+// warm and cold storage tiers. Each segment holds events from one UTC day:
+//
+//	<tier>/<id>/events.gz      independent gzip members of up to blockSize JSON lines
+//	<tier>/<id>/index/         Bluge index of the segment (blugeindex.Segment)
+//	<tier>/<id>/manifest.json  time span, block table, SHA-256 and integrity block refs
+//
+// The manifest is written last: a directory without one is an interrupted
+// write and is removed on Open. Segment index documents carry a reference
+// "<segment id>/<block>" so a search hit can be read back by decompressing a
+// single block.
+//
+// A Manager opened with a key writes encrypted segments: each gzip member is
+// sealed with AES-GCM bound to its reference, and the index files use the
+// blugeindex encrypted format. Segments written before encryption was
+// enabled stay readable and are encrypted when next rewritten.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/blugeindex"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// Storage tiers of a segment.
+const (
+	TierWarm = "warm"
+	TierCold = "cold"
+)
+
+const (
+	blockSize    = 1000
+	eventsFile   = "events.gz"
+	indexDir     = "index"
+	manifestFile = "manifest.json"
+	dayLayout    = "20060102"
+)
+
+// ErrNotFound is returned for unknown segments.
+var ErrNotFound = errors.New("archive: segment not found")
+
+// ErrNoKey is returned by Open when an encrypted segment is found but the
+// Manager has no key.
+var ErrNoKey = errors.New("archive: segment is encrypted but no key is configured")
+
+// Manifest describes a sealed segment. It never changes after sealing.
+type Manifest struct {
+	ID              string    `json:"id"`
+	Day             string    `json:"day"`   // UTC day, YYYYMMDD
+	Start           time.Time `json:"start"` // oldest event
+	End             time.Time `json:"end"`   // newest event
+	Events          int       `json:"events"`
+	Bytes           int64     `json:"bytes"`  // size of events.gz
+	SHA256          string    `json:"sha256"` // of events.gz
+	Blocks          []Block   `json:"blocks"`
+	IntegrityBlocks []int64   `json:"integrity_blocks,omitempty"` // forensics blocks sealed over the span
+	Replaces        string    `json:"replaces,omitempty"`         // segment this one is a rewrite of
+	Encrypted       bool      `json:"encrypted,omitempty"`        // blocks and index are encrypted
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Block locates one gzip member in events.gz (sealed, when encrypted).
+type Block struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	Events int   `json:"events"`
+}
+
+// Segment is a sealed segment and where it currently lives.
+type Segment struct {
+	Manifest
+	Tier string `json:"tier"`
+	Dir  string `json:"dir"`
+}
+
+type segment struct {
+	Segment
+	idx *blugeindex.Segment
+	key []byte // nil for plaintext segments
+}
+
+// Manager owns the segments of the warm and cold tiers.
+type Manager struct {
+	dirs   map[string]string
+	fields blugeindex.FieldConfig
+	key    []byte
+
+	mu   sync.RWMutex
+	segs map[string]*segment
+}
+
+// Open loads the segments under warmDir and coldDir, creating the
+// directories as needed. fc must match the live index so archived events
+// are searchable the same way.
+func Open(warmDir, coldDir string, fc blugeindex.FieldConfig) (*Manager, error) {
+	return OpenEncrypted(warmDir, coldDir, fc, nil)
+}
+
+// OpenEncrypted is Open with new segments encrypted with key (32 bytes).
+// A nil key writes plaintext segments.
+func OpenEncrypted(warmDir, coldDir string, fc blugeindex.FieldConfig, key []byte) (*Manager, error) {
+	m := &Manager{
+		dirs:   map[string]string{TierWarm: warmDir, TierCold: coldDir},
+		fields: fc,
+		key:    key,
+		segs:   make(map[string]*segment),
+	}
+	// Cold is loaded first so that a segment left in both tiers by an
+	// interrupted move resolves to its destination.
+	for _, tier := range []string{TierCold, TierWarm} {
+		dir := m.dirs[tier]
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("archive: mkdir %s: %w", dir, err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("archive: read %s: %w", dir, err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if _, dup := m.segs[e.Name()]; dup {
+				log.Printf("archive: removing stale copy %s", path)
+				os.RemoveAll(path)
+				continue
+			}
+			seg, err := openSegment(path, tier, key)
+			if errors.Is(err, ErrNoKey) {
+				m.Close()
+				return nil, fmt.Errorf("%w: %s", err, path)
+			}
+			if err != nil {
+				log.Printf("archive: removing incomplete segment %s: %v", path, err)
+				os.RemoveAll(path)
+				continue
+			}
+			m.segs[seg.ID] = seg
+		}
+	}
+	// A rewrite interrupted before the original was deleted leaves both;
+	// the replacement wins.
+	for _, s := range m.segs {
+		if old, ok := m.segs[s.Replaces]; ok && s.Replaces != "" {
+			log.Printf("archive: removing %s, replaced by %s", old.Dir, s.ID)
+			old.idx.Close()
+			os.RemoveAll(old.Dir)
+			delete(m.segs, old.ID)
+		}
+	}
+	return m, nil
+}
+
+func openSegment(dir, tier string, key []byte) (*segment, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var mf Manifest
+	if err := json.Unmarshal(b, &mf); err != nil {
+		return nil, fmt.Errorf("archive: manifest %s: %w", dir, err)
+	}
+	if mf.Encrypted && key == nil {
+		return nil, ErrNoKey
+	}
+	seg := &segment{Segment: Segment{Manifest: mf, Tier: tier, Dir: dir}}
+	if mf.Encrypted {
+		seg.key = key
+	}
+	if seg.idx, err = blugeindex.OpenSegment(filepath.Join(dir, indexDir), seg.key); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// List returns all segments, oldest first.
+func (m *Manager) List() []Segment {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Segment, 0, len(m.segs))
+	for _, s := range m.segs {
+		out = append(out, s.Segment)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Close releases every segment.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []error
+	for _, s := range m.segs {
+		if err := s.idx.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	m.segs = map[string]*segment{}
+	return errors.Join(errs...)
+}
+
+// ─── Writing ─────────────────────────────────────────────────────────────────
+
+// Writer builds one segment. Events must be added in time order.
+type Writer struct {
+	m     *Manager
+	id    string
+	tmp   string
+	f     *os.File
+	buf   *bufio.Writer
+	sha   hash.Hash
+	off   int64
+	idx   *blugeindex.SegmentWriter
+	prior []*blugeindex.Segment
+
+	mf      Manifest
+	pending []*models.Event
+}
+
+// NewWriter starts a warm segment for the UTC day containing day. Events
+// already archived in an earlier segment of that day are skipped, so a day
+// interrupted between sealing and purging the hot tier can be archived again.
+func (m *Manager) NewWriter(day time.Time) (*Writer, error) {
+	return m.newWriter(day, "")
+}
+
+// newWriter is NewWriter, ignoring the events of segment replaced.
+func (m *Manager) newWriter(day time.Time, replaced string) (*Writer, error) {
+	day = day.UTC()
+	key := day.Format(dayLayout)
+
+	m.mu.RLock()
+	seq := 0
+	var prior []*blugeindex.Segment
+	for _, s := range m.segs {
+		if s.Day == key {
+			if s.ID != replaced {
+				prior = append(prior, s.idx)
+			}
+			if n, err := strconv.Atoi(strings.TrimPrefix(s.ID, key+"-")); err == nil && n >= seq {
+				seq = n + 1
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	id := fmt.Sprintf("%s-%02d", key, seq)
+	tmp := filepath.Join(m.dirs[TierWarm], id)
+	if err := os.MkdirAll(tmp, 0o700); err != nil {
+		return nil, fmt.Errorf("archive: mkdir %s: %w", tmp, err)
+	}
+	f, err := os.Create(filepath.Join(tmp, eventsFile))
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("archive: create %s: %w", id, err)
+	}
+	idx, err := blugeindex.NewSegmentWriter(filepath.Join(tmp, indexDir), m.fields, m.key)
+	if err != nil {
+		f.Close()
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	return &Writer{
+		m:     m,
+		id:    id,
+		tmp:   tmp,
+		f:     f,
+		buf:   bufio.NewWriter(f),
+		sha:   sha256.New(),
+		idx:   idx,
+		prior: prior,
+		mf:    Manifest{ID: id, Day: key, Replaces: replaced, Encrypted: m.key != nil},
+	}, nil
+}
+
+// Add appends ev to the segment.
+func (w *Writer) Add(ev *models.Event) error {
+	for _, p := range w.prior {
+		if _, found, err := p.Ref(ev.ID); err != nil {
+			return err
+		} else if found {
+			return nil
+		}
+	}
+	if w.mf.Events == 0 || ev.Timestamp.Before(w.mf.Start) {
+		w.mf.Start = ev.Timestamp
+	}
+	if ev.Timestamp.After(w.mf.End) {
+		w.mf.End = ev.Timestamp
+	}
+	w.mf.Events++
+	w.pending = append(w.pending, ev)
+	if len(w.pending) >= blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// Events returns the number of events added so far.
+func (w *Writer) Events() int { return w.mf.Events }
+
+func (w *Writer) flushBlock() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	block := len(w.mf.Blocks)
+	ref := w.id + "/" + strconv.Itoa(block)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	enc := json.NewEncoder(zw)
+	for _, ev := range w.pending {
+		if err := enc.Encode(ev); err != nil {
+			return fmt.Errorf("archive: encode %s: %w", ev.ID, err)
+		}
+		if err := w.idx.Add(ev, ref); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("archive: compress block: %w", err)
+	}
+	data := gz.Bytes()
+	if w.m.key != nil {
+		var err error
+		if data, err = keyring.SealWithKey(w.m.key, data, []byte(ref)); err != nil {
+			return fmt.Errorf("archive: encrypt block: %w", err)
+		}
+	}
+	if _, err := io.MultiWriter(w.buf, w.sha).Write(data); err != nil {
+		return fmt.Errorf("archive: write %s: %w", w.id, err)
+	}
+	n := int64(len(data))
+	w.mf.Blocks = append(w.mf.Blocks, Block{Offset: w.off, Length: n, Events: len(w.pending)})
+	w.off += n
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// Seal finishes the segment and makes it searchable. integrity lists the
+// forensics integrity blocks that cover its events.
+func (w *Writer) Seal(integrity []int64) (*Segment, error) {
+	if err := w.seal(integrity); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	seg, err := openSegment(w.tmp, TierWarm, w.m.key)
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	w.m.mu.Lock()
+	w.m.segs[seg.ID] = seg
+	w.m.mu.Unlock()
+	return &seg.Segment, nil
+}
+
+func (w *Writer) seal(integrity []int64) error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("archive: write %s: %w", w.id, err)
+	}
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("archive: sync %s: %w", w.id, err)
+	}
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("archive: close %s: %w", w.id, err)
+	}
+	w.f = nil
+	if err := w.idx.Close(); err != nil {
+		return err
+	}
+	w.idx = nil
+
+	w.mf.Bytes = w.off
+	w.mf.SHA256 = hex.EncodeToString(w.sha.Sum(nil))
+	w.mf.IntegrityBlocks = integrity
+	w.mf.CreatedAt = time.Now().UTC()
+	b, err := json.MarshalIndent(&w.mf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileSync(filepath.Join(w.tmp, manifestFile), b)
+}
+
+// Abort discards an unsealed segment.
+func (w *Writer) Abort() {
+	if w.f != nil {
+		w.f.Close()
+	}
+	if w.idx != nil {
+		w.idx.Close()
+	}
+	os.RemoveAll(w.tmp)
+}
+
+func writeFileSync(path string, b []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ─── Reading ─────────────────────────────────────────────────────────────────
+
+// View pins the segments overlapping a time range so they are not moved or
+// deleted while a search reads them. Release must be called when done.
+type View struct {
+	m    *Manager
+	segs []*segment
+}
+
+// View returns the segments that may hold events in [start, end] (unix nano,
+// 0 = unbounded). A nil Manager yields an empty view.
+func (m *Manager) View(start, end int64) *View {
+	if m == nil {
+		return &View{}
+	}
+	m.mu.RLock()
+	v := &View{m: m}
+	for _, s := range m.segs {
+		if (start > 0 && s.End.UnixNano() < start) || (end > 0 && s.Start.UnixNano() > end) {
+			continue
+		}
+		v.segs = append(v.segs, s)
+	}
+	return v
+}
+
+// Release unpins the view's segments.
+func (v *View) Release() {
+	if v.m != nil {
+		v.m.mu.RUnlock()
+		v.m = nil
+	}
+}
+
+// Indexes returns the segment indexes of the view.
+func (v *View) Indexes() []*blugeindex.Segment {
+	out := make([]*blugeindex.Segment, len(v.segs))
+	for i, s := range v.segs {
+		out[i] = s.idx
+	}
+	return out
+}
+
+// Segments returns the view's segments, oldest first.
+func (v *View) Segments() []Segment {
+	out := make([]Segment, len(v.segs))
+	for i, s := range v.segs {
+		out[i] = s.Segment
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Events reads the events with the given IDs and references (as returned by
+// a search over Indexes), decompressing each referenced block once. IDs
+// with an empty reference are skipped.
+func (v *View) Events(ids, refs []string) (map[string]*models.Event, error) {
+	want := make(map[string]map[string]bool)
+	for i, ref := range refs {
+		if ref == "" {
+			continue
+		}
+		if want[ref] == nil {
+			want[ref] = make(map[string]bool)
+		}
+		want[ref][ids[i]] = true
+	}
+	out := make(map[string]*models.Event, len(ids))
+	for ref, ids := range want {
+		seg, block, err := v.resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		err = seg.readBlock(block, func(ev *models.Event) bool {
+			if ids[ev.ID] {
+				out[ev.ID] = ev
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Find returns the archived event with id, or nil.
+func (v *View) Find(id string) (*models.Event, error) {
+	for _, s := range v.segs {
+		ref, found, err := s.idx.Ref(id)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		evs, err := v.Events([]string{id}, []string{ref})
+		if err != nil {
+			return nil, err
+		}
+		return evs[id], nil
+	}
+	return nil, nil
+}
+
+// Contains reports whether an event with id is archived in the view,
+// without reading it.
+func (v *View) Contains(id string) (bool, error) {
+	for _, s := range v.segs {
+		if _, found, err := s.idx.Ref(id); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// segment returns the view's segment with id, or nil.
+func (v *View) segment(id string) *segment {
+	for _, s := range v.segs {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (v *View) resolve(ref string) (*segment, int, error) {
+	i := strings.LastIndexByte(ref, '/')
+	if i < 0 {
+		return nil, 0, fmt.Errorf("archive: bad reference %q", ref)
+	}
+	block, err := strconv.Atoi(ref[i+1:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("archive: bad reference %q", ref)
+	}
+	for _, s := range v.segs {
+		if s.ID == ref[:i] {
+			if block < 0 || block >= len(s.Blocks) {
+				return nil, 0, fmt.Errorf("archive: %s has no block %d", s.ID, block)
+			}
+			return s, block, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: %s", ErrNotFound, ref[:i])
+}
+
+// readBlock decodes one block, calling fn for each event until it returns
+// false.
+func (s *segment) readBlock(block int, fn func(*models.Event) bool) error {
+	f, err := os.Open(filepath.Join(s.Dir, eventsFile))
+	if err != nil {
+		return fmt.Errorf("archive: open %s: %w", s.ID, err)
+	}
+	defer f.Close()
+	b := s.Blocks[block]
+	data := make([]byte, b.Length)
+	if _, err := f.ReadAt(data, b.Offset); err != nil {
+		return fmt.Errorf("archive: %s block %d: %w", s.ID, block, err)
+	}
+	if s.key != nil {
+		if data, err = keyring.OpenWithKey(s.key, data, []byte(s.ID+"/"+strconv.Itoa(block))); err != nil {
+			return fmt.Errorf("archive: %s block %d: %w", s.ID, block, err)
+		}
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("archive: %s block %d: %w", s.ID, block, err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+	for {
+		var ev models.Event
+		if err := dec.Decode(&ev); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("archive: %s block %d: %w", s.ID, block, err)
+		}
+		if !fn(&ev) {
+			return nil
+		}
+	}
+}
+
+// scan decodes every block in order, calling fn for each event until it
+// returns false.
+func (s *segment) scan(fn func(*models.Event) bool) error {
+	more := true
+	for i := range s.Blocks {
+		err := s.readBlock(i, func(ev *models.Event) bool {
+			more = fn(ev)
+			return more
+		})
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// ─── Maintenance ─────────────────────────────────────────────────────────────
+
+// Verify checks a segment's events file against the manifest hash and block
+// table.
+func (m *Manager) Verify(id string) error {
+	v := m.View(0, 0)
+	defer v.Release()
+	seg := v.segment(id)
+	if seg == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	f, err := os.Open(filepath.Join(seg.Dir, eventsFile))
+	if err != nil {
+		return fmt.Errorf("archive: open %s: %w", id, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("archive: read %s: %w", id, err)
+	}
+	if n != seg.Bytes || hex.EncodeToString(h.Sum(nil)) != seg.SHA256 {
+		return fmt.Errorf("archive: %s does not match its manifest", id)
+	}
+	events := 0
+	for i := range seg.Blocks {
+		if err := seg.readBlock(i, func(*models.Event) bool { events++; return true }); err != nil {
+			return err
+		}
+	}
+	if events != seg.Events {
+		return fmt.Errorf("archive: %s holds %d events, manifest says %d", id, events, seg.Events)
+	}
+	return nil
+}
+
+// Move relocates a segment to another tier. Searches are only paused for
+// the final swap.
+func (m *Manager) Move(id, tier string) error {
+	dst, ok := m.dirs[tier]
+	if !ok {
+		return fmt.Errorf("archive: unknown tier %q", tier)
+	}
+	m.mu.RLock()
+	seg, ok := m.segs[id]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if seg.Tier == tier {
+		return nil
+	}
+	// Copy to a staging directory first (removed on Open if interrupted),
+	// which also works across filesystems, then swap under the lock.
+	target := filepath.Join(dst, id)
+	staged := filepath.Join(dst, "."+id+".tmp")
+	os.RemoveAll(staged)
+	if err := copyDir(seg.Dir, staged); err != nil {
+		os.RemoveAll(staged)
+		return fmt.Errorf("archive: copy %s: %w", id, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.Rename(staged, target); err != nil {
+		os.RemoveAll(staged)
+		return fmt.Errorf("archive: move %s: %w", id, err)
+	}
+	idx, err := blugeindex.OpenSegment(filepath.Join(target, indexDir), seg.key)
+	if err != nil {
+		os.RemoveAll(target)
+		return err
+	}
+	seg.idx.Close()
+	if err := os.RemoveAll(seg.Dir); err != nil {
+		log.Printf("archive: remove %s after move: %v", seg.Dir, err)
+	}
+	seg.idx, seg.Dir, seg.Tier = idx, target, tier
+	return nil
+}
+
+// Scan calls fn for every event of a segment in order, stopping at the
+// first error.
+func (m *Manager) Scan(id string, fn func(*models.Event) error) error {
+	v := m.View(0, 0)
+	defer v.Release()
+	seg := v.segment(id)
+	if seg == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	var fnErr error
+	err := seg.scan(func(ev *models.Event) bool {
+		fnErr = fn(ev)
+		return fnErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// Rewrite replaces a segment with a copy, in the same tier, without the
+// events whose IDs are in drop, and returns the replacement. Every ID in
+// drop must be in the segment. A segment left empty is deleted and nil is
+// returned; an empty drop set changes nothing.
+func (m *Manager) Rewrite(id string, drop map[string]bool) (*Segment, error) {
+	m.mu.RLock()
+	seg, ok := m.segs[id]
+	var cur Segment
+	if ok {
+		cur = seg.Segment
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if len(drop) == 0 {
+		return &cur, nil
+	}
+	mf := cur.Manifest
+	if len(drop) >= mf.Events {
+		return nil, m.Delete(id)
+	}
+
+	day, err := time.Parse(dayLayout, mf.Day)
+	if err != nil {
+		return nil, fmt.Errorf("archive: %s: bad day %q", id, mf.Day)
+	}
+	w, err := m.newWriter(day, id)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Scan(id, func(ev *models.Event) error {
+		if drop[ev.ID] {
+			return nil
+		}
+		return w.Add(ev)
+	})
+	if err == nil && w.Events() != mf.Events-len(drop) {
+		err = fmt.Errorf("archive: rewrite %s kept %d events, expected %d", id, w.Events(), mf.Events-len(drop))
+	}
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	repl, err := w.Seal(mf.IntegrityBlocks)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	tier := seg.Tier
+	m.mu.RUnlock()
+	if tier != TierWarm {
+		if err := m.Move(repl.ID, tier); err != nil {
+			log.Printf("archive: move rewritten %s to %s: %v", repl.ID, tier, err)
+		}
+	}
+	m.mu.RLock()
+	out := *repl
+	m.mu.RUnlock()
+	return &out, m.Delete(id)
+}
+
+// Delete removes a segment permanently.
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seg, ok := m.segs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	delete(m.segs, id)
+	seg.idx.Close()
+	if err := os.RemoveAll(seg.Dir); err != nil {
+		return fmt.Errorf("archive: delete %s: %w", id, err)
+	}
+	return nil
+}
+
+// Usage returns the number of archived events and bytes on disk per tier.
+func (m *Manager) Usage() (events int64, bytes map[string]int64) {
+	bytes = map[string]int64{TierWarm: 0, TierCold: 0}
+	for _, s := range m.List() {
+		events += int64(s.Events)
+		bytes[s.Tier] += dirSize(s.Dir)
+	}
+	return events, bytes
+}
+
+func dirSize(dir string) int64 {
+	var n int64
+	filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o700)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Sync(); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
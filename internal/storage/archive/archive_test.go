@@ -0,0 +1,223 @@
+package archive_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/archive"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/blugeindex"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+func openManager(t *testing.T, dir string) *archive.Manager {
+	t.Helper()
+	m, err := archive.Open(filepath.Join(dir, "warm"), filepath.Join(dir, "cold"), blugeindex.FieldConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func writeDay(t *testing.T, m *archive.Manager, day time.Time, n int) *archive.Segment {
+	t.Helper()
+	w, err := m.NewWriter(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		ev := &models.Event{ID: "ev-" + strconv.Itoa(i), Timestamp: day.Add(time.Duration(i) * time.Second), Host: "h", Message: "archived event"}
+		if err := w.Add(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seg, err := w.Seal([]int64{7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seg
+}
+
+func TestSegmentWriteReadMove(t *testing.T) {
+	dir := t.TempDir()
+	m := openManager(t, dir)
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	seg := writeDay(t, m, day, 2500)
+	if seg.ID != "20260301-00" || seg.Events != 2500 || len(seg.Blocks) != 3 || seg.Tier != archive.TierWarm {
+		t.Fatalf("segment = %+v", seg.Manifest)
+	}
+	if len(seg.IntegrityBlocks) != 2 {
+		t.Errorf("integrity refs = %v", seg.IntegrityBlocks)
+	}
+
+	// Rewriting the same day skips events already archived.
+	w, err := m.NewWriter(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(&models.Event{ID: "ev-5", Timestamp: day}); err != nil || w.Events() != 0 {
+		t.Errorf("duplicate added: %d events, %v", w.Events(), err)
+	}
+	w.Abort()
+
+	if err := m.Move(seg.ID, archive.TierCold); err != nil {
+		t.Fatal(err)
+	}
+	v := m.View(day.UnixNano(), day.Add(time.Hour).UnixNano())
+	ev, err := v.Find("ev-2100")
+	v.Release()
+	if err != nil || ev == nil || !ev.Timestamp.Equal(day.Add(2100*time.Second)) {
+		t.Fatalf("Find after move = %+v, %v", ev, err)
+	}
+	if v := m.View(day.Add(48*time.Hour).UnixNano(), 0); len(v.Indexes()) != 0 {
+		t.Error("view outside the segment span is not empty")
+	} else {
+		v.Release()
+	}
+	m.Close()
+
+	// Reopening finds the cold segment and drops an interrupted write.
+	if err := os.MkdirAll(filepath.Join(dir, "warm", "20260302-00"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	m = openManager(t, dir)
+	defer m.Close()
+	segs := m.List()
+	if len(segs) != 1 || segs[0].Tier != archive.TierCold {
+		t.Fatalf("reopened = %+v", segs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "warm", "20260302-00")); !os.IsNotExist(err) {
+		t.Error("incomplete segment not removed")
+	}
+	if err := m.Verify(seg.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any change to the events file is detected.
+	path := filepath.Join(segs[0].Dir, "events.gz")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[100] ^= 0xff
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(seg.ID); err == nil {
+		t.Error("tampered segment verified")
+	}
+}
+
+func TestSegmentRewrite(t *testing.T) {
+	dir := t.TempDir()
+	m := openManager(t, dir)
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	seg := writeDay(t, m, day, 1500)
+	if err := m.Move(seg.ID, archive.TierCold); err != nil {
+		t.Fatal(err)
+	}
+
+	drop := map[string]bool{}
+	for i := 0; i < 1500; i += 3 {
+		drop["ev-"+strconv.Itoa(i)] = true
+	}
+	repl, err := m.Rewrite(seg.ID, drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repl.ID == seg.ID || repl.Replaces != seg.ID || repl.Tier != archive.TierCold || repl.Events != 1000 || len(repl.IntegrityBlocks) != 2 {
+		t.Fatalf("replacement = %+v (%s)", repl.Manifest, repl.Tier)
+	}
+	if segs := m.List(); len(segs) != 1 || segs[0].ID != repl.ID {
+		t.Fatalf("segments after rewrite = %+v", segs)
+	}
+	v := m.View(0, 0)
+	if ev, _ := v.Find("ev-3"); ev != nil {
+		t.Error("dropped event still readable")
+	}
+	if ev, _ := v.Find("ev-4"); ev == nil {
+		t.Error("kept event lost")
+	}
+	v.Release()
+	if err := m.Verify(repl.ID); err != nil {
+		t.Error(err)
+	}
+	if _, err := m.Rewrite(repl.ID, map[string]bool{"nope": true}); err == nil {
+		t.Error("dropping an unknown event should fail")
+	}
+
+	// Dropping everything deletes the segment.
+	all := map[string]bool{}
+	for i := 0; i < 1500; i++ {
+		if !drop["ev-"+strconv.Itoa(i)] {
+			all["ev-"+strconv.Itoa(i)] = true
+		}
+	}
+	if repl, err := m.Rewrite(repl.ID, all); err != nil || repl != nil {
+		t.Fatalf("rewrite to empty = %+v, %v", repl, err)
+	}
+	if n := len(m.List()); n != 0 {
+		t.Errorf("%d segments left", n)
+	}
+	m.Close()
+}
+
+func TestEncryptedSegments(t *testing.T) {
+	dir := t.TempDir()
+	warm, cold := filepath.Join(dir, "warm"), filepath.Join(dir, "cold")
+	key := make([]byte, 32)
+	key[0] = 5
+	plainDay := time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)
+	encDay := plainDay.AddDate(0, 0, 1)
+
+	m := openManager(t, dir)
+	writeDay(t, m, plainDay, 10)
+	m.Close()
+
+	m, err := archive.OpenEncrypted(warm, cold, blugeindex.FieldConfig{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := writeDay(t, m, encDay, 1200)
+	if !seg.Encrypted {
+		t.Fatal("segment not marked encrypted")
+	}
+	if err := m.Move(seg.ID, archive.TierCold); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(cold, seg.ID, "events.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] == 0x1f && b[1] == 0x8b {
+		t.Error("events file holds a plain gzip member")
+	}
+	v := m.View(plainDay.UnixNano(), plainDay.Add(time.Hour).UnixNano())
+	if ev, err := v.Find("ev-7"); err != nil || ev == nil || !ev.Timestamp.Equal(plainDay.Add(7*time.Second)) {
+		t.Fatalf("Find plaintext = %+v, %v", ev, err)
+	}
+	v.Release()
+	v = m.View(encDay.UnixNano(), 0)
+	if ev, err := v.Find("ev-1100"); err != nil || ev == nil || !ev.Timestamp.Equal(encDay.Add(1100*time.Second)) {
+		t.Fatalf("Find encrypted = %+v, %v", ev, err)
+	}
+	v.Release()
+	for _, s := range m.List() {
+		if err := m.Verify(s.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Close()
+
+	// Without the key Open fails and leaves the encrypted segment alone.
+	if _, err := archive.Open(warm, cold, blugeindex.FieldConfig{}); !errors.Is(err, archive.ErrNoKey) {
+		t.Fatalf("open without key: err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cold, seg.ID, "manifest.json")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,740 @@
+// Package storage (backup.go) takes consistent online backups of every
+// engine and restores them.
+//
+// A backup is a directory named after its ID holding
+//   - badger.bak: Badger's streaming backup, gzipped (and encrypted),
+//   - sqlite.db: an SQLite online backup (encrypted page by page when
+//     encryption is on),
+//   - bluge/{partition}/: the files of a snapshot of the live index,
+//   - archive/{tier}/{segment}/: the archive segments,
+//   - keys/master.json: the sealed master key, when encryption is on,
+//   - manifest.json: the SHA-256 of every file and the last forensics
+//     integrity block, written last.
+//
+// Ingestion is paused only while the SQLite, Bluge and archive copies are
+// taken; Badger is streamed afterwards up to the version it had reached.
+// An incremental backup streams only Badger versions written since its
+// parent and refers to the parent for Bluge and archive files it already
+// holds. Restore verifies the whole chain before touching the data.
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/badgerstore"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+)
+
+const (
+	backupManifestFile = "manifest.json"
+	backupBadgerFile   = "badger.bak"
+	backupSQLiteFile   = "sqlite.db"
+	backupBlugeDir     = "bluge"
+	backupArchiveDir   = "archive"
+	backupKeyFile      = "keys/master.json"
+
+	backupIDLayout = "20060102T150405.000Z"
+
+	// restoreFile in the base path schedules a restore for the next Open.
+	restoreFile = "restore.json"
+)
+
+// BackupManifest describes a backup. Files lists everything needed to
+// restore it except the Badger backups of its ancestors.
+type BackupManifest struct {
+	ID            string       `json:"id"`
+	Parent        string       `json:"parent,omitempty"`      // backup this one extends
+	SinceBlock    int64        `json:"since_block,omitempty"` // parent's LastBlock
+	Created       time.Time    `json:"created"`
+	Encrypted     bool         `json:"encrypted"`
+	BadgerSince   uint64       `json:"badger_since"` // first Badger version included
+	BadgerFence   uint64       `json:"badger_fence"` // last Badger version included
+	LastBlock     int64        `json:"last_block"`   // 0 before the first integrity block
+	LastBlockHash string       `json:"last_block_hash,omitempty"`
+	Files         []BackupFile `json:"files"`
+}
+
+// BackupFile is one file of a backup.
+type BackupFile struct {
+	Path   string `json:"path"`             // slash-separated, relative to the backup
+	Backup string `json:"backup,omitempty"` // ancestor holding the file, if not this backup
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupOptions controls Engine.Backup.
+type BackupOptions struct {
+	// Dir holds the backups, each in a subdirectory named after its ID.
+	Dir string
+	// SinceBlock, when set, takes an incremental backup on top of the
+	// backup in Dir whose LastBlock it is.
+	SinceBlock int64
+	// Pause holds event ingestion until resume is called; nil skips it.
+	Pause func(ctx context.Context) (resume func(), err error)
+}
+
+// ─── Backup ──────────────────────────────────────────────────────────────────
+
+// Backup writes a consistent backup of every engine into a new
+// subdirectory of opts.Dir and returns its manifest.
+func (e *Engine) Backup(ctx context.Context, opts BackupOptions) (*BackupManifest, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("storage: backup: no directory")
+	}
+	var parent *BackupManifest
+	inherited := make(map[string]BackupFile)
+	if opts.SinceBlock > 0 {
+		var err error
+		if parent, err = findBackup(opts.Dir, opts.SinceBlock); err != nil {
+			return nil, err
+		}
+		if parent.Encrypted != (e.Keyring != nil) {
+			return nil, fmt.Errorf("storage: backup %s was taken with encryption %s; take a full backup", parent.ID, onOff(parent.Encrypted))
+		}
+		for _, f := range parent.Files {
+			if f.Backup == "" {
+				f.Backup = parent.ID
+			}
+			inherited[f.Path] = f
+		}
+	}
+
+	now := time.Now().UTC()
+	mf := &BackupManifest{ID: now.Format(backupIDLayout), Created: now, Encrypted: e.Keyring != nil}
+	if parent != nil {
+		mf.Parent, mf.SinceBlock, mf.BadgerSince = parent.ID, parent.LastBlock, parent.BadgerFence+1
+	}
+	dir := filepath.Join(opts.Dir, mf.ID)
+	if _, err := os.Stat(dir); err == nil {
+		return nil, fmt.Errorf("storage: backup %s already exists", mf.ID)
+	}
+	tmp := filepath.Join(opts.Dir, "."+mf.ID+".tmp")
+	if err := os.MkdirAll(tmp, 0o700); err != nil {
+		return nil, fmt.Errorf("storage: backup: %w", err)
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			os.RemoveAll(tmp)
+		}
+	}()
+
+	resume := func() {}
+	if opts.Pause != nil {
+		var err error
+		if resume, err = opts.Pause(ctx); err != nil {
+			return nil, fmt.Errorf("storage: backup: pause ingestion: %w", err)
+		}
+	}
+	// The fence stays pinned until Badger is streamed, so events deleted
+	// or changed after it are still backed up as of the fence.
+	fence, unpin := e.Badger.Pin()
+	defer unpin()
+	mf.BadgerFence = fence
+	var files []BackupFile
+	err := e.capture(tmp, mf, inherited, &files)
+	resume()
+	if err != nil {
+		return nil, fmt.Errorf("storage: backup: %w", err)
+	}
+	if err := e.backupBadger(filepath.Join(tmp, backupBadgerFile), mf.BadgerSince, mf.BadgerFence); err != nil {
+		return nil, fmt.Errorf("storage: backup: %w", err)
+	}
+
+	// Hash everything written here; inherited files keep their entries.
+	err = filepath.WalkDir(tmp, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(tmp, p)
+		if err != nil {
+			return err
+		}
+		size, sum, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, BackupFile{Path: filepath.ToSlash(rel), Size: size, SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storage: backup: hash: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	mf.Files = files
+
+	b, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("storage: backup: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(tmp, backupManifestFile), b); err != nil {
+		return nil, fmt.Errorf("storage: backup: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return nil, fmt.Errorf("storage: backup: %w", err)
+	}
+	ok = true
+	log.Printf("storage: backup %s written to %s (%d files)", mf.ID, dir, len(mf.Files))
+	return mf, nil
+}
+
+// capture copies the stores that must be taken together, while ingestion
+// is paused, and records the last integrity block. Files already held by
+// the parent are added to files as inherited.
+func (e *Engine) capture(dir string, mf *BackupManifest, inherited map[string]BackupFile, files *[]BackupFile) error {
+	// The block is read before the copy, so the copy holds it even if a
+	// new block is sealed in between.
+	last, err := e.SQLite.GetLastIntegrityBlock()
+	if err != nil {
+		return fmt.Errorf("last integrity block: %w", err)
+	}
+	if last != nil {
+		mf.LastBlock, mf.LastBlockHash = last.ID, hex.EncodeToString(last.RootHash)
+	}
+	if err := e.SQLite.Backup(filepath.Join(dir, backupSQLiteFile)); err != nil {
+		return err
+	}
+
+	names, err := e.Bluge.Snapshot(filepath.Join(dir, backupBlugeDir), func(name string) bool {
+		_, ok := inherited[path.Join(backupBlugeDir, name)]
+		return ok
+	})
+	if err != nil {
+		return fmt.Errorf("bluge snapshot: %w", err)
+	}
+	for _, name := range names {
+		if f, ok := inherited[path.Join(backupBlugeDir, name)]; ok {
+			*files = append(*files, f)
+		}
+	}
+
+	view := e.Archive.View(0, 0)
+	defer view.Release()
+	for _, seg := range view.Segments() {
+		prefix := path.Join(backupArchiveDir, seg.Tier, seg.ID) + "/"
+		found := false
+		for p, f := range inherited {
+			if strings.HasPrefix(p, prefix) {
+				*files = append(*files, f)
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+		if err := linkTree(seg.Dir, filepath.Join(dir, filepath.FromSlash(prefix))); err != nil {
+			return fmt.Errorf("archive segment %s: %w", seg.ID, err)
+		}
+	}
+
+	if e.Keyring != nil {
+		if err := copyFile(e.Keyring.Path(), filepath.Join(dir, filepath.FromSlash(backupKeyFile))); err != nil {
+			return fmt.Errorf("master key: %w", err)
+		}
+	}
+	return nil
+}
+
+// backupBadger streams Badger versions [since, fence] to path, gzipped and,
+// with encryption on, encrypted with the backup key.
+func (e *Engine) backupBadger(path string, since, fence uint64) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var enc io.WriteCloser
+	if e.Keyring != nil {
+		if enc, err = keyring.NewWriter(f, e.Keyring.Key(keyring.PurposeBackup)); err != nil {
+			return err
+		}
+		w = enc
+	}
+	zw := gzip.NewWriter(w)
+	if err := e.Badger.Backup(zw, since, fence); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// findBackup returns the most recent backup in dir whose last integrity
+// block is block.
+func findBackup(dir string, block int64) (*BackupManifest, error) {
+	all, err := ListBackups(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].LastBlock == block {
+			return all[i], nil
+		}
+	}
+	return nil, fmt.Errorf("storage: no backup in %s ends at integrity block %d", dir, block)
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// ListBackups returns the manifests of the completed backups in dir,
+// oldest first. Files are not verified.
+func ListBackups(dir string) ([]*BackupManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage: list backups: %w", err)
+	}
+	var out []*BackupManifest
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		mf, err := readBackupManifest(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue // not a backup, or an incomplete one
+		}
+		out = append(out, mf)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func readBackupManifest(dir string) (*BackupManifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var mf BackupManifest
+	if err := json.Unmarshal(b, &mf); err != nil {
+		return nil, fmt.Errorf("storage: manifest %s: %w", dir, err)
+	}
+	if mf.ID != filepath.Base(dir) {
+		return nil, fmt.Errorf("storage: manifest %s names backup %q", dir, mf.ID)
+	}
+	return &mf, nil
+}
+
+// ─── Verify ──────────────────────────────────────────────────────────────────
+
+// VerifyBackup checks the backup in dir and every ancestor it needs, which
+// must sit next to it: the chain must link up and every file must match
+// its manifest. It returns the chain, oldest first.
+func VerifyBackup(dir string) ([]*BackupManifest, error) {
+	root := filepath.Dir(dir)
+	var chain []*BackupManifest
+	for id := filepath.Base(dir); id != ""; {
+		mf, err := readBackupManifest(filepath.Join(root, id))
+		if err != nil {
+			return nil, fmt.Errorf("storage: verify backup %s: %w", id, err)
+		}
+		if n := len(chain); n > 0 {
+			child := chain[n-1]
+			if child.SinceBlock != mf.LastBlock || child.BadgerSince != mf.BadgerFence+1 || child.Encrypted != mf.Encrypted {
+				return nil, fmt.Errorf("storage: verify backup %s: does not extend %s", child.ID, mf.ID)
+			}
+		}
+		if len(chain) > 64 {
+			return nil, fmt.Errorf("storage: verify backup %s: chain too long", filepath.Base(dir))
+		}
+		chain = append(chain, mf)
+		id = mf.Parent
+	}
+
+	checked := make(map[string]bool)
+	for _, mf := range chain {
+		for _, f := range mf.Files {
+			holder := f.Backup
+			if holder == "" {
+				holder = mf.ID
+			}
+			key := holder + "/" + f.Path
+			if checked[key] {
+				continue
+			}
+			p, err := backupPath(root, holder, f.Path)
+			if err != nil {
+				return nil, fmt.Errorf("storage: verify backup %s: %w", mf.ID, err)
+			}
+			size, sum, err := hashFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("storage: verify backup %s: %w", mf.ID, err)
+			}
+			if size != f.Size || sum != f.SHA256 {
+				return nil, fmt.Errorf("storage: verify backup %s: %s does not match the manifest", mf.ID, f.Path)
+			}
+			checked[key] = true
+		}
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// backupPath resolves a manifest path, refusing paths that leave the
+// backup.
+func backupPath(root, id, rel string) (string, error) {
+	if !fs.ValidPath(rel) || rel == "." || !fs.ValidPath(id) || strings.Contains(id, "/") {
+		return "", fmt.Errorf("invalid path %q in backup %q", rel, id)
+	}
+	return filepath.Join(root, id, filepath.FromSlash(rel)), nil
+}
+
+// ─── Restore ─────────────────────────────────────────────────────────────────
+
+// ScheduleRestore verifies the backup in dir and arranges for the next
+// Open with cfg to restore it before opening the engines.
+func ScheduleRestore(cfg *config.Config, dir string) (*BackupManifest, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("storage: schedule restore: %w", err)
+	}
+	chain, err := VerifyBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	mf := chain[len(chain)-1]
+	if mf.Encrypted && !cfg.Storage.Encryption.Enabled {
+		return nil, errors.New("storage: schedule restore: the backup is encrypted but encryption at rest is disabled")
+	}
+	b, err := json.Marshal(map[string]string{"backup": dir})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Storage.BasePath, 0o700); err != nil {
+		return nil, fmt.Errorf("storage: schedule restore: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(cfg.Storage.BasePath, restoreFile), b); err != nil {
+		return nil, fmt.Errorf("storage: schedule restore: %w", err)
+	}
+	return mf, nil
+}
+
+// runScheduledRestore performs a restore left by ScheduleRestore. If it
+// fails the current data is untouched and the schedule is kept, so the
+// system does not come up until the backup is fixed or the schedule file
+// removed.
+func runScheduledRestore(cfg *config.Config) error {
+	marker := filepath.Join(cfg.Storage.BasePath, restoreFile)
+	b, err := os.ReadFile(marker)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("storage: scheduled restore: %w", err)
+	}
+	var req struct {
+		Backup string `json:"backup"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return fmt.Errorf("storage: scheduled restore: %s: %w", marker, err)
+	}
+	if _, err := Restore(cfg, req.Backup); err != nil {
+		return fmt.Errorf("%w (remove %s to start with the current data)", err, marker)
+	}
+	return os.Remove(marker)
+}
+
+// Restore replaces the data under cfg with the backup in dir, which is
+// verified first together with its ancestors. The engines must not be
+// open. The restored stores are built and checked in a staging directory;
+// only then is the current data moved aside, next to each directory with a
+// ".pre-restore-" suffix, and replaced.
+func Restore(cfg *config.Config, dir string) (*BackupManifest, error) {
+	chain, err := VerifyBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	mf := chain[len(chain)-1]
+	root := filepath.Dir(dir)
+	base := cfg.Storage.BasePath
+	enc := cfg.Storage.Encryption
+	if mf.Encrypted && !enc.Enabled {
+		return nil, errors.New("storage: restore: the backup is encrypted but encryption at rest is disabled")
+	}
+
+	stage := filepath.Join(base, ".restore")
+	if err := os.RemoveAll(stage); err != nil {
+		return nil, fmt.Errorf("storage: restore: %w", err)
+	}
+	defer os.RemoveAll(stage)
+	from := func(f BackupFile) string {
+		holder := f.Backup
+		if holder == "" {
+			holder = mf.ID
+		}
+		p, _ := backupPath(root, holder, f.Path) // validated by VerifyBackup
+		return p
+	}
+
+	// Unlock the backup's master key with the configured secret.
+	var kr *keyring.Keyring
+	key := func(string) []byte { return nil }
+	for _, f := range mf.Files {
+		if f.Path == backupKeyFile {
+			if err := copyFile(from(f), filepath.Join(stage, "keys", "master.json")); err != nil {
+				return nil, fmt.Errorf("storage: restore: %w", err)
+			}
+		}
+	}
+	if mf.Encrypted {
+		if kr, err = unlock(stage, enc); err != nil {
+			return nil, fmt.Errorf("storage: restore: unlock the backup's master key: %w", err)
+		}
+		key = kr.Key
+	}
+
+	warm, cold := tierDirs(cfg)
+	targets := map[string]string{
+		"badger": filepath.Join(base, "badger"),
+		"bluge":  filepath.Join(base, "bluge"),
+		"sqlite": filepath.Join(base, "sqlite"),
+		"warm":   warm,
+		"cold":   cold,
+	}
+	if mf.Encrypted {
+		targets["keys"] = filepath.Join(base, "keys")
+	}
+	for name := range targets {
+		if err := os.MkdirAll(filepath.Join(stage, name), 0o700); err != nil {
+			return nil, fmt.Errorf("storage: restore: %w", err)
+		}
+	}
+
+	sqlitePath := filepath.Join(stage, "sqlite", "oblivra.db")
+	for _, f := range mf.Files {
+		var dst string
+		switch {
+		case f.Path == backupSQLiteFile:
+			dst = sqlitePath
+			if mf.Encrypted {
+				dst += sqlitestore.EncryptedSuffix
+			}
+		case strings.HasPrefix(f.Path, backupBlugeDir+"/"):
+			dst = filepath.Join(stage, "bluge", filepath.FromSlash(strings.TrimPrefix(f.Path, backupBlugeDir+"/")))
+		case strings.HasPrefix(f.Path, backupArchiveDir+"/"):
+			rest := strings.TrimPrefix(f.Path, backupArchiveDir+"/")
+			tier, rel, _ := strings.Cut(rest, "/")
+			if _, ok := targets[tier]; !ok || (tier != "warm" && tier != "cold") {
+				return nil, fmt.Errorf("storage: restore: unknown archive tier in %s", f.Path)
+			}
+			dst = filepath.Join(stage, tier, filepath.FromSlash(rel))
+		default:
+			continue
+		}
+		if err := copyFile(from(f), dst); err != nil {
+			return nil, fmt.Errorf("storage: restore: %w", err)
+		}
+	}
+
+	if err := checkRestoredSQLite(sqlitePath, key(keyring.PurposeSQLite), mf); err != nil {
+		return nil, fmt.Errorf("storage: restore: %w", err)
+	}
+
+	rotation := time.Duration(enc.KeyRotationDays) * day
+	bstore, err := badgerstore.OpenEncrypted(filepath.Join(stage, "badger", "hot"), key(keyring.PurposeBadger), rotation)
+	if err != nil {
+		return nil, fmt.Errorf("storage: restore: %w", err)
+	}
+	for _, m := range chain {
+		p, _ := backupPath(root, m.ID, backupBadgerFile)
+		if err := loadBadgerBackup(bstore, p, kr); err != nil {
+			_ = bstore.Close()
+			return nil, fmt.Errorf("storage: restore: badger backup of %s: %w", m.ID, err)
+		}
+	}
+	if err := bstore.Close(); err != nil {
+		return nil, fmt.Errorf("storage: restore: %w", err)
+	}
+
+	// Everything is staged and checked: swap it in.
+	aside := ".pre-restore-" + time.Now().UTC().Format("20060102T150405Z")
+	for name, target := range targets {
+		if _, err := os.Stat(target); err == nil {
+			if err := os.Rename(target, target+aside); err != nil {
+				return nil, fmt.Errorf("storage: restore: move %s aside: %w", target, err)
+			}
+		}
+		if err := moveDir(filepath.Join(stage, name), target); err != nil {
+			return nil, fmt.Errorf("storage: restore: %w", err)
+		}
+	}
+	log.Printf("storage: restored backup %s; previous data kept with suffix %s", mf.ID, aside)
+	return mf, nil
+}
+
+// checkRestoredSQLite opens the staged database and checks that it holds
+// the backup's last integrity block.
+func checkRestoredSQLite(path string, key []byte, mf *BackupManifest) error {
+	var db *sqlitestore.DB
+	var err error
+	if key != nil {
+		db, err = sqlitestore.OpenEncrypted(path, key)
+	} else {
+		db, err = sqlitestore.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if mf.LastBlock == 0 {
+		return nil
+	}
+	b, err := db.GetIntegrityBlock(mf.LastBlock)
+	if err != nil {
+		return err
+	}
+	if b == nil || hex.EncodeToString(b.RootHash) != mf.LastBlockHash {
+		return fmt.Errorf("restored database does not hold integrity block %d from the manifest", mf.LastBlock)
+	}
+	return nil
+}
+
+// loadBadgerBackup loads one badger.bak into store.
+func loadBadgerBackup(store *badgerstore.Store, path string, kr *keyring.Keyring) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if kr != nil {
+		if r, err = keyring.NewReader(f, kr.Key(keyring.PurposeBackup)); err != nil {
+			return err
+		}
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return store.Load(zr)
+}
+
+// ─── Files ───────────────────────────────────────────────────────────────────
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeFileSync(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyFile copies src to dst, creating dst's directory.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// linkTree hard-links every file under src into dst, copying where links
+// are not possible. Only immutable files may be linked.
+func linkTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o700)
+		}
+		if os.Link(p, target) == nil {
+			return nil
+		}
+		return copyFile(p, target)
+	})
+}
+
+// moveDir renames src to dst, copying when they are on different
+// filesystems.
+func moveDir(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+	if err := linkTree(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
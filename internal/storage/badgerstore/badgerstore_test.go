@@ -1,11 +1,14 @@
 package badgerstore_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
 	"time"
 
+	badger "github.com/dgraph-io/badger/v4"
 	"github.com/google/uuid"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/badgerstore"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
@@ -87,6 +90,82 @@ func TestPutEventBatch(t *testing.T) {
 	}
 }
 
+func TestGetEventsPreservesOrder(t *testing.T) {
+	s, cleanup := tmpStore(t)
+	defer cleanup()
+
+	base := time.Now()
+	events := make([]*models.Event, 5)
+	for i := range events {
+		events[i] = makeEvent(base.Add(time.Duration(i) * time.Second))
+	}
+	if err := s.PutEventBatch(events); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ask in reverse-chronological order (as Bluge returns them) with a
+	// missing ID in the middle.
+	ids := []string{events[4].ID, events[1].ID, "does-not-exist", events[3].ID}
+	got, err := s.GetEvents(ids)
+	if err != nil {
+		t.Fatalf("GetEvents: %v", err)
+	}
+	want := []string{events[4].ID, events[1].ID, events[3].ID}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i, ev := range got {
+		if ev.ID != want[i] {
+			t.Errorf("result %d: got %s, want %s", i, ev.ID, want[i])
+		}
+	}
+}
+
+func TestIDIndexBackfill(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-legacy-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Write events the way stores did before the id: index existed.
+	legacy := []*models.Event{makeEvent(time.Now()), makeEvent(time.Now().Add(time.Second))}
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(txn *badger.Txn) error {
+		for _, ev := range legacy {
+			val, _ := json.Marshal(ev)
+			key := fmt.Sprintf("evt:%015d:%s", ev.Timestamp.UnixNano(), ev.ID)
+			if err := txn.Set([]byte(key), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := badgerstore.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+
+	for _, ev := range legacy {
+		got, err := s.GetEvent(ev.ID)
+		if err != nil {
+			t.Fatalf("GetEvent after backfill: %v", err)
+		}
+		if got.Message != ev.Message {
+			t.Errorf("got message %q, want %q", got.Message, ev.Message)
+		}
+	}
+}
+
 func TestQueryTimeRange(t *testing.T) {
 	s, cleanup := tmpStore(t)
 	defer cleanup()
@@ -116,8 +195,12 @@ func TestDeleteOlderThan(t *testing.T) {
 	defer cleanup()
 
 	base := time.Now().Add(-10 * 24 * time.Hour) // 10 days ago
-	for i := 0; i < 5; i++ {
-		// 5 old events (8-12 days ago)
+	old := makeEvent(base)
+	if err := s.PutEvent(old); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 5; i++ {
+		// 4 more old events (11-14 days ago)
 		if err := s.PutEvent(makeEvent(base.Add(-time.Duration(i)*24*time.Hour))); err != nil {
 			t.Fatal(err)
 		}
@@ -137,6 +220,9 @@ func TestDeleteOlderThan(t *testing.T) {
 	if deleted != 5 {
 		t.Errorf("deleted %d, want 5", deleted)
 	}
+	if _, err := s.GetEvent(old.ID); err == nil {
+		t.Error("purged event still reachable through the id index")
+	}
 
 	// Recent events should still be there
 	recent, err := s.QueryTimeRange(time.Now().Add(-2*time.Hour), time.Now(), 100)
@@ -159,3 +245,110 @@ func TestStats(t *testing.T) {
 	fmt.Printf("BadgerDB stats: LSM=%d VLog=%d\n", stats.LSMBytes, stats.VLogBytes)
 	// Just assert it doesn't panic and returns something
 }
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	key[0] = 1
+	otherKey := make([]byte, 32)
+	otherKey[0] = 2
+
+	// Start from a plaintext store, as before encryption was enabled.
+	plain, err := badgerstore.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := makeEvent(time.Now().Add(-time.Hour))
+	if err := plain.PutEvent(old); err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+
+	s, err := badgerstore.OpenEncrypted(dir, key, time.Hour)
+	if err != nil {
+		t.Fatalf("enable encryption: %v", err)
+	}
+	ev := makeEvent(time.Now())
+	if err := s.PutEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []*models.Event{old, ev} {
+		if got, err := s.GetEvent(want.ID); err != nil || got.ID != want.ID {
+			t.Fatalf("GetEvent(%s) = %v, %v", want.ID, got, err)
+		}
+	}
+	s.Close()
+
+	if _, err := badgerstore.Open(dir); err == nil {
+		t.Fatal("encrypted store opened without a key")
+	}
+	if _, err := badgerstore.OpenEncrypted(dir, otherKey, 0); err == nil {
+		t.Fatal("encrypted store opened with the wrong key")
+	}
+
+	if err := badgerstore.RotateKey(dir, key, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	s, err = badgerstore.OpenEncrypted(dir, otherKey, 0)
+	if err != nil {
+		t.Fatalf("open after rotation: %v", err)
+	}
+	defer s.Close()
+	if got, err := s.GetEvent(old.ID); err != nil || got.ID != old.ID {
+		t.Fatalf("GetEvent after rotation = %v, %v", got, err)
+	}
+}
+
+func TestBackupAtFence(t *testing.T) {
+	s, cleanup := tmpStore(t)
+	defer cleanup()
+	kept, deleted := makeEvent(time.Now()), makeEvent(time.Now())
+	if err := s.PutEventBatch([]*models.Event{kept, deleted}); err != nil {
+		t.Fatal(err)
+	}
+	fence, release := s.Pin()
+	defer release()
+
+	// Changes after the fence belong to the next backup.
+	if n, err := s.DeleteEvents([]string{deleted.ID}); err != nil || n != 1 {
+		t.Fatalf("DeleteEvents = %d, %v", n, err)
+	}
+	late := makeEvent(time.Now())
+	if err := s.PutEvent(late); err != nil {
+		t.Fatal(err)
+	}
+	var full bytes.Buffer
+	if err := s.Backup(&full, 0, fence); err != nil {
+		t.Fatal(err)
+	}
+	next, release2 := s.Pin()
+	defer release2()
+	var incr bytes.Buffer
+	if err := s.Backup(&incr, fence+1, next); err != nil {
+		t.Fatal(err)
+	}
+
+	r, cleanupR := tmpStore(t)
+	defer cleanupR()
+	if err := r.Load(&full); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []*models.Event{kept, deleted} {
+		if _, err := r.GetEvent(ev.ID); err != nil {
+			t.Errorf("event in place at the fence not restored: %v", err)
+		}
+	}
+	if _, err := r.GetEvent(late.ID); err == nil {
+		t.Error("event written after the fence restored")
+	}
+
+	if err := r.Load(&incr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetEvent(deleted.ID); err == nil {
+		t.Error("incremental backup did not carry the delete")
+	}
+	if _, err := r.GetEvent(late.ID); err != nil {
+		t.Errorf("incremental backup missing the late event: %v", err)
+	}
+}
@@ -0,0 +1,470 @@
+package blugeindex
+
+// aggregate.go computes facets, time histograms and numeric statistics over
+// the documents matching a Query, using the doc values stored with each
+// sortable/aggregatable field.
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/aggregations"
+)
+
+const (
+	defaultFacetSize     = 10
+	defaultHistogramBars = 60
+	maxHistogramBuckets  = 1000
+	timestampField       = "timestamp"
+	aggNameTimeBounds    = "time_bounds"
+	aggNameHistogram     = "histogram"
+	aggPrefixTerms       = "terms:"
+	aggPrefixCardinality = "card:"
+	aggPrefixStats       = "stats:"
+)
+
+// histogramIntervals are the "nice" intervals auto-selection picks from.
+var histogramIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+// AggRequest lists the aggregations to compute. Field names are the same as
+// in FieldFilter: fixed attributes (host, source, user, …) or dynamic fields.
+type AggRequest struct {
+	Terms       []TermsAgg    `json:"terms"`       // top values per field
+	Histogram   *HistogramAgg `json:"histogram"`   // event counts over time
+	Cardinality []string      `json:"cardinality"` // approximate distinct counts
+	Stats       []StatsAgg    `json:"stats"`       // numeric field statistics
+}
+
+// TermsAgg requests the top Size values of Field (0 → 10).
+type TermsAgg struct {
+	Field string `json:"field"`
+	Size  int    `json:"size"`
+}
+
+// HistogramAgg requests event counts per time bucket. With Interval zero an
+// interval is chosen so that at most MaxBuckets (0 → 60) buckets are returned.
+type HistogramAgg struct {
+	Interval   time.Duration `json:"interval"`
+	MaxBuckets int           `json:"max_buckets"`
+}
+
+// StatsAgg requests min/max/avg/sum and percentiles (0-100) of a numeric
+// field. Percentiles defaults to 50, 95 and 99.
+type StatsAgg struct {
+	Field       string    `json:"field"`
+	Percentiles []float64 `json:"percentiles"`
+}
+
+// AggResult holds the computed aggregations keyed by field name.
+type AggResult struct {
+	Total       uint64                  `json:"total"`
+	Terms       map[string]*TermsResult `json:"terms,omitempty"`
+	Histogram   *HistogramResult        `json:"histogram,omitempty"`
+	Cardinality map[string]uint64       `json:"cardinality,omitempty"`
+	Stats       map[string]*StatsResult `json:"stats,omitempty"`
+}
+
+// TermsResult is a facet: the most frequent values of a field.
+type TermsResult struct {
+	Buckets []TermBucket `json:"buckets"`
+	Other   int          `json:"other"` // matches whose value fell outside the top N
+}
+
+// TermBucket is one facet value.
+type TermBucket struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// HistogramResult is a date histogram.
+type HistogramResult struct {
+	Interval time.Duration     `json:"interval"`
+	Buckets  []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket counts events in [Start, Start+Interval).
+type HistogramBucket struct {
+	Start time.Time `json:"start"`
+	Count uint64    `json:"count"`
+}
+
+// StatsResult summarises a numeric field. Percentiles are keyed by their
+// formatted percentage ("95", "99.9").
+type StatsResult struct {
+	Count       uint64             `json:"count"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Sum         float64            `json:"sum"`
+	Avg         float64            `json:"avg"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+}
+
+// Aggregate computes req over all documents matching q, including those in
+// the given archive segments. q's paging and sort options are ignored.
+func (idx *Index) Aggregate(ctx context.Context, q *Query, req *AggRequest, segments ...*Segment) (*AggResult, error) {
+	bq, err := buildQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := idx.snapshot(q, segments)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	sr := bluge.NewTopNSearch(0, bq).WithStandardAggregations()
+	for _, t := range req.Terms {
+		field, err := keywordField(t.Field)
+		if err != nil {
+			return nil, err
+		}
+		size := t.Size
+		if size <= 0 {
+			size = defaultFacetSize
+		}
+		sr.AddAggregation(aggPrefixTerms+t.Field, aggregations.NewTermsAggregation(uniqueValues(field), size))
+	}
+	for _, name := range req.Cardinality {
+		field, err := keywordField(name)
+		if err != nil {
+			return nil, err
+		}
+		sr.AddAggregation(aggPrefixCardinality+name, aggregations.Cardinality(uniqueValues(field)))
+	}
+	for _, s := range req.Stats {
+		if coreFields[s.Field] || s.Field == "" {
+			return nil, fmt.Errorf("blugeindex: field %q is not numeric", s.Field)
+		}
+		sr.AddAggregation(aggPrefixStats+s.Field, &statsAggregation{src: search.Field(numericPrefix + s.Field)})
+	}
+
+	// Without explicit time bounds the histogram range comes from the data,
+	// which needs a first pass to find the oldest and newest match.
+	start, end := time.Time{}, time.Time{}
+	if q.StartTime > 0 {
+		start = time.Unix(0, q.StartTime)
+	}
+	if q.EndTime > 0 {
+		end = time.Unix(0, q.EndTime)
+	}
+	if req.Histogram != nil && (start.IsZero() || end.IsZero()) {
+		sr.AddAggregation(aggNameTimeBounds, &timeBoundsAggregation{})
+	}
+
+	dmi, err := snap.search(ctx, sr)
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: aggregate: %w", err)
+	}
+	if err := drain(dmi); err != nil {
+		return nil, err
+	}
+	aggs := dmi.Aggregations()
+
+	res := &AggResult{Total: aggs.Count()}
+	if len(req.Terms) > 0 {
+		res.Terms = make(map[string]*TermsResult, len(req.Terms))
+		for _, t := range req.Terms {
+			calc, _ := aggs.Aggregation(aggPrefixTerms + t.Field).(*aggregations.TermsCalculator)
+			tr := &TermsResult{Buckets: []TermBucket{}}
+			if calc != nil {
+				tr.Other = calc.Other()
+				for _, b := range calc.Buckets() {
+					tr.Buckets = append(tr.Buckets, TermBucket{Value: b.Name(), Count: b.Count()})
+				}
+			}
+			res.Terms[t.Field] = tr
+		}
+	}
+	if len(req.Cardinality) > 0 {
+		res.Cardinality = make(map[string]uint64, len(req.Cardinality))
+		for _, name := range req.Cardinality {
+			res.Cardinality[name] = uint64(aggs.Metric(aggPrefixCardinality + name))
+		}
+	}
+	if len(req.Stats) > 0 {
+		res.Stats = make(map[string]*StatsResult, len(req.Stats))
+		for _, s := range req.Stats {
+			res.Stats[s.Field] = statsResult(aggs, s)
+		}
+	}
+
+	if req.Histogram != nil {
+		if tb, ok := aggs.Aggregation(aggNameTimeBounds).(*timeBoundsCalculator); ok {
+			if start.IsZero() {
+				start = tb.min
+			}
+			if end.IsZero() {
+				end = tb.max.Add(time.Nanosecond)
+			}
+		}
+		hist, err := histogram(ctx, snap, bq, req.Histogram, start, end, res.Total)
+		if err != nil {
+			return nil, err
+		}
+		res.Histogram = hist
+	}
+	return res, nil
+}
+
+// histogram runs the date histogram over [start, end).
+func histogram(ctx context.Context, snap *snapshot, q bluge.Query, h *HistogramAgg, start, end time.Time, total uint64) (*HistogramResult, error) {
+	res := &HistogramResult{Buckets: []HistogramBucket{}}
+	if total == 0 || start.IsZero() || !end.After(start) {
+		res.Interval = h.Interval
+		return res, nil
+	}
+
+	interval := h.Interval
+	if interval <= 0 {
+		interval = autoInterval(end.Sub(start), h.MaxBuckets)
+	}
+	start = start.UTC().Truncate(interval)
+	n := int((end.Sub(start) + interval - 1) / interval)
+	if n > maxHistogramBuckets {
+		return nil, fmt.Errorf("blugeindex: histogram interval %s yields %d buckets (max %d)", interval, n, maxHistogramBuckets)
+	}
+	res.Interval = interval
+
+	sr := bluge.NewTopNSearch(0, q)
+	sr.AddAggregation(aggNameHistogram, &histogramAggregation{start: start, interval: interval, n: n})
+	dmi, err := snap.search(ctx, sr)
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: histogram: %w", err)
+	}
+	if err := drain(dmi); err != nil {
+		return nil, err
+	}
+	calc, _ := dmi.Aggregations().Aggregation(aggNameHistogram).(*histogramCalculator)
+	for i := 0; i < n; i++ {
+		var c uint64
+		if calc != nil {
+			c = calc.counts[i]
+		}
+		res.Buckets = append(res.Buckets, HistogramBucket{Start: start.Add(time.Duration(i) * interval), Count: c})
+	}
+	return res, nil
+}
+
+// autoInterval picks the smallest nice interval that covers span in at most
+// maxBuckets buckets.
+func autoInterval(span time.Duration, maxBuckets int) time.Duration {
+	if maxBuckets <= 0 {
+		maxBuckets = defaultHistogramBars
+	}
+	for _, iv := range histogramIntervals {
+		if span/iv < time.Duration(maxBuckets) {
+			return iv
+		}
+	}
+	return histogramIntervals[len(histogramIntervals)-1]
+}
+
+func statsResult(aggs *search.Bucket, s StatsAgg) *StatsResult {
+	out := &StatsResult{}
+	sc, _ := aggs.Aggregation(aggPrefixStats + s.Field).(*statsCalculator)
+	if sc == nil || sc.count == 0 {
+		return out
+	}
+	out.Count, out.Min, out.Max, out.Sum = sc.count, sc.min, sc.max, sc.sum
+	out.Avg = sc.sum / float64(sc.count)
+
+	pcts := s.Percentiles
+	if len(pcts) == 0 {
+		pcts = []float64{50, 95, 99}
+	}
+	out.Percentiles = make(map[string]float64, len(pcts))
+	for _, p := range pcts {
+		if v, err := sc.quantiles.Quantile(p / 100); err == nil && !math.IsNaN(v) {
+			out.Percentiles[strconv.FormatFloat(p, 'f', -1, 64)] = v
+		}
+	}
+	return out
+}
+
+// keywordField maps a user-facing field name to its keyword index field.
+func keywordField(name string) (string, error) {
+	switch {
+	case name == "" || name == timestampField || name == "message":
+		return "", fmt.Errorf("blugeindex: cannot aggregate on %q", name)
+	case coreFields[name]:
+		return name, nil
+	}
+	return keywordPrefix + name, nil
+}
+
+func drain(dmi search.DocumentMatchIterator) error {
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return fmt.Errorf("blugeindex: iterate: %w", err)
+	}
+	return nil
+}
+
+// ─── custom calculators ──────────────────────────────────────────────────────
+
+// Bluge loads a field's doc values once per aggregation that names it, so
+// two aggregations over the same field would see every value twice. Text
+// sources are therefore de-duplicated per document, and the numeric stats
+// are computed by a single aggregation that wraps the percentile sketch.
+
+// uniqueValues is a TextValuesSource yielding each distinct non-empty value
+// once (unset fixed attributes such as user are indexed as "").
+type uniqueValues string
+
+func (f uniqueValues) Fields() []string { return []string{string(f)} }
+func (f uniqueValues) Values(d *search.DocumentMatch) [][]byte {
+	vals := d.DocValues(string(f))
+	out := vals[:0:0]
+	for i, v := range vals {
+		if len(v) == 0 {
+			continue
+		}
+		dup := false
+		for _, prev := range vals[:i] {
+			if string(prev) == string(v) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// statsAggregation computes count/min/max/sum and percentiles in one pass;
+// unlike the stock Min/Max metrics it reports an empty field as zero, not ±Inf.
+type statsAggregation struct {
+	src search.NumericValuesSource
+}
+
+func (a *statsAggregation) Fields() []string { return a.src.Fields() }
+func (a *statsAggregation) Calculator() search.Calculator {
+	return &statsCalculator{
+		src:       a.src,
+		min:       math.Inf(1),
+		max:       math.Inf(-1),
+		quantiles: aggregations.Quantiles(a.src).Calculator().(*aggregations.QuantilesCalculator),
+	}
+}
+
+type statsCalculator struct {
+	src       search.NumericValuesSource
+	count     uint64
+	min, max  float64
+	sum       float64
+	quantiles *aggregations.QuantilesCalculator
+}
+
+func (c *statsCalculator) Consume(d *search.DocumentMatch) {
+	for _, v := range c.src.Numbers(d) {
+		c.count++
+		c.sum += v
+		c.min = math.Min(c.min, v)
+		c.max = math.Max(c.max, v)
+	}
+	c.quantiles.Consume(d)
+}
+
+func (c *statsCalculator) Merge(other search.Calculator) {
+	if o, ok := other.(*statsCalculator); ok {
+		c.count += o.count
+		c.sum += o.sum
+		c.min = math.Min(c.min, o.min)
+		c.max = math.Max(c.max, o.max)
+		c.quantiles.Merge(o.quantiles)
+	}
+}
+
+func (c *statsCalculator) Finish() {}
+
+// timeBoundsAggregation finds the oldest and newest timestamp.
+type timeBoundsAggregation struct{}
+
+func (timeBoundsAggregation) Fields() []string { return []string{timestampField} }
+func (timeBoundsAggregation) Calculator() search.Calculator {
+	return &timeBoundsCalculator{}
+}
+
+type timeBoundsCalculator struct {
+	min, max time.Time
+}
+
+func (c *timeBoundsCalculator) Consume(d *search.DocumentMatch) {
+	for _, t := range search.Field(timestampField).Dates(d) {
+		c.observe(t, t)
+	}
+}
+
+func (c *timeBoundsCalculator) observe(lo, hi time.Time) {
+	if lo.IsZero() {
+		return
+	}
+	if c.min.IsZero() || lo.Before(c.min) {
+		c.min = lo
+	}
+	if hi.After(c.max) {
+		c.max = hi
+	}
+}
+
+func (c *timeBoundsCalculator) Merge(other search.Calculator) {
+	if o, ok := other.(*timeBoundsCalculator); ok {
+		c.observe(o.min, o.max)
+	}
+}
+
+func (c *timeBoundsCalculator) Finish() {}
+
+// histogramAggregation counts matches in fixed-width time buckets.
+type histogramAggregation struct {
+	start    time.Time
+	interval time.Duration
+	n        int
+}
+
+func (a *histogramAggregation) Fields() []string { return []string{timestampField} }
+func (a *histogramAggregation) Calculator() search.Calculator {
+	return &histogramCalculator{agg: a, counts: make([]uint64, a.n)}
+}
+
+type histogramCalculator struct {
+	agg    *histogramAggregation
+	counts []uint64
+}
+
+func (c *histogramCalculator) Consume(d *search.DocumentMatch) {
+	for _, t := range search.Field(timestampField).Dates(d) {
+		if t.Before(c.agg.start) {
+			continue
+		}
+		if i := int(t.Sub(c.agg.start) / c.agg.interval); i < c.agg.n {
+			c.counts[i]++
+		}
+	}
+}
+
+func (c *histogramCalculator) Merge(other search.Calculator) {
+	if o, ok := other.(*histogramCalculator); ok {
+		for i := range c.counts {
+			c.counts[i] += o.counts[i]
+		}
+	}
+}
+
+func (c *histogramCalculator) Finish() {}
@@ -1,11 +1,13 @@
 // Package blugeindex wraps the Bluge full-text search engine for OBLIVRA.
-// It indexes the searchable fields of each Event and exposes a query API
-// that returns matching event IDs (the full payloads live in BadgerDB).
+// It indexes the searchable fields of each Event — the fixed attributes plus
+// typed dynamic Fields/Metadata (see fields.go) — and exposes a query API
+// that returns matching event IDs (the full payloads live in BadgerDB). The
+// live index is split into time partitions, see partition.go.
 package blugeindex
 
 import (
-	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/blugelabs/bluge"
@@ -22,47 +24,66 @@ type Query struct {
 	Source    string // exact keyword filter
 	Host      string // exact keyword filter
 	Severity  string // exact keyword filter
+	Tenant    string // restrict to one tenant ("" = all tenants)
 	StartTime int64  // unix nano lower bound (0 = no bound)
 	EndTime   int64  // unix nano upper bound (0 = no bound)
 	Limit     int    // max results (0 → defaultSearchLimit)
+	Fields    []FieldFilter
+	Cond      *Cond // arbitrary boolean filter, ANDed with the fields above
+
+	// Sort lists sort fields, "-" prefixed for descending (default
+	// "-timestamp"). See sortOrder for the accepted names.
+	Sort []string
+	// After is the Result.Next cursor of the previous page.
+	After string
+	// EstimateTotal skips the exact hit count; Result.Total is then only a
+	// lower bound.
+	EstimateTotal bool
 }
 
-// Index wraps a Bluge writer/reader pair.
+// Index is the live index, split into time partitions (see partition.go).
 type Index struct {
-	writer *bluge.Writer
+	fields   *fieldPolicy
+	path     string
+	key      []byte
+	interval time.Duration
+
+	mu    sync.RWMutex // guards parts; held exclusively to add or drop one
+	parts []*partition // sorted by start, non-overlapping
+
+	freeze sync.RWMutex // held exclusively by Snapshot, see snapshot.go
 }
 
-// Open creates the on-disk Bluge index at path.
+// Open creates the on-disk Bluge index at path with the default field policy.
 func Open(path string) (*Index, error) {
-	cfg := bluge.DefaultConfig(path)
-	w, err := bluge.OpenWriter(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("blugeindex: open writer: %w", err)
-	}
-	return &Index{writer: w}, nil
+	return OpenWithFields(path, FieldConfig{})
+}
+
+// OpenWithFields creates the on-disk Bluge index at path, indexing dynamic
+// fields according to fc.
+func OpenWithFields(path string, fc FieldConfig) (*Index, error) {
+	return OpenEncrypted(path, fc, nil)
 }
 
-// IndexEvent adds a single event's searchable fields to the index.
-// Fields indexed:
+// OpenEncrypted is OpenWithFields with the index files encrypted with key;
+// a nil key leaves them in plaintext.
+func OpenEncrypted(path string, fc FieldConfig, key []byte) (*Index, error) {
+	return OpenPartitioned(path, fc, key, 0)
+}
+
+// IndexEvent adds a single event's searchable fields to the partition
+// holding its timestamp. Fields indexed:
 //   - _id       (keyword) – document identifier
 //   - message   (text)    – full-text search
 //   - source    (keyword) – exact match filter
-//   - host      (keyword) – exact match filter
+//   - host      (keyword) – exact match filter (plus ip.host when an address)
 //   - user      (keyword) – exact match filter
 //   - severity  (keyword) – exact match filter
 //   - category  (keyword) – exact match filter
 //   - timestamp (date)    – range queries
+//   - Fields / Metadata   – typed dynamic fields, see fields.go
 func (idx *Index) IndexEvent(ev *models.Event) error {
-	doc := bluge.NewDocument(ev.ID).
-		AddField(bluge.NewTextField("message", ev.Message).StoreValue()).
-		AddField(bluge.NewKeywordField("source", ev.Source).StoreValue()).
-		AddField(bluge.NewKeywordField("host", ev.Host).StoreValue()).
-		AddField(bluge.NewKeywordField("user", ev.User).StoreValue()).
-		AddField(bluge.NewKeywordField("severity", string(ev.Severity)).StoreValue()).
-		AddField(bluge.NewKeywordField("category", ev.Category).StoreValue()).
-		AddField(bluge.NewDateTimeField("timestamp", ev.Timestamp).StoreValue())
-
-	return idx.writer.Update(&customTermQuery{id: ev.ID, field: "_id"}, doc)
+	return idx.IndexEventBatch([]*models.Event{ev})
 }
 
 type customTermQuery struct {
@@ -73,30 +94,84 @@ type customTermQuery struct {
 func (q *customTermQuery) Field() string { return q.field }
 func (q *customTermQuery) Term() []byte  { return []byte(q.id) }
 
-// IndexEventBatch indexes multiple events in one batch commit.
+// IndexEventBatch indexes multiple events with one batch commit per
+// partition they fall into.
 func (idx *Index) IndexEventBatch(events []*models.Event) error {
-	batch := bluge.NewBatch()
-	for _, ev := range events {
-		doc := bluge.NewDocument(ev.ID).
-			AddField(bluge.NewTextField("message", ev.Message).StoreValue()).
-			AddField(bluge.NewKeywordField("source", ev.Source).StoreValue()).
-			AddField(bluge.NewKeywordField("host", ev.Host).StoreValue()).
-			AddField(bluge.NewKeywordField("user", ev.User).StoreValue()).
-			AddField(bluge.NewKeywordField("severity", string(ev.Severity)).StoreValue()).
-			AddField(bluge.NewKeywordField("category", ev.Category).StoreValue()).
-			AddField(bluge.NewDateTimeField("timestamp", ev.Timestamp).StoreValue())
-		batch.Update(&customTermQuery{id: ev.ID, field: "_id"}, doc)
+	for {
+		idx.mu.RLock()
+		groups, ok := idx.group(events)
+		if ok {
+			err := idx.writeGroups(groups)
+			idx.mu.RUnlock()
+			return err
+		}
+		idx.mu.RUnlock()
+		if err := idx.addPartitions(events); err != nil {
+			return err
+		}
 	}
-	return idx.writer.Batch(batch)
+}
+
+func (idx *Index) writeGroups(groups map[*partition][]*models.Event) error {
+	for p, evs := range groups {
+		batch := bluge.NewBatch()
+		for _, ev := range evs {
+			batch.Update(&customTermQuery{id: ev.ID, field: "_id"}, idx.buildDocument(ev))
+		}
+		if err := p.writer.Batch(batch); err != nil {
+			return fmt.Errorf("blugeindex: partition %s: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+func (idx *Index) buildDocument(ev *models.Event) *bluge.Document {
+	doc := bluge.NewDocument(ev.ID).
+		AddField(bluge.NewTextField("message", ev.Message).StoreValue().SearchTermPositions()).
+		AddField(bluge.NewKeywordField("source", ev.Source).StoreValue().Sortable()).
+		AddField(bluge.NewKeywordField("host", ev.Host).StoreValue().Sortable()).
+		AddField(bluge.NewKeywordField("user", ev.User).StoreValue().Sortable()).
+		AddField(bluge.NewKeywordField("severity", string(ev.Severity)).StoreValue().Sortable()).
+		AddField(bluge.NewKeywordField("category", ev.Category).StoreValue().Sortable()).
+		AddField(bluge.NewDateTimeField("timestamp", ev.Timestamp).StoreValue().Sortable()).
+		AddField(bluge.NewKeywordField("tenant", models.TenantOrDefault(ev.Tenant)).StoreValue())
+	if key, ok := ipKey(ev.Host); ok {
+		doc.AddField(bluge.NewKeywordField(ipPrefix+"host", key))
+	}
+	idx.fields.addDynamicFields(doc, ev.Fields, ev.Metadata)
+	return doc
+}
+
+// CappedFields returns the dynamic fields that reached their cardinality cap
+// since startup; new values of these fields are no longer indexed as terms.
+func (idx *Index) CappedFields() []string {
+	return idx.fields.cappedFields()
 }
 
 // Search executes a Query and returns the matching event IDs.
 func (idx *Index) Search(q *Query) ([]string, error) {
-	limit := q.Limit
-	if limit <= 0 {
-		limit = defaultSearchLimit
+	res, err := idx.SearchPage(q)
+	if err != nil {
+		return nil, err
 	}
+	return res.IDs, nil
+}
 
+// tenantQuery matches the events of tenant. Documents indexed before
+// tenants existed have no tenant field and belong to the default tenant.
+func tenantQuery(tenant string) bluge.Query {
+	q := bluge.NewTermQuery(tenant).SetField("tenant")
+	if tenant != models.DefaultTenant {
+		return q
+	}
+	untagged := bluge.NewBooleanQuery().
+		AddMust(bluge.NewMatchAllQuery()).
+		AddMustNot(bluge.NewWildcardQuery("?*").SetField("tenant"))
+	return bluge.NewBooleanQuery().AddShould(q, untagged)
+}
+
+// buildQuery translates a Query's filters into a Bluge query.
+func buildQuery(q *Query) (bluge.Query, error) {
 	var clauses []bluge.Query
 
 	if q.Text != "" {
@@ -111,6 +186,9 @@ func (idx *Index) Search(q *Query) ([]string, error) {
 	if q.Severity != "" {
 		clauses = append(clauses, bluge.NewTermQuery(q.Severity).SetField("severity"))
 	}
+	if q.Tenant != "" {
+		clauses = append(clauses, tenantQuery(q.Tenant))
+	}
 	if q.StartTime > 0 || q.EndTime > 0 {
 		var start, end time.Time
 		if q.StartTime > 0 {
@@ -123,60 +201,85 @@ func (idx *Index) Search(q *Query) ([]string, error) {
 		}
 		clauses = append(clauses, bluge.NewDateRangeQuery(start, end).SetField("timestamp"))
 	}
-
-	var finalQuery bluge.Query
-	switch len(clauses) {
-	case 0:
-		finalQuery = bluge.NewMatchAllQuery()
-	case 1:
-		finalQuery = clauses[0]
-	default:
-		bq := bluge.NewBooleanQuery()
-		for _, c := range clauses {
-			bq.AddMust(c)
+	if q.Cond != nil {
+		cq, err := condQuery(q.Cond)
+		if err != nil {
+			return nil, err
 		}
-		finalQuery = bq
+		clauses = append(clauses, cq)
 	}
-
-	reader, err := idx.writer.Reader()
-	if err != nil {
-		return nil, fmt.Errorf("blugeindex: open reader: %w", err)
+	var negated []bluge.Query
+	for _, f := range q.Fields {
+		fq, negate, err := filterQuery(f)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			negated = append(negated, fq)
+		} else {
+			clauses = append(clauses, fq)
+		}
 	}
-	defer reader.Close()
 
-	req := bluge.NewTopNSearch(limit, finalQuery).
-		SortBy([]string{"-timestamp"}).
-		WithStandardAggregations()
-
-	dmi, err := reader.Search(context.Background(), req)
-	if err != nil {
-		return nil, fmt.Errorf("blugeindex: search: %w", err)
+	switch {
+	case len(clauses) == 0 && len(negated) == 0:
+		return bluge.NewMatchAllQuery(), nil
+	case len(clauses) == 1 && len(negated) == 0:
+		return clauses[0], nil
 	}
-
-	var ids []string
-	next, err := dmi.Next()
-	for err == nil && next != nil {
-		next.VisitStoredFields(func(field string, value []byte) bool {
-			if field == "_id" {
-				ids = append(ids, string(value))
-			}
-			return true
-		})
-		next, err = dmi.Next()
+	bq := bluge.NewBooleanQuery()
+	for _, c := range clauses {
+		bq.AddMust(c)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("blugeindex: iterate: %w", err)
+	for _, c := range negated {
+		bq.AddMustNot(c)
 	}
-
-	return ids, nil
+	return bq, nil
 }
 
 // DeleteEvent removes a document by event ID.
 func (idx *Index) DeleteEvent(id string) error {
-	return idx.writer.Delete(&customTermQuery{id: id, field: "_id"})
+	return idx.DeleteEvents([]string{id})
+}
+
+// DeleteEvents removes the documents of several events, looking for them in
+// every partition. DeleteEventsBetween is cheaper when their time span is
+// known.
+func (idx *Index) DeleteEvents(ids []string) error {
+	return idx.DeleteEventsBetween(time.Time{}, time.Time{}, ids)
+}
+
+// DeleteEventsBetween removes the documents of events timestamped in
+// [start, end) in one batch per partition overlapping the span; a zero
+// bound leaves that side open.
+func (idx *Index) DeleteEventsBetween(start, end time.Time, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, p := range idx.overlapping(start, end) {
+		batch := bluge.NewBatch()
+		for _, id := range ids {
+			batch.Delete(&customTermQuery{id: id, field: "_id"})
+		}
+		if err := p.writer.Batch(batch); err != nil {
+			return fmt.Errorf("blugeindex: partition %s: %w", p.name, err)
+		}
+	}
+	return nil
 }
 
-// Close commits pending writes and closes the writer.
+// Close commits pending writes and closes every partition.
 func (idx *Index) Close() error {
-	return idx.writer.Close()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var first error
+	for _, p := range idx.parts {
+		if err := p.writer.Close(); err != nil && first == nil {
+			first = fmt.Errorf("blugeindex: close partition %s: %w", p.name, err)
+		}
+	}
+	idx.parts = nil
+	return first
 }
@@ -1,7 +1,13 @@
 package blugeindex_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -185,3 +191,557 @@ func TestBatchIndex(t *testing.T) {
 		t.Errorf("expected %d results, got %d", n, len(got))
 	}
 }
+
+func fieldEvent(fields map[string]interface{}, meta map[string]string) *models.Event {
+	ev := makeEvent(uuid.NewString(), "firewall", "10.1.2.3", "INFO", "connection", time.Now())
+	ev.Fields = fields
+	ev.Metadata = meta
+	return ev
+}
+
+func TestSearch_DynamicFields(t *testing.T) {
+	idx, cleanup := tmpIndex(t)
+	defer cleanup()
+
+	web := fieldEvent(map[string]interface{}{"dst_port": 443, "dst_ip": "192.168.1.20", "action": "allow"}, map[string]string{"geo_country": "LY"})
+	ssh := fieldEvent(map[string]interface{}{"dst_port": 22, "dst_ip": "10.0.0.9", "action": "deny"}, nil)
+	bare := fieldEvent(nil, nil)
+	if err := idx.IndexEventBatch([]*models.Event{web, ssh, bare}); err != nil {
+		t.Fatalf("IndexEventBatch: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		filter blugeindex.FieldFilter
+		want   []string
+	}{
+		{"keyword", blugeindex.FieldFilter{Field: "action", Value: "deny"}, []string{ssh.ID}},
+		{"number as term", blugeindex.FieldFilter{Field: "dst_port", Value: "443"}, []string{web.ID}},
+		{"metadata", blugeindex.FieldFilter{Field: "geo_country", Value: "LY"}, []string{web.ID}},
+		{"range", blugeindex.FieldFilter{Field: "dst_port", Op: "gte", Value: "100"}, []string{web.ID}},
+		{"cidr", blugeindex.FieldFilter{Field: "dst_ip", Op: "cidr", Value: "10.0.0.0/8"}, []string{ssh.ID}},
+		{"core cidr", blugeindex.FieldFilter{Field: "host", Op: "cidr", Value: "10.1.0.0/16"}, []string{web.ID, ssh.ID, bare.ID}},
+		{"prefix", blugeindex.FieldFilter{Field: "action", Op: "prefix", Value: "al"}, []string{web.ID}},
+		{"exists", blugeindex.FieldFilter{Field: "dst_port", Op: "exists"}, []string{web.ID, ssh.ID}},
+		{"ne", blugeindex.FieldFilter{Field: "action", Op: "ne", Value: "deny"}, []string{web.ID, bare.ID}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ids, err := idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{tc.filter}})
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if !sameIDs(ids, tc.want) {
+				t.Errorf("got %v, want %v", ids, tc.want)
+			}
+		})
+	}
+
+	if _, err := idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{{Field: "dst_port", Op: "gt", Value: "abc"}}}); err == nil {
+		t.Error("expected error for non-numeric range value")
+	}
+	if _, err := idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{{Field: "x", Op: "regex", Value: "."}}}); err == nil {
+		t.Error("expected error for unknown operator")
+	}
+}
+
+func TestFieldCardinalityCap(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := blugeindex.OpenWithFields(dir, blugeindex.FieldConfig{
+		Rules: map[string]blugeindex.FieldRule{
+			"request_id": {MaxCardinality: 2},
+			"secret":     {Type: blugeindex.FieldTypeNone},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	var evs []*models.Event
+	for _, rid := range []string{"r1", "r2", "r3"} {
+		evs = append(evs, fieldEvent(map[string]interface{}{"request_id": rid, "secret": "hunter2"}, nil))
+	}
+	if err := idx.IndexEventBatch(evs); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, _ := idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{{Field: "request_id", Value: "r3"}}})
+	if len(ids) != 0 {
+		t.Errorf("value beyond cardinality cap was indexed: %v", ids)
+	}
+	ids, _ = idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{{Field: "request_id", Value: "r1"}}})
+	if len(ids) != 1 {
+		t.Errorf("expected r1 to be indexed, got %v", ids)
+	}
+	ids, _ = idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{{Field: "secret", Op: "exists"}}})
+	if len(ids) != 0 {
+		t.Errorf("field with type none was indexed: %v", ids)
+	}
+	if capped := idx.CappedFields(); len(capped) != 1 || capped[0] != "request_id" {
+		t.Errorf("CappedFields = %v", capped)
+	}
+}
+
+func TestFieldCardinalityCapKeepsNumbersAndIPs(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := blugeindex.OpenWithFields(dir, blugeindex.FieldConfig{
+		MaxCardinality: 3,
+		Rules:          map[string]blugeindex.FieldRule{"dst_ip": {Type: blugeindex.FieldTypeIP}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	var evs []*models.Event
+	for i := 1; i <= 5; i++ {
+		evs = append(evs, fieldEvent(map[string]interface{}{
+			"bytes":   i * 100,
+			"src_ip":  fmt.Sprintf("10.0.0.%d", i),
+			"dst_ip":  fmt.Sprintf("192.168.0.%d", i),
+			"session": fmt.Sprintf("s%d", i),
+		}, nil))
+	}
+	if err := idx.IndexEventBatch(evs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []blugeindex.FieldFilter{
+		{Field: "bytes", Op: "gte", Value: "0"},
+		{Field: "bytes", Op: "exists"},
+		{Field: "src_ip", Op: "cidr", Value: "10.0.0.0/8"},
+		{Field: "dst_ip", Op: "cidr", Value: "192.168.0.0/16"},
+		{Field: "dst_ip", Op: "exists"},
+	} {
+		ids, err := idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{f}})
+		if err != nil || len(ids) != len(evs) {
+			t.Errorf("%s %s %s = %d events, %v; want %d", f.Field, f.Op, f.Value, len(ids), err, len(evs))
+		}
+	}
+	for _, f := range []blugeindex.FieldFilter{
+		{Field: "bytes", Op: "gt", Value: "400"},
+		{Field: "bytes", Value: "500"},
+		{Field: "src_ip", Value: "10.0.0.5"},
+	} {
+		ids, _ := idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{f}})
+		if !sameIDs(ids, []string{evs[4].ID}) {
+			t.Errorf("%s %s %s past the cap = %v, want %v", f.Field, f.Op, f.Value, ids, evs[4].ID)
+		}
+	}
+	// Keyword terms are still capped.
+	ids, _ := idx.Search(&blugeindex.Query{Fields: []blugeindex.FieldFilter{{Field: "session", Value: "s5"}}})
+	if len(ids) != 0 {
+		t.Errorf("term beyond cardinality cap was indexed: %v", ids)
+	}
+}
+
+func sameIDs(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := make(map[string]bool, len(got))
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearchPage_CursorAndTotal(t *testing.T) {
+	idx, cleanup := tmpIndex(t)
+	defer cleanup()
+
+	base := time.Now().Add(-time.Hour)
+	var evs []*models.Event
+	for i := 0; i < 25; i++ {
+		evs = append(evs, makeEvent(uuid.NewString(), "syslog", "h", "INFO", "tick", base.Add(time.Duration(i)*time.Second)))
+	}
+	if err := idx.IndexEventBatch(evs); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	q := &blugeindex.Query{Limit: 10}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		res, err := idx.SearchPage(q)
+		if err != nil {
+			t.Fatalf("SearchPage: %v", err)
+		}
+		if res.Total != 25 || !res.TotalExact {
+			t.Errorf("total = %d exact=%v, want 25 exact", res.Total, res.TotalExact)
+		}
+		got = append(got, res.IDs...)
+		if res.Next == "" {
+			break
+		}
+		q.After = res.Next
+	}
+	if len(got) != 25 {
+		t.Fatalf("paged %d ids, want 25", len(got))
+	}
+	for i, id := range got {
+		if want := evs[24-i].ID; id != want {
+			t.Fatalf("hit %d = %s, want %s (newest first)", i, id, want)
+		}
+	}
+
+	// A cursor is bound to the sort order it was issued for.
+	first, _ := idx.SearchPage(&blugeindex.Query{Limit: 10})
+	if _, err := idx.SearchPage(&blugeindex.Query{Limit: 10, Sort: []string{"timestamp"}, After: first.Next}); err == nil {
+		t.Error("expected error for cursor reused with another sort order")
+	}
+
+	est, err := idx.SearchPage(&blugeindex.Query{Limit: 10, EstimateTotal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.TotalExact || est.Total < 10 || est.Next == "" {
+		t.Errorf("estimate = %+v", est)
+	}
+}
+
+func TestSearchPage_SortFields(t *testing.T) {
+	idx, cleanup := tmpIndex(t)
+	defer cleanup()
+
+	now := time.Now()
+	a := makeEvent(uuid.NewString(), "fw", "host-b", "INFO", "x", now)
+	a.Fields = map[string]interface{}{"bytes": 300}
+	b := makeEvent(uuid.NewString(), "fw", "host-a", "INFO", "x", now)
+	b.Fields = map[string]interface{}{"bytes": 5000}
+	c := makeEvent(uuid.NewString(), "fw", "host-c", "INFO", "x", now)
+	c.Fields = map[string]interface{}{"bytes": 20}
+	if err := idx.IndexEventBatch([]*models.Event{a, b, c}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := idx.SearchPage(&blugeindex.Query{Sort: []string{"host"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.IDs) != 3 || res.IDs[0] != b.ID || res.IDs[2] != c.ID {
+		t.Errorf("sort by host = %v", res.IDs)
+	}
+	res, err = idx.SearchPage(&blugeindex.Query{Sort: []string{"-bytes"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.IDs) != 3 || res.IDs[0] != b.ID || res.IDs[1] != a.ID || res.IDs[2] != c.ID {
+		t.Errorf("sort by -bytes = %v", res.IDs)
+	}
+	if _, err := idx.SearchPage(&blugeindex.Query{Sort: []string{"message"}}); err == nil {
+		t.Error("expected error sorting on a text field")
+	}
+}
+
+func TestEach(t *testing.T) {
+	idx, cleanup := tmpIndex(t)
+	defer cleanup()
+
+	var evs []*models.Event
+	for i := 0; i < 2500; i++ {
+		evs = append(evs, makeEvent(uuid.NewString(), "syslog", "h", "INFO", "bulk", time.Now()))
+	}
+	if err := idx.IndexEventBatch(evs); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	batches := 0
+	err := idx.Each(context.Background(), &blugeindex.Query{Source: "syslog"}, func(page *blugeindex.Result) error {
+		batches++
+		for _, id := range page.IDs {
+			seen[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Each: %v", err)
+	}
+	if len(seen) != 2500 || batches != 3 {
+		t.Errorf("visited %d ids in %d batches, want 2500 in 3", len(seen), batches)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	idx, cleanup := tmpIndex(t)
+	defer cleanup()
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var evs []*models.Event
+	for i := 0; i < 10; i++ {
+		host := "web-01"
+		if i >= 7 {
+			host = "db-01"
+		}
+		ev := makeEvent(uuid.NewString(), "netflow", host, "INFO", "flow", base.Add(time.Duration(i)*time.Minute))
+		ev.Fields = map[string]interface{}{"bytes": (i + 1) * 100, "dst_ip": "10.0.0." + strconv.Itoa(i%4)}
+		evs = append(evs, ev)
+	}
+	if err := idx.IndexEventBatch(evs); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := idx.Aggregate(context.Background(), &blugeindex.Query{Source: "netflow"}, &blugeindex.AggRequest{
+		Terms:       []blugeindex.TermsAgg{{Field: "host"}, {Field: "dst_ip", Size: 2}},
+		Histogram:   &blugeindex.HistogramAgg{},
+		Cardinality: []string{"dst_ip"},
+		Stats:       []blugeindex.StatsAgg{{Field: "bytes", Percentiles: []float64{50}}},
+	})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	if res.Total != 10 {
+		t.Errorf("total = %d, want 10", res.Total)
+	}
+
+	hosts := res.Terms["host"].Buckets
+	if len(hosts) != 2 || hosts[0].Value != "web-01" || hosts[0].Count != 7 || hosts[1].Count != 3 {
+		t.Errorf("host facet = %+v", hosts)
+	}
+	if ips := res.Terms["dst_ip"]; len(ips.Buckets) != 2 || ips.Other == 0 {
+		t.Errorf("dst_ip facet = %+v", ips)
+	}
+	if c := res.Cardinality["dst_ip"]; c != 4 {
+		t.Errorf("cardinality(dst_ip) = %d, want 4", c)
+	}
+
+	st := res.Stats["bytes"]
+	if st.Count != 10 || st.Min != 100 || st.Max != 1000 || st.Sum != 5500 || st.Avg != 550 {
+		t.Errorf("stats(bytes) = %+v", st)
+	}
+	if p := st.Percentiles["50"]; p < 400 || p > 700 {
+		t.Errorf("p50(bytes) = %v", p)
+	}
+
+	h := res.Histogram
+	if h.Interval != 10*time.Second {
+		t.Errorf("auto interval = %s, want 10s for a 9m span", h.Interval)
+	}
+	var sum uint64
+	for _, b := range h.Buckets {
+		sum += b.Count
+	}
+	if sum != 10 || !h.Buckets[0].Start.Equal(base) || h.Buckets[0].Count != 1 {
+		t.Errorf("histogram sum=%d first=%+v", sum, h.Buckets[0])
+	}
+
+	if _, err := idx.Aggregate(context.Background(), &blugeindex.Query{}, &blugeindex.AggRequest{Stats: []blugeindex.StatsAgg{{Field: "host"}}}); err == nil {
+		t.Error("expected error for stats on a keyword field")
+	}
+}
+
+func TestSegmentSearchedWithLiveIndex(t *testing.T) {
+	idx, cleanup := tmpIndex(t)
+	defer cleanup()
+
+	base := time.Now().Add(-48 * time.Hour)
+	if err := idx.IndexEvent(makeEvent("live-1", "sshd", "h1", "HIGH", "Failed password", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "seg")
+	sw, err := blugeindex.NewSegmentWriter(dir, blugeindex.FieldConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("old-%d", i)
+		if err := sw.Add(makeEvent(id, "sshd", "h2", "HIGH", "Failed password", base.Add(time.Duration(i)*time.Minute)), fmt.Sprintf("seg/%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	seg, err := blugeindex.OpenSegment(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+
+	res, err := idx.SearchPage(&blugeindex.Query{Text: "failed", Limit: 2}, seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 4 || fmt.Sprint(res.IDs, res.Refs) != "[live-1 old-2] [ seg/2]" {
+		t.Fatalf("page 1 = %+v", res)
+	}
+	res, err = idx.SearchPage(&blugeindex.Query{Text: "failed", Limit: 2, After: res.Next}, seg)
+	if err != nil || fmt.Sprint(res.IDs) != "[old-1 old-0]" || res.Next != "" {
+		t.Fatalf("page 2 = %+v, %v", res, err)
+	}
+
+	if ref, ok, err := seg.Ref("old-1"); err != nil || !ok || ref != "seg/1" {
+		t.Errorf("Ref = %q %v %v", ref, ok, err)
+	}
+	agg, err := idx.Aggregate(context.Background(), &blugeindex.Query{}, &blugeindex.AggRequest{Cardinality: []string{"host"}}, seg)
+	if err != nil || agg.Total != 4 || agg.Cardinality["host"] != 2 {
+		t.Errorf("Aggregate = %+v, %v", agg, err)
+	}
+}
+
+func TestEncryptedIndex(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	key[0] = 9
+
+	// An index written before encryption stays readable afterwards.
+	plain, err := blugeindex.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.IndexEvent(makeEvent("plain-1", "sshd", "h1", "HIGH", "Failed password", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+
+	idx, err := blugeindex.OpenEncrypted(dir, blugeindex.FieldConfig{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.IndexEvent(makeEvent("secret-1", "sshd", "h1", "HIGH", "Failed password zanzibar", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := idx.Search(&blugeindex.Query{Text: "failed"})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("Search = %v, %v", ids, err)
+	}
+	idx.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*.seg"))
+	encrypted := 0
+	for _, f := range files {
+		data, _ := os.ReadFile(f)
+		if strings.Contains(string(data), "zanzibar") {
+			t.Errorf("%s holds plaintext", f)
+		}
+		if strings.HasPrefix(string(data), "OBLVENC1") {
+			encrypted++
+		}
+	}
+	if encrypted == 0 {
+		t.Fatalf("no encrypted segment among %v", files)
+	}
+
+	idx, err = blugeindex.OpenEncrypted(dir, blugeindex.FieldConfig{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := idx.Search(&blugeindex.Query{Text: "zanzibar"}); err != nil || len(ids) != 1 {
+		t.Fatalf("Search after reopen = %v, %v", ids, err)
+	}
+	idx.Close()
+
+	wrong := make([]byte, 32)
+	if idx, err := blugeindex.OpenEncrypted(dir, blugeindex.FieldConfig{}, wrong); err == nil {
+		idx.Close()
+		t.Fatal("opened with the wrong key")
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := blugeindex.OpenPartitioned(dir, blugeindex.FieldConfig{}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := idx.Search(&blugeindex.Query{}); err != nil || len(ids) != 0 {
+		t.Fatalf("Search of an empty index = %v, %v", ids, err)
+	}
+
+	day0 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	var events []*models.Event
+	for d := 0; d < 3; d++ {
+		for h := 0; h < 4; h++ {
+			id := fmt.Sprintf("d%d-h%d", d, h)
+			events = append(events, makeEvent(id, "sshd", "h1", "HIGH", "Accepted publickey", day0.Add(time.Duration(d*24+h*6)*time.Hour)))
+		}
+	}
+	if err := idx.IndexEventBatch(events); err != nil {
+		t.Fatal(err)
+	}
+	parts := idx.Partitions()
+	if len(parts) != 3 || !parts[0].Start.Equal(day0) || !parts[2].End.Equal(day0.Add(72*time.Hour)) {
+		t.Fatalf("partitions = %+v", parts)
+	}
+
+	// Results from several partitions come back merged in sort order.
+	var got []string
+	q := &blugeindex.Query{Limit: 5}
+	for {
+		res, err := idx.SearchPage(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, res.IDs...)
+		if res.Next == "" {
+			break
+		}
+		q.After = res.Next
+	}
+	if len(got) != 12 || got[0] != "d2-h3" || got[11] != "d0-h0" {
+		t.Fatalf("paged search = %v", got)
+	}
+	ids, err := idx.Search(&blugeindex.Query{StartTime: day0.Add(30 * time.Hour).UnixNano(), EndTime: day0.Add(48 * time.Hour).UnixNano()})
+	if err != nil || !sameIDs(ids, []string{"d1-h1", "d1-h2", "d1-h3"}) {
+		t.Fatalf("range search = %v, %v", ids, err)
+	}
+	agg, err := idx.Aggregate(context.Background(), &blugeindex.Query{}, &blugeindex.AggRequest{Terms: []blugeindex.TermsAgg{{Field: "host"}}})
+	if err != nil || agg.Total != 12 {
+		t.Fatalf("Aggregate = %+v, %v", agg, err)
+	}
+
+	// Dropping a partition removes its directory and its events.
+	dropped, err := idx.DropPartitions(func(p blugeindex.Partition) (bool, error) {
+		return p.Start.Equal(day0), nil
+	})
+	if err != nil || len(dropped) != 1 {
+		t.Fatalf("DropPartitions = %v, %v", dropped, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, dropped[0].Name)); !os.IsNotExist(err) {
+		t.Errorf("partition directory left behind: %v", err)
+	}
+	if ids, _ := idx.Search(&blugeindex.Query{}); len(ids) != 8 {
+		t.Fatalf("after drop = %v", ids)
+	}
+
+	// Partitions survive a restart and snapshots keep them apart.
+	names, err := idx.Snapshot(filepath.Join(t.TempDir(), "snap"), nil)
+	if err != nil || len(names) == 0 || !strings.HasPrefix(names[0], parts[1].Name+"/") {
+		t.Fatalf("Snapshot = %v, %v", names, err)
+	}
+	idx.Close()
+	idx, err = blugeindex.OpenPartitioned(dir, blugeindex.FieldConfig{}, nil, 6*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.IndexEvent(makeEvent("late", "sshd", "h1", "HIGH", "Accepted", day0.Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	parts = idx.Partitions()
+	if len(parts) != 3 || parts[0].End.Sub(parts[0].Start) != 6*time.Hour {
+		t.Fatalf("partitions after reopen = %+v", parts)
+	}
+	if ids, _ := idx.Search(&blugeindex.Query{}); len(ids) != 9 {
+		t.Fatalf("after reopen = %v", ids)
+	}
+	idx.Close()
+
+	// An index from before partitioning has to be rebuilt.
+	legacy := t.TempDir()
+	if err := os.WriteFile(filepath.Join(legacy, "000000000001.seg"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blugeindex.Open(legacy); !errors.Is(err, blugeindex.ErrUnpartitioned) {
+		t.Fatalf("Open of an unpartitioned index = %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package blugeindex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
+	segment "github.com/blugelabs/bluge_segment_api"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
+)
+
+// config returns the Bluge configuration for the index at path, encrypting
+// its files with key unless key is nil.
+func config(path string, key []byte) bluge.Config {
+	return bluge.DefaultConfigWithDirectory(func() index.Directory {
+		return directory(path, key)
+	})
+}
+
+// directory returns the Bluge directory for path, encrypting its files with
+// key unless key is nil.
+func directory(path string, key []byte) index.Directory {
+	if key == nil {
+		return index.NewFileSystemDirectory(path)
+	}
+	return &cryptDirectory{FileSystemDirectory: index.NewFileSystemDirectory(path), path: path, key: key}
+}
+
+// cryptDirectory stores segment and snapshot files in the keyring stream
+// format. Encrypted files are decrypted into memory when loaded instead of
+// being memory-mapped. Files written before encryption was enabled are still
+// loaded as plaintext; merges replace them with encrypted ones over time.
+type cryptDirectory struct {
+	*index.FileSystemDirectory
+	path string
+	key  []byte
+}
+
+func (d *cryptDirectory) fileName(kind string, id uint64) string {
+	return filepath.Join(d.path, fmt.Sprintf("%012x", id)+kind)
+}
+
+// Persist writes the item encrypted.
+func (d *cryptDirectory) Persist(kind string, id uint64, w index.WriterTo, closeCh chan struct{}) error {
+	path := d.fileName(kind, id)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	enc, err := keyring.NewWriter(f, d.key)
+	if err == nil {
+		_, err = w.WriteTo(enc, closeCh)
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// Load decrypts the item, or falls back to the plain directory for files
+// written before encryption was enabled.
+func (d *cryptDirectory) Load(kind string, id uint64) (*segment.Data, io.Closer, error) {
+	path := d.fileName(kind, id)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	head := make([]byte, keyring.HeaderSize)
+	n, _ := io.ReadFull(f, head)
+	if !keyring.IsEncrypted(head[:n]) {
+		_ = f.Close()
+		return d.FileSystemDirectory.Load(kind, id)
+	}
+	data, err := io.ReadAll(io.MultiReader(bytes.NewReader(head), f))
+	_ = f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := keyring.Decrypt(data, d.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("blugeindex: %s: %w", path, err)
+	}
+	return segment.NewDataBytes(plain), nil, nil
+}
@@ -0,0 +1,535 @@
+package blugeindex
+
+// fields.go indexes the dynamic parts of an event — Fields (parser output)
+// and Metadata (enrichment output) — with a type per value:
+//
+//	fld.{name}  (keyword) – every scalar, in its canonical string form
+//	num.{name}  (numeric) – numbers, for range queries
+//	ip.{name}   (keyword) – IP addresses as 32 hex digits (IPv4-mapped for v4)
+//	                        so a CIDR block is a contiguous term range
+//	_fields     (keyword) – names of all dynamic fields present (for "exists")
+//
+// A per-field policy can force a type or disable indexing, and caps the number
+// of distinct keyword and text terms per field so one high-cardinality field
+// (request IDs, random tokens) cannot bloat the index. Numbers and IP
+// addresses are indexed past the cap, and every value still marks its field
+// present, so range, CIDR and exists queries stay complete.
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blugelabs/bluge"
+)
+
+const (
+	keywordPrefix = "fld."
+	numericPrefix = "num."
+	ipPrefix      = "ip."
+	fieldsField   = "_fields"
+
+	defaultMaxFields      = 1000
+	defaultMaxCardinality = 10_000
+	maxKeywordLen         = 512
+	maxFlattenDepth       = 3
+)
+
+// Field types accepted in FieldRule.Type.
+const (
+	FieldTypeAuto    = "auto"
+	FieldTypeKeyword = "keyword"
+	FieldTypeNumeric = "numeric"
+	FieldTypeIP      = "ip"
+	FieldTypeText    = "text"
+	FieldTypeNone    = "none"
+)
+
+// FieldConfig is the per-index policy for dynamic fields.
+type FieldConfig struct {
+	MaxFields      int                  // distinct dynamic field names (0 → 1000)
+	MaxCardinality int                  // distinct values per field (0 → 10000)
+	Rules          map[string]FieldRule // per-field overrides keyed by field name
+}
+
+// FieldRule overrides indexing for one field.
+type FieldRule struct {
+	Type           string // auto | keyword | numeric | ip | text | none
+	MaxCardinality int    // 0 → FieldConfig.MaxCardinality
+}
+
+// coreFields are the fixed event attributes indexed under their own names.
+var coreFields = map[string]bool{
+	"message": true, "source": true, "host": true,
+	"user": true, "severity": true, "category": true, "tenant": true,
+}
+
+// fieldPolicy applies a FieldConfig and tracks per-field cardinality.
+// Cardinality is tracked in memory from process start; it is a safety valve,
+// not an exact statistic.
+type fieldPolicy struct {
+	cfg    FieldConfig
+	mu     sync.Mutex
+	seen   map[string]map[uint64]struct{}
+	capped map[string]bool
+}
+
+func newFieldPolicy(cfg FieldConfig) *fieldPolicy {
+	if cfg.MaxFields <= 0 {
+		cfg.MaxFields = defaultMaxFields
+	}
+	if cfg.MaxCardinality <= 0 {
+		cfg.MaxCardinality = defaultMaxCardinality
+	}
+	return &fieldPolicy{
+		cfg:    cfg,
+		seen:   make(map[string]map[uint64]struct{}),
+		capped: make(map[string]bool),
+	}
+}
+
+func (p *fieldPolicy) rule(name string) FieldRule {
+	r := p.cfg.Rules[name]
+	if r.Type == "" {
+		r.Type = FieldTypeAuto
+	}
+	if r.MaxCardinality <= 0 {
+		r.MaxCardinality = p.cfg.MaxCardinality
+	}
+	return r
+}
+
+// allowField reports whether field name may be indexed within the budget of
+// distinct field names.
+func (p *fieldPolicy) allowField(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.seen[name]; ok {
+		return true
+	}
+	if len(p.seen) >= p.cfg.MaxFields {
+		return false
+	}
+	p.seen[name] = make(map[uint64]struct{})
+	return true
+}
+
+// allowTerm reports whether value may be indexed as a keyword or text term
+// of field name, recording it against the field's cardinality budget. The
+// caller has admitted name with allowField.
+func (p *fieldPolicy) allowTerm(name, value string, limit int) bool {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	sum := h.Sum64()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	set := p.seen[name]
+	if _, ok := set[sum]; ok {
+		return true
+	}
+	if len(set) >= limit {
+		if !p.capped[name] {
+			p.capped[name] = true
+			log.Printf("blugeindex: field %q reached %d distinct values; new values will not be indexed as terms", name, limit)
+		}
+		return false
+	}
+	set[sum] = struct{}{}
+	return true
+}
+
+// cappedFields lists fields that have hit their cardinality cap.
+func (p *fieldPolicy) cappedFields() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]string, 0, len(p.capped))
+	for name := range p.capped {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// addDynamicFields indexes ev.Fields and ev.Metadata on doc.
+func (p *fieldPolicy) addDynamicFields(doc *bluge.Document, fields map[string]interface{}, meta map[string]string) {
+	present := make(map[string]bool)
+	for name, v := range fields {
+		p.addValue(doc, name, v, 0, present)
+	}
+	for name, v := range meta {
+		p.addValue(doc, name, v, 0, present)
+	}
+	for name := range present {
+		doc.AddField(bluge.NewKeywordField(fieldsField, name))
+	}
+}
+
+func (p *fieldPolicy) addValue(doc *bluge.Document, name string, v interface{}, depth int, present map[string]bool) {
+	if coreFields[name] {
+		return // never shadow the fixed attributes
+	}
+	switch val := v.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		if depth >= maxFlattenDepth {
+			return
+		}
+		for k, sub := range val {
+			p.addValue(doc, name+"."+k, sub, depth+1, present)
+		}
+		return
+	case map[string]string:
+		if depth >= maxFlattenDepth {
+			return
+		}
+		for k, sub := range val {
+			p.addValue(doc, name+"."+k, sub, depth+1, present)
+		}
+		return
+	case []interface{}:
+		for _, item := range val {
+			p.addValue(doc, name, item, depth+1, present)
+		}
+		return
+	case []string:
+		for _, item := range val {
+			p.addValue(doc, name, item, depth+1, present)
+		}
+		return
+	}
+
+	rule := p.rule(name)
+	if rule.Type == FieldTypeNone {
+		return
+	}
+	str, num, isNum := scalar(v)
+	if str == "" || len(str) > maxKeywordLen {
+		return
+	}
+	if !p.allowField(name) {
+		return
+	}
+	present[name] = true
+
+	switch rule.Type {
+	case FieldTypeText:
+		if p.allowTerm(name, str, rule.MaxCardinality) {
+			doc.AddField(bluge.NewTextField(keywordPrefix+name, str))
+		}
+		return
+	case FieldTypeNumeric:
+		if !isNum {
+			if f, err := strconv.ParseFloat(str, 64); err == nil {
+				num, isNum = f, true
+			}
+		}
+		if isNum {
+			doc.AddField(bluge.NewNumericField(numericPrefix+name, num).Sortable())
+		}
+		return
+	case FieldTypeIP:
+		if key, ok := ipKey(str); ok {
+			doc.AddField(bluge.NewKeywordField(ipPrefix+name, key))
+		}
+		return
+	case FieldTypeKeyword:
+		if p.allowTerm(name, str, rule.MaxCardinality) {
+			doc.AddField(bluge.NewKeywordField(keywordPrefix+name, str).Aggregatable())
+		}
+		return
+	}
+
+	// auto: numeric strings (common in parsed syslog) are indexed as numbers too
+	if p.allowTerm(name, str, rule.MaxCardinality) {
+		doc.AddField(bluge.NewKeywordField(keywordPrefix+name, str).Aggregatable())
+	}
+	if !isNum {
+		if f, err := strconv.ParseFloat(str, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			num, isNum = f, true
+		}
+	}
+	if isNum {
+		doc.AddField(bluge.NewNumericField(numericPrefix+name, num).Sortable())
+	} else if key, ok := ipKey(str); ok {
+		doc.AddField(bluge.NewKeywordField(ipPrefix+name, key))
+	}
+}
+
+// scalar returns the canonical string form of v and, for numbers, its value.
+func scalar(v interface{}) (string, float64, bool) {
+	switch n := v.(type) {
+	case string:
+		return n, 0, false
+	case bool:
+		return strconv.FormatBool(n), 0, false
+	case float64:
+		return formatNumber(n), n, true
+	case float32:
+		return formatNumber(float64(n)), float64(n), true
+	case int:
+		return strconv.Itoa(n), float64(n), true
+	case int8:
+		return strconv.FormatInt(int64(n), 10), float64(n), true
+	case int16:
+		return strconv.FormatInt(int64(n), 10), float64(n), true
+	case int32:
+		return strconv.FormatInt(int64(n), 10), float64(n), true
+	case int64:
+		return strconv.FormatInt(n, 10), float64(n), true
+	case uint:
+		return strconv.FormatUint(uint64(n), 10), float64(n), true
+	case uint8:
+		return strconv.FormatUint(uint64(n), 10), float64(n), true
+	case uint16:
+		return strconv.FormatUint(uint64(n), 10), float64(n), true
+	case uint32:
+		return strconv.FormatUint(uint64(n), 10), float64(n), true
+	case uint64:
+		return strconv.FormatUint(n, 10), float64(n), true
+	case json.Number:
+		if f, err := n.Float64(); err == nil {
+			return formatNumber(f), f, true
+		}
+		return n.String(), 0, false
+	}
+	return fmt.Sprintf("%v", v), 0, false
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ipKey encodes an address as 32 lowercase hex digits of its 16-byte form.
+func ipKey(s string) (string, bool) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return "", false
+	}
+	return hex.EncodeToString(ip.To16()), true
+}
+
+// cidrRange returns the first and last ipKey in a CIDR block. A bare address
+// is treated as a single-host block.
+func cidrRange(s string) (string, string, error) {
+	if !strings.Contains(s, "/") {
+		key, ok := ipKey(s)
+		if !ok {
+			return "", "", fmt.Errorf("invalid IP or CIDR %q", s)
+		}
+		return key, key, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid CIDR %q", s)
+	}
+	lo := network.IP.To16()
+	hi := make(net.IP, len(lo))
+	copy(hi, lo)
+	mask := network.Mask
+	// IPv4 masks are 4 bytes; align them with the tail of the 16-byte form.
+	off := len(hi) - len(mask)
+	for i := range mask {
+		hi[off+i] |= ^mask[i]
+	}
+	return hex.EncodeToString(lo), hex.EncodeToString(hi), nil
+}
+
+// ─── FILTERS ─────────────────────────────────────────────────────────────────
+
+// Filter operators accepted in FieldFilter.Op.
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpCIDR     = "cidr"
+	OpPrefix   = "prefix"
+	OpWildcard = "wildcard"
+	OpExists   = "exists"
+)
+
+// FieldFilter is a generic filter over a core or dynamic field.
+type FieldFilter struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`    // eq (default) | ne | gt | gte | lt | lte | cidr | prefix | wildcard | exists
+	Value string `json:"value"` // ignored for exists
+}
+
+// filterQuery translates f into a Bluge query. negate is true for "ne", in
+// which case the returned query is the positive form to be used as MustNot.
+func filterQuery(f FieldFilter) (q bluge.Query, negate bool, err error) {
+	if f.Field == "" {
+		return nil, false, fmt.Errorf("blugeindex: filter without field")
+	}
+	op := strings.ToLower(f.Op)
+	if op == "" {
+		op = OpEq
+	}
+	core := coreFields[f.Field]
+
+	switch op {
+	case OpEq, OpNe:
+		return eqQuery(f.Field, f.Value, core), op == OpNe, nil
+
+	case OpGt, OpGte, OpLt, OpLte:
+		if core {
+			return nil, false, fmt.Errorf("blugeindex: field %s does not support %s", f.Field, op)
+		}
+		n, err := strconv.ParseFloat(f.Value, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("blugeindex: %s %s: %q is not a number", f.Field, op, f.Value)
+		}
+		var rq *bluge.NumericRangeQuery
+		switch op {
+		case OpGt:
+			rq = bluge.NewNumericRangeInclusiveQuery(n, bluge.MaxNumeric, false, true)
+		case OpGte:
+			rq = bluge.NewNumericRangeInclusiveQuery(n, bluge.MaxNumeric, true, true)
+		case OpLt:
+			rq = bluge.NewNumericRangeInclusiveQuery(bluge.MinNumeric, n, true, false)
+		default:
+			rq = bluge.NewNumericRangeInclusiveQuery(bluge.MinNumeric, n, true, true)
+		}
+		return rq.SetField(numericPrefix + f.Field), false, nil
+
+	case OpCIDR:
+		lo, hi, err := cidrRange(f.Value)
+		if err != nil {
+			return nil, false, fmt.Errorf("blugeindex: %s: %w", f.Field, err)
+		}
+		return bluge.NewTermRangeInclusiveQuery(lo, hi, true, true).SetField(ipPrefix + f.Field), false, nil
+
+	case OpPrefix:
+		field := keywordPrefix + f.Field
+		if core {
+			field = f.Field
+		}
+		return bluge.NewPrefixQuery(f.Value).SetField(field), false, nil
+
+	case OpWildcard:
+		field := keywordPrefix + f.Field
+		if core {
+			field = f.Field
+		}
+		if f.Field == "message" {
+			// message is analysed: match the pattern against its lower-cased tokens.
+			return bluge.NewWildcardQuery(strings.ToLower(f.Value)).SetField(field), false, nil
+		}
+		return bluge.NewWildcardQuery(f.Value).SetField(field), false, nil
+
+	case OpExists:
+		if core {
+			return bluge.NewWildcardQuery("?*").SetField(f.Field), false, nil
+		}
+		return bluge.NewTermQuery(f.Field).SetField(fieldsField), false, nil
+	}
+	return nil, false, fmt.Errorf("blugeindex: unknown filter operator %q", f.Op)
+}
+
+// eqQuery matches value as a keyword, as a canonical number and as an
+// address, so "22", "22.0" and "::ffff:10.0.0.1" all find what users expect.
+func eqQuery(field, value string, core bool) bluge.Query {
+	if core {
+		if field == "message" {
+			return bluge.NewMatchQuery(value).SetField(field)
+		}
+		q := bluge.NewBooleanQuery().SetMinShould(1)
+		q.AddShould(bluge.NewTermQuery(value).SetField(field))
+		if field == "host" {
+			if key, ok := ipKey(value); ok {
+				q.AddShould(bluge.NewTermQuery(key).SetField(ipPrefix + field))
+			}
+		}
+		return q
+	}
+	q := bluge.NewBooleanQuery().SetMinShould(1)
+	q.AddShould(bluge.NewTermQuery(value).SetField(keywordPrefix + field))
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		if canon := formatNumber(n); canon != value {
+			q.AddShould(bluge.NewTermQuery(canon).SetField(keywordPrefix + field))
+		}
+		// numbers are indexed past the cardinality cap that limits terms
+		q.AddShould(bluge.NewNumericRangeInclusiveQuery(n, n, true, true).SetField(numericPrefix + field))
+	}
+	if key, ok := ipKey(value); ok {
+		q.AddShould(bluge.NewTermQuery(key).SetField(ipPrefix + field))
+	}
+	return q
+}
+
+// Cond is a boolean combination of filters, used by the query language to
+// push a whole search expression into the index. Exactly one member is set.
+type Cond struct {
+	And    []*Cond      `json:"and,omitempty"`
+	Or     []*Cond      `json:"or,omitempty"`
+	Not    *Cond        `json:"not,omitempty"`
+	Text   string       `json:"text,omitempty"`   // full-text match on message
+	Phrase bool         `json:"phrase,omitempty"` // Text must match as a phrase
+	Field  *FieldFilter `json:"field,omitempty"`
+}
+
+func condQuery(c *Cond) (bluge.Query, error) {
+	switch {
+	case len(c.And) > 0:
+		bq := bluge.NewBooleanQuery()
+		for _, sub := range c.And {
+			if sub.Not != nil {
+				q, err := condQuery(sub.Not)
+				if err != nil {
+					return nil, err
+				}
+				bq.AddMustNot(q)
+				continue
+			}
+			q, err := condQuery(sub)
+			if err != nil {
+				return nil, err
+			}
+			bq.AddMust(q)
+		}
+		return bq, nil
+	case len(c.Or) > 0:
+		bq := bluge.NewBooleanQuery().SetMinShould(1)
+		for _, sub := range c.Or {
+			q, err := condQuery(sub)
+			if err != nil {
+				return nil, err
+			}
+			bq.AddShould(q)
+		}
+		return bq, nil
+	case c.Not != nil:
+		q, err := condQuery(c.Not)
+		if err != nil {
+			return nil, err
+		}
+		return bluge.NewBooleanQuery().AddMust(bluge.NewMatchAllQuery()).AddMustNot(q), nil
+	case c.Text != "":
+		if c.Phrase {
+			return bluge.NewMatchPhraseQuery(c.Text).SetField("message"), nil
+		}
+		return bluge.NewMatchQuery(c.Text).SetField("message"), nil
+	case c.Field != nil:
+		q, negate, err := filterQuery(*c.Field)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			return bluge.NewBooleanQuery().AddMust(bluge.NewMatchAllQuery()).AddMustNot(q), nil
+		}
+		return q, nil
+	}
+	return nil, fmt.Errorf("blugeindex: empty condition")
+}
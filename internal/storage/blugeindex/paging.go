@@ -0,0 +1,225 @@
+package blugeindex
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blugelabs/bluge"
+)
+
+// exportPageSize is the page size used by Each.
+const exportPageSize = 1000
+
+// Result is one page of search hits.
+type Result struct {
+	IDs        []string
+	Refs       []string // archive reference per ID ("" for live events); nil without segments
+	Total      uint64   // matching documents (a lower bound unless TotalExact)
+	TotalExact bool
+	Next       string // cursor for the following page; empty on the last page
+}
+
+// sortableFields are the fixed attributes that can be sorted on. Any other
+// name is treated as a dynamic numeric field (num.{name}).
+var sortableFields = map[string]bool{
+	"timestamp": true, "source": true, "host": true, "user": true,
+	"severity": true, "category": true, "_score": true,
+}
+
+// sortOrder maps user-facing sort fields to index fields and appends _id as
+// a tie-breaker so every hit has a unique sort key for cursor pagination.
+func sortOrder(fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		fields = []string{"-timestamp"}
+	}
+	order := make([]string, 0, len(fields)+1)
+	for _, f := range fields {
+		desc := strings.HasPrefix(f, "-")
+		name := strings.TrimPrefix(f, "-")
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("blugeindex: empty sort field")
+		case sortableFields[name]:
+		default:
+			if coreFields[name] || strings.ContainsAny(name, " *") {
+				return nil, fmt.Errorf("blugeindex: cannot sort on %q", name)
+			}
+			name = numericPrefix + name
+		}
+		if desc {
+			name = "-" + name
+		}
+		order = append(order, name)
+	}
+	return append(order, "_id"), nil
+}
+
+// cursor is the decoded form of Result.Next. It carries the sort order it
+// was produced under so it cannot be replayed against a different one.
+type cursor struct {
+	Sort   []string `json:"s"`
+	Values [][]byte `json:"v"`
+}
+
+func encodeCursor(order []string, values [][]byte) string {
+	b, _ := json.Marshal(cursor{Sort: order, Values: values})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, order []string) ([][]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: invalid cursor: %w", err)
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("blugeindex: invalid cursor: %w", err)
+	}
+	if strings.Join(c.Sort, ",") != strings.Join(order, ",") || len(c.Values) != len(order) {
+		return nil, fmt.Errorf("blugeindex: cursor does not match sort order")
+	}
+	return c.Values, nil
+}
+
+// SearchPage executes a Query and returns one page of IDs, the total hit
+// count and a cursor for the next page. Archive segments, if given, are
+// searched together with the live index.
+func (idx *Index) SearchPage(q *Query, segments ...*Segment) (*Result, error) {
+	bq, err := buildQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	order, err := sortOrder(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	var after [][]byte
+	if q.After != "" {
+		if after, err = decodeCursor(q.After, order); err != nil {
+			return nil, err
+		}
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	snap, err := idx.snapshot(q, segments)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	return searchPage(snap, bq, order, after, limit, !q.EstimateTotal)
+}
+
+// Each walks every hit of q in sort order, calling fn with pages of hits.
+// All pages are read from one index snapshot, so events indexed during the
+// walk are not included. q.Limit and q.After are ignored.
+func (idx *Index) Each(ctx context.Context, q *Query, fn func(page *Result) error, segments ...*Segment) error {
+	bq, err := buildQuery(q)
+	if err != nil {
+		return err
+	}
+	order, err := sortOrder(q.Sort)
+	if err != nil {
+		return err
+	}
+
+	snap, err := idx.snapshot(q, segments)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	var after [][]byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		res, err := searchPage(snap, bq, order, after, exportPageSize, false)
+		if err != nil {
+			return err
+		}
+		if len(res.IDs) > 0 {
+			if err := fn(res); err != nil {
+				return err
+			}
+		}
+		if res.Next == "" {
+			return nil
+		}
+		after, _ = decodeCursor(res.Next, order)
+	}
+}
+
+func searchPage(snap *snapshot, q bluge.Query, order []string, after [][]byte, limit int, count bool) (*Result, error) {
+	// Ask for one extra hit to learn whether another page exists.
+	req := bluge.NewTopNSearch(limit+1, q).SortBy(order)
+	if after != nil {
+		req = req.After(after)
+	}
+	if count {
+		req = req.WithStandardAggregations()
+	}
+
+	dmi, err := snap.search(context.Background(), req)
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: search: %w", err)
+	}
+
+	res := &Result{}
+	var lastSort [][]byte
+	more := false
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		if len(res.IDs) == limit {
+			more = true
+			break
+		}
+		id, ref := "", ""
+		err = next.VisitStoredFields(func(field string, value []byte) bool {
+			switch field {
+			case "_id":
+				id = string(value)
+			case refField:
+				ref = string(value)
+			}
+			// Live documents have no reference, so only segment searches
+			// read past the ID.
+			return snap.segments && ref == ""
+		})
+		if err != nil {
+			break
+		}
+		res.IDs = append(res.IDs, id)
+		if snap.segments {
+			res.Refs = append(res.Refs, ref)
+		}
+		lastSort = make([][]byte, len(next.SortValue))
+		for i, v := range next.SortValue {
+			lastSort[i] = append([]byte(nil), v...)
+		}
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: iterate: %w", err)
+	}
+
+	if more {
+		res.Next = encodeCursor(order, lastSort)
+	}
+	if count {
+		res.Total = dmi.Aggregations().Count()
+		res.TotalExact = true
+	} else {
+		res.Total = uint64(len(res.IDs))
+		if more {
+			res.Total++
+		}
+	}
+	return res, nil
+}
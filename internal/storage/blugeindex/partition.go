@@ -0,0 +1,296 @@
+package blugeindex
+
+// partition.go splits the live index by event time. Each partition is a
+// Bluge index of its own in a sub-directory named after its span, so
+// searches open only the partitions overlapping their time range and
+// retention drops whole partitions instead of deleting documents one by
+// one.
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/index"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+const (
+	// DefaultPartitionInterval is the span of a new partition unless
+	// OpenPartitioned is given another.
+	DefaultPartitionInterval = 24 * time.Hour
+
+	// partitionLayout formats the UTC bounds in a partition's directory
+	// name, "{start}-{end}".
+	partitionLayout = "20060102T1504"
+	// droppedSuffix marks a partition directory being deleted; leftovers
+	// are removed on open.
+	droppedSuffix = ".dropped"
+)
+
+// ErrUnpartitioned is returned by OpenPartitioned for an index written
+// before the live index was partitioned. It has to be rebuilt.
+var ErrUnpartitioned = errors.New("blugeindex: index predates time partitions")
+
+// ErrCorrupt is returned by OpenPartitioned when a partition's files
+// cannot be read as an index. The index has to be rebuilt.
+var ErrCorrupt = errors.New("blugeindex: index is corrupt")
+
+// Partition describes one time partition, holding the documents of events
+// timestamped in [Start, End).
+type Partition struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type partition struct {
+	name       string
+	start, end time.Time
+	writer     *bluge.Writer
+}
+
+func (p *partition) info() Partition {
+	return Partition{Name: p.name, Start: p.start, End: p.end}
+}
+
+// OpenPartitioned opens the live index at path, creating it if needed. New
+// partitions span interval (0 → DefaultPartitionInterval), aligned to
+// multiples of it, so daily partitions start at midnight UTC. Partitions
+// created with another interval keep their span. key encrypts the index
+// files unless nil.
+func OpenPartitioned(path string, fc FieldConfig, key []byte, interval time.Duration) (*Index, error) {
+	if interval == 0 {
+		interval = DefaultPartitionInterval
+	}
+	if interval < time.Minute || interval%time.Minute != 0 {
+		return nil, fmt.Errorf("blugeindex: partition interval %v is not a whole number of minutes", interval)
+	}
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("blugeindex: %w", err)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && isIndexFile(e.Name()) {
+			return nil, ErrUnpartitioned
+		}
+	}
+
+	idx := &Index{fields: newFieldPolicy(fc), path: path, key: key, interval: interval}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, droppedSuffix) {
+			if err := os.RemoveAll(filepath.Join(path, name)); err != nil {
+				log.Printf("blugeindex: remove dropped partition: %v", err)
+			}
+			continue
+		}
+		start, end, ok := parsePartitionName(name)
+		if !ok {
+			continue
+		}
+		p, err := idx.openPartition(name, start, end)
+		if err != nil {
+			_ = idx.Close()
+			return nil, err
+		}
+		idx.parts = append(idx.parts, p)
+	}
+	sort.Slice(idx.parts, func(i, j int) bool { return idx.parts[i].start.Before(idx.parts[j].start) })
+	return idx, nil
+}
+
+func isIndexFile(name string) bool {
+	return strings.HasSuffix(name, index.ItemKindSegment) || strings.HasSuffix(name, index.ItemKindSnapshot)
+}
+
+func partitionName(start, end time.Time) string {
+	return start.UTC().Format(partitionLayout) + "-" + end.UTC().Format(partitionLayout)
+}
+
+func parsePartitionName(name string) (start, end time.Time, ok bool) {
+	s, e, found := strings.Cut(name, "-")
+	if !found {
+		return start, end, false
+	}
+	start, err := time.Parse(partitionLayout, s)
+	if err != nil {
+		return start, end, false
+	}
+	end, err = time.Parse(partitionLayout, e)
+	if err != nil || !end.After(start) {
+		return start, end, false
+	}
+	return start, end, true
+}
+
+func (idx *Index) openPartition(name string, start, end time.Time) (*partition, error) {
+	dir := filepath.Join(idx.path, name)
+	w, err := bluge.OpenWriter(bluge.DefaultConfigWithDirectory(func() index.Directory {
+		return &frozenDirectory{Directory: directory(dir, idx.key), freeze: &idx.freeze}
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: open partition %s: %w", name, corrupt(err))
+	}
+	return &partition{name: name, start: start, end: end, writer: w}, nil
+}
+
+// corrupt marks an open error as ErrCorrupt unless the file system or the
+// operating system raised it: a held lock, too many open files or a denied
+// permission pass, and rebuilding would not fix them.
+func corrupt(err error) error {
+	var pathErr *fs.PathError
+	var sysErr *os.SyscallError
+	var errno syscall.Errno
+	if errors.As(err, &pathErr) || errors.As(err, &sysErr) || errors.As(err, &errno) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrCorrupt, err)
+}
+
+// find returns the partition holding ts, or nil. idx.mu must be held.
+func (idx *Index) find(ts time.Time) *partition {
+	i := sort.Search(len(idx.parts), func(i int) bool { return idx.parts[i].end.After(ts) })
+	if i < len(idx.parts) && !idx.parts[i].start.After(ts) {
+		return idx.parts[i]
+	}
+	return nil
+}
+
+// group sorts events by partition; ok is false if one has no partition yet.
+// idx.mu must be held.
+func (idx *Index) group(events []*models.Event) (groups map[*partition][]*models.Event, ok bool) {
+	groups = make(map[*partition][]*models.Event)
+	for _, ev := range events {
+		p := idx.find(ev.Timestamp)
+		if p == nil {
+			return nil, false
+		}
+		groups[p] = append(groups[p], ev)
+	}
+	return groups, true
+}
+
+// addPartitions creates the partitions missing for events.
+func (idx *Index) addPartitions(events []*models.Event) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, ev := range events {
+		if idx.find(ev.Timestamp) != nil {
+			continue
+		}
+		start, end := idx.span(ev.Timestamp)
+		p, err := idx.openPartition(partitionName(start, end), start, end)
+		if err != nil {
+			return err
+		}
+		i := sort.Search(len(idx.parts), func(i int) bool { return idx.parts[i].start.After(start) })
+		idx.parts = append(idx.parts, nil)
+		copy(idx.parts[i+1:], idx.parts[i:])
+		idx.parts[i] = p
+	}
+	return nil
+}
+
+// span returns the bounds of a new partition holding ts: the interval
+// around it, clipped to the partitions on either side. idx.mu must be held.
+func (idx *Index) span(ts time.Time) (start, end time.Time) {
+	start = ts.Truncate(idx.interval).UTC()
+	end = start.Add(idx.interval)
+	i := sort.Search(len(idx.parts), func(i int) bool { return idx.parts[i].end.After(ts) })
+	if i < len(idx.parts) && idx.parts[i].start.Before(end) {
+		end = idx.parts[i].start
+	}
+	if i > 0 && idx.parts[i-1].end.After(start) {
+		start = idx.parts[i-1].end
+	}
+	return start, end
+}
+
+// overlapping returns the partitions overlapping [start, end); a zero bound
+// leaves that side open. idx.mu must be held.
+func (idx *Index) overlapping(start, end time.Time) []*partition {
+	var out []*partition
+	for _, p := range idx.parts {
+		if (end.IsZero() || p.start.Before(end)) && (start.IsZero() || p.end.After(start)) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Partitions lists the partitions, oldest first.
+func (idx *Index) Partitions() []Partition {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	out := make([]Partition, len(idx.parts))
+	for i, p := range idx.parts {
+		out[i] = p.info()
+	}
+	return out
+}
+
+// DropPartitions closes and deletes every partition for which drop returns
+// true, and returns those dropped. Nothing is indexed while it runs, so a
+// drop decided on the state of the event store cannot race with events
+// being indexed. A partition is recreated when an event in its span is
+// indexed again.
+func (idx *Index) DropPartitions(drop func(Partition) (bool, error)) ([]Partition, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var dropped []Partition
+	kept := make([]*partition, 0, len(idx.parts))
+	var err error
+	for _, p := range idx.parts {
+		ok := false
+		if err == nil {
+			ok, err = drop(p.info())
+		}
+		if !ok || err != nil {
+			kept = append(kept, p)
+			continue
+		}
+		if rerr := idx.removePartition(p); rerr != nil {
+			log.Printf("%v", rerr)
+		}
+		dropped = append(dropped, p.info())
+	}
+	idx.parts = kept
+	return dropped, err
+}
+
+// removePartition closes p and deletes its directory. The directory is
+// renamed first so that a removal cut short is finished on the next open.
+func (idx *Index) removePartition(p *partition) error {
+	cerr := p.writer.Close()
+	idx.freeze.RLock()
+	defer idx.freeze.RUnlock()
+	dir := filepath.Join(idx.path, p.name)
+	if err := os.Rename(dir, dir+droppedSuffix); err != nil {
+		if os.IsNotExist(err) {
+			return nil // never written to
+		}
+		return fmt.Errorf("blugeindex: drop partition %s: %w", p.name, err)
+	}
+	if err := os.RemoveAll(dir + droppedSuffix); err != nil {
+		return fmt.Errorf("blugeindex: drop partition %s: %w", p.name, err)
+	}
+	if cerr != nil {
+		return fmt.Errorf("blugeindex: drop partition %s: %w", p.name, cerr)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package blugeindex
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// refField stores, for archived events, where the archive keeps the payload.
+const refField = "_ref"
+
+// Segment is an immutable index over archived events, written once with a
+// SegmentWriter and searched alongside the live index.
+type Segment struct {
+	reader *bluge.Reader
+}
+
+// OpenSegment opens the segment index at path for reading. key decrypts a
+// segment written encrypted and is nil otherwise.
+func OpenSegment(path string, key []byte) (*Segment, error) {
+	r, err := bluge.OpenReader(config(path, key))
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: open segment %s: %w", path, err)
+	}
+	return &Segment{reader: r}, nil
+}
+
+// Ref returns the archive reference stored with event id.
+func (s *Segment) Ref(id string) (string, bool, error) {
+	req := bluge.NewTopNSearch(1, bluge.NewTermQuery(id).SetField("_id"))
+	dmi, err := s.reader.Search(context.Background(), req)
+	if err != nil {
+		return "", false, fmt.Errorf("blugeindex: segment lookup: %w", err)
+	}
+	dm, err := dmi.Next()
+	if err != nil || dm == nil {
+		return "", false, err
+	}
+	ref := ""
+	err = dm.VisitStoredFields(func(field string, value []byte) bool {
+		if field == refField {
+			ref = string(value)
+			return false
+		}
+		return true
+	})
+	return ref, ref != "", err
+}
+
+// Close releases the segment's files.
+func (s *Segment) Close() error {
+	return s.reader.Close()
+}
+
+// SegmentWriter builds a segment index. Documents are indexed exactly as in
+// the live index, plus the archive reference.
+type SegmentWriter struct {
+	w      *bluge.OfflineWriter
+	fields *fieldPolicy
+	docs   int
+}
+
+// NewSegmentWriter creates a segment index at path, which must not exist.
+// A non-nil key encrypts the segment files.
+func NewSegmentWriter(path string, fc FieldConfig, key []byte) (*SegmentWriter, error) {
+	w, err := bluge.OpenOfflineWriter(config(path, key), 1000, 10)
+	if err != nil {
+		return nil, fmt.Errorf("blugeindex: create segment %s: %w", path, err)
+	}
+	return &SegmentWriter{w: w, fields: newFieldPolicy(fc)}, nil
+}
+
+// Add indexes ev with its archive reference.
+func (sw *SegmentWriter) Add(ev *models.Event, ref string) error {
+	idx := Index{fields: sw.fields}
+	doc := idx.buildDocument(ev).AddField(bluge.NewKeywordField(refField, ref).StoreValue())
+	if err := sw.w.Insert(doc); err != nil {
+		return fmt.Errorf("blugeindex: segment insert: %w", err)
+	}
+	sw.docs++
+	return nil
+}
+
+// Close merges and writes the segment. An empty segment cannot be written;
+// its directory is left for the caller to remove.
+func (sw *SegmentWriter) Close() error {
+	if sw.docs == 0 {
+		return fmt.Errorf("blugeindex: empty segment")
+	}
+	if err := sw.w.Close(); err != nil {
+		return fmt.Errorf("blugeindex: write segment: %w", err)
+	}
+	return nil
+}
+
+// snapshot is a point-in-time view of the live partitions a query can
+// match plus any segments.
+type snapshot struct {
+	live     []*bluge.Reader
+	readers  []*bluge.Reader
+	segments bool
+}
+
+// snapshot opens readers on the partitions overlapping q's time range.
+func (idx *Index) snapshot(q *Query, segments []*Segment) (*snapshot, error) {
+	var start, end time.Time
+	if q.StartTime > 0 {
+		start = time.Unix(0, q.StartTime)
+	}
+	if q.EndTime > 0 {
+		end = time.Unix(0, q.EndTime)
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	s := &snapshot{segments: len(segments) > 0}
+	for _, p := range idx.overlapping(start, end) {
+		r, err := p.writer.Reader()
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("blugeindex: open reader: %w", err)
+		}
+		s.live = append(s.live, r)
+	}
+	s.readers = append(s.readers, s.live...)
+	for _, seg := range segments {
+		s.readers = append(s.readers, seg.reader)
+	}
+	return s, nil
+}
+
+func (s *snapshot) search(ctx context.Context, req bluge.SearchRequest) (search.DocumentMatchIterator, error) {
+	if len(s.readers) == 1 {
+		return s.readers[0].Search(ctx, req)
+	}
+	return bluge.MultiSearch(ctx, req, s.readers...)
+}
+
+// Close releases the partition readers; segments are owned by the caller.
+func (s *snapshot) Close() error {
+	var first error
+	for _, r := range s.live {
+		if err := r.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
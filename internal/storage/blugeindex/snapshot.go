@@ -0,0 +1,114 @@
+package blugeindex
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/blugelabs/bluge/index"
+)
+
+// frozenDirectory holds off new files and removals while a Snapshot copies
+// the index. Bluge writes each file once and never modifies it afterwards,
+// so the files present while nothing is being persisted or removed form a
+// consistent index.
+type frozenDirectory struct {
+	index.Directory
+	freeze *sync.RWMutex
+}
+
+func (d *frozenDirectory) Persist(kind string, id uint64, w index.WriterTo, closeCh chan struct{}) error {
+	d.freeze.RLock()
+	defer d.freeze.RUnlock()
+	return d.Directory.Persist(kind, id, w, closeCh)
+}
+
+func (d *frozenDirectory) Remove(kind string, id uint64) error {
+	d.freeze.RLock()
+	defer d.freeze.RUnlock()
+	return d.Directory.Remove(kind, id)
+}
+
+// Snapshot copies the persisted files of every partition into dst, which
+// must not exist, one sub-directory per partition, and returns the names of
+// all the files making up the snapshot relative to dst ("{partition}/{file}",
+// slash-separated), sorted. Files for which skip returns true are left out
+// of dst; incremental backups use it for files an earlier snapshot already
+// holds. Files are hard-linked where possible and copied otherwise;
+// encrypted indexes stay encrypted. Writes that were acknowledged before
+// the call are included.
+func (idx *Index) Snapshot(dst string, skip func(name string) bool) ([]string, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	idx.freeze.Lock()
+	defer idx.freeze.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dst, 0o700); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, p := range idx.parts {
+		entries, err := os.ReadDir(filepath.Join(idx.path, p.name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // nothing persisted yet
+		} else if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !isIndexFile(e.Name()) {
+				continue
+			}
+			name := path.Join(p.name, e.Name())
+			names = append(names, name)
+			if skip != nil && skip(name) {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Join(dst, p.name), 0o700); err != nil {
+				return nil, err
+			}
+			if err := linkOrCopy(filepath.Join(idx.path, p.name, e.Name()), filepath.Join(dst, p.name, e.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// linkOrCopy hard-links src to dst, falling back to a synced copy when the
+// two are on different filesystems or links are not supported. dst must
+// not exist.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	} else if errors.Is(err, os.ErrExist) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
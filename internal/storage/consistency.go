@@ -0,0 +1,349 @@
+// Package storage (consistency.go) keeps the live Bluge index in step with
+// BadgerDB, the authoritative store of hot events. WriteEventBatch writes
+// Badger first and Bluge second, so a failure in between leaves events that
+// search cannot find; a delete that fails half-way leaves index documents
+// without an event. A background scanner compares the two stores window by
+// window, indexes missing events and reports orphaned documents. Reindex
+// rebuilds the whole index from Badger.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/blugeindex"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+const (
+	// consistencyWindow is the span compared at a time; the event IDs of
+	// one window are held in memory.
+	consistencyWindow = time.Hour
+	// consistencySettle keeps the scanner away from batches still being
+	// written to both stores.
+	consistencySettle = time.Minute
+	// maxOrphanIDs caps the orphan IDs listed in a report.
+	maxOrphanIDs = 100
+
+	defaultConsistencyInterval = 60 // minutes
+	defaultConsistencyLookback = 24 // hours
+)
+
+// ErrReindexRunning is returned by Reindex while another reindex runs.
+var ErrReindexRunning = errors.New("storage: a reindex is already running")
+
+// ConsistencyReport is the result of comparing Badger and the live index
+// over [Start, End).
+type ConsistencyReport struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Events      int       `json:"events"`       // events in Badger
+	Missing     int       `json:"missing"`      // events missing from the index
+	Repaired    int       `json:"repaired"`     // missing events indexed
+	OrphanCount int       `json:"orphan_count"` // index documents without an event
+	Orphans     []string  `json:"orphans,omitempty"`
+	Removed     int       `json:"removed"` // orphans deleted (Reindex only)
+}
+
+func (r *ConsistencyReport) addOrphans(ids []string) {
+	r.OrphanCount += len(ids)
+	if n := maxOrphanIDs - len(r.Orphans); n > 0 {
+		r.Orphans = append(r.Orphans, ids[:min(n, len(ids))]...)
+	}
+}
+
+// ReindexProgress reports a running or finished Reindex.
+type ReindexProgress struct {
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started"`
+	Position time.Time `json:"position"` // events before this are done
+	Percent  float64   `json:"percent"`  // share of the time span covered
+	Events   int       `json:"events"`   // events indexed so far
+	Removed  int       `json:"removed"`  // orphaned documents deleted so far
+	Error    string    `json:"error,omitempty"`
+}
+
+// reindexState tracks the current or last Reindex.
+type reindexState struct {
+	mu       sync.Mutex
+	progress ReindexProgress
+}
+
+// ─── Checking ────────────────────────────────────────────────────────────────
+
+// CheckConsistency compares the events in Badger with the live index over
+// [start, end). With repair set, events missing from the index are indexed.
+// Orphaned index documents are only reported.
+func (e *Engine) CheckConsistency(ctx context.Context, start, end time.Time, repair bool) (*ConsistencyReport, error) {
+	rep := &ConsistencyReport{Start: start, End: end}
+	for ws := start; ws.Before(end); ws = ws.Add(consistencyWindow) {
+		we := ws.Add(consistencyWindow)
+		if we.After(end) {
+			we = end
+		}
+		if err := e.syncWindow(ctx, ws, we, repair, false, rep); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// syncWindow compares one window and applies the requested fixes. With
+// reindexAll every event is written to the index again, not only the
+// missing ones.
+func (e *Engine) syncWindow(ctx context.Context, start, end time.Time, repair, reindexAll bool, rep *ConsistencyReport) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	inBadger := make(map[string]bool)
+	var batch []*models.Event
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := e.Bluge.IndexEventBatch(batch); err != nil {
+			return fmt.Errorf("storage: reindex: %w", err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	err := e.Badger.ScanRange(start, end, func(ev *models.Event) error {
+		inBadger[ev.ID] = true
+		if reindexAll {
+			batch = append(batch, ev)
+			rep.Repaired++
+			if len(batch) == reindexBatch {
+				return flush()
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("storage: consistency scan: %w", err)
+	}
+	rep.Events += len(inBadger)
+
+	inIndex := make(map[string]bool, len(inBadger))
+	var orphans []string
+	err = e.Bluge.Each(ctx, &blugeindex.Query{StartTime: start.UnixNano(), EndTime: end.UnixNano()}, func(page *blugeindex.Result) error {
+		for _, id := range page.IDs {
+			if inBadger[id] {
+				inIndex[id] = true
+			} else {
+				orphans = append(orphans, id)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		orphans, err = e.confirmOrphans(orphans)
+	}
+	if err != nil {
+		return fmt.Errorf("storage: consistency scan: %w", err)
+	}
+	rep.addOrphans(orphans)
+	if reindexAll && len(orphans) > 0 {
+		if err := e.Bluge.DeleteEventsBetween(start, end, orphans); err != nil {
+			return fmt.Errorf("storage: reindex: %w", err)
+		}
+		rep.Removed += len(orphans)
+	}
+	if reindexAll {
+		return nil
+	}
+
+	var missing []string
+	for id := range inBadger {
+		if !inIndex[id] {
+			missing = append(missing, id)
+		}
+	}
+	rep.Missing += len(missing)
+	if !repair || len(missing) == 0 {
+		return nil
+	}
+	events, err := e.Badger.GetEvents(missing)
+	if err != nil {
+		return fmt.Errorf("storage: consistency repair: %w", err)
+	}
+	if err := e.Bluge.IndexEventBatch(events); err != nil {
+		return fmt.Errorf("storage: consistency repair: %w", err)
+	}
+	rep.Repaired += len(events)
+	return nil
+}
+
+// confirmOrphans drops the IDs whose events are in Badger after all, having
+// been written after the window was scanned.
+func (e *Engine) confirmOrphans(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	found, err := e.Badger.GetEvents(ids)
+	if err != nil || len(found) == 0 {
+		return ids, err
+	}
+	exists := make(map[string]bool, len(found))
+	for _, ev := range found {
+		exists[ev.ID] = true
+	}
+	out := ids[:0]
+	for _, id := range ids {
+		if !exists[id] {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// StartConsistencyScanner checks the last Consistency.LookbackHours of the
+// hot tier every Consistency.IntervalMinutes, repairing missing index
+// entries. It stops when ctx is cancelled.
+func (e *Engine) StartConsistencyScanner(ctx context.Context, cfg config.ConsistencyConfig) {
+	interval, lookback := cfg.IntervalMinutes, cfg.LookbackHours
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultConsistencyInterval
+	}
+	if lookback <= 0 {
+		lookback = defaultConsistencyLookback
+	}
+	go func() {
+		t := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			end := time.Now().Add(-consistencySettle)
+			rep, err := e.CheckConsistency(ctx, end.Add(-time.Duration(lookback)*time.Hour), end, true)
+			if err != nil {
+				log.Printf("%v", err)
+				continue
+			}
+			if rep.Missing > 0 || rep.OrphanCount > 0 {
+				log.Printf("storage: consistency: %d events missing from the index (%d repaired), %d orphaned index documents",
+					rep.Missing, rep.Repaired, rep.OrphanCount)
+			}
+		}
+	}()
+}
+
+// ─── Reindex ─────────────────────────────────────────────────────────────────
+
+// reindexBatch is the number of events indexed per batch by Reindex.
+const reindexBatch = 1000
+
+// Reindex rebuilds the live index from Badger: every hot event is indexed
+// again and every index document without an event is deleted. It runs
+// while the engine is in use and calls progress after each window. Only
+// one reindex runs at a time.
+func (e *Engine) Reindex(ctx context.Context, progress func(ReindexProgress)) (*ConsistencyReport, error) {
+	rs := &e.reindex
+	rs.mu.Lock()
+	if rs.progress.Running {
+		rs.mu.Unlock()
+		return nil, ErrReindexRunning
+	}
+	rs.progress = ReindexProgress{Running: true, Started: time.Now()}
+	rs.mu.Unlock()
+
+	update := func(f func(p *ReindexProgress)) {
+		rs.mu.Lock()
+		f(&rs.progress)
+		p := rs.progress
+		rs.mu.Unlock()
+		if progress != nil {
+			progress(p)
+		}
+	}
+	rep, err := e.reindexAll(ctx, update)
+	update(func(p *ReindexProgress) {
+		p.Running = false
+		if err != nil {
+			p.Error = err.Error()
+		} else {
+			p.Percent = 100
+		}
+	})
+	return rep, err
+}
+
+func (e *Engine) reindexAll(ctx context.Context, update func(func(*ReindexProgress))) (*ConsistencyReport, error) {
+	rep := &ConsistencyReport{}
+	oldest, ok, err := e.Badger.Oldest()
+	if err != nil {
+		return rep, fmt.Errorf("storage: reindex: %w", err)
+	}
+	newest, _, err := e.Badger.Newest()
+	if err != nil {
+		return rep, fmt.Errorf("storage: reindex: %w", err)
+	}
+	if !ok {
+		oldest, newest = time.Now(), time.Now()
+	}
+	start, end := oldest.Truncate(consistencyWindow), newest.Add(1)
+	rep.Start, rep.End = start, end
+
+	// Documents outside Badger's span have no event by definition.
+	for _, span := range [][2]time.Time{{{}, start}, {end, {}}} {
+		q := &blugeindex.Query{}
+		if !span[0].IsZero() {
+			q.StartTime = span[0].UnixNano()
+		}
+		if !span[1].IsZero() {
+			q.EndTime = span[1].UnixNano()
+		}
+		var orphans []string
+		err := e.Bluge.Each(ctx, q, func(page *blugeindex.Result) error {
+			orphans = append(orphans, page.IDs...)
+			return nil
+		})
+		if err == nil {
+			orphans, err = e.confirmOrphans(orphans)
+		}
+		if err == nil && len(orphans) > 0 {
+			err = e.Bluge.DeleteEventsBetween(span[0], span[1], orphans)
+		}
+		if err != nil {
+			return rep, fmt.Errorf("storage: reindex: %w", err)
+		}
+		rep.addOrphans(orphans)
+		rep.Removed += len(orphans)
+	}
+
+	span := end.Sub(start)
+	for ws := start; ws.Before(end); ws = ws.Add(consistencyWindow) {
+		we := ws.Add(consistencyWindow)
+		if we.After(end) {
+			we = end
+		}
+		if err := e.syncWindow(ctx, ws, we, true, true, rep); err != nil {
+			return rep, err
+		}
+		update(func(p *ReindexProgress) {
+			p.Position, p.Events, p.Removed = we, rep.Repaired, rep.Removed
+			p.Percent = 100 * float64(we.Sub(start)) / float64(span)
+		})
+	}
+	return rep, nil
+}
+
+// ReindexStatus returns the progress of the running or last Reindex.
+func (e *Engine) ReindexStatus() ReindexProgress {
+	e.reindex.mu.Lock()
+	defer e.reindex.mu.Unlock()
+	return e.reindex.progress
+}
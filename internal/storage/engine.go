@@ -3,16 +3,29 @@
 //   - BadgerDB  (badgerstore)  — raw event storage, time-ordered key-value
 //   - Bluge     (blugeindex)   — full-text inverted index for sub-100ms search
 //   - SQLite    (sqlitestore)  — relational metadata: alerts, cases, assets, agents, rules
+//
+// BadgerDB and the live Bluge index, partitioned by event time, form the hot
+// tier. Older events move to compressed archive segments (archive) on the
+// warm and cold tiers, which are searched together with the hot tier.
+//
+// With Storage.Encryption enabled every engine encrypts its files with a
+// subkey of the master key held by keyring.
 package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/archive"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/badgerstore"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/blugeindex"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
 	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
 )
@@ -20,11 +33,26 @@ import (
 // Engine is the top-level storage coordinator.
 // All callers import this package; the sub-packages are internal implementation.
 type Engine struct {
-	Badger *badgerstore.Store
-	Bluge  *blugeindex.Index
-	SQLite *sqlitestore.DB
+	Badger  *badgerstore.Store
+	Bluge   *blugeindex.Index
+	SQLite  *sqlitestore.DB
+	Archive *archive.Manager
+	Keyring *keyring.Keyring // nil unless encryption at rest is enabled
+
+	// IndexReset is set when the live index was corrupt or outdated and was
+	// recreated empty; Reindex restores it from Badger.
+	IndexReset bool
+
+	base      string
+	retention retentionState
+	reindex   reindexState
+	guard     guardState
 }
 
+// DefaultPassphraseEnv holds the master key passphrase unless
+// Encryption.PassphraseEnv names another variable.
+const DefaultPassphraseEnv = "OBLIVRA_PASSPHRASE"
+
 // SearchQuery is the public search API exposed to the Wails frontend.
 // It is translated to blugeindex.Query before being passed to Bluge.
 type SearchQuery struct {
@@ -32,54 +60,209 @@ type SearchQuery struct {
 	Source    string // exact keyword filter
 	Host      string // exact keyword filter
 	Severity  string // exact keyword filter
+	Tenant    string // restrict to one tenant ("" = all tenants)
 	StartTime int64  // unix nano (0 = no lower bound)
 	EndTime   int64  // unix nano (0 = no upper bound)
 	Limit     int    // 0 → default 200
+	Fields    []FieldFilter
+	Cond      *Cond // boolean filter tree, used by the query language
+
+	Sort          []string // e.g. ["-timestamp"] (default), ["host", "-dst_port"]
+	After         string   // SearchResult.Next of the previous page
+	EstimateTotal bool     // skip the exact hit count on very large result sets
+}
+
+// SearchResult is one page of search results.
+type SearchResult struct {
+	Events     []*models.Event `json:"events"`
+	Total      uint64          `json:"total"`
+	TotalExact bool            `json:"total_exact"`
+	Next       string          `json:"next,omitempty"` // empty on the last page
 }
 
+// Aggregation request/result types, see blugeindex for field semantics.
+type (
+	AggRequest   = blugeindex.AggRequest
+	AggResult    = blugeindex.AggResult
+	TermsAgg     = blugeindex.TermsAgg
+	HistogramAgg = blugeindex.HistogramAgg
+	StatsAgg     = blugeindex.StatsAgg
+)
+
+// Cond is a boolean combination of full-text and field filters.
+type Cond = blugeindex.Cond
+
+// FieldFilter is a typed predicate on a dynamic event field
+// (e.g. {Field: "dst_port", Op: "gte", Value: "1024"}).
+type FieldFilter = blugeindex.FieldFilter
+
 // Open initialises all three storage engines from config.
-// All required directories are created automatically.
+// All required directories are created automatically. A restore scheduled
+// with ScheduleRestore is carried out first.
 func Open(ctx context.Context, cfg *config.Config) (*Engine, error) {
 	base := cfg.Storage.BasePath
+	if cfg.Storage.IndexPartitionHours < 0 {
+		return nil, fmt.Errorf("storage: index_partition_hours is negative")
+	}
+	if err := runScheduledRestore(cfg); err != nil {
+		return nil, err
+	}
 
-	bstore, err := badgerstore.Open(filepath.Join(base, "badger", "hot"))
+	var kr *keyring.Keyring
+	key := func(string) []byte { return nil }
+	if enc := cfg.Storage.Encryption; enc.Enabled {
+		var err error
+		if kr, err = unlock(base, enc); err != nil {
+			return nil, fmt.Errorf("storage: unlock master key: %w", err)
+		}
+		key = kr.Key
+	}
+	rotation := time.Duration(cfg.Storage.Encryption.KeyRotationDays) * day
+
+	bstore, err := badgerstore.OpenEncrypted(filepath.Join(base, "badger", "hot"), key(keyring.PurposeBadger), rotation)
 	if err != nil {
 		return nil, fmt.Errorf("storage: open badger: %w", err)
 	}
 
-	bidx, err := blugeindex.Open(filepath.Join(base, "bluge"))
+	// The index can be rebuilt from Badger, so one that is corrupt or
+	// predates time partitions is moved aside and replaced with an empty
+	// one; IndexReset asks for a Reindex. Any other error, such as a lock
+	// held by another process, is returned as is.
+	blugePath := filepath.Join(base, "bluge")
+	openIndex := func() (*blugeindex.Index, error) {
+		return blugeindex.OpenPartitioned(blugePath, fieldConfig(cfg.Storage.FieldIndex), key(keyring.PurposeBluge),
+			time.Duration(cfg.Storage.IndexPartitionHours)*time.Hour)
+	}
+	bidx, err := openIndex()
+	indexReset := false
+	if errors.Is(err, blugeindex.ErrUnpartitioned) || errors.Is(err, blugeindex.ErrCorrupt) {
+		stamp := time.Now().UTC().Format("20060102T150405Z")
+		aside := blugePath + ".broken-" + stamp
+		if errors.Is(err, blugeindex.ErrUnpartitioned) {
+			aside = blugePath + ".unpartitioned-" + stamp
+			log.Printf("storage: the index predates time partitions; moving it to %s and rebuilding", aside)
+		} else {
+			log.Printf("storage: the index is corrupt (%v); moving it to %s", err, aside)
+		}
+		if rerr := os.Rename(blugePath, aside); rerr == nil {
+			bidx, err = openIndex()
+			indexReset = err == nil
+		}
+	}
 	if err != nil {
 		_ = bstore.Close()
 		return nil, fmt.Errorf("storage: open bluge: %w", err)
 	}
 
-	sdb, err := sqlitestore.Open(filepath.Join(base, "sqlite", "oblivra.db"))
+	sqlitePath := filepath.Join(base, "sqlite", "oblivra.db")
+	var sdb *sqlitestore.DB
+	if kr != nil {
+		sdb, err = sqlitestore.OpenEncrypted(sqlitePath, kr.Key(keyring.PurposeSQLite))
+	} else {
+		sdb, err = sqlitestore.Open(sqlitePath)
+	}
 	if err != nil {
 		_ = bstore.Close()
 		_ = bidx.Close()
 		return nil, fmt.Errorf("storage: open sqlite: %w", err)
 	}
 
-	return &Engine{
-		Badger: bstore,
-		Bluge:  bidx,
-		SQLite: sdb,
-	}, nil
+	warm, cold := tierDirs(cfg)
+	arc, err := archive.OpenEncrypted(warm, cold, fieldConfig(cfg.Storage.FieldIndex), key(keyring.PurposeArchive))
+	if err != nil {
+		_ = bstore.Close()
+		_ = bidx.Close()
+		_ = sdb.Close()
+		return nil, fmt.Errorf("storage: open archive: %w", err)
+	}
+
+	spoolPath := cfg.Storage.Guardian.SpoolPath
+	if spoolPath == "" {
+		spoolPath = filepath.Join(base, "spool")
+	}
+	sp, err := openSpool(spoolPath, key(keyring.PurposeSpool), cfg.Storage.Guardian.SpoolMaxMB)
+	if err != nil {
+		_ = bstore.Close()
+		_ = bidx.Close()
+		_ = sdb.Close()
+		_ = arc.Close()
+		return nil, err
+	}
+
+	e := &Engine{
+		Badger:     bstore,
+		Bluge:      bidx,
+		SQLite:     sdb,
+		Archive:    arc,
+		Keyring:    kr,
+		IndexReset: indexReset,
+		base:       base,
+	}
+	e.guard.spool = sp
+	return e, nil
+}
+
+// unlock opens the master key in {base}/keys with the configured key file or
+// passphrase.
+func unlock(base string, enc config.EncryptionConfig) (*keyring.Keyring, error) {
+	secret := keyring.Secret{KeyFile: enc.KeyFile}
+	if secret.KeyFile == "" {
+		env := enc.PassphraseEnv
+		if env == "" {
+			env = DefaultPassphraseEnv
+		}
+		if secret.Passphrase = os.Getenv(env); secret.Passphrase == "" {
+			return nil, fmt.Errorf("set %s or encryption.key_file", env)
+		}
+	}
+	return keyring.Unlock(filepath.Join(base, "keys"), secret)
+}
+
+// tierDirs returns the warm and cold archive directories.
+func tierDirs(cfg *config.Config) (warm, cold string) {
+	warm, cold = cfg.Storage.Tiers.WarmPath, cfg.Storage.Tiers.ColdPath
+	if warm == "" {
+		warm = filepath.Join(cfg.Storage.BasePath, "archive", "warm")
+	}
+	if cold == "" {
+		cold = filepath.Join(cfg.Storage.BasePath, "archive", "cold")
+	}
+	return warm, cold
+}
+
+func fieldConfig(fc config.FieldIndexConfig) blugeindex.FieldConfig {
+	out := blugeindex.FieldConfig{
+		MaxFields:      fc.MaxFields,
+		MaxCardinality: fc.MaxCardinality,
+		Rules:          make(map[string]blugeindex.FieldRule, len(fc.Fields)),
+	}
+	for name, r := range fc.Fields {
+		out.Rules[name] = blugeindex.FieldRule{Type: r.Type, MaxCardinality: r.MaxCardinality}
+	}
+	return out
 }
 
 // WriteEvent writes a single event to BadgerDB (raw) and Bluge (index).
 func (e *Engine) WriteEvent(ctx context.Context, ev *models.Event) error {
-	if err := e.Badger.PutEvent(ev); err != nil {
-		return fmt.Errorf("storage: badger write: %w", err)
+	return e.WriteEventBatch(ctx, []*models.Event{ev})
+}
+
+// WriteEventBatch writes a batch of events atomically to both stores. Under
+// disk pressure low-priority routes are sampled, and once writes are
+// refused the batch is spooled instead; ErrStorageFull is returned when the
+// spool is full as well (see guardian.go).
+func (e *Engine) WriteEventBatch(ctx context.Context, events []*models.Event) error {
+	events = e.guard.sample(events)
+	if len(events) == 0 {
+		return nil
 	}
-	if err := e.Bluge.IndexEvent(ev); err != nil {
-		return fmt.Errorf("storage: bluge index: %w", err)
+	if PressureLevel(e.guard.level.Load()) >= PressureRefuse {
+		return e.guard.spool.add(events)
 	}
-	return nil
+	return e.writeEvents(events)
 }
 
-// WriteEventBatch writes a batch of events atomically to both stores.
-func (e *Engine) WriteEventBatch(ctx context.Context, events []*models.Event) error {
+func (e *Engine) writeEvents(events []*models.Event) error {
 	if err := e.Badger.PutEventBatch(events); err != nil {
 		return fmt.Errorf("storage: badger batch: %w", err)
 	}
@@ -91,27 +274,144 @@ func (e *Engine) WriteEventBatch(ctx context.Context, events []*models.Event) er
 
 // SearchEvents executes a query: Bluge returns IDs, BadgerDB returns full payloads.
 func (e *Engine) SearchEvents(ctx context.Context, q *SearchQuery) ([]*models.Event, error) {
-	ids, err := e.Bluge.Search(&blugeindex.Query{
-		Text:      q.Text,
-		Source:    q.Source,
-		Host:      q.Host,
-		Severity:  q.Severity,
-		StartTime: q.StartTime,
-		EndTime:   q.EndTime,
-		Limit:     q.Limit,
-	})
+	res, err := e.SearchEventsPage(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return res.Events, nil
+}
+
+// SearchEventsPage executes a query and returns one page of events along
+// with the total hit count and a cursor for the next page. Archive segments
+// overlapping the query's time range are searched with the hot index.
+func (e *Engine) SearchEventsPage(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	view := e.Archive.View(q.StartTime, q.EndTime)
+	defer view.Release()
+
+	page, err := e.Bluge.SearchPage(q.blugeQuery(), view.Indexes()...)
 	if err != nil {
 		return nil, fmt.Errorf("storage: bluge search: %w", err)
 	}
-	if len(ids) == 0 {
-		return nil, nil
+	res := &SearchResult{Total: page.Total, TotalExact: page.TotalExact, Next: page.Next}
+	if len(page.IDs) == 0 {
+		return res, nil
+	}
+	if res.Events, err = e.loadEvents(page, view); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// loadEvents reads the payloads of a page of hits, in hit order, from
+// BadgerDB or the archive depending on where each hit was found.
+func (e *Engine) loadEvents(page *blugeindex.Result, view *archive.View) ([]*models.Event, error) {
+	if page.Refs == nil {
+		return e.Badger.GetEvents(page.IDs)
+	}
+	var hot []string
+	for i, id := range page.IDs {
+		if page.Refs[i] == "" {
+			hot = append(hot, id)
+		}
+	}
+	hotEvents, err := e.Badger.GetEvents(hot)
+	if err != nil {
+		return nil, err
+	}
+	byID, err := view.Events(page.IDs, page.Refs)
+	if err != nil {
+		return nil, fmt.Errorf("storage: archive read: %w", err)
+	}
+	for _, ev := range hotEvents {
+		byID[ev.ID] = ev
+	}
+	out := make([]*models.Event, 0, len(page.IDs))
+	for _, id := range page.IDs {
+		if ev := byID[id]; ev != nil {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+// GetEvent returns one event by ID from the hot tier or, failing that, the
+// archive.
+func (e *Engine) GetEvent(id string) (*models.Event, error) {
+	ev, err := e.Badger.GetEvent(id)
+	if err == nil {
+		return ev, nil
+	}
+	view := e.Archive.View(0, 0)
+	defer view.Release()
+	if arc, aerr := view.Find(id); aerr != nil {
+		return nil, fmt.Errorf("storage: archive read: %w", aerr)
+	} else if arc != nil {
+		return arc, nil
+	}
+	return nil, err
+}
+
+// ExportEvents streams every event matching q, in sort order, to fn without
+// holding the result set in memory. q.Limit and q.After are ignored.
+func (e *Engine) ExportEvents(ctx context.Context, q *SearchQuery, fn func(*models.Event) error) error {
+	view := e.Archive.View(q.StartTime, q.EndTime)
+	defer view.Release()
+
+	err := e.Bluge.Each(ctx, q.blugeQuery(), func(page *blugeindex.Result) error {
+		events, err := e.loadEvents(page, view)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, view.Indexes()...)
+	if err != nil {
+		return fmt.Errorf("storage: export: %w", err)
+	}
+	return nil
+}
+
+// Aggregate computes facets, a date histogram, cardinalities and numeric
+// statistics over the events matching q, entirely from the index.
+func (e *Engine) Aggregate(ctx context.Context, q *SearchQuery, req *AggRequest) (*AggResult, error) {
+	view := e.Archive.View(q.StartTime, q.EndTime)
+	defer view.Release()
+
+	res, err := e.Bluge.Aggregate(ctx, q.blugeQuery(), req, view.Indexes()...)
+	if err != nil {
+		return nil, fmt.Errorf("storage: aggregate: %w", err)
+	}
+	return res, nil
+}
+
+func (q *SearchQuery) blugeQuery() *blugeindex.Query {
+	return &blugeindex.Query{
+		Text:          q.Text,
+		Source:        q.Source,
+		Host:          q.Host,
+		Severity:      q.Severity,
+		Tenant:        q.Tenant,
+		StartTime:     q.StartTime,
+		EndTime:       q.EndTime,
+		Limit:         q.Limit,
+		Fields:        q.Fields,
+		Cond:          q.Cond,
+		Sort:          q.Sort,
+		After:         q.After,
+		EstimateTotal: q.EstimateTotal,
 	}
-	return e.Badger.GetEvents(ids)
 }
 
 // Close shuts down all three engines gracefully.
 func (e *Engine) Close() error {
 	var errs []error
+	if err := e.Archive.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("archive: %w", err))
+	}
 	if err := e.Bluge.Close(); err != nil {
 		errs = append(errs, fmt.Errorf("bluge: %w", err))
 	}
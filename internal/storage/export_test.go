@@ -0,0 +1,7 @@
+package storage
+
+import "time"
+
+// SetArchiveScanned sets the hook archiveDay runs between scanning a day
+// and removing the scanned events.
+func SetArchiveScanned(f func(start, end time.Time)) { archiveScanned = f }
@@ -0,0 +1,448 @@
+// Package storage (guardian.go) protects the storage engines from running
+// out of space. A background guardian measures the free space of the file
+// system under BasePath and each engine's usage against its quota, and
+// acts on the lowest headroom in graduated steps:
+//   - warn:   raise an alert,
+//   - sample: keep one in SampleRate events of low-priority routes,
+//   - purge:  delete the oldest events not under a legal hold, one archive
+//     segment or hot UTC day per check,
+//   - refuse: spool event batches to disk instead of writing them, and
+//     refuse ingestion once the spool is full.
+//
+// Spooled batches are written once the pressure falls below the refuse
+// watermark.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+const (
+	defaultGuardianInterval = 30 // seconds
+	defaultWarnPercent      = 15
+	defaultSamplePercent    = 10
+	defaultPurgePercent     = 5
+	defaultRefusePercent    = 2
+	defaultSampleRate       = 10
+
+	// guardianRuleID is the rule ID of the alerts raised by the guardian.
+	guardianRuleID = "storage-guardian"
+)
+
+var defaultLowPriorityRoutes = []string{"netflow", "pcap"}
+
+// PressureLevel is the action the guardian takes against disk pressure;
+// each level includes those below it.
+type PressureLevel int32
+
+const (
+	PressureNormal PressureLevel = iota
+	PressureWarn
+	PressureSample
+	PressurePurge
+	PressureRefuse
+)
+
+func (l PressureLevel) String() string {
+	switch l {
+	case PressureWarn:
+		return "warn"
+	case PressureSample:
+		return "sample"
+	case PressurePurge:
+		return "purge"
+	case PressureRefuse:
+		return "refuse"
+	}
+	return "normal"
+}
+
+// MarshalText encodes the level by name.
+func (l PressureLevel) MarshalText() ([]byte, error) { return []byte(l.String()), nil }
+
+// GuardianStatus is the outcome of the guardian's last check.
+type GuardianStatus struct {
+	Enabled        bool               `json:"enabled"`
+	Level          PressureLevel      `json:"level"`
+	Checked        time.Time          `json:"checked"`
+	DiskFreeBytes  uint64             `json:"disk_free_bytes"`
+	DiskTotalBytes uint64             `json:"disk_total_bytes"`
+	Headroom       map[string]float64 `json:"headroom"` // percent, for "disk" and each engine with a quota
+	Sampled        int64              `json:"sampled"`  // low-priority events dropped by sampling
+	SpoolBatches   int                `json:"spool_batches"`
+	SpoolBytes     int64              `json:"spool_bytes"`
+	LastPurge      *PurgeReport       `json:"last_purge,omitempty"`
+	Error          string             `json:"error,omitempty"`
+}
+
+// guardState is the guardian's state in Engine. level and sampling are read
+// on every write, so they are atomics.
+type guardState struct {
+	level    atomic.Int32
+	sampling atomic.Pointer[sampling]
+	seen     atomic.Uint64 // low-priority events seen while sampling
+	sampled  atomic.Int64
+	spool    *spool
+
+	mu     sync.Mutex
+	status GuardianStatus
+}
+
+type sampling struct {
+	routes map[string]bool
+	rate   uint64
+}
+
+// watermarks holds the headroom percentages below which each level starts.
+type watermarks struct {
+	warn, sample, purge, refuse float64
+}
+
+func (w watermarks) level(headroom float64) PressureLevel {
+	switch {
+	case headroom < w.refuse:
+		return PressureRefuse
+	case headroom < w.purge:
+		return PressurePurge
+	case headroom < w.sample:
+		return PressureSample
+	case headroom < w.warn:
+		return PressureWarn
+	}
+	return PressureNormal
+}
+
+func orDefault(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// guardianSettings returns the watermarks and sampling set by cfg.
+func guardianSettings(cfg config.GuardianConfig) (watermarks, *sampling) {
+	wm := watermarks{
+		warn:   float64(orDefault(cfg.WarnPercent, defaultWarnPercent)),
+		sample: float64(orDefault(cfg.SamplePercent, defaultSamplePercent)),
+		purge:  float64(orDefault(cfg.PurgePercent, defaultPurgePercent)),
+		refuse: float64(orDefault(cfg.RefusePercent, defaultRefusePercent)),
+	}
+	routes := cfg.LowPriorityRoutes
+	if routes == nil {
+		routes = defaultLowPriorityRoutes
+	}
+	s := &sampling{routes: make(map[string]bool, len(routes)), rate: uint64(orDefault(cfg.SampleRate, defaultSampleRate))}
+	for _, r := range routes {
+		s.routes[r] = true
+	}
+	return wm, s
+}
+
+// StartGuardian runs CheckPressure now and then every
+// Guardian.IntervalSeconds. It stops when ctx is cancelled.
+func (e *Engine) StartGuardian(ctx context.Context, cfg config.GuardianConfig, alert func(*models.Alert)) {
+	interval := cfg.IntervalSeconds
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultGuardianInterval
+	}
+	go func() {
+		t := time.NewTicker(time.Duration(interval) * time.Second)
+		defer t.Stop()
+		for {
+			e.CheckPressure(cfg, alert)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// CheckPressure measures headroom, sets the pressure level and takes its
+// actions, and returns the resulting status. alert, unless nil, is called
+// whenever the level rises to warn or above.
+func (e *Engine) CheckPressure(cfg config.GuardianConfig, alert func(*models.Alert)) GuardianStatus {
+	g := &e.guard
+	wm, smp := guardianSettings(cfg)
+	g.sampling.Store(smp)
+	st := GuardianStatus{Enabled: true, Checked: time.Now(), Headroom: map[string]float64{}}
+	free, total, err := diskSpace(e.base)
+	if err != nil {
+		st.Error = fmt.Sprintf("disk space: %v", err)
+	} else if total > 0 {
+		st.DiskFreeBytes, st.DiskTotalBytes = free, total
+		st.Headroom["disk"] = 100 * float64(free) / float64(total)
+	}
+	usage := e.engineUsage()
+	for name, quota := range map[string]int{
+		"badger":  cfg.BadgerQuotaMB,
+		"bluge":   cfg.BlugeQuotaMB,
+		"sqlite":  cfg.SQLiteQuotaMB,
+		"archive": cfg.ArchiveQuotaMB,
+	} {
+		if quota > 0 {
+			limit := float64(int64(quota) << 20)
+			st.Headroom[name] = 100 * (limit - float64(usage[name])) / limit
+		}
+	}
+	level := PressureNormal
+	for _, h := range st.Headroom {
+		level = max(level, wm.level(h))
+	}
+	prev := PressureLevel(g.level.Swap(int32(level)))
+	if level != prev {
+		log.Printf("storage: guardian: disk pressure %s → %s (headroom %s)", prev, level, formatHeadroom(st.Headroom))
+		if level > prev && level >= PressureWarn && alert != nil {
+			alert(guardianAlert(level, st.Headroom))
+		}
+	}
+
+	if level >= PressurePurge {
+		rep, err := e.emergencyPurge()
+		if err != nil {
+			log.Printf("storage: guardian: emergency purge: %v", err)
+		}
+		if rep != nil {
+			st.LastPurge = rep
+		}
+	}
+	if level < PressureRefuse {
+		if n, err := g.spool.replay(spoolReplayBatches, e.writeEvents); err != nil {
+			log.Printf("storage: guardian: %v", err)
+		} else if n > 0 {
+			log.Printf("storage: guardian: wrote %d spooled events", n)
+		}
+	}
+
+	st.Level = level
+	st.Sampled = g.sampled.Load()
+	st.SpoolBatches, st.SpoolBytes = g.spool.usage()
+	g.mu.Lock()
+	if st.LastPurge == nil {
+		st.LastPurge = g.status.LastPurge
+	}
+	g.status = st
+	g.mu.Unlock()
+	return st
+}
+
+func formatHeadroom(h map[string]float64) string {
+	s := ""
+	for _, name := range []string{"disk", "badger", "bluge", "sqlite", "archive"} {
+		if v, ok := h[name]; ok {
+			if s != "" {
+				s += ", "
+			}
+			s += fmt.Sprintf("%s %.1f%%", name, v)
+		}
+	}
+	return s
+}
+
+func guardianAlert(level PressureLevel, headroom map[string]float64) *models.Alert {
+	sev := models.SeverityMedium
+	switch level {
+	case PressurePurge:
+		sev = models.SeverityHigh
+	case PressureRefuse:
+		sev = models.SeverityCritical
+	}
+	now := time.Now()
+	meta := map[string]string{"level": level.String()}
+	for name, v := range headroom {
+		meta["headroom_"+name] = fmt.Sprintf("%.1f", v)
+	}
+	return &models.Alert{
+		ID:        fmt.Sprintf("guard_%d", now.UnixNano()),
+		RuleID:    guardianRuleID,
+		Timestamp: now,
+		Severity:  sev,
+		Title:     "Storage under disk pressure: " + level.String(),
+		Summary:   "Storage headroom is low (" + formatHeadroom(headroom) + "); the guardian is now at level " + level.String(),
+		Status:    "open",
+		Metadata:  meta,
+	}
+}
+
+// GuardianStatus returns the outcome of the guardian's last check.
+func (e *Engine) GuardianStatus() GuardianStatus {
+	g := &e.guard
+	g.mu.Lock()
+	st := g.status
+	g.mu.Unlock()
+	st.Level = PressureLevel(g.level.Load())
+	st.Sampled = g.sampled.Load()
+	st.SpoolBatches, st.SpoolBytes = g.spool.usage()
+	return st
+}
+
+// Admit reports whether ingestion should accept more events: it returns
+// ErrStorageFull while writes are refused and the spool is full.
+func (e *Engine) Admit() error {
+	if PressureLevel(e.guard.level.Load()) >= PressureRefuse && e.guard.spool.full() {
+		return ErrStorageFull
+	}
+	return nil
+}
+
+// sample drops all but one in rate events of low-priority routes while the
+// level is sample or above. Detection has already seen the dropped events.
+func (g *guardState) sample(events []*models.Event) []*models.Event {
+	s := g.sampling.Load()
+	if s == nil || PressureLevel(g.level.Load()) < PressureSample {
+		return events
+	}
+	kept := events[:0:0]
+	for _, ev := range events {
+		if s.routes[ev.Metadata[models.MetaRoute]] && g.seen.Add(1)%s.rate != 0 {
+			g.sampled.Add(1)
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	return kept
+}
+
+// ─── Usage ───────────────────────────────────────────────────────────────────
+
+// engineUsage returns the bytes on disk of each engine.
+func (e *Engine) engineUsage() map[string]int64 {
+	st := e.Stats()
+	return map[string]int64{
+		"badger":  st.BadgerLSMBytes + st.BadgerVLogBytes,
+		"bluge":   st.BlugeBytes,
+		"sqlite":  st.SQLiteBytes,
+		"archive": st.WarmBytes + st.ColdBytes,
+	}
+}
+
+func dirSize(dir string) int64 {
+	var n int64
+	filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+// ─── Emergency purge ─────────────────────────────────────────────────────────
+
+// emergencyPurge deletes the oldest events not under a legal hold: those
+// of the oldest archive segment holding any, or else of the oldest hot UTC
+// day before today. It is audited like a retention run. Badger returns the
+// space through its value log GC.
+func (e *Engine) emergencyPurge() (*PurgeReport, error) {
+	rep := &PurgeReport{Started: time.Now(), ByClass: map[string]int{}}
+	holds, err := e.loadLegalHolds()
+	if err != nil {
+		return nil, err
+	}
+	done, err := e.purgeOldestSegment(holds, rep)
+	if err == nil && !done {
+		done, err = e.purgeOldestDay(holds, rep)
+	}
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+	}
+	if !done && err == nil {
+		return nil, nil // nothing left to purge
+	}
+	rep.DurationMs = time.Since(rep.Started).Milliseconds()
+	e.auditPurge("emergency_purge", rep)
+	log.Printf("storage: guardian: emergency purge deleted %d hot and %d archived events", rep.HotDeleted, rep.ArchiveDeleted)
+	return rep, err
+}
+
+func (e *Engine) purgeOldestSegment(holds *legalHolds, rep *PurgeReport) (bool, error) {
+	for _, seg := range e.Archive.List() {
+		drop := make(map[string]bool)
+		held := 0
+		err := e.Archive.Scan(seg.ID, func(ev *models.Event) error {
+			if holds.pins(ev) {
+				held++
+			} else {
+				drop[ev.ID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return false, fmt.Errorf("segment %s: %w", seg.ID, err)
+		}
+		if len(drop) == 0 {
+			continue
+		}
+		st := &e.retention
+		st.mu.Lock()
+		_, err = e.Archive.Rewrite(seg.ID, drop)
+		delete(st.due, seg.ID)
+		st.mu.Unlock()
+		if err != nil {
+			return false, fmt.Errorf("segment %s: %w", seg.ID, err)
+		}
+		rep.Held += held
+		rep.ArchiveDeleted += len(drop)
+		if held == 0 {
+			rep.SegmentsDeleted++
+		} else {
+			rep.SegmentsRewritten++
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (e *Engine) purgeOldestDay(holds *legalHolds, rep *PurgeReport) (bool, error) {
+	oldest, ok, err := e.Badger.Oldest()
+	if err != nil || !ok {
+		return false, err
+	}
+	today := time.Now().UTC().Truncate(day)
+	for start := oldest.UTC().Truncate(day); start.Before(today); start = start.Add(day) {
+		end := start.Add(day)
+		var ids []string
+		err := e.Badger.ScanRange(start, end, func(ev *models.Event) error {
+			if holds.pins(ev) {
+				rep.Held++
+			} else {
+				ids = append(ids, ev.ID)
+			}
+			return nil
+		})
+		if err != nil || len(ids) == 0 {
+			if err != nil {
+				return false, fmt.Errorf("hot tier %s: %w", start.Format("2006-01-02"), err)
+			}
+			continue
+		}
+		for i := 0; i < len(ids); i += purgeBatch {
+			n, err := e.Badger.DeleteEvents(ids[i:min(i+purgeBatch, len(ids))])
+			rep.HotDeleted += n
+			if err != nil {
+				return true, fmt.Errorf("hot tier %s: %w", start.Format("2006-01-02"), err)
+			}
+		}
+		if err := e.pruneIndex(start, end, ids); err != nil {
+			return true, fmt.Errorf("hot tier %s: remove from index: %w", start.Format("2006-01-02"), err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,302 @@
+// Package keyring holds the master key that encrypts OBLIVRA data at rest.
+//
+// The master key is 32 random bytes stored in {dir}/master.json, sealed with
+// AES-256-GCM under a key-encryption key (KEK). The KEK is derived from a
+// passphrase with Argon2id or read from a key file. Every storage engine
+// gets its own subkey derived from the master key with HKDF-SHA256, so
+// changing the passphrase only re-seals master.json.
+//
+// Small values are sealed with Seal/Open. Large files use a chunked stream
+// format (NewWriter/Decrypt): an 8-byte magic and 12-byte nonce base, then
+// 64 KiB chunks each sealed with the nonce base XOR the chunk index and the
+// final chunk flagged in the additional data, so truncation is detected.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Subkey purposes.
+const (
+	PurposeBadger    = "badger"
+	PurposeBluge     = "bluge"
+	PurposeSQLite    = "sqlite"
+	PurposeArchive   = "archive"
+	PurposeForensics = "forensics"
+	PurposeBackup    = "backup"
+	PurposeSpool     = "spool"
+)
+
+const (
+	fileName = "master.json"
+	keySize  = 32
+
+	kdfArgon2  = "argon2id"
+	kdfKeyFile = "keyfile"
+
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+)
+
+// ErrWrongKey is returned when the passphrase or key file does not unlock
+// the master key.
+var ErrWrongKey = errors.New("keyring: wrong passphrase or key file")
+
+// Secret unlocks the master key: a passphrase, or a key file holding 32 raw
+// bytes or 64 hex characters. KeyFile wins when both are set.
+type Secret struct {
+	Passphrase string
+	KeyFile    string
+}
+
+// sealedMaster is the on-disk form of the master key.
+type sealedMaster struct {
+	Version int       `json:"version"`
+	KDF     string    `json:"kdf"`
+	Salt    []byte    `json:"salt,omitempty"`
+	Time    uint32    `json:"time,omitempty"`
+	Memory  uint32    `json:"memory_kib,omitempty"`
+	Threads uint8     `json:"threads,omitempty"`
+	Key     []byte    `json:"key"` // nonce || AES-GCM(master)
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Keyring is an unlocked master key.
+type Keyring struct {
+	dir    string
+	master []byte
+}
+
+// Unlock opens the master key in dir with s, creating a new master key on
+// first use.
+func Unlock(dir string, s Secret) (*Keyring, error) {
+	path := filepath.Join(dir, fileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return create(dir, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring: read %s: %w", path, err)
+	}
+	var sm sealedMaster
+	if err := json.Unmarshal(data, &sm); err != nil {
+		return nil, fmt.Errorf("keyring: parse %s: %w", path, err)
+	}
+	if (sm.KDF == kdfKeyFile) != (s.KeyFile != "") {
+		return nil, fmt.Errorf("keyring: master key is sealed with %s", sm.KDF)
+	}
+	kek, err := sm.kek(s)
+	if err != nil {
+		return nil, err
+	}
+	master, err := open(kek, sm.Key, []byte(fileName))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return &Keyring{dir: dir, master: master}, nil
+}
+
+func create(dir string, s Secret) (*Keyring, error) {
+	master := make([]byte, keySize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, fmt.Errorf("keyring: generate master key: %w", err)
+	}
+	k := &Keyring{dir: dir, master: master}
+	if err := k.Rewrap(s); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Path returns the file holding the sealed master key.
+func (k *Keyring) Path() string {
+	return filepath.Join(k.dir, fileName)
+}
+
+// Rewrap seals the master key under a new secret, e.g. after a passphrase
+// change. Data keys are unchanged, so nothing else is re-encrypted.
+func (k *Keyring) Rewrap(s Secret) error {
+	now := time.Now().UTC()
+	sm := sealedMaster{Version: 1, Created: now, Updated: now}
+	if old, err := os.ReadFile(filepath.Join(k.dir, fileName)); err == nil {
+		var prev sealedMaster
+		if json.Unmarshal(old, &prev) == nil && !prev.Created.IsZero() {
+			sm.Created = prev.Created
+		}
+	}
+	if s.KeyFile != "" {
+		sm.KDF = kdfKeyFile
+	} else {
+		sm.KDF = kdfArgon2
+		sm.Salt = make([]byte, 16)
+		if _, err := rand.Read(sm.Salt); err != nil {
+			return fmt.Errorf("keyring: salt: %w", err)
+		}
+		sm.Time, sm.Memory, sm.Threads = argonTime, argonMemory, argonThreads
+	}
+	kek, err := sm.kek(s)
+	if err != nil {
+		return err
+	}
+	if sm.Key, err = seal(kek, k.master, []byte(fileName)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sm, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(k.dir, 0o700); err != nil {
+		return fmt.Errorf("keyring: mkdir: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(k.dir, fileName), data)
+}
+
+// kek derives the key-encryption key for s.
+func (sm *sealedMaster) kek(s Secret) ([]byte, error) {
+	if sm.KDF == kdfKeyFile {
+		return readKeyFile(s.KeyFile)
+	}
+	if s.Passphrase == "" {
+		return nil, errors.New("keyring: no passphrase or key file given")
+	}
+	return argon2.IDKey([]byte(s.Passphrase), sm.Salt, sm.Time, sm.Memory, sm.Threads, keySize), nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: read key file: %w", err)
+	}
+	if len(data) == keySize {
+		return data, nil
+	}
+	if key, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil && len(key) == keySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("keyring: key file must hold %d raw bytes or %d hex characters", keySize, 2*keySize)
+}
+
+// Key returns the 32-byte subkey for purpose.
+func (k *Keyring) Key(purpose string) []byte {
+	key, err := hkdf.Key(sha256.New, k.master, nil, "oblivra/"+purpose, keySize)
+	if err != nil {
+		panic(err) // only fails for oversized output
+	}
+	return key
+}
+
+// Seal encrypts a small value with the purpose subkey.
+func (k *Keyring) Seal(purpose string, plaintext []byte) ([]byte, error) {
+	return seal(k.Key(purpose), plaintext, []byte(purpose))
+}
+
+// Open decrypts a value sealed with Seal for the same purpose.
+func (k *Keyring) Open(purpose string, sealed []byte) ([]byte, error) {
+	out, err := open(k.Key(purpose), sealed, []byte(purpose))
+	if err != nil {
+		return nil, fmt.Errorf("keyring: open %s value: %w", purpose, err)
+	}
+	return out, nil
+}
+
+// SealWithKey encrypts a small value with key, binding it to aad, as
+// nonce || AES-GCM ciphertext.
+func SealWithKey(key, plaintext, aad []byte) ([]byte, error) {
+	return seal(key, plaintext, aad)
+}
+
+// OpenWithKey decrypts a value sealed with SealWithKey.
+func OpenWithKey(key, sealed, aad []byte) ([]byte, error) {
+	out, err := open(key, sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: open value: %w", err)
+	}
+	return out, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("keyring: nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], aad)
+}
+
+// writeFileAtomic writes data to path through a synced temporary file and a
+// rename, so readers see either the old or the new contents.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("keyring: write %s: %w", path, err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("keyring: write %s: %w", path, err)
+	}
+	return nil
+}
+
+// chunkNonce is base XOR the big-endian chunk index.
+func chunkNonce(base []byte, i uint64) []byte {
+	n := make([]byte, len(base))
+	copy(n, base)
+	var idx [8]byte
+	binary.BigEndian.PutUint64(idx[:], i)
+	for j := range idx {
+		n[len(n)-8+j] ^= idx[j]
+	}
+	return n
+}
@@ -0,0 +1,112 @@
+package keyring_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
+)
+
+func TestUnlockPassphraseAndRewrap(t *testing.T) {
+	dir := t.TempDir()
+	k, err := keyring.Unlock(dir, keyring.Secret{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := k.Seal(keyring.PurposeForensics, []byte("signing key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(k.Key(keyring.PurposeBadger), k.Key(keyring.PurposeSQLite)) {
+		t.Error("subkeys for different purposes are equal")
+	}
+
+	if _, err := keyring.Unlock(dir, keyring.Secret{Passphrase: "wrong"}); !errors.Is(err, keyring.ErrWrongKey) {
+		t.Fatalf("wrong passphrase: err = %v", err)
+	}
+	if _, err := keyring.Unlock(dir, keyring.Secret{}); err == nil {
+		t.Fatal("unlocked without a secret")
+	}
+
+	again, err := keyring.Unlock(dir, keyring.Secret{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := again.Open(keyring.PurposeForensics, sealed); err != nil || string(got) != "signing key" {
+		t.Fatalf("open = %q, %v", got, err)
+	}
+	if _, err := again.Open(keyring.PurposeArchive, sealed); err == nil {
+		t.Error("value opened under another purpose")
+	}
+
+	// Switching to a key file keeps the master key.
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := again.Rewrap(keyring.Secret{KeyFile: keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Unlock(dir, keyring.Secret{Passphrase: "correct horse"}); err == nil {
+		t.Fatal("old passphrase still unlocks after rewrap")
+	}
+	byFile, err := keyring.Unlock(dir, keyring.Secret{KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(byFile.Key(keyring.PurposeBluge), k.Key(keyring.PurposeBluge)) {
+		t.Error("rewrap changed the data keys")
+	}
+}
+
+func TestStream(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	path := filepath.Join(t.TempDir(), "data.enc")
+	for _, size := range []int{0, 10, 64 << 10, 200_000} {
+		data := bytes.Repeat([]byte("x"), size)
+		if err := keyring.WriteFile(path, data, key); err != nil {
+			t.Fatal(err)
+		}
+		got, err := keyring.ReadFile(path, key)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("size %d: round trip = %d bytes, %v", size, len(got), err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := keyring.NewReader(f, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = io.ReadAll(r)
+		f.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("size %d: streamed round trip = %d bytes, %v", size, len(got), err)
+		}
+	}
+
+	raw, _ := os.ReadFile(path)
+	if !keyring.IsEncrypted(raw) || bytes.Contains(raw, []byte("xxxx")) {
+		t.Fatal("file is not encrypted")
+	}
+	// Dropping the final chunk must not go unnoticed.
+	if _, err := keyring.Decrypt(raw[:len(raw)-(200_000-2*(64<<10))-16], key); err == nil {
+		t.Error("truncated stream decrypted")
+	}
+	r, err := keyring.NewReader(bytes.NewReader(raw[:keyring.HeaderSize+2*(64<<10+16)]), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("truncated stream read without error")
+	}
+	if _, err := keyring.Decrypt(raw, bytes.Repeat([]byte{8}, 32)); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+}
@@ -0,0 +1,218 @@
+package keyring
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	magic     = "OBLVENC1"
+	nonceSize = 12
+	chunkSize = 64 << 10
+	tagSize   = 16
+)
+
+// HeaderSize is the length of the stream header checked by IsEncrypted.
+const HeaderSize = len(magic) + nonceSize
+
+var (
+	aadChunk = []byte{0}
+	aadFinal = []byte{1}
+)
+
+// IsEncrypted reports whether data starts with the stream format header.
+func IsEncrypted(data []byte) bool {
+	return len(data) >= HeaderSize && string(data[:len(magic)]) == magic
+}
+
+// streamWriter encrypts everything written to it in chunks.
+type streamWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	base  []byte
+	buf   []byte
+	index uint64
+	out   []byte
+}
+
+// NewWriter returns a writer that encrypts to w with key. Close writes the
+// final chunk; it does not close w.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	base := make([]byte, nonceSize)
+	if _, err := rand.Read(base); err != nil {
+		return nil, fmt.Errorf("keyring: nonce: %w", err)
+	}
+	if _, err := w.Write(append([]byte(magic), base...)); err != nil {
+		return nil, err
+	}
+	return &streamWriter{w: w, aead: aead, base: base, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(s.buf) == chunkSize {
+			if err := s.flush(aadChunk); err != nil {
+				return n - len(p), err
+			}
+		}
+		c := copy(s.buf[len(s.buf):chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p = p[c:]
+	}
+	return n, nil
+}
+
+func (s *streamWriter) flush(aad []byte) error {
+	s.out = s.aead.Seal(s.out[:0], chunkNonce(s.base, s.index), s.buf, aad)
+	s.index++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(s.out)
+	return err
+}
+
+// Close seals the buffered data as the final chunk, which may be empty.
+func (s *streamWriter) Close() error {
+	if s.aead == nil {
+		return nil
+	}
+	err := s.flush(aadFinal)
+	s.aead = nil
+	return err
+}
+
+// Decrypt opens a complete stream written by NewWriter.
+func Decrypt(data, key []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("keyring: not an encrypted stream")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	base := data[len(magic):HeaderSize]
+	body := data[HeaderSize:]
+	out := make([]byte, 0, len(body))
+	for i := uint64(0); ; i++ {
+		n := min(len(body), chunkSize+tagSize)
+		final := n == len(body)
+		aad := aadChunk
+		if final {
+			aad = aadFinal
+		}
+		if out, err = aead.Open(out, chunkNonce(base, i), body[:n], aad); err != nil {
+			return nil, fmt.Errorf("keyring: decrypt chunk %d: %w", i, err)
+		}
+		if final {
+			return out, nil
+		}
+		body = body[n:]
+	}
+}
+
+// streamReader decrypts a stream chunk by chunk.
+type streamReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	base  []byte
+	index uint64
+	in    []byte
+	buf   []byte // decrypted, not yet read
+	done  bool
+}
+
+// NewReader returns a reader that decrypts a stream written by NewWriter
+// without holding it in memory. A truncated or modified stream fails with
+// an error rather than ending early.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, head); err != nil || !IsEncrypted(head) {
+		return nil, errors.New("keyring: not an encrypted stream")
+	}
+	return &streamReader{
+		r:    bufio.NewReaderSize(r, chunkSize+tagSize+1),
+		aead: aead,
+		base: head[len(magic):],
+		in:   make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// next decrypts the following chunk. A chunk is final when nothing
+// follows it.
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.in)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	final := n < len(s.in)
+	if !final {
+		if _, perr := s.r.Peek(1); errors.Is(perr, io.EOF) {
+			final = true
+		}
+	}
+	aad := aadChunk
+	if final {
+		aad = aadFinal
+	}
+	out, err := s.aead.Open(s.in[:0:0], chunkNonce(s.base, s.index), s.in[:n], aad)
+	if err != nil {
+		return fmt.Errorf("keyring: decrypt chunk %d: %w", s.index, err)
+	}
+	s.index++
+	s.buf, s.done = out, final
+	return nil
+}
+
+// WriteFile encrypts data with key and replaces path with it atomically.
+func WriteFile(path string, data, key []byte) error {
+	var buf bytes.Buffer
+	buf.Grow(HeaderSize + len(data) + (len(data)/chunkSize+1)*tagSize)
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// ReadFile reads and decrypts a file written by WriteFile or NewWriter.
+func ReadFile(path string, key []byte) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: read %s: %w", path, err)
+	}
+	return Decrypt(data, key)
+}
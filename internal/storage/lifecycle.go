@@ -1,76 +1,244 @@
-// Package storage (lifecycle.go) implements the data retention policy for OBLIVRA.
-// A background goroutine runs once per day and purges events older than
-// StorageConfig.Retention days from both BadgerDB and the Bluge index.
+// Package storage (lifecycle.go) implements tiering and retention for OBLIVRA.
+// A background goroutine runs once per day and
+//   - purges expired events from every tier according to the retention
+//     classes and legal holds (see retention.go),
+//   - archives whole UTC days older than Tiers.HotDays from BadgerDB and the
+//     live Bluge index into warm archive segments, dropping index
+//     partitions left empty,
+//   - moves warm segments older than Tiers.WarmDays to the cold tier.
 package storage
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"path/filepath"
 	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/archive"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/blugeindex"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
 )
 
-const gcSchedule = 24 * time.Hour
+const (
+	gcSchedule = 24 * time.Hour
+	day        = 24 * time.Hour
+
+	// integritySlack widens a segment's span when looking up integrity
+	// blocks, since blocks are sealed some minutes after their events.
+	integritySlack = 10 * time.Minute
+)
 
-// StartLifecycleManager launches the background retention goroutine.
-// It stops when ctx is cancelled.
-func (e *Engine) StartLifecycleManager(ctx context.Context, retentionDays int) {
-	go e.lifecycleLoop(ctx, retentionDays)
+// StartLifecycleManager launches the background tiering and retention
+// goroutine. It stops when ctx is cancelled.
+func (e *Engine) StartLifecycleManager(ctx context.Context, cfg config.StorageConfig) {
+	go e.lifecycleLoop(ctx, cfg)
 }
 
-func (e *Engine) lifecycleLoop(ctx context.Context, retentionDays int) {
-	if retentionDays <= 0 {
-		return // retention disabled
+func (e *Engine) lifecycleLoop(ctx context.Context, cfg config.StorageConfig) {
+	if cfg.Retention <= 0 && len(cfg.RetentionClasses) == 0 && cfg.Tiers.HotDays <= 0 {
+		return // retention and tiering disabled
 	}
 
 	ticker := time.NewTicker(gcSchedule)
 	defer ticker.Stop()
 
 	// Run immediately on startup, then on the daily schedule.
-	e.purgeOldEvents(retentionDays)
+	e.RunLifecycle(cfg, time.Now())
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			e.purgeOldEvents(retentionDays)
+			e.RunLifecycle(cfg, time.Now())
+		}
+	}
+}
+
+// RunLifecycle performs one retention and tiering pass as of now. Retention
+// runs first so events already due are not archived only to be purged.
+func (e *Engine) RunLifecycle(cfg config.StorageConfig, now time.Time) {
+	if policy, err := NewRetentionPolicy(cfg); err != nil {
+		log.Printf("storage: retention disabled: %v", err)
+	} else if rep, err := e.ApplyRetention(policy, now); err != nil {
+		log.Printf("%v", err)
+	} else if n := rep.HotDeleted + rep.ArchiveDeleted; n > 0 {
+		log.Printf("storage: retention purged %d events (%d held)", n, rep.Held)
+	}
+	if cfg.Tiers.HotDays > 0 {
+		if err := e.archiveHot(now.AddDate(0, 0, -cfg.Tiers.HotDays)); err != nil {
+			log.Printf("storage: archive hot tier: %v", err)
+		}
+	}
+	// Partitions emptied by an interrupted run are dropped here.
+	if _, err := e.dropEmptyPartitions(time.Time{}, time.Time{}); err != nil {
+		log.Printf("storage: drop index partitions: %v", err)
+	}
+	if cfg.Tiers.WarmDays > 0 {
+		cutoff := now.AddDate(0, 0, -cfg.Tiers.WarmDays)
+		for _, seg := range e.Archive.List() {
+			if seg.Tier == archive.TierWarm && seg.End.Before(cutoff) {
+				if err := e.Archive.Move(seg.ID, archive.TierCold); err != nil {
+					log.Printf("storage: move %s to cold: %v", seg.ID, err)
+				}
+			}
+		}
+	}
+}
+
+// archiveHot moves every whole UTC day before cutoff from the hot tier into
+// archive segments, oldest first.
+func (e *Engine) archiveHot(cutoff time.Time) error {
+	limit := cutoff.UTC().Truncate(day)
+	var prev time.Time
+	archived := 0
+	for {
+		oldest, ok, err := e.Badger.Oldest()
+		if err != nil {
+			return err
+		}
+		if !ok || !oldest.Before(limit) {
+			return nil
+		}
+		start := oldest.UTC().Truncate(day)
+		// A day is archived again while events keep arriving in it.
+		if start.Equal(prev) && archived == 0 {
+			return fmt.Errorf("day %s still in hot tier after archiving", start.Format("2006-01-02"))
+		}
+		prev = start
+		if archived, err = e.archiveDay(start, start.Add(day)); err != nil {
+			return fmt.Errorf("day %s: %w", start.Format("2006-01-02"), err)
 		}
 	}
 }
 
-// purgeOldEvents deletes events older than retentionDays from BadgerDB.
-// Bluge documents for those events are deleted by ID via a time-range query.
-func (e *Engine) purgeOldEvents(retentionDays int) {
-	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+// archiveScanned, when set by tests, runs between archiveDay's scan of a day
+// and the removal of the scanned events.
+var archiveScanned func(start, end time.Time)
 
-	// 1. Fetch IDs of events to be deleted from BadgerDB (for Bluge cleanup).
-	oldEvents, err := e.Badger.QueryTimeRange(time.Time{}, cutoff, 100_000)
+// archiveDay writes the hot events in [start, end) to a new segment and,
+// once it is sealed, removes them from BadgerDB and the live index. It
+// returns how many events it archived.
+func (e *Engine) archiveDay(start, end time.Time) (int, error) {
+	w, err := e.Archive.NewWriter(start)
 	if err != nil {
-		// Log and continue — partial purge is better than no purge.
-		return
+		return 0, err
 	}
+	var ids []string
+	var first, last time.Time
+	err = e.Badger.ScanRange(start, end, func(ev *models.Event) error {
+		if len(ids) == 0 {
+			first = ev.Timestamp
+		}
+		last = ev.Timestamp
+		ids = append(ids, ev.ID)
+		return w.Add(ev)
+	})
+	if err != nil {
+		w.Abort()
+		return 0, err
+	}
+
+	if w.Events() > 0 {
+		integrity, err := e.SQLite.IntegrityBlockIDsBetween(first, last.Add(integritySlack))
+		if err != nil {
+			w.Abort()
+			return 0, fmt.Errorf("integrity blocks: %w", err)
+		}
+		seg, err := w.Seal(integrity)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("storage: archived %d events to %s", seg.Events, seg.ID)
+	} else {
+		w.Abort() // everything was archived by an earlier, interrupted run
+	}
+
+	if archiveScanned != nil {
+		archiveScanned(start, end)
+	}
+	// Only the events written to the segment are removed: others may have
+	// arrived in the day since it was scanned, and are left for the next run.
+	for i := 0; i < len(ids); i += purgeBatch {
+		if _, err := e.Badger.DeleteEvents(ids[i:min(i+purgeBatch, len(ids))]); err != nil {
+			return len(ids), fmt.Errorf("remove from badger: %w", err)
+		}
+	}
+	if err := e.pruneIndex(start, end, ids); err != nil {
+		return len(ids), fmt.Errorf("remove from index: %w", err)
+	}
+	return len(ids), nil
+}
 
-	// 2. Delete from Bluge index.
-	for _, ev := range oldEvents {
-		_ = e.Bluge.DeleteEvent(ev.ID)
+// pruneIndex removes from the live index the events in [start, end) just
+// deleted from BadgerDB. Partitions left without events are dropped whole;
+// ids are deleted one by one only from partitions that keep some.
+func (e *Engine) pruneIndex(start, end time.Time, ids []string) error {
+	kept, err := e.dropEmptyPartitions(start, end)
+	if err != nil || !kept {
+		return err
 	}
+	for i := 0; i < len(ids); i += purgeBatch {
+		if err := e.Bluge.DeleteEventsBetween(start, end, ids[i:min(i+purgeBatch, len(ids))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// 3. Delete raw payloads from BadgerDB.
-	_, _ = e.Badger.DeleteOlderThan(cutoff)
+// dropEmptyPartitions drops the index partitions overlapping [start, end)
+// (zero bounds leave a side open) that hold no event in BadgerDB. kept
+// reports whether any overlapping partition remains.
+func (e *Engine) dropEmptyPartitions(start, end time.Time) (kept bool, err error) {
+	dropped, err := e.Bluge.DropPartitions(func(p blugeindex.Partition) (bool, error) {
+		if (!end.IsZero() && !p.Start.Before(end)) || (!start.IsZero() && !p.End.After(start)) {
+			return false, nil
+		}
+		has, err := e.Badger.HasEvents(p.Start, p.End)
+		kept = kept || has || err != nil
+		return !has, err
+	})
+	for _, p := range dropped {
+		log.Printf("storage: dropped index partition %s", p.Name)
+	}
+	return kept, err
 }
 
-// StorageStats holds combined metrics from all three engines.
+// StorageStats holds combined metrics from all three engines and the
+// archive tiers.
 type StorageStats struct {
 	BadgerLSMBytes  int64  `json:"badger_lsm_bytes"`
 	BadgerVLogBytes int64  `json:"badger_vlog_bytes"`
+	BlugeBytes      int64  `json:"bluge_bytes"`
 	SQLitePath      string `json:"sqlite_path"`
+	SQLiteBytes     int64  `json:"sqlite_bytes"`
+	ArchiveSegments int    `json:"archive_segments"`
+	ArchivedEvents  int64  `json:"archived_events"`
+	WarmBytes       int64  `json:"warm_bytes"`
+	ColdBytes       int64  `json:"cold_bytes"`
+	DiskFreeBytes   uint64 `json:"disk_free_bytes"` // file system under BasePath
+	DiskTotalBytes  uint64 `json:"disk_total_bytes"`
 }
 
 // Stats returns combined storage metrics for the dashboard.
 func (e *Engine) Stats() StorageStats {
 	bs := e.Badger.Stats()
+	events, bytes := e.Archive.Usage()
+	free, total, _ := diskSpace(e.base)
 	return StorageStats{
 		BadgerLSMBytes:  bs.LSMBytes,
 		BadgerVLogBytes: bs.VLogBytes,
+		BlugeBytes:      dirSize(filepath.Join(e.base, "bluge")),
+		SQLitePath:      filepath.Join(e.base, "sqlite", "oblivra.db"),
+		SQLiteBytes:     dirSize(filepath.Join(e.base, "sqlite")),
+		ArchiveSegments: len(e.Archive.List()),
+		ArchivedEvents:  events,
+		WarmBytes:       bytes[archive.TierWarm],
+		ColdBytes:       bytes[archive.TierCold],
+		DiskFreeBytes:   free,
+		DiskTotalBytes:  total,
 	}
 }
 
@@ -0,0 +1,389 @@
+// Package storage (retention.go) implements per-class retention and legal
+// holds. Each event falls into the first configured retention class it
+// matches, or the default class (StorageConfig.Retention days). Purging
+// walks the hot tier one day at a time and the archive one segment at a
+// time, deleting in batches until nothing expired is left, and never
+// removes an event pinned by an active legal hold. Every run is written to
+// the audit log.
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/config"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/sqlitestore"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// DefaultRetentionClass names the class of events no configured class
+// matches.
+const DefaultRetentionClass = "default"
+
+// purgeBatch is how many events are deleted from the hot tier at once.
+const purgeBatch = 10_000
+
+// ─── Retention policy ────────────────────────────────────────────────────────
+
+// RetentionPolicy assigns events to retention classes.
+type RetentionPolicy struct {
+	classes     []retentionClass
+	defaultDays int
+	key         string // identifies the configuration, see retentionState
+}
+
+type retentionClass struct {
+	name                                    string
+	days                                    int
+	sources, categories, severities, routes []*regexp.Regexp
+}
+
+// NewRetentionPolicy compiles the retention classes of cfg.
+func NewRetentionPolicy(cfg config.StorageConfig) (*RetentionPolicy, error) {
+	if cfg.Retention < 0 {
+		return nil, fmt.Errorf("storage: retention_days is negative")
+	}
+	p := &RetentionPolicy{defaultDays: cfg.Retention}
+	seen := map[string]bool{DefaultRetentionClass: true}
+	for i, rc := range cfg.RetentionClasses {
+		switch {
+		case rc.Name == "":
+			return nil, fmt.Errorf("storage: retention class %d has no name", i+1)
+		case seen[rc.Name]:
+			return nil, fmt.Errorf("storage: duplicate retention class %q", rc.Name)
+		case rc.Days < 0:
+			return nil, fmt.Errorf("storage: retention class %q: days is negative", rc.Name)
+		case len(rc.Sources)+len(rc.Categories)+len(rc.Severities)+len(rc.Routes) == 0:
+			return nil, fmt.Errorf("storage: retention class %q matches every event; set retention_days instead", rc.Name)
+		}
+		seen[rc.Name] = true
+		p.classes = append(p.classes, retentionClass{
+			name:       rc.Name,
+			days:       rc.Days,
+			sources:    globs(rc.Sources),
+			categories: globs(rc.Categories),
+			severities: globs(rc.Severities),
+			routes:     globs(rc.Routes),
+		})
+	}
+	b, _ := json.Marshal(cfg.RetentionClasses)
+	p.key = fmt.Sprintf("%d:%s", cfg.Retention, b)
+	return p, nil
+}
+
+// globs compiles * / ? wildcard patterns into case-insensitive regexps.
+func globs(patterns []string) []*regexp.Regexp {
+	out := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		var b strings.Builder
+		b.WriteString("(?is)^")
+		for _, ch := range p {
+			switch ch {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		}
+		b.WriteString("$")
+		out[i] = regexp.MustCompile(b.String())
+	}
+	return out
+}
+
+func anyMatch(res []*regexp.Regexp, s string) bool {
+	if len(res) == 0 {
+		return true
+	}
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Class returns the retention class of ev and its retention in days
+// (0 = kept forever).
+func (p *RetentionPolicy) Class(ev *models.Event) (string, int) {
+	route := ev.Metadata[models.MetaRoute]
+	for _, c := range p.classes {
+		if anyMatch(c.sources, ev.Source) && anyMatch(c.categories, ev.Category) &&
+			anyMatch(c.severities, string(ev.Severity)) && anyMatch(c.routes, route) {
+			return c.name, c.days
+		}
+	}
+	return DefaultRetentionClass, p.defaultDays
+}
+
+// expiry returns when ev's retention ends; ok is false if it never does.
+func (p *RetentionPolicy) expiry(ev *models.Event) (class string, at time.Time, ok bool) {
+	class, days := p.Class(ev)
+	if days <= 0 {
+		return class, time.Time{}, false
+	}
+	return class, ev.Timestamp.AddDate(0, 0, days), true
+}
+
+// minDays returns the shortest finite retention, or 0 if nothing expires.
+func (p *RetentionPolicy) minDays() int {
+	m := p.defaultDays
+	for _, c := range p.classes {
+		if c.days > 0 && (m <= 0 || c.days < m) {
+			m = c.days
+		}
+	}
+	return m
+}
+
+// ─── Legal holds ─────────────────────────────────────────────────────────────
+
+// legalHolds is the set of events pinned by active holds.
+type legalHolds struct {
+	ids    map[string]bool
+	ranges []holdRange
+}
+
+type holdRange struct{ start, end time.Time } // zero = unbounded
+
+func (e *Engine) loadLegalHolds() (*legalHolds, error) {
+	holds, err := e.SQLite.ListLegalHolds(false)
+	if err != nil {
+		return nil, fmt.Errorf("storage: legal holds: %w", err)
+	}
+	ids, err := e.SQLite.HeldCaseEventIDs()
+	if err != nil {
+		return nil, fmt.Errorf("storage: legal hold events: %w", err)
+	}
+	h := &legalHolds{ids: make(map[string]bool, len(ids))}
+	for _, id := range ids {
+		h.ids[id] = true
+	}
+	for _, hold := range holds {
+		if hold.HasRange() {
+			h.ranges = append(h.ranges, holdRange{hold.Start, hold.End})
+		}
+	}
+	return h, nil
+}
+
+// pins reports whether ev is under a legal hold.
+func (h *legalHolds) pins(ev *models.Event) bool {
+	if h.ids[ev.ID] {
+		return true
+	}
+	for _, r := range h.ranges {
+		if (r.start.IsZero() || !ev.Timestamp.Before(r.start)) && (r.end.IsZero() || !ev.Timestamp.After(r.end)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ─── Purge ───────────────────────────────────────────────────────────────────
+
+// PurgeReport summarises one retention run. It is stored as the details of
+// the run's audit log entry.
+type PurgeReport struct {
+	Started           time.Time      `json:"started"`
+	DurationMs        int64          `json:"duration_ms"`
+	HotDeleted        int            `json:"hot_deleted"`
+	ArchiveDeleted    int            `json:"archive_deleted"`
+	SegmentsRewritten int            `json:"segments_rewritten"`
+	SegmentsDeleted   int            `json:"segments_deleted"`
+	ByClass           map[string]int `json:"by_class"`
+	Held              int            `json:"held"` // expired events kept by a legal hold
+	Errors            []string       `json:"errors,omitempty"`
+}
+
+// retentionState remembers, per archive segment, when its next event
+// expires so unchanged segments are not re-read every day. Expired but held
+// events count as due, so a segment holding them is re-read on each run and
+// releasing a hold needs no invalidation.
+type retentionState struct {
+	mu  sync.Mutex
+	key string               // RetentionPolicy.key the entries were computed for
+	due map[string]time.Time // segment ID → next expiry
+}
+
+// ApplyRetention purges every expired, unheld event as of now from the hot
+// tier and the archive and records the run in the audit log.
+func (e *Engine) ApplyRetention(policy *RetentionPolicy, now time.Time) (*PurgeReport, error) {
+	rep := &PurgeReport{Started: now, ByClass: map[string]int{}}
+	if policy.minDays() <= 0 {
+		return rep, nil // nothing expires
+	}
+	began := time.Now()
+	defer func() {
+		rep.DurationMs = time.Since(began).Milliseconds()
+		e.auditPurge("retention_purge", rep)
+	}()
+	holds, err := e.loadLegalHolds()
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+		return rep, err
+	}
+	if err := e.purgeHot(policy, holds, now, rep); err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+	}
+	e.purgeArchive(policy, holds, now, rep)
+	if len(rep.Errors) > 0 {
+		return rep, fmt.Errorf("storage: retention: %s", strings.Join(rep.Errors, "; "))
+	}
+	return rep, nil
+}
+
+// purgeHot deletes expired events from BadgerDB and then from the live
+// index, one UTC day at a time, up to the shortest retention. Index
+// partitions left without events are dropped whole.
+func (e *Engine) purgeHot(policy *RetentionPolicy, holds *legalHolds, now time.Time, rep *PurgeReport) error {
+	limit := now.AddDate(0, 0, -policy.minDays())
+	oldest, ok, err := e.Badger.Oldest()
+	if err != nil || !ok {
+		return err
+	}
+	var batch, expired []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := e.Badger.DeleteEvents(batch)
+		if err != nil {
+			return err
+		}
+		rep.HotDeleted += n
+		expired = append(expired, batch...)
+		batch = batch[:0]
+		return nil
+	}
+	for start := oldest.UTC().Truncate(day); start.Before(limit); start = start.Add(day) {
+		end := start.Add(day)
+		if end.After(limit) {
+			end = limit
+		}
+		err := e.Badger.ScanRange(start, end, func(ev *models.Event) error {
+			class, at, ok := policy.expiry(ev)
+			if !ok || at.After(now) {
+				return nil
+			}
+			if holds.pins(ev) {
+				rep.Held++
+				return nil
+			}
+			rep.ByClass[class]++
+			batch = append(batch, ev.ID)
+			if len(batch) >= purgeBatch {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err == nil && len(expired) > 0 {
+			if err = e.pruneIndex(start, end, expired); err != nil {
+				err = fmt.Errorf("remove from index: %w", err)
+			}
+			expired = expired[:0]
+		}
+		if err != nil {
+			return fmt.Errorf("hot tier %s: %w", start.Format("2006-01-02"), err)
+		}
+	}
+	return nil
+}
+
+// purgeArchive rewrites or deletes archive segments holding expired events.
+func (e *Engine) purgeArchive(policy *RetentionPolicy, holds *legalHolds, now time.Time, rep *PurgeReport) {
+	st := &e.retention
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.key != policy.key || st.due == nil {
+		st.key, st.due = policy.key, make(map[string]time.Time)
+	}
+
+	oldest := now.AddDate(0, 0, -policy.minDays())
+	for _, seg := range e.Archive.List() {
+		if !seg.Start.Before(oldest) {
+			continue // nothing in it can have expired yet
+		}
+		if due, ok := st.due[seg.ID]; ok && due.After(now) {
+			continue
+		}
+		drop := make(map[string]bool)
+		classes := map[string]int{}
+		held := 0
+		var next time.Time
+		err := e.Archive.Scan(seg.ID, func(ev *models.Event) error {
+			class, at, ok := policy.expiry(ev)
+			switch {
+			case !ok:
+			case at.After(now):
+				if next.IsZero() || at.Before(next) {
+					next = at
+				}
+			case holds.pins(ev):
+				held++
+				if next.IsZero() || at.Before(next) {
+					next = at
+				}
+			default:
+				drop[ev.ID] = true
+				classes[class]++
+			}
+			return nil
+		})
+		if err == nil {
+			// An unchanged segment comes back as is; an emptied one as nil.
+			repl, rerr := e.Archive.Rewrite(seg.ID, drop)
+			if err = rerr; err == nil {
+				delete(st.due, seg.ID)
+				if repl != nil && !next.IsZero() {
+					st.due[repl.ID] = next
+				}
+			}
+		}
+		if err != nil {
+			rep.Errors = append(rep.Errors, fmt.Sprintf("segment %s: %v", seg.ID, err))
+			continue
+		}
+		rep.Held += held
+		if len(drop) == 0 {
+			continue
+		}
+		rep.ArchiveDeleted += len(drop)
+		for c, n := range classes {
+			rep.ByClass[c] += n
+		}
+		if len(drop) >= seg.Events {
+			rep.SegmentsDeleted++
+		} else {
+			rep.SegmentsRewritten++
+		}
+	}
+}
+
+// auditPurge records a purge in the audit log under action.
+func (e *Engine) auditPurge(action string, rep *PurgeReport) {
+	details, _ := json.Marshal(rep)
+	err := e.SQLite.InsertAuditLog(&sqlitestore.AuditRecord{
+		ID:         uuid.NewString(),
+		UserID:     "system",
+		Action:     action,
+		TargetType: "storage",
+		TargetID:   "retention",
+		Details:    string(details),
+		Timestamp:  time.Now(),
+	})
+	if err != nil {
+		log.Printf("storage: audit %s: %v", action, err)
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/internal/storage/keyring"
+	"github.com/libyan-cooperation-org/OBLIVRA-Sovereign-SIEM-/pkg/models"
+)
+
+// ErrStorageFull is returned for writes refused under disk pressure once
+// the spool is full as well.
+var ErrStorageFull = errors.New("storage: disk full and spool full; event batch refused")
+
+const (
+	spoolExt           = ".spool"
+	defaultSpoolMaxMB  = 1024
+	spoolReplayBatches = 100 // batches replayed per guardian check
+)
+
+// spool holds event batches refused under disk pressure, one file per
+// batch, until they can be written. Files are encrypted with the spool
+// subkey when encryption at rest is on, and survive a restart.
+type spool struct {
+	dir string
+	key []byte
+	max int64
+
+	mu    sync.Mutex
+	files []string // oldest first
+	bytes int64
+	seq   uint64
+}
+
+func openSpool(dir string, key []byte, maxMB int) (*spool, error) {
+	if maxMB <= 0 {
+		maxMB = defaultSpoolMaxMB
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("storage: spool: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("storage: spool: %w", err)
+	}
+	s := &spool{dir: dir, key: key, max: int64(maxMB) << 20}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), spoolExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("storage: spool: %w", err)
+		}
+		s.files = append(s.files, e.Name())
+		s.bytes += info.Size()
+	}
+	sort.Strings(s.files)
+	return s, nil
+}
+
+// add writes events to a new spool file, or returns ErrStorageFull.
+func (s *spool) add(events []*models.Event) error {
+	data, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("storage: spool: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bytes+int64(len(data)) > s.max {
+		return ErrStorageFull
+	}
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1_000_000, spoolExt)
+	path := filepath.Join(s.dir, name)
+	if s.key != nil {
+		err = keyring.WriteFile(path, data, s.key)
+	} else if err = writeFileSync(path+".tmp", data); err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return fmt.Errorf("storage: spool: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err == nil {
+		s.bytes += info.Size()
+	}
+	s.files = append(s.files, name)
+	return nil
+}
+
+// replay writes up to max spooled batches, oldest first, removing each
+// once written, and returns how many events it wrote.
+func (s *spool) replay(max int, write func([]*models.Event) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	written := 0
+	for len(s.files) > 0 && max > 0 {
+		path := filepath.Join(s.dir, s.files[0])
+		var data []byte
+		var err error
+		if s.key != nil {
+			data, err = keyring.ReadFile(path, s.key)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		var events []*models.Event
+		if err == nil {
+			err = json.Unmarshal(data, &events)
+		}
+		if err == nil {
+			err = write(events)
+		}
+		if err != nil {
+			return written, fmt.Errorf("storage: replay %s: %w", s.files[0], err)
+		}
+		info, _ := os.Stat(path)
+		if err := os.Remove(path); err != nil {
+			return written, fmt.Errorf("storage: replay: %w", err)
+		}
+		if info != nil {
+			s.bytes -= info.Size()
+		}
+		s.files = s.files[1:]
+		written += len(events)
+		max--
+	}
+	return written, nil
+}
+
+// full reports whether the spool has reached its cap.
+func (s *spool) full() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes >= s.max
+}
+
+// usage returns the number of spooled batches and their size.
+func (s *spool) usage() (batches int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files), s.bytes
+}
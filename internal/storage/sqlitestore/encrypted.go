@@ -0,0 +1,205 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"modernc.org/sqlite"
+)
+
+// EncryptedSuffix is appended to the database path for its encrypted file.
+const EncryptedSuffix = ".enc"
+
+// OpenEncrypted opens (or creates) the database at path+EncryptedSuffix,
+// whose pages are encrypted with key (32 bytes) as SQLite reads and writes
+// them (see vfs.go), and runs all migrations. A plaintext database at path,
+// from before encryption was enabled, is imported and removed.
+func OpenEncrypted(path string, key []byte) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("sqlitestore: mkdir: %w", err)
+	}
+	id, err := registerKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+	fail := func(err error) (*DB, error) {
+		unregisterKey(id)
+		return nil, err
+	}
+	encPath := path + EncryptedSuffix
+	if err := checkEncrypted(encPath, id); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(path); err == nil {
+			if err := importPlain(path, encPath, id); err != nil {
+				return fail(fmt.Errorf("sqlitestore: import %s: %w", path, err))
+			}
+		}
+	} else if err != nil {
+		return fail(fmt.Errorf("sqlitestore: %s: %w", encPath, err))
+	}
+
+	// WAL mode, as in Open; temporary tables stay in memory.
+	db, err := sql.Open("sqlite", encryptedDSN(encPath, id)+"&_pragma=journal_mode(WAL)&_pragma=foreign_keys(ON)&_pragma=busy_timeout(5000)&_pragma=temp_store(MEMORY)")
+	if err != nil {
+		return fail(fmt.Errorf("sqlitestore: open: %w", err))
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	s := &DB{db: db, path: path, keyID: id}
+	if err := s.migrate(); err != nil {
+		_ = db.Close()
+		return fail(fmt.Errorf("sqlitestore: migrate: %w", err))
+	}
+	return s, nil
+}
+
+// encryptedDSN is the URI of the database file at path, encrypted with the
+// key registered as id.
+func encryptedDSN(path, id string) string {
+	return fmt.Sprintf("file:%s?vfs=%s&%s=%s", path, cryptVFSName, cryptKeyParam, id)
+}
+
+// checkEncrypted checks that the database at path, if it is not empty,
+// decrypts with the key registered as id. SQLite would only find a wrong
+// key after opening the database, and could discard its WAL on closing it.
+func checkEncrypted(path, id string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, cryptBlockSize)
+	if _, err := io.ReadFull(f, head); errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !checkKey(id, head) {
+		return errors.New("wrong key, or not an encrypted database")
+	}
+	return nil
+}
+
+// importPlain copies the plaintext database at path into a new encrypted
+// database at encPath, then removes the plaintext files. The copy is built
+// beside encPath and renamed into place once complete.
+func importPlain(path, encPath, id string) error {
+	tmp := encPath + ".tmp"
+	for _, p := range []string{tmp, tmp + "-journal"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	db, err := sql.Open("sqlite", encryptedDSN(tmp, id))
+	if err != nil {
+		return err
+	}
+	err = restore(db, "file:"+path)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, encPath)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(encPath))
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	for _, p := range []string{path, path + "-wal", path + "-shm"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("sqlitestore: remove plaintext %s: %v", p, err)
+		}
+	}
+	log.Printf("sqlitestore: encrypted %s", path)
+	return nil
+}
+
+// restore replaces the contents of db with the database at uri.
+func restore(db *sql.DB, uri string) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc any) error {
+		r, ok := dc.(interface {
+			NewRestore(string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("driver cannot restore")
+		}
+		b, err := r.NewRestore(uri)
+		if err != nil {
+			return err
+		}
+		if _, err := b.Step(-1); err != nil {
+			_ = b.Finish()
+			return err
+		}
+		return b.Finish()
+	})
+}
+
+// syncDir makes a rename in dir durable. Windows cannot sync directories,
+// and does not need to.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) && !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	return nil
+}
+
+// Backup writes a consistent copy of the database to path with SQLite's
+// online backup while it stays in use. The copy of an encrypted database
+// is encrypted with the same key, so it can replace path+EncryptedSuffix
+// as is.
+func (s *DB) Backup(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("sqlitestore: backup: %w", err)
+	}
+	dst := "file:" + path
+	if s.keyID != "" {
+		dst = encryptedDSN(path, s.keyID)
+	}
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("sqlitestore: backup: %w", err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc any) error {
+		bk, ok := dc.(interface {
+			NewBackup(string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("driver cannot back up")
+		}
+		b, err := bk.NewBackup(dst)
+		if err != nil {
+			return err
+		}
+		if _, err := b.Step(-1); err != nil {
+			_ = b.Finish()
+			return err
+		}
+		return b.Finish()
+	})
+	if err != nil {
+		return fmt.Errorf("sqlitestore: backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrSchemaTooNew is returned by Open when the database was migrated by a
+// newer build than this one.
+var ErrSchemaTooNew = errors.New("sqlitestore: database schema is newer than this build supports")
+
+// migration is one numbered schema change. Migrations are applied in order,
+// each in its own transaction together with its schema_version row. fn, if
+// set, runs after up for changes plain SQL cannot make idempotently.
+type migration struct {
+	version int
+	name    string
+	up      string
+	fn      func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change, oldest first. Versions are
+// consecutive from 1. Append new migrations; never edit or reorder one that
+// has shipped.
+var migrations = []migration{
+	{1, "initial schema", schema, nil},
+	{2, "index alert foreign keys", `
+CREATE INDEX IF NOT EXISTS idx_alerts_event      ON alerts(event_id);
+CREATE INDEX IF NOT EXISTS idx_case_alerts_alert ON case_alerts(alert_id);
+`, nil},
+	{3, "tenants", `
+CREATE TABLE IF NOT EXISTS tenants (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    created_at  INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO tenants (id, name, created_at) VALUES ('default', 'Default', strftime('%s', 'now'));
+
+CREATE TABLE IF NOT EXISTS user_tenants (
+    user_id     TEXT NOT NULL REFERENCES users(id)   ON DELETE CASCADE,
+    tenant_id   TEXT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, tenant_id)
+);
+`, addTenantColumns},
+	{4, "alert event lists", "", addAlertEventIDs},
+	{5, "detection state", `
+CREATE TABLE IF NOT EXISTS detection_state (
+    kind        TEXT NOT NULL,
+    rule_id     TEXT NOT NULL,
+    key         TEXT NOT NULL,
+    data        TEXT NOT NULL,
+    expires_at  INTEGER NOT NULL,
+    PRIMARY KEY (kind, rule_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_detection_state_rule ON detection_state(rule_id);
+`, nil},
+	{6, "lookup table tenants", "", addLookupTenants},
+}
+
+// addTenantColumns gives operational records a tenant, existing rows going
+// to the default tenant. Rules and enrichment data are global (empty tenant) unless
+// bound to a tenant, and API tokens ingest into no tenant in particular.
+func addTenantColumns(tx *sql.Tx) error {
+	for _, c := range []struct{ table, def string }{
+		{"alerts", "'default'"},
+		{"cases", "'default'"},
+		{"saved_searches", "'default'"},
+		{"api_tokens", "''"},
+		{"rules", "''"},
+		{"assets", "''"},
+		{"honeytokens", "''"},
+	} {
+		if err := addColumn(tx, c.table, "tenant", "TEXT NOT NULL DEFAULT "+c.def); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`
+CREATE INDEX IF NOT EXISTS idx_alerts_tenant          ON alerts(tenant, timestamp);
+CREATE INDEX IF NOT EXISTS idx_cases_tenant           ON cases(tenant);
+CREATE INDEX IF NOT EXISTS idx_assets_tenant_hostname ON assets(tenant, hostname);
+`)
+	return err
+}
+
+// addAlertEventIDs lets correlation alerts list all their events, as a JSON
+// array; single-event alerts leave it empty.
+func addAlertEventIDs(tx *sql.Tx) error {
+	return addColumn(tx, "alerts", "event_ids", "TEXT NOT NULL DEFAULT ''")
+}
+
+// addLookupTenants gives lookup tables a tenant, existing tables becoming
+// global (empty tenant). The tenant is part of the key, so tenants can hold
+// tables of the same name, and the tables are rebuilt to change it.
+func addLookupTenants(tx *sql.Tx) error {
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('lookup_tables') WHERE name = 'tenant'`).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+CREATE TABLE lookup_tables_new (
+    tenant      TEXT NOT NULL DEFAULT '',
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    key_column  TEXT NOT NULL,
+    columns     TEXT NOT NULL,
+    version     INTEGER NOT NULL,
+    rows        INTEGER NOT NULL,
+    updated_at  INTEGER NOT NULL,
+    updated_by  TEXT NOT NULL,
+    PRIMARY KEY (tenant, name)
+);
+INSERT INTO lookup_tables_new (name, description, key_column, columns, version, rows, updated_at, updated_by)
+    SELECT name, description, key_column, columns, version, rows, updated_at, updated_by FROM lookup_tables;
+DROP TABLE lookup_tables;
+ALTER TABLE lookup_tables_new RENAME TO lookup_tables;
+
+CREATE TABLE lookup_versions_new (
+    tenant      TEXT NOT NULL DEFAULT '',
+    name        TEXT NOT NULL,
+    version     INTEGER NOT NULL,
+    key_column  TEXT NOT NULL,
+    rows        INTEGER NOT NULL,
+    data        TEXT NOT NULL,
+    created_at  INTEGER NOT NULL,
+    created_by  TEXT NOT NULL,
+    PRIMARY KEY (tenant, name, version)
+);
+INSERT INTO lookup_versions_new (name, version, key_column, rows, data, created_at, created_by)
+    SELECT name, version, key_column, rows, data, created_at, created_by FROM lookup_versions;
+DROP TABLE lookup_versions;
+ALTER TABLE lookup_versions_new RENAME TO lookup_versions;
+`)
+	return err
+}
+
+// addColumn adds a column to table unless it already has one by that name.
+func addColumn(tx *sql.Tx, table, column, decl string) error {
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
+}
+
+// SchemaVersion is the schema version this build migrates databases to.
+func SchemaVersion() int { return len(migrations) }
+
+// migrate applies the pending migrations. A database that already has
+// tables is backed up next to its file first, as path.v<N>.bak (plus
+// EncryptedSuffix when encrypted), N being the version before migrating.
+func (s *DB) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at INTEGER NOT NULL
+)`); err != nil {
+		return err
+	}
+	var current, tables int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current); err != nil {
+		return err
+	}
+	if current > SchemaVersion() {
+		return fmt.Errorf("%w: database is at version %d, this build supports up to %d", ErrSchemaTooNew, current, SchemaVersion())
+	}
+	if current == SchemaVersion() {
+		return nil
+	}
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name != 'schema_version'`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		backup := fmt.Sprintf("%s.v%d.bak", s.path, current)
+		if s.keyID != "" {
+			backup += EncryptedSuffix
+		}
+		if err := s.Backup(backup); err != nil {
+			return fmt.Errorf("pre-migration backup: %w", err)
+		}
+		log.Printf("sqlitestore: migrating %s from schema version %d to %d (backup %s)", s.path, current, SchemaVersion(), backup)
+	}
+	for _, m := range migrations[current:] {
+		if err := s.apply(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// apply runs one migration and records it, atomically.
+func (s *DB) apply(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(m.up); err != nil {
+		return err
+	}
+	if m.fn != nil {
+		if err := m.fn(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,7 +1,7 @@
 // Package sqlitestore provides relational storage for OBLIVRA metadata:
 // alerts, cases, assets, detection rules, and agents.
-// It uses database/sql with the go-sqlite3 CGo driver and runs all DDL
-// migrations on Open so the schema is always up to date.
+// It uses database/sql with the pure-Go modernc.org/sqlite driver and
+// brings the schema up to date on Open with numbered migrations (migrate.go).
 package sqlitestore
 
 import (
@@ -18,7 +18,11 @@ import (
 
 // DB wraps *sql.DB with OBLIVRA-specific helpers.
 type DB struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
+	// keyID names the page key of an encrypted database (see vfs.go); it
+	// is empty for a plaintext one.
+	keyID string
 }
 
 // Open opens (or creates) the SQLite file at path and runs all migrations.
@@ -26,6 +30,9 @@ func Open(path string) (*DB, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
 		return nil, fmt.Errorf("sqlitestore: mkdir: %w", err)
 	}
+	if _, err := os.Stat(path + EncryptedSuffix); err == nil {
+		return nil, fmt.Errorf("sqlitestore: %s is encrypted; open it with OpenEncrypted", path)
+	}
 
 	// WAL mode: concurrent reads + one writer, no full-file locks.
 	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=foreign_keys(ON)&_pragma=busy_timeout(5000)", path)
@@ -37,7 +44,7 @@ func Open(path string) (*DB, error) {
 	db.SetMaxIdleConns(1)
 	db.SetConnMaxLifetime(0)
 
-	s := &DB{db: db}
+	s := &DB{db: db, path: path}
 	if err := s.migrate(); err != nil {
 		_ = db.Close()
 		return nil, fmt.Errorf("sqlitestore: migrate: %w", err)
@@ -47,11 +54,9 @@ func Open(path string) (*DB, error) {
 
 // ─── MIGRATIONS ──────────────────────────────────────────────────────────────
 
-func (s *DB) migrate() error {
-	_, err := s.db.Exec(schema)
-	return err
-}
-
+// schema is migration 1: the schema as it stood before migrations were
+// versioned. Its statements are idempotent so that databases created by
+// older builds, which have no schema_version table, can run it again.
 const schema = `
 CREATE TABLE IF NOT EXISTS alerts (
     id          TEXT PRIMARY KEY,
@@ -257,6 +262,72 @@ CREATE TABLE IF NOT EXISTS saved_searches (
     created_at  INTEGER NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS search_schedules (
+    search_id       TEXT PRIMARY KEY,
+    cron            TEXT NOT NULL DEFAULT '',
+    interval_sec    INTEGER NOT NULL DEFAULT 0,
+    window_sec      INTEGER NOT NULL DEFAULT 0,
+    trigger_type    TEXT NOT NULL DEFAULT 'count',
+    trigger_field   TEXT NOT NULL DEFAULT '',
+    trigger_op      TEXT NOT NULL DEFAULT 'gt',
+    trigger_value   REAL NOT NULL DEFAULT 0,
+    throttle_sec    INTEGER NOT NULL DEFAULT 0,
+    severity        TEXT NOT NULL,
+    enabled         INTEGER NOT NULL DEFAULT 1,
+    updated_at      INTEGER NOT NULL,
+    last_run        INTEGER NOT NULL DEFAULT 0,
+    last_alert_at   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS search_runs (
+    id          TEXT PRIMARY KEY,
+    search_id   TEXT NOT NULL,
+    started_at  INTEGER NOT NULL,
+    duration_ms INTEGER NOT NULL,
+    hits        INTEGER NOT NULL,
+    scanned     INTEGER NOT NULL,
+    triggered   INTEGER NOT NULL,
+    suppressed  INTEGER NOT NULL,
+    alert_id    TEXT NOT NULL DEFAULT '',
+    error       TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_search_runs ON search_runs(search_id, started_at);
+
+CREATE TABLE IF NOT EXISTS lookup_tables (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    key_column  TEXT NOT NULL,
+    columns     TEXT NOT NULL,
+    version     INTEGER NOT NULL,
+    rows        INTEGER NOT NULL,
+    updated_at  INTEGER NOT NULL,
+    updated_by  TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS lookup_versions (
+    name        TEXT NOT NULL,
+    version     INTEGER NOT NULL,
+    key_column  TEXT NOT NULL,
+    rows        INTEGER NOT NULL,
+    data        TEXT NOT NULL,
+    created_at  INTEGER NOT NULL,
+    created_by  TEXT NOT NULL,
+    PRIMARY KEY (name, version)
+);
+
+CREATE TABLE IF NOT EXISTS legal_holds (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    case_id     TEXT NOT NULL DEFAULT '',   -- pins the case's evidence and alert events
+    start_at    INTEGER NOT NULL DEFAULT 0, -- unix seconds; 0/0 → no time range
+    end_at      INTEGER NOT NULL DEFAULT 0,
+    reason      TEXT NOT NULL DEFAULT '',
+    created_by  TEXT NOT NULL,
+    created_at  INTEGER NOT NULL,
+    released_by TEXT NOT NULL DEFAULT '',
+    released_at INTEGER NOT NULL DEFAULT 0
+);
+
 -- Default Permissions
 INSERT OR IGNORE INTO permissions (id, name, description) VALUES 
 ('p1', 'logs:search', 'Search and view logs'),
@@ -299,28 +370,36 @@ INSERT OR IGNORE INTO role_permissions (role_id, permission_id) VALUES
 func (s *DB) InsertAlert(a *models.Alert) error {
 	_, err := s.db.Exec(`
 		INSERT OR REPLACE INTO alerts
-		(id, event_id, rule_id, timestamp, severity, title, summary, status, assignee, host, metadata)
-		VALUES (?,?,?,?,?,?,?,?,?,?,?)`,
+		(id, event_id, rule_id, timestamp, severity, title, summary, status, assignee, host, metadata, tenant, event_ids)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 		a.ID, a.EventID, a.RuleID, a.Timestamp.Unix(),
 		string(a.Severity), a.Title, a.Summary, a.Status, a.Assignee, a.Host,
-		serializeMetadata(a.Metadata),
+		serializeMetadata(a.Metadata), models.TenantOrDefault(a.Tenant), serializeIDs(a.EventIDs),
 	)
 	return err
 }
 
 // GetAlert retrieves a single alert by ID.
 func (s *DB) GetAlert(id string) (*models.Alert, error) {
-	row := s.db.QueryRow(`SELECT id,event_id,rule_id,timestamp,severity,title,summary,status,assignee,host,metadata FROM alerts WHERE id=?`, id)
+	row := s.db.QueryRow(`SELECT `+alertColumns+` FROM alerts WHERE id=?`, id)
 	return scanAlert(row)
 }
 
+// alertColumns are the columns scanAlert and scanAlerts read.
+const alertColumns = `id,event_id,rule_id,timestamp,severity,title,summary,status,assignee,host,metadata,tenant,event_ids`
+
 // ListAlerts returns alerts matching optional filters, ordered newest first.
-func (s *DB) ListAlerts(status, severity string, limit int) ([]*models.Alert, error) {
+// An empty tenant lists the alerts of every tenant.
+func (s *DB) ListAlerts(tenant, status, severity string, limit int) ([]*models.Alert, error) {
 	if limit <= 0 {
 		limit = 200
 	}
-	q := `SELECT id,event_id,rule_id,timestamp,severity,title,summary,status,assignee,host,metadata FROM alerts WHERE 1=1`
+	q := `SELECT ` + alertColumns + ` FROM alerts WHERE 1=1`
 	var args []interface{}
+	if tenant != "" {
+		q += ` AND tenant=?`
+		args = append(args, tenant)
+	}
 	if status != "" {
 		q += ` AND status=?`
 		args = append(args, status)
@@ -346,9 +425,10 @@ func (s *DB) UpdateAlertStatus(id, status, assignee string) error {
 	return err
 }
 
-// AlertCounts returns a map of severity→count for open alerts.
-func (s *DB) AlertCounts() (map[string]int, error) {
-	rows, err := s.db.Query(`SELECT severity, COUNT(*) FROM alerts WHERE status='open' GROUP BY severity`)
+// AlertCounts returns a map of severity→count for open alerts of tenant, or
+// of every tenant if it is empty.
+func (s *DB) AlertCounts(tenant string) (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT severity, COUNT(*) FROM alerts WHERE status='open' AND (?='' OR tenant=?) GROUP BY severity`, tenant, tenant)
 	if err != nil {
 		return nil, err
 	}
@@ -378,27 +458,28 @@ type CaseRecord struct {
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	AlertCount  int
+	Tenant      string
 }
 
 // InsertCase persists a new case.
 func (s *DB) InsertCase(c *CaseRecord) error {
 	_, err := s.db.Exec(`
 		INSERT OR REPLACE INTO cases
-		(id, title, description, severity, status, assignee, created_at, updated_at, alert_count)
-		VALUES (?,?,?,?,?,?,?,?,?)`,
+		(id, title, description, severity, status, assignee, created_at, updated_at, alert_count, tenant)
+		VALUES (?,?,?,?,?,?,?,?,?,?)`,
 		c.ID, c.Title, c.Description, c.Severity, c.Status, c.Assignee,
-		c.CreatedAt.Unix(), c.UpdatedAt.Unix(), c.AlertCount,
+		c.CreatedAt.Unix(), c.UpdatedAt.Unix(), c.AlertCount, models.TenantOrDefault(c.Tenant),
 	)
 	return err
 }
 
 // GetCase retrieves a single case by ID.
 func (s *DB) GetCase(id string) (*CaseRecord, error) {
-	row := s.db.QueryRow(`SELECT id,title,description,severity,status,assignee,created_at,updated_at,alert_count FROM cases WHERE id=?`, id)
+	row := s.db.QueryRow(`SELECT id,title,description,severity,status,assignee,created_at,updated_at,alert_count,tenant FROM cases WHERE id=?`, id)
 	var c CaseRecord
 	var createdUnix, updatedUnix int64
 	err := row.Scan(&c.ID, &c.Title, &c.Description, &c.Severity, &c.Status,
-		&c.Assignee, &createdUnix, &updatedUnix, &c.AlertCount)
+		&c.Assignee, &createdUnix, &updatedUnix, &c.AlertCount, &c.Tenant)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -410,13 +491,18 @@ func (s *DB) GetCase(id string) (*CaseRecord, error) {
 	return &c, nil
 }
 
-// ListCases returns all cases ordered by updated_at desc.
-func (s *DB) ListCases(status string, limit int) ([]*CaseRecord, error) {
+// ListCases returns all cases ordered by updated_at desc. An empty tenant
+// lists the cases of every tenant.
+func (s *DB) ListCases(tenant, status string, limit int) ([]*CaseRecord, error) {
 	if limit <= 0 {
 		limit = 200
 	}
-	q := `SELECT id,title,description,severity,status,assignee,created_at,updated_at,alert_count FROM cases WHERE 1=1`
+	q := `SELECT id,title,description,severity,status,assignee,created_at,updated_at,alert_count,tenant FROM cases WHERE 1=1`
 	var args []interface{}
+	if tenant != "" {
+		q += ` AND tenant=?`
+		args = append(args, tenant)
+	}
 	if status != "" {
 		q += ` AND status=?`
 		args = append(args, status)
@@ -433,7 +519,7 @@ func (s *DB) ListCases(status string, limit int) ([]*CaseRecord, error) {
 		var c CaseRecord
 		var createdUnix, updatedUnix int64
 		if err := rows.Scan(&c.ID, &c.Title, &c.Description, &c.Severity, &c.Status,
-			&c.Assignee, &createdUnix, &updatedUnix, &c.AlertCount); err != nil {
+			&c.Assignee, &createdUnix, &updatedUnix, &c.AlertCount, &c.Tenant); err != nil {
 			return nil, err
 		}
 		c.CreatedAt = time.Unix(createdUnix, 0)
@@ -476,7 +562,7 @@ func (s *DB) UnlinkAlertFromCase(caseID, alertID string) error {
 // GetAlertsForCase returns all alerts linked to a case.
 func (s *DB) GetAlertsForCase(caseID string) ([]*models.Alert, error) {
 	rows, err := s.db.Query(`
-		SELECT a.id, a.event_id, a.rule_id, a.timestamp, a.severity, a.title, a.summary, a.status, a.assignee, a.host, a.metadata
+		SELECT a.id, a.event_id, a.rule_id, a.timestamp, a.severity, a.title, a.summary, a.status, a.assignee, a.host, a.metadata, a.tenant, a.event_ids
 		FROM alerts a
 		JOIN case_alerts ca ON a.id = ca.alert_id
 		WHERE ca.case_id = ?`, caseID)
@@ -538,28 +624,30 @@ type AssetRecord struct {
 	Owner       string
 	LastSeen    time.Time
 	Tags        string // JSON
+	Tenant      string // "" for an asset known to every tenant
 }
 
 // UpsertAsset inserts or replaces an asset record.
 func (s *DB) UpsertAsset(a *AssetRecord) error {
 	_, err := s.db.Exec(`
 		INSERT OR REPLACE INTO assets
-		(id, hostname, ip, os, type, criticality, owner, last_seen, tags)
-		VALUES (?,?,?,?,?,?,?,?,?)`,
+		(id, hostname, ip, os, type, criticality, owner, last_seen, tags, tenant)
+		VALUES (?,?,?,?,?,?,?,?,?,?)`,
 		a.ID, a.Hostname, a.IP, a.OS, a.Type, a.Criticality,
-		a.Owner, a.LastSeen.Unix(), a.Tags,
+		a.Owner, a.LastSeen.Unix(), a.Tags, a.Tenant,
 	)
 	return err
 }
 
-// ListAssets returns all assets ordered by hostname.
-func (s *DB) ListAssets(limit int) ([]*AssetRecord, error) {
+// ListAssets returns the assets of tenant, global ones included, ordered
+// by hostname. An empty tenant lists every asset.
+func (s *DB) ListAssets(tenant string, limit int) ([]*AssetRecord, error) {
 	if limit <= 0 {
 		limit = 1000
 	}
 	rows, err := s.db.Query(`
-		SELECT id,hostname,ip,os,type,criticality,owner,last_seen,tags
-		FROM assets ORDER BY hostname LIMIT ?`, limit)
+		SELECT id,hostname,ip,os,type,criticality,owner,last_seen,tags,tenant
+		FROM assets WHERE (?='' OR tenant IN ('', ?)) ORDER BY hostname LIMIT ?`, tenant, tenant, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -569,7 +657,7 @@ func (s *DB) ListAssets(limit int) ([]*AssetRecord, error) {
 		var a AssetRecord
 		var lastSeenUnix int64
 		if err := rows.Scan(&a.ID, &a.Hostname, &a.IP, &a.OS, &a.Type,
-			&a.Criticality, &a.Owner, &lastSeenUnix, &a.Tags); err != nil {
+			&a.Criticality, &a.Owner, &lastSeenUnix, &a.Tags, &a.Tenant); err != nil {
 			return nil, err
 		}
 		a.LastSeen = time.Unix(lastSeenUnix, 0)
@@ -618,22 +706,23 @@ type TokenRecord struct {
 	Token     string
 	ExpiresAt time.Time
 	CreatedAt time.Time
+	Tenant    string // tenant of the events ingested with the token; "" leaves it to the sender's address and listener
 }
 
 func (s *DB) InsertToken(t *TokenRecord) error {
 	_, err := s.db.Exec(`
-		INSERT INTO api_tokens (id, user_id, token, expires_at, created_at)
-		VALUES (?, ?, ?, ?, ?)`,
-		t.ID, t.UserID, t.Token, t.ExpiresAt.Unix(), t.CreatedAt.Unix(),
+		INSERT INTO api_tokens (id, user_id, token, expires_at, created_at, tenant)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.Token, t.ExpiresAt.Unix(), t.CreatedAt.Unix(), t.Tenant,
 	)
 	return err
 }
 
 func (s *DB) GetToken(token string) (*TokenRecord, error) {
-	row := s.db.QueryRow(`SELECT id, user_id, token, expires_at, created_at FROM api_tokens WHERE token = ?`, token)
+	row := s.db.QueryRow(`SELECT id, user_id, token, expires_at, created_at, tenant FROM api_tokens WHERE token = ?`, token)
 	var t TokenRecord
 	var expires, created int64
-	if err := row.Scan(&t.ID, &t.UserID, &t.Token, &expires, &created); err == sql.ErrNoRows {
+	if err := row.Scan(&t.ID, &t.UserID, &t.Token, &expires, &created, &t.Tenant); err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		return nil, err
@@ -690,15 +779,17 @@ func (s *DB) GetUserPermissions(userID string) ([]string, error) {
 	return perms, rows.Err()
 }
 
-// GetAssetByHost retrieves a single asset by its hostname.
-func (s *DB) GetAssetByHost(hostname string) (*AssetRecord, error) {
+// GetAssetByHost retrieves a single asset by its hostname, preferring the
+// tenant's own record over a global one.
+func (s *DB) GetAssetByHost(tenant, hostname string) (*AssetRecord, error) {
 	row := s.db.QueryRow(`
-		SELECT id,hostname,ip,os,type,criticality,owner,last_seen,tags
-		FROM assets WHERE hostname = ?`, hostname)
+		SELECT id,hostname,ip,os,type,criticality,owner,last_seen,tags,tenant
+		FROM assets WHERE hostname = ? AND tenant IN ('', ?)
+		ORDER BY tenant DESC LIMIT 1`, hostname, tenant)
 	var a AssetRecord
 	var lastSeenUnix int64
 	err := row.Scan(&a.ID, &a.Hostname, &a.IP, &a.OS, &a.Type,
-		&a.Criticality, &a.Owner, &lastSeenUnix, &a.Tags)
+		&a.Criticality, &a.Owner, &lastSeenUnix, &a.Tags, &a.Tenant)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -709,6 +800,90 @@ func (s *DB) GetAssetByHost(hostname string) (*AssetRecord, error) {
 	return &a, nil
 }
 
+// ─── TENANTS ─────────────────────────────────────────────────────────────────
+
+// TenantRecord is one tenant: a set of events, alerts and cases visible only
+// to its members.
+type TenantRecord struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// UpsertTenant creates a tenant or renames an existing one.
+func (s *DB) UpsertTenant(t *TenantRecord) error {
+	_, err := s.db.Exec(`
+		INSERT INTO tenants (id, name, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET name = excluded.name`,
+		t.ID, t.Name, t.CreatedAt.Unix())
+	return err
+}
+
+// GetTenant returns one tenant, or nil if there is none.
+func (s *DB) GetTenant(id string) (*TenantRecord, error) {
+	var t TenantRecord
+	var created int64
+	err := s.db.QueryRow(`SELECT id, name, created_at FROM tenants WHERE id = ?`, id).Scan(&t.ID, &t.Name, &created)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.CreatedAt = time.Unix(created, 0)
+	return &t, nil
+}
+
+// ListTenants returns every tenant ordered by ID.
+func (s *DB) ListTenants() ([]*TenantRecord, error) {
+	rows, err := s.db.Query(`SELECT id, name, created_at FROM tenants ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tenants []*TenantRecord
+	for rows.Next() {
+		var t TenantRecord
+		var created int64
+		if err := rows.Scan(&t.ID, &t.Name, &created); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = time.Unix(created, 0)
+		tenants = append(tenants, &t)
+	}
+	return tenants, rows.Err()
+}
+
+// AddUserTenant makes a user a member of a tenant.
+func (s *DB) AddUserTenant(userID, tenantID string) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO user_tenants (user_id, tenant_id) VALUES (?, ?)`, userID, tenantID)
+	return err
+}
+
+// RemoveUserTenant ends a user's membership of a tenant.
+func (s *DB) RemoveUserTenant(userID, tenantID string) error {
+	_, err := s.db.Exec(`DELETE FROM user_tenants WHERE user_id = ? AND tenant_id = ?`, userID, tenantID)
+	return err
+}
+
+// GetUserTenants returns the IDs of the tenants a user is a member of.
+func (s *DB) GetUserTenants(userID string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT tenant_id FROM user_tenants WHERE user_id = ? ORDER BY tenant_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // ─── AGENTS ──────────────────────────────────────────────────────────────────
 
 // AgentRecord is the SQLite representation of an agent node.
@@ -783,6 +958,7 @@ type RuleRecord struct {
 	ResponseParams string
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
+	Tenant         string // "" for a rule applied to every tenant
 }
 
 // InsertRule persists a new detection rule.
@@ -793,17 +969,17 @@ func (s *DB) InsertRule(r *RuleRecord) error {
 	}
 	_, err := s.db.Exec(`
 		INSERT OR REPLACE INTO rules
-		(id, name, description, severity, enabled, mitre, condition, threshold, window, response_action, response_params, created_at, updated_at)
-		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		(id, name, description, severity, enabled, mitre, condition, threshold, window, response_action, response_params, created_at, updated_at, tenant)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 		r.ID, r.Name, r.Description, r.Severity, enabled,
-		r.MITRE, r.Condition, r.Threshold, r.Window, r.ResponseAction, r.ResponseParams, r.CreatedAt.Unix(), r.UpdatedAt.Unix(),
+		r.MITRE, r.Condition, r.Threshold, r.Window, r.ResponseAction, r.ResponseParams, r.CreatedAt.Unix(), r.UpdatedAt.Unix(), r.Tenant,
 	)
 	return err
 }
 
 // ListRules returns all rules, enabled ones first.
 func (s *DB) ListRules(enabledOnly bool) ([]*RuleRecord, error) {
-	q := `SELECT id,name,description,severity,enabled,mitre,condition,threshold,window,response_action,response_params,created_at,updated_at FROM rules`
+	q := `SELECT id,name,description,severity,enabled,mitre,condition,threshold,window,response_action,response_params,created_at,updated_at,tenant FROM rules`
 	if enabledOnly {
 		q += ` WHERE enabled=1`
 	}
@@ -819,7 +995,7 @@ func (s *DB) ListRules(enabledOnly bool) ([]*RuleRecord, error) {
 		var enabledInt int
 		var createdUnix, updatedUnix int64
 		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.Severity,
-			&enabledInt, &r.MITRE, &r.Condition, &r.Threshold, &r.Window, &r.ResponseAction, &r.ResponseParams, &createdUnix, &updatedUnix); err != nil {
+			&enabledInt, &r.MITRE, &r.Condition, &r.Threshold, &r.Window, &r.ResponseAction, &r.ResponseParams, &createdUnix, &updatedUnix, &r.Tenant); err != nil {
 			return nil, err
 		}
 		r.Enabled = enabledInt == 1
@@ -853,28 +1029,6 @@ func (s *DB) InsertIntegrityBlock(b *IntegrityBlockRecord) error {
 	return err
 }
 
-// ListIntegrityBlocks returns the N most recent sealed blocks.
-func (s *DB) ListIntegrityBlocks(limit int) ([]*IntegrityBlockRecord, error) {
-	rows, err := s.db.Query(`
-		SELECT id, root_hash, prev_hash, event_count, timestamp, signature
-		FROM integrity_blocks ORDER BY id DESC LIMIT ?`, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var blocks []*IntegrityBlockRecord
-	for rows.Next() {
-		var b IntegrityBlockRecord
-		var ts int64
-		if err := rows.Scan(&b.ID, &b.RootHash, &b.PrevHash, &b.EventCount, &ts, &b.Signature); err != nil {
-			return nil, err
-		}
-		b.Timestamp = time.Unix(ts, 0)
-		blocks = append(blocks, &b)
-	}
-	return blocks, rows.Err()
-}
-
 // ListIntegrityBlocks returns the most recent N integrity blocks ordered newest first.
 func (s *DB) ListIntegrityBlocks(limit int) ([]*IntegrityBlockRecord, error) {
 	if limit <= 0 {
@@ -918,21 +1072,61 @@ func (s *DB) GetLastIntegrityBlock() (*IntegrityBlockRecord, error) {
 	return &b, nil
 }
 
+// GetIntegrityBlock returns the block with the given ID, or nil if there is
+// none.
+func (s *DB) GetIntegrityBlock(id int64) (*IntegrityBlockRecord, error) {
+	row := s.db.QueryRow(`
+		SELECT id, root_hash, prev_hash, event_count, timestamp, signature
+		FROM integrity_blocks WHERE id = ?`, id)
+	var b IntegrityBlockRecord
+	var ts int64
+	err := row.Scan(&b.ID, &b.RootHash, &b.PrevHash, &b.EventCount, &ts, &b.Signature)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.Timestamp = time.Unix(ts, 0)
+	return &b, nil
+}
+
+// IntegrityBlockIDsBetween returns the IDs of blocks sealed in [start, end],
+// oldest first.
+func (s *DB) IntegrityBlockIDsBetween(start, end time.Time) ([]int64, error) {
+	rows, err := s.db.Query(`
+		SELECT id FROM integrity_blocks
+		WHERE timestamp >= ? AND timestamp <= ? ORDER BY id`, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // ─── DECEPTION ───────────────────────────────────────────────────────────────
 
 // InsertHoneytoken adds a new honeytoken to the database.
 func (s *DB) InsertHoneytoken(h *models.Honeytoken) error {
 	_, err := s.db.Exec(`
-		INSERT INTO honeytokens (id, type, value, description, created_at)
-		VALUES (?, ?, ?, ?, ?)`,
-		h.ID, string(h.Type), h.Value, h.Description, h.CreatedAt.Unix(),
+		INSERT INTO honeytokens (id, type, value, description, created_at, tenant)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		h.ID, string(h.Type), h.Value, h.Description, h.CreatedAt.Unix(), h.Tenant,
 	)
 	return err
 }
 
 // ListHoneytokens returns all active honeytokens.
 func (s *DB) ListHoneytokens() ([]*models.Honeytoken, error) {
-	rows, err := s.db.Query(`SELECT id, type, value, description, created_at FROM honeytokens`)
+	rows, err := s.db.Query(`SELECT id, type, value, description, created_at, tenant FROM honeytokens`)
 	if err != nil {
 		return nil, err
 	}
@@ -943,7 +1137,7 @@ func (s *DB) ListHoneytokens() ([]*models.Honeytoken, error) {
 		var h models.Honeytoken
 		var typeStr string
 		var createdAt int64
-		if err := rows.Scan(&h.ID, &typeStr, &h.Value, &h.Description, &createdAt); err != nil {
+		if err := rows.Scan(&h.ID, &typeStr, &h.Value, &h.Description, &createdAt, &h.Tenant); err != nil {
 			return nil, err
 		}
 		h.Type = models.HoneytokenType(typeStr)
@@ -1048,51 +1242,6 @@ func (s *DB) DeleteFimWatch(path string) error {
 	return err
 }
 
-// UpsertFimBaseline stores or updates the known-good hash for a file path.
-func (s *DB) UpsertFimBaseline(path, hash string) error {
-	_, err := s.db.Exec(
-		`INSERT OR REPLACE INTO fim_baselines (path, hash, updated_at) VALUES (?, ?, ?)`,
-		path, hash, time.Now().Unix(),
-	)
-	return err
-}
-
-// GetFimBaseline returns the stored hash for a path, or empty string if not found.
-func (s *DB) GetFimBaseline(path string) (string, error) {
-	var hash string
-	err := s.db.QueryRow(`SELECT hash FROM fim_baselines WHERE path = ?`, path).Scan(&hash)
-	if err == sql.ErrNoRows {
-		return "", nil
-	}
-	return hash, err
-}
-
-// ListIntegrityBlocks returns the most recent N forensics integrity blocks.
-func (s *DB) ListIntegrityBlocks(limit int) ([]*IntegrityBlockRecord, error) {
-	if limit <= 0 {
-		limit = 50
-	}
-	rows, err := s.db.Query(
-		`SELECT id, root_hash, prev_hash, event_count, timestamp, signature
-		 FROM integrity_blocks ORDER BY id DESC LIMIT ?`, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var blocks []*IntegrityBlockRecord
-	for rows.Next() {
-		var b IntegrityBlockRecord
-		var ts int64
-		if err := rows.Scan(&b.ID, &b.RootHash, &b.PrevHash, &b.EventCount, &ts, &b.Signature); err != nil {
-			return nil, err
-		}
-		b.Timestamp = time.Unix(ts, 0)
-		blocks = append(blocks, &b)
-	}
-	return blocks, rows.Err()
-}
-
 // ─── FIM BASELINES ────────────────────────────────────────────────────────────
 // Baselines store the last-known SHA-256 hash for each watched path so FIM
 // can detect changes across restarts.
@@ -1116,26 +1265,6 @@ func (s *DB) GetFimBaseline(path string) (string, error) {
 	return hash, err
 }
 
-// UpsertFimBaseline stores or updates the known-good hash for a path.
-func (s *DB) UpsertFimBaseline(path, hash string) error {
-	_, err := s.db.Exec(
-		`INSERT OR REPLACE INTO fim_baselines (path, hash, updated_at) VALUES (?, ?, ?)`,
-		path, hash, time.Now().Unix(),
-	)
-	return err
-}
-
-// GetFimBaseline retrieves the stored baseline hash for a path.
-// Returns empty string if not found.
-func (s *DB) GetFimBaseline(path string) (string, error) {
-	var hash string
-	err := s.db.QueryRow(`SELECT hash FROM fim_baselines WHERE path = ?`, path).Scan(&hash)
-	if err == sql.ErrNoRows {
-		return "", nil
-	}
-	return hash, err
-}
-
 // ─── FORENSICS & EVIDENCE ───────────────────────────────────────────────────
 
 type EvidenceRecord struct {
@@ -1221,7 +1350,11 @@ func (s *DB) ListAuditLogs(limit int) ([]*AuditRecord, error) {
 
 // Close closes the underlying database connection.
 func (s *DB) Close() error {
-	return s.db.Close()
+	err := s.db.Close()
+	if s.keyID != "" {
+		unregisterKey(s.keyID)
+	}
+	return err
 }
 
 // DB returns the underlying *sql.DB for direct queries not covered by helpers.
@@ -1229,83 +1362,512 @@ func (s *DB) DB() *sql.DB {
 	return s.db
 }
 
-// ─── INTEGRITY BLOCKS (LIST) ─────────────────────────────────────────────────
+func (s *DB) InsertSavedSearch(ss *models.SavedSearch) error {
+	_, err := s.db.Exec(`INSERT INTO saved_searches (id, name, query, created_by, created_at, tenant) VALUES (?, ?, ?, ?, ?, ?)`,
+		ss.ID, ss.Name, ss.Query, ss.CreatedBy, ss.CreatedAt.Unix(), models.TenantOrDefault(ss.Tenant))
+	return err
+}
 
-// ListIntegrityBlocks returns the most recent N forensic integrity blocks.
-func (s *DB) ListIntegrityBlocks(limit int) ([]*IntegrityBlockRecord, error) {
+// ListSavedSearches returns the saved searches of tenant, or of every
+// tenant if it is empty, newest first.
+func (s *DB) ListSavedSearches(tenant string) ([]*models.SavedSearch, error) {
+	rows, err := s.db.Query(`SELECT id, name, query, created_by, created_at, tenant FROM saved_searches WHERE (?='' OR tenant=?) ORDER BY created_at DESC`, tenant, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.SavedSearch
+	for rows.Next() {
+		var s models.SavedSearch
+		var t int64
+		if err := rows.Scan(&s.ID, &s.Name, &s.Query, &s.CreatedBy, &t, &s.Tenant); err != nil {
+			return nil, err
+		}
+		s.CreatedAt = time.Unix(t, 0)
+		results = append(results, &s)
+	}
+	return results, rows.Err()
+}
+
+func (s *DB) GetSavedSearch(id string) (*models.SavedSearch, error) {
+	var ss models.SavedSearch
+	var t int64
+	err := s.db.QueryRow(`SELECT id, name, query, created_by, created_at, tenant FROM saved_searches WHERE id = ?`, id).
+		Scan(&ss.ID, &ss.Name, &ss.Query, &ss.CreatedBy, &t, &ss.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	ss.CreatedAt = time.Unix(t, 0)
+	return &ss, nil
+}
+
+// ─── SCHEDULED SEARCHES ───────────────────────────────────────────────────────
+
+const scheduleColumns = `search_id, cron, interval_sec, window_sec, trigger_type, trigger_field,
+	trigger_op, trigger_value, throttle_sec, severity, enabled, updated_at, last_run, last_alert_at`
+
+// UpsertSearchSchedule stores the schedule of a saved search, keeping its
+// run state.
+func (s *DB) UpsertSearchSchedule(searchID string, sc *models.SearchSchedule) error {
+	enabled := 0
+	if sc.Enabled {
+		enabled = 1
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO search_schedules (search_id, cron, interval_sec, window_sec, trigger_type, trigger_field,
+			trigger_op, trigger_value, throttle_sec, severity, enabled, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(search_id) DO UPDATE SET
+			cron=excluded.cron, interval_sec=excluded.interval_sec, window_sec=excluded.window_sec,
+			trigger_type=excluded.trigger_type, trigger_field=excluded.trigger_field,
+			trigger_op=excluded.trigger_op, trigger_value=excluded.trigger_value,
+			throttle_sec=excluded.throttle_sec, severity=excluded.severity,
+			enabled=excluded.enabled, updated_at=excluded.updated_at`,
+		searchID, sc.Cron, sc.Interval, sc.Window, sc.Trigger.Type, sc.Trigger.Field,
+		sc.Trigger.Op, sc.Trigger.Threshold, sc.Throttle, string(sc.Severity), enabled, sc.UpdatedAt.Unix(),
+	)
+	return err
+}
+
+func (s *DB) DeleteSearchSchedule(searchID string) error {
+	_, err := s.db.Exec(`DELETE FROM search_schedules WHERE search_id = ?`, searchID)
+	return err
+}
+
+// ListSearchSchedules returns every schedule keyed by saved search ID.
+func (s *DB) ListSearchSchedules() (map[string]*models.SearchSchedule, error) {
+	rows, err := s.db.Query(`SELECT ` + scheduleColumns + ` FROM search_schedules`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]*models.SearchSchedule)
+	for rows.Next() {
+		var id string
+		var sc models.SearchSchedule
+		var enabled int
+		var updated, lastRun, lastAlert int64
+		if err := rows.Scan(&id, &sc.Cron, &sc.Interval, &sc.Window, &sc.Trigger.Type, &sc.Trigger.Field,
+			&sc.Trigger.Op, &sc.Trigger.Threshold, &sc.Throttle, (*string)(&sc.Severity), &enabled,
+			&updated, &lastRun, &lastAlert); err != nil {
+			return nil, err
+		}
+		sc.Enabled = enabled == 1
+		sc.UpdatedAt = time.Unix(updated, 0)
+		if lastRun > 0 {
+			sc.LastRun = time.Unix(lastRun, 0)
+		}
+		if lastAlert > 0 {
+			sc.LastAlertAt = time.Unix(lastAlert, 0)
+		}
+		out[id] = &sc
+	}
+	return out, rows.Err()
+}
+
+// UpdateSearchScheduleState records when a schedule last ran and alerted.
+func (s *DB) UpdateSearchScheduleState(searchID string, lastRun, lastAlert time.Time) error {
+	var alertTS int64
+	if !lastAlert.IsZero() {
+		alertTS = lastAlert.Unix()
+	}
+	_, err := s.db.Exec(`UPDATE search_schedules SET last_run = ?, last_alert_at = ? WHERE search_id = ?`,
+		lastRun.Unix(), alertTS, searchID)
+	return err
+}
+
+func (s *DB) InsertSearchRun(r *models.SearchRun) error {
+	_, err := s.db.Exec(`
+		INSERT INTO search_runs (id, search_id, started_at, duration_ms, hits, scanned, triggered, suppressed, alert_id, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.SearchID, r.StartedAt.Unix(), r.DurationMs, r.Hits, r.Scanned,
+		boolInt(r.Triggered), boolInt(r.Suppressed), r.AlertID, r.Error,
+	)
+	return err
+}
+
+// ListSearchRuns returns the most recent runs of a scheduled search.
+func (s *DB) ListSearchRuns(searchID string, limit int) ([]*models.SearchRun, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 	rows, err := s.db.Query(`
-		SELECT id, root_hash, prev_hash, event_count, timestamp, signature
-		FROM integrity_blocks
-		ORDER BY id DESC LIMIT ?`, limit)
+		SELECT id, search_id, started_at, duration_ms, hits, scanned, triggered, suppressed, alert_id, error
+		FROM search_runs WHERE search_id = ? ORDER BY started_at DESC LIMIT ?`, searchID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var blocks []*IntegrityBlockRecord
+	var runs []*models.SearchRun
 	for rows.Next() {
-		var b IntegrityBlockRecord
+		var r models.SearchRun
 		var ts int64
-		if err := rows.Scan(&b.ID, &b.RootHash, &b.PrevHash, &b.EventCount, &ts, &b.Signature); err != nil {
+		var triggered, suppressed int
+		if err := rows.Scan(&r.ID, &r.SearchID, &ts, &r.DurationMs, &r.Hits, &r.Scanned,
+			&triggered, &suppressed, &r.AlertID, &r.Error); err != nil {
 			return nil, err
 		}
-		b.Timestamp = time.Unix(ts, 0)
-		blocks = append(blocks, &b)
+		r.StartedAt = time.Unix(ts, 0)
+		r.Triggered = triggered == 1
+		r.Suppressed = suppressed == 1
+		runs = append(runs, &r)
 	}
-	return blocks, rows.Err()
+	return runs, rows.Err()
 }
 
-// ─── FIM BASELINES ────────────────────────────────────────────────────────────
+// PruneSearchRuns drops run history older than before.
+func (s *DB) PruneSearchRuns(before time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM search_runs WHERE started_at < ?`, before.Unix())
+	return err
+}
 
-// UpsertFimBaseline stores the current SHA-256 hash for a watched path.
-func (s *DB) UpsertFimBaseline(path, hash string) error {
+// ─── LOOKUP TABLES ────────────────────────────────────────────────────────────
+
+// LookupRecord describes the current version of a lookup table. Tables
+// with an empty Tenant are global.
+type LookupRecord struct {
+	Tenant      string
+	Name        string
+	Description string
+	KeyColumn   string
+	Columns     []string
+	Version     int
+	Rows        int
+	UpdatedAt   time.Time
+	UpdatedBy   string
+}
+
+// LookupVersionRecord is one stored version of a lookup table (without data).
+type LookupVersionRecord struct {
+	Name      string
+	Version   int
+	KeyColumn string
+	Rows      int
+	CreatedAt time.Time
+	CreatedBy string
+}
+
+// maxLookupVersions is how many versions of each lookup table are kept.
+const maxLookupVersions = 20
+
+// SaveLookupVersion stores data (CSV) as the next version of the table
+// rec.Name of rec.Tenant and makes it current. It returns the new version
+// number.
+func (s *DB) SaveLookupVersion(rec *LookupRecord, data string) (int, error) {
+	cols, err := json.Marshal(rec.Columns)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM lookup_versions WHERE tenant = ? AND name = ?`,
+		rec.Tenant, rec.Name).Scan(&version); err != nil {
+		return 0, err
+	}
+	ts := rec.UpdatedAt.Unix()
+	if _, err := tx.Exec(`
+		INSERT INTO lookup_versions (tenant, name, version, key_column, rows, data, created_at, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Tenant, rec.Name, version, rec.KeyColumn, rec.Rows, data, ts, rec.UpdatedBy); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO lookup_tables (tenant, name, description, key_column, columns, version, rows, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Tenant, rec.Name, rec.Description, rec.KeyColumn, string(cols), version, rec.Rows, ts, rec.UpdatedBy); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM lookup_versions WHERE tenant = ? AND name = ? AND version <= ?`,
+		rec.Tenant, rec.Name, version-maxLookupVersions); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	rec.Version = version
+	return version, nil
+}
+
+// GetLookup returns the table name of tenant ("" for a global table).
+func (s *DB) GetLookup(tenant, name string) (*LookupRecord, error) {
+	row := s.db.QueryRow(`
+		SELECT tenant, name, description, key_column, columns, version, rows, updated_at, updated_by
+		FROM lookup_tables WHERE tenant = ? AND name = ?`, tenant, name)
+	return scanLookup(row)
+}
+
+// ListLookups returns the global tables and those of tenant; an empty
+// tenant returns every table.
+func (s *DB) ListLookups(tenant string) ([]*LookupRecord, error) {
+	rows, err := s.db.Query(`
+		SELECT tenant, name, description, key_column, columns, version, rows, updated_at, updated_by
+		FROM lookup_tables WHERE (?='' OR tenant IN ('', ?)) ORDER BY name, tenant`, tenant, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*LookupRecord
+	for rows.Next() {
+		rec, err := scanLookup(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rec)
+	}
+	return list, rows.Err()
+}
+
+func scanLookup(row interface{ Scan(...interface{}) error }) (*LookupRecord, error) {
+	var r LookupRecord
+	var cols string
+	var ts int64
+	if err := row.Scan(&r.Tenant, &r.Name, &r.Description, &r.KeyColumn, &cols, &r.Version, &r.Rows, &ts, &r.UpdatedBy); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(cols), &r.Columns)
+	r.UpdatedAt = time.Unix(ts, 0)
+	return &r, nil
+}
+
+// ListLookupVersions returns the stored versions of a table, newest first.
+func (s *DB) ListLookupVersions(tenant, name string) ([]*LookupVersionRecord, error) {
+	rows, err := s.db.Query(`
+		SELECT name, version, key_column, rows, created_at, created_by
+		FROM lookup_versions WHERE tenant = ? AND name = ? ORDER BY version DESC`, tenant, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*LookupVersionRecord
+	for rows.Next() {
+		var v LookupVersionRecord
+		var ts int64
+		if err := rows.Scan(&v.Name, &v.Version, &v.KeyColumn, &v.Rows, &ts, &v.CreatedBy); err != nil {
+			return nil, err
+		}
+		v.CreatedAt = time.Unix(ts, 0)
+		list = append(list, &v)
+	}
+	return list, rows.Err()
+}
+
+// GetLookupData returns the CSV of one version of a table.
+func (s *DB) GetLookupData(tenant, name string, version int) (string, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM lookup_versions WHERE tenant = ? AND name = ? AND version = ?`,
+		tenant, name, version).Scan(&data)
+	return data, err
+}
+
+// DeleteLookup removes a table and all of its versions.
+func (s *DB) DeleteLookup(tenant, name string) error {
+	if _, err := s.db.Exec(`DELETE FROM lookup_versions WHERE tenant = ? AND name = ?`, tenant, name); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM lookup_tables WHERE tenant = ? AND name = ?`, tenant, name)
+	return err
+}
+
+// ─── LEGAL HOLDS ──────────────────────────────────────────────────────────────
+
+// LegalHoldRecord pins events against retention: those linked to CaseID
+// (evidence and the alerts attached to the case) and/or every event in
+// [Start, End]. A hold applies until it is released.
+type LegalHoldRecord struct {
+	ID         string
+	Name       string
+	CaseID     string
+	Start      time.Time
+	End        time.Time
+	Reason     string
+	CreatedBy  string
+	CreatedAt  time.Time
+	ReleasedBy string
+	ReleasedAt time.Time
+}
+
+// Active reports whether the hold has not been released.
+func (h *LegalHoldRecord) Active() bool { return h.ReleasedAt.IsZero() }
+
+// HasRange reports whether the hold pins a time range.
+func (h *LegalHoldRecord) HasRange() bool { return !h.Start.IsZero() || !h.End.IsZero() }
+
+// InsertLegalHold stores a new hold.
+func (s *DB) InsertLegalHold(h *LegalHoldRecord) error {
 	_, err := s.db.Exec(`
-		INSERT INTO fim_baselines (path, hash, updated_at)
-		VALUES (?, ?, ?)
-		ON CONFLICT(path) DO UPDATE SET hash = excluded.hash, updated_at = excluded.updated_at`,
-		path, hash, time.Now().Unix(),
+		INSERT INTO legal_holds (id, name, case_id, start_at, end_at, reason, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		h.ID, h.Name, h.CaseID, unixOrZero(h.Start), unixOrZero(h.End), h.Reason, h.CreatedBy, h.CreatedAt.Unix(),
 	)
 	return err
 }
 
-// GetFimBaseline retrieves the stored baseline hash for a path.
-// Returns empty string if no baseline exists yet.
-func (s *DB) GetFimBaseline(path string) (string, error) {
-	var hash string
-	err := s.db.QueryRow(`SELECT hash FROM fim_baselines WHERE path = ?`, path).Scan(&hash)
-	if err == sql.ErrNoRows {
-		return "", nil
+// ReleaseLegalHold ends an active hold. It returns sql.ErrNoRows when no
+// active hold has that ID.
+func (s *DB) ReleaseLegalHold(id, by string, at time.Time) error {
+	res, err := s.db.Exec(`UPDATE legal_holds SET released_by = ?, released_at = ? WHERE id = ? AND released_at = 0`,
+		by, at.Unix(), id)
+	if err != nil {
+		return err
 	}
-	return hash, err
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func (s *DB) InsertSavedSearch(id, name, query, user string, created int64) error {
-	_, err := s.db.Exec(`INSERT INTO saved_searches (id, name, query, created_by, created_at) VALUES (?, ?, ?, ?, ?)`,
-		id, name, query, user, created)
-	return err
+// ListLegalHolds returns holds newest first; released holds are included
+// only when all is set.
+func (s *DB) ListLegalHolds(all bool) ([]*LegalHoldRecord, error) {
+	q := `SELECT id, name, case_id, start_at, end_at, reason, created_by, created_at, released_by, released_at FROM legal_holds`
+	if !all {
+		q += ` WHERE released_at = 0`
+	}
+	rows, err := s.db.Query(q + ` ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []*LegalHoldRecord
+	for rows.Next() {
+		var h LegalHoldRecord
+		var start, end, created, released int64
+		if err := rows.Scan(&h.ID, &h.Name, &h.CaseID, &start, &end, &h.Reason,
+			&h.CreatedBy, &created, &h.ReleasedBy, &released); err != nil {
+			return nil, err
+		}
+		h.Start, h.End = timeOrZero(start), timeOrZero(end)
+		h.CreatedAt = time.Unix(created, 0)
+		h.ReleasedAt = timeOrZero(released)
+		holds = append(holds, &h)
+	}
+	return holds, rows.Err()
 }
 
-func (s *DB) ListSavedSearches() ([]*models.SavedSearch, error) {
-	rows, err := s.db.Query(`SELECT id, name, query, created_by, created_at FROM saved_searches ORDER BY created_at DESC`)
+// HeldCaseEventIDs returns the IDs of events linked to cases under an
+// active hold: evidence records and the events behind the cases' alerts,
+// including every event of a correlation alert.
+func (s *DB) HeldCaseEventIDs() ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT e.event_id FROM evidence e
+		JOIN legal_holds h ON h.case_id = e.case_id AND h.case_id != '' AND h.released_at = 0
+		UNION
+		SELECT a.event_id FROM alerts a
+		JOIN case_alerts ca ON ca.alert_id = a.id
+		JOIN legal_holds h ON h.case_id = ca.case_id AND h.case_id != '' AND h.released_at = 0
+		UNION
+		SELECT j.value FROM alerts a
+		JOIN case_alerts ca ON ca.alert_id = a.id
+		JOIN legal_holds h ON h.case_id = ca.case_id AND h.case_id != '' AND h.released_at = 0
+		JOIN json_each(a.event_ids) j
+		WHERE a.event_ids != ''`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
 
-	var results []*models.SavedSearch
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ─── DETECTION STATE ──────────────────────────────────────────────────────────
+
+// DetectionStateRecord is one checkpointed piece of detection engine state:
+// a threshold counter, alert cooldown or partial sequence match. Data is
+// the engine's encoding of it.
+type DetectionStateRecord struct {
+	Kind      string
+	RuleID    string
+	Key       string
+	Data      string
+	ExpiresAt time.Time
+}
+
+// ReplaceDetectionState replaces the stored detection state with recs.
+func (s *DB) ReplaceDetectionState(recs []*DetectionStateRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM detection_state`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO detection_state (kind, rule_id, key, data, expires_at) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range recs {
+		if _, err := stmt.Exec(r.Kind, r.RuleID, r.Key, r.Data, r.ExpiresAt.Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListDetectionState returns the stored detection state.
+func (s *DB) ListDetectionState() ([]*DetectionStateRecord, error) {
+	rows, err := s.db.Query(`SELECT kind, rule_id, key, data, expires_at FROM detection_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []*DetectionStateRecord
 	for rows.Next() {
-		var s models.SavedSearch
-		var t int64
-		if err := rows.Scan(&s.ID, &s.Name, &s.Query, &s.CreatedBy, &t); err != nil {
+		var r DetectionStateRecord
+		var expires int64
+		if err := rows.Scan(&r.Kind, &r.RuleID, &r.Key, &r.Data, &expires); err != nil {
 			return nil, err
 		}
-		s.CreatedAt = time.Unix(t, 0)
-		results = append(results, &s)
+		r.ExpiresAt = time.Unix(expires, 0)
+		recs = append(recs, &r)
 	}
-	return results, rows.Err()
+	return recs, rows.Err()
+}
+
+// DeleteDetectionState removes the stored state of a rule.
+func (s *DB) DeleteDetectionState(ruleID string) error {
+	_, err := s.db.Exec(`DELETE FROM detection_state WHERE rule_id = ?`, ruleID)
+	return err
 }
 
 // ─── SCAN HELPERS ─────────────────────────────────────────────────────────────
@@ -1313,13 +1875,14 @@ func (s *DB) ListSavedSearches() ([]*models.SavedSearch, error) {
 func scanAlert(row *sql.Row) (*models.Alert, error) {
 	var a models.Alert
 	var ts int64
-	var meta string
+	var meta, ids string
 	if err := row.Scan(&a.ID, &a.EventID, &a.RuleID, &ts,
-		(*string)(&a.Severity), &a.Title, &a.Summary, &a.Status, &a.Assignee, &a.Host, &meta); err != nil {
+		(*string)(&a.Severity), &a.Title, &a.Summary, &a.Status, &a.Assignee, &a.Host, &meta, &a.Tenant, &ids); err != nil {
 		return nil, err
 	}
 	a.Timestamp = time.Unix(ts, 0)
 	a.Metadata = parseMetadata(meta)
+	a.EventIDs = parseIDs(ids)
 	return &a, nil
 }
 
@@ -1328,13 +1891,14 @@ func scanAlerts(rows *sql.Rows) ([]*models.Alert, error) {
 	for rows.Next() {
 		var a models.Alert
 		var ts int64
-		var meta string
+		var meta, ids string
 		if err := rows.Scan(&a.ID, &a.EventID, &a.RuleID, &ts,
-			(*string)(&a.Severity), &a.Title, &a.Summary, &a.Status, &a.Assignee, &a.Host, &meta); err != nil {
+			(*string)(&a.Severity), &a.Title, &a.Summary, &a.Status, &a.Assignee, &a.Host, &meta, &a.Tenant, &ids); err != nil {
 			return nil, err
 		}
 		a.Timestamp = time.Unix(ts, 0)
 		a.Metadata = parseMetadata(meta)
+		a.EventIDs = parseIDs(ids)
 		alerts = append(alerts, &a)
 	}
 	return alerts, rows.Err()
@@ -1348,6 +1912,23 @@ func serializeMetadata(m map[string]string) string {
 	return string(b)
 }
 
+func serializeIDs(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(ids)
+	return string(b)
+}
+
+func parseIDs(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var ids []string
+	_ = json.Unmarshal([]byte(s), &ids)
+	return ids
+}
+
 func parseMetadata(s string) map[string]string {
 	m := make(map[string]string)
 	if s == "" {
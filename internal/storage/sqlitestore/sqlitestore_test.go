@@ -1,7 +1,13 @@
 package sqlitestore_test
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -49,7 +55,7 @@ func TestInsertAndListAlerts(t *testing.T) {
 		t.Fatalf("InsertAlert: %v", err)
 	}
 
-	alerts, err := db.ListAlerts("open", "", 100)
+	alerts, err := db.ListAlerts("", "open", "", 100)
 	if err != nil {
 		t.Fatalf("ListAlerts: %v", err)
 	}
@@ -59,6 +65,22 @@ func TestInsertAndListAlerts(t *testing.T) {
 	if alerts[0].Title != a.Title {
 		t.Errorf("title mismatch: %s != %s", alerts[0].Title, a.Title)
 	}
+	if alerts[0].EventIDs != nil {
+		t.Errorf("EventIDs = %v, want none", alerts[0].EventIDs)
+	}
+
+	a.ID = uuid.NewString()
+	a.EventIDs = []string{uuid.NewString(), uuid.NewString(), a.EventID}
+	if err := db.InsertAlert(a); err != nil {
+		t.Fatalf("InsertAlert: %v", err)
+	}
+	got, err := db.GetAlert(a.ID)
+	if err != nil {
+		t.Fatalf("GetAlert: %v", err)
+	}
+	if !slices.Equal(got.EventIDs, a.EventIDs) {
+		t.Errorf("EventIDs = %v, want %v", got.EventIDs, a.EventIDs)
+	}
 }
 
 func TestUpdateAlertStatus(t *testing.T) {
@@ -104,7 +126,7 @@ func TestAlertCounts(t *testing.T) {
 		})
 	}
 
-	counts, err := db.AlertCounts()
+	counts, err := db.AlertCounts("")
 	if err != nil {
 		t.Fatalf("AlertCounts: %v", err)
 	}
@@ -134,7 +156,7 @@ func TestInsertAndListCases(t *testing.T) {
 	if err := db.InsertCase(c); err != nil {
 		t.Fatalf("InsertCase: %v", err)
 	}
-	cases, err := db.ListCases("", 100)
+	cases, err := db.ListCases("", "", 100)
 	if err != nil {
 		t.Fatalf("ListCases: %v", err)
 	}
@@ -159,7 +181,7 @@ func TestUpdateCaseStatus(t *testing.T) {
 	if err := db.UpdateCaseStatus(c.ID, "resolved"); err != nil {
 		t.Fatalf("UpdateCaseStatus: %v", err)
 	}
-	cases, err := db.ListCases("resolved", 10)
+	cases, err := db.ListCases("", "resolved", 10)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -259,7 +281,7 @@ func TestUpsertAndListAssets(t *testing.T) {
 	if err := db.UpsertAsset(asset); err != nil {
 		t.Fatalf("UpsertAsset: %v", err)
 	}
-	assets, err := db.ListAssets(100)
+	assets, err := db.ListAssets("", 100)
 	if err != nil {
 		t.Fatalf("ListAssets: %v", err)
 	}
@@ -270,3 +292,290 @@ func TestUpsertAndListAssets(t *testing.T) {
 		t.Errorf("criticality = %q", assets[0].Criticality)
 	}
 }
+
+// ─── Encryption ──────────────────────────────────────────────────────────────
+
+func TestTenantScoping(t *testing.T) {
+	db, cleanup := tmpDB(t)
+	defer cleanup()
+
+	for i, tenant := range []string{"", "acme", "acme"} {
+		a := &models.Alert{ID: uuid.NewString(), EventID: uuid.NewString(), RuleID: "r",
+			Timestamp: time.Now(), Severity: models.SeverityHigh, Title: strconv.Itoa(i), Status: "open", Tenant: tenant}
+		if err := db.InsertAlert(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for tenant, want := range map[string]int{"": 3, "acme": 2, models.DefaultTenant: 1, "globex": 0} {
+		alerts, err := db.ListAlerts(tenant, "", "", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(alerts) != want {
+			t.Errorf("ListAlerts(%q) = %d alerts, want %d", tenant, len(alerts), want)
+		}
+	}
+	if counts, err := db.AlertCounts("acme"); err != nil || counts["HIGH"] != 2 {
+		t.Errorf("AlertCounts(acme) = %v, %v", counts, err)
+	}
+
+	// A tenant's own asset record wins over the global one.
+	now := time.Now()
+	for _, tenant := range []string{"", "acme"} {
+		if err := db.UpsertAsset(&sqlitestore.AssetRecord{ID: uuid.NewString(), Hostname: "dc-01",
+			Criticality: "high", LastSeen: now, Tenant: tenant}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a, err := db.GetAssetByHost("acme", "dc-01"); err != nil || a == nil || a.Tenant != "acme" {
+		t.Errorf("GetAssetByHost(acme) = %+v, %v", a, err)
+	}
+	if a, err := db.GetAssetByHost("globex", "dc-01"); err != nil || a == nil || a.Tenant != "" {
+		t.Errorf("GetAssetByHost(globex) = %+v, %v", a, err)
+	}
+
+	// Membership.
+	if err := db.UpsertTenant(&sqlitestore.TenantRecord{ID: "acme", Name: "Acme", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if tenants, err := db.ListTenants(); err != nil || len(tenants) != 2 {
+		t.Fatalf("ListTenants = %v, %v; want default and acme", tenants, err)
+	}
+	if err := db.InsertUser(&sqlitestore.UserRecord{ID: "u1", Username: "alice", PasswordHash: "x", Role: "analyst", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddUserTenant("u1", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := db.GetUserTenants("u1"); err != nil || len(ids) != 1 || ids[0] != "acme" {
+		t.Errorf("GetUserTenants = %v, %v", ids, err)
+	}
+	if err := db.RemoveUserTenant("u1", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := db.GetUserTenants("u1"); len(ids) != 0 {
+		t.Errorf("membership kept after removal: %v", ids)
+	}
+}
+
+func TestEncryptedDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oblivra.db")
+	key := make([]byte, 32)
+	key[0] = 3
+	alert := func(title string) *models.Alert {
+		return &models.Alert{ID: uuid.NewString(), EventID: uuid.NewString(), RuleID: "r", Timestamp: time.Now(), Severity: models.SeverityHigh, Title: title, Status: "open"}
+	}
+
+	// A plaintext database is imported and removed.
+	plain, err := sqlitestore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.InsertAlert(alert("plaintext era")); err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+
+	db, err := sqlitestore.OpenEncrypted(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertAlert(alert("Kerberoasting zanzibar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("plaintext database not removed")
+	}
+	raw, err := os.ReadFile(path + sqlitestore.EncryptedSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "zanzibar") || strings.Contains(string(raw), "SQLite format") {
+		t.Fatal("database is not encrypted")
+	}
+
+	db, err = sqlitestore.OpenEncrypted(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts, err := db.ListAlerts("", "open", "", 10)
+	if err != nil || len(alerts) != 2 {
+		t.Fatalf("ListAlerts after reopen = %d, %v", len(alerts), err)
+	}
+	db.Close()
+
+	if _, err := sqlitestore.OpenEncrypted(path, make([]byte, 32)); err == nil {
+		t.Fatal("opened with the wrong key")
+	}
+	if _, err := sqlitestore.Open(path); err == nil {
+		t.Fatal("encrypted database opened as plaintext")
+	}
+}
+
+func TestEncryptedCommitsSurviveCrash(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "oblivra.db")
+	key := make([]byte, 32)
+	key[0] = 5
+	db, err := sqlitestore.OpenEncrypted(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var ids []string
+	for i := 0; i < 200; i++ {
+		a := &models.Alert{ID: uuid.NewString(), EventID: uuid.NewString(), RuleID: "r", Timestamp: time.Now(), Severity: models.SeverityHigh, Title: "zanzibar " + strconv.Itoa(i), Status: "open"}
+		if err := db.InsertAlert(a); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, a.ID)
+	}
+	if _, err := db.DB().Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path + sqlitestore.EncryptedSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateAlertStatus(ids[0], "closed", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB().Exec(`DELETE FROM alerts WHERE id = ?`, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy the files as a crash would leave them: the commits are only in
+	// the WAL.
+	crash := filepath.Join(t.TempDir(), "oblivra.db")
+	for _, suffix := range []string{sqlitestore.EncryptedSuffix, sqlitestore.EncryptedSuffix + "-wal"} {
+		raw, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(raw), "zanzibar") || strings.Contains(string(raw), "alice") {
+			t.Fatalf("%s is not encrypted", suffix)
+		}
+		if err := os.WriteFile(crash+suffix, raw, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	re, err := sqlitestore.OpenEncrypted(crash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer re.Close()
+	alerts, err := re.ListAlerts("", "", "", 1000)
+	if err != nil || len(alerts) != len(ids)-1 {
+		t.Fatalf("ListAlerts after crash = %d, %v", len(alerts), err)
+	}
+	a, err := re.GetAlert(ids[0])
+	if err != nil || a == nil || a.Status != "closed" || a.Assignee != "alice" {
+		t.Fatalf("GetAlert after crash = %+v, %v", a, err)
+	}
+	if a, _ := re.GetAlert(ids[1]); a != nil {
+		t.Error("deleted alert came back")
+	}
+
+	// Checkpointing writes back only the pages that changed.
+	if _, err := db.DB().Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(path + sqlitestore.EncryptedSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := 0
+	for off := 0; off < len(before) && off < len(after); off += 4096 {
+		if !bytes.Equal(before[off:off+4096], after[off:off+4096]) {
+			changed++
+		}
+	}
+	if blocks := len(before) / 4096; changed == 0 || changed > blocks/2 {
+		t.Errorf("%d of %d blocks rewritten", changed, blocks)
+	}
+}
+
+// ─── Migrations ──────────────────────────────────────────────────────────────
+
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oblivra.db")
+	version := func(db *sqlitestore.DB) int {
+		t.Helper()
+		var v int
+		if err := db.DB().QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// A fresh database is migrated without a backup.
+	db, err := sqlitestore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := version(db); v != sqlitestore.SchemaVersion() {
+		t.Fatalf("fresh database at version %d, want %d", v, sqlitestore.SchemaVersion())
+	}
+	if err := db.InsertAlert(&models.Alert{ID: "a1", EventID: "e1", RuleID: "r", Timestamp: time.Now(), Severity: models.SeverityLow, Title: "kept", Status: "open"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A database from before versioned migrations is backed up and upgraded.
+	if _, err := db.DB().Exec(`DROP TABLE schema_version`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = sqlitestore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := version(db); v != sqlitestore.SchemaVersion() {
+		t.Fatalf("legacy database at version %d, want %d", v, sqlitestore.SchemaVersion())
+	}
+	if _, err := os.Stat(path + ".v0.bak"); err != nil {
+		t.Fatalf("no pre-migration backup: %v", err)
+	}
+	if a, err := db.GetAlert("a1"); err != nil || a.Title != "kept" {
+		t.Fatalf("GetAlert after migration = %v, %v", a, err)
+	}
+
+	// Reopening an up-to-date database changes nothing.
+	db.Close()
+	db, err = sqlitestore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".v" + strconv.Itoa(sqlitestore.SchemaVersion()) + ".bak"); !os.IsNotExist(err) {
+		t.Error("backup taken with no migration pending")
+	}
+
+	// Lookup tables from before tenants become global.
+	if _, err := db.DB().Exec(`
+DROP TABLE lookup_tables;
+CREATE TABLE lookup_tables (name TEXT PRIMARY KEY, description TEXT NOT NULL DEFAULT '', key_column TEXT NOT NULL,
+    columns TEXT NOT NULL, version INTEGER NOT NULL, rows INTEGER NOT NULL, updated_at INTEGER NOT NULL, updated_by TEXT NOT NULL);
+INSERT INTO lookup_tables VALUES ('vip_users', '', 'user', '["user"]', 1, 1, 0, 'x');
+DELETE FROM schema_version WHERE version = 6`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if db, err = sqlitestore.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := db.GetLookup("", "vip_users"); err != nil || rec.KeyColumn != "user" {
+		t.Fatalf("GetLookup after migration = %+v, %v", rec, err)
+	}
+
+	// A schema written by a newer build is refused.
+	if _, err := db.DB().Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', 0)`, sqlitestore.SchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := sqlitestore.Open(path); !errors.Is(err, sqlitestore.ErrSchemaTooNew) {
+		t.Fatalf("Open of a newer schema = %v, want ErrSchemaTooNew", err)
+	}
+}
@@ -0,0 +1,373 @@
+package sqlitestore
+
+// vfs.go encrypts databases page by page. It registers the SQLite VFS
+// cryptVFSName, which wraps the default one and encrypts the files a
+// database writes (the database, its WAL and rollback journals, and
+// temporary files) in blocks of cryptBlockSize bytes with AES-256-XTS,
+// each block tweaked by its position. XTS preserves length, so a changed
+// page is rewritten in place and nothing else is; a write that covers part
+// of a block reads, decrypts and re-encrypts the whole block, and files are
+// kept a whole number of blocks long. The encryption keeps the data
+// confidential but is not authenticated: it cannot detect blocks that were
+// altered or rolled back.
+//
+// A database's key is passed by reference: OpenEncrypted registers it and
+// names it in the URI parameter cryptKeyParam, which SQLite also resolves
+// for the database's journals. Temporary files get random keys.
+// Super-journals hold only file names and are not encrypted.
+
+import (
+	"crypto/aes"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/crypto/xts"
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	cryptVFSName   = "oblivra-crypt"
+	cryptKeyParam  = "cryptkey"
+	cryptBlockSize = 4096
+)
+
+// cryptDevice are the device characteristics of the wrapped VFS that still
+// hold once its blocks are encrypted.
+const cryptDevice = sqlite3.SQLITE_IOCAP_UNDELETABLE_WHEN_OPEN | sqlite3.SQLITE_IOCAP_IMMUTABLE | sqlite3.SQLITE_IOCAP_BATCH_ATOMIC
+
+var (
+	cryptOnce    sync.Once
+	cryptErr     error
+	cryptVFS     sqlite3.Tsqlite3_vfs
+	cryptMethods sqlite3.Tsqlite3_io_methods
+	cryptParam   uintptr // cryptKeyParam as a C string
+	baseVFS      uintptr
+
+	cryptMu    sync.RWMutex
+	cryptKeys  = map[string]*xts.Cipher{}
+	cryptFiles = map[uintptr]*cryptFile{}
+	cryptSeq   uint64
+)
+
+// cryptFile is an open encrypted file. SQLite allocates it as a pointer
+// to cryptMethods followed by the wrapped file. The block buffer is in C
+// memory, which the wrapped file reads into and writes from.
+type cryptFile struct {
+	xts   *xts.Cipher
+	base  uintptr
+	buf   uintptr
+	block []byte // buf
+}
+
+// registerKey makes key (32 bytes) available to the VFS and returns the
+// name to pass in cryptKeyParam.
+func registerKey(key []byte) (string, error) {
+	if len(key) != 32 {
+		return "", fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	if err := registerCryptVFS(); err != nil {
+		return "", err
+	}
+	pageKey, err := hkdf.Key(sha256.New, key, nil, "oblivra sqlite pages", 64)
+	if err != nil {
+		return "", err
+	}
+	c, err := xts.NewCipher(aes.NewCipher, pageKey)
+	if err != nil {
+		return "", err
+	}
+	cryptMu.Lock()
+	defer cryptMu.Unlock()
+	cryptSeq++
+	id := strconv.FormatUint(cryptSeq, 10)
+	cryptKeys[id] = c
+	return id, nil
+}
+
+func unregisterKey(id string) {
+	cryptMu.Lock()
+	defer cryptMu.Unlock()
+	delete(cryptKeys, id)
+}
+
+// checkKey reports whether the first block of data, the start of an
+// encrypted database, decrypts with the key registered as id.
+func checkKey(id string, data []byte) bool {
+	cryptMu.RLock()
+	c := cryptKeys[id]
+	cryptMu.RUnlock()
+	if c == nil || len(data) < cryptBlockSize {
+		return false
+	}
+	block := make([]byte, cryptBlockSize)
+	c.Decrypt(block, data[:cryptBlockSize], 0)
+	return string(block[:16]) == "SQLite format 3\x00"
+}
+
+func registerCryptVFS() error {
+	cryptOnce.Do(func() {
+		tls := libc.NewTLS()
+		defer tls.Close()
+		baseVFS = sqlite3.Xsqlite3_vfs_find(tls, 0)
+		if baseVFS == 0 {
+			cryptErr = errors.New("no default VFS")
+			return
+		}
+		name, err := libc.CString(cryptVFSName)
+		if err == nil {
+			cryptParam, err = libc.CString(cryptKeyParam)
+		}
+		if err != nil {
+			cryptErr = err
+			return
+		}
+		cryptVFS = *(*sqlite3.Tsqlite3_vfs)(ptr(baseVFS))
+		cryptVFS.FszOsFile += int32(unsafe.Sizeof(sqlite3.Tsqlite3_file{}))
+		cryptVFS.FzName = name
+		cryptVFS.FpNext = 0
+		setFunc(&cryptVFS.FxOpen, cryptOpen)
+
+		cryptMethods.FiVersion = 2 // no memory-mapped I/O, which would bypass decryption
+		setFunc(&cryptMethods.FxClose, cryptClose)
+		setFunc(&cryptMethods.FxRead, cryptRead)
+		setFunc(&cryptMethods.FxWrite, cryptWrite)
+		setFunc(&cryptMethods.FxTruncate, cryptTruncate)
+		setFunc(&cryptMethods.FxSync, cryptSync)
+		setFunc(&cryptMethods.FxFileSize, cryptFileSize)
+		setFunc(&cryptMethods.FxLock, cryptLock)
+		setFunc(&cryptMethods.FxUnlock, cryptUnlock)
+		setFunc(&cryptMethods.FxCheckReservedLock, cryptCheckReservedLock)
+		setFunc(&cryptMethods.FxFileControl, cryptFileControl)
+		setFunc(&cryptMethods.FxSectorSize, cryptSectorSize)
+		setFunc(&cryptMethods.FxDeviceCharacteristics, cryptDeviceCharacteristics)
+		setFunc(&cryptMethods.FxShmMap, cryptShmMap)
+		setFunc(&cryptMethods.FxShmLock, cryptShmLock)
+		setFunc(&cryptMethods.FxShmBarrier, cryptShmBarrier)
+		setFunc(&cryptMethods.FxShmUnmap, cryptShmUnmap)
+
+		if rc := sqlite3.Xsqlite3_vfs_register(tls, uintptr(unsafe.Pointer(&cryptVFS)), 0); rc != sqlite3.SQLITE_OK {
+			cryptErr = fmt.Errorf("register VFS: error %d", rc)
+		}
+	})
+	return cryptErr
+}
+
+// setFunc stores fn in a C function pointer slot, the way the transpiled
+// SQLite stores and calls them.
+func setFunc[F any](slot *uintptr, fn F) {
+	*(*F)(unsafe.Pointer(slot)) = fn
+}
+
+// fn returns the Go function in a C function pointer slot.
+func fn[F any](slot *uintptr) F {
+	return *(*F)(unsafe.Pointer(slot))
+}
+
+// ptr converts an address SQLite passed in to a pointer.
+func ptr(p uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&p))
+}
+
+func cryptOpen(tls *libc.TLS, pVfs, zName, pFile uintptr, flags int32, pOutFlags uintptr) int32 {
+	base := (*sqlite3.Tsqlite3_vfs)(ptr(baseVFS))
+	open := fn[func(*libc.TLS, uintptr, uintptr, uintptr, int32, uintptr) int32](&base.FxOpen)
+	if flags&sqlite3.SQLITE_OPEN_SUPER_JOURNAL != 0 {
+		return open(tls, baseVFS, zName, pFile, flags, pOutFlags)
+	}
+	file := (*sqlite3.Tsqlite3_file)(ptr(pFile))
+	file.FpMethods = 0
+	var c *xts.Cipher
+	if zName != 0 && flags&(sqlite3.SQLITE_OPEN_MAIN_DB|sqlite3.SQLITE_OPEN_MAIN_JOURNAL|sqlite3.SQLITE_OPEN_WAL) != 0 {
+		id := libc.GoString(sqlite3.Xsqlite3_uri_parameter(tls, zName, cryptParam))
+		cryptMu.RLock()
+		c = cryptKeys[id]
+		cryptMu.RUnlock()
+		if c == nil {
+			return sqlite3.SQLITE_AUTH
+		}
+	} else {
+		key := make([]byte, 64)
+		if _, err := rand.Read(key); err != nil {
+			return sqlite3.SQLITE_CANTOPEN
+		}
+		var err error
+		if c, err = xts.NewCipher(aes.NewCipher, key); err != nil {
+			return sqlite3.SQLITE_CANTOPEN
+		}
+	}
+	f := &cryptFile{xts: c, base: pFile + unsafe.Sizeof(*file), buf: libc.Xmalloc(tls, cryptBlockSize)}
+	if f.buf == 0 {
+		return sqlite3.SQLITE_NOMEM
+	}
+	f.block = unsafe.Slice((*byte)(ptr(f.buf)), cryptBlockSize)
+	if rc := open(tls, baseVFS, zName, f.base, flags, pOutFlags); rc != sqlite3.SQLITE_OK {
+		libc.Xfree(tls, f.buf)
+		return rc
+	}
+	cryptMu.Lock()
+	cryptFiles[pFile] = f
+	cryptMu.Unlock()
+	file.FpMethods = uintptr(unsafe.Pointer(&cryptMethods))
+	return sqlite3.SQLITE_OK
+}
+
+func openFile(pFile uintptr) *cryptFile {
+	cryptMu.RLock()
+	defer cryptMu.RUnlock()
+	return cryptFiles[pFile]
+}
+
+// methods returns the I/O methods of the wrapped file.
+func (f *cryptFile) methods() *sqlite3.Tsqlite3_io_methods {
+	return (*sqlite3.Tsqlite3_io_methods)(ptr((*sqlite3.Tsqlite3_file)(ptr(f.base)).FpMethods))
+}
+
+func (f *cryptFile) read(tls *libc.TLS, off int64) int32 {
+	read := fn[func(*libc.TLS, uintptr, uintptr, int32, int64) int32](&f.methods().FxRead)
+	return read(tls, f.base, f.buf, cryptBlockSize, off)
+}
+
+func (f *cryptFile) write(tls *libc.TLS, off int64) int32 {
+	write := fn[func(*libc.TLS, uintptr, uintptr, int32, int64) int32](&f.methods().FxWrite)
+	return write(tls, f.base, f.buf, cryptBlockSize, off)
+}
+
+func cryptClose(tls *libc.TLS, pFile uintptr) int32 {
+	f := openFile(pFile)
+	rc := fn[func(*libc.TLS, uintptr) int32](&f.methods().FxClose)(tls, f.base)
+	cryptMu.Lock()
+	delete(cryptFiles, pFile)
+	cryptMu.Unlock()
+	libc.Xfree(tls, f.buf)
+	return rc
+}
+
+// cryptRead reads the blocks covering the range and decrypts them. A block
+// past the end of the file, or cut short by a crash, reads as zeros.
+func cryptRead(tls *libc.TLS, pFile, zBuf uintptr, iAmt int32, iOfst int64) int32 {
+	f := openFile(pFile)
+	buf := unsafe.Slice((*byte)(ptr(zBuf)), iAmt)
+	n := 0
+	for off := iOfst &^ (cryptBlockSize - 1); n < len(buf); off += cryptBlockSize {
+		if rc := f.read(tls, off); rc != sqlite3.SQLITE_OK {
+			if rc == sqlite3.SQLITE_IOERR_SHORT_READ {
+				clear(buf[n:])
+			}
+			return rc
+		}
+		f.xts.Decrypt(f.block, f.block, uint64(off/cryptBlockSize))
+		n += copy(buf[n:], f.block[iOfst+int64(n)-off:])
+	}
+	return sqlite3.SQLITE_OK
+}
+
+// cryptWrite encrypts the range block by block, reading back the blocks it
+// covers only in part.
+func cryptWrite(tls *libc.TLS, pFile, zBuf uintptr, iAmt int32, iOfst int64) int32 {
+	f := openFile(pFile)
+	data := unsafe.Slice((*byte)(ptr(zBuf)), iAmt)
+	n := 0
+	for off := iOfst &^ (cryptBlockSize - 1); n < len(data); off += cryptBlockSize {
+		start := int(iOfst + int64(n) - off)
+		if start > 0 || len(data)-n < cryptBlockSize {
+			switch rc := f.read(tls, off); rc {
+			case sqlite3.SQLITE_OK:
+				f.xts.Decrypt(f.block, f.block, uint64(off/cryptBlockSize))
+			case sqlite3.SQLITE_IOERR_SHORT_READ:
+				clear(f.block)
+			default:
+				return rc
+			}
+		}
+		n += copy(f.block[start:], data[n:])
+		f.xts.Encrypt(f.block, f.block, uint64(off/cryptBlockSize))
+		if rc := f.write(tls, off); rc != sqlite3.SQLITE_OK {
+			return rc
+		}
+	}
+	return sqlite3.SQLITE_OK
+}
+
+func cryptTruncate(tls *libc.TLS, pFile uintptr, size int64) int32 {
+	f := openFile(pFile)
+	size = (size + cryptBlockSize - 1) &^ (cryptBlockSize - 1)
+	return fn[func(*libc.TLS, uintptr, int64) int32](&f.methods().FxTruncate)(tls, f.base, size)
+}
+
+func cryptSectorSize(tls *libc.TLS, pFile uintptr) int32 {
+	f := openFile(pFile)
+	size := fn[func(*libc.TLS, uintptr) int32](&f.methods().FxSectorSize)(tls, f.base)
+	if size <= 0 || cryptBlockSize%size == 0 {
+		return cryptBlockSize
+	}
+	a, b := size, int32(cryptBlockSize)
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return size / a * cryptBlockSize
+}
+
+func cryptDeviceCharacteristics(tls *libc.TLS, pFile uintptr) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr) int32](&f.methods().FxDeviceCharacteristics)(tls, f.base) & cryptDevice
+}
+
+// The remaining methods pass through to the wrapped file. The shared
+// memory of a WAL database holds only its index, never page contents.
+
+func cryptSync(tls *libc.TLS, pFile uintptr, flags int32) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, int32) int32](&f.methods().FxSync)(tls, f.base, flags)
+}
+
+func cryptFileSize(tls *libc.TLS, pFile, pSize uintptr) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, uintptr) int32](&f.methods().FxFileSize)(tls, f.base, pSize)
+}
+
+func cryptLock(tls *libc.TLS, pFile uintptr, lock int32) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, int32) int32](&f.methods().FxLock)(tls, f.base, lock)
+}
+
+func cryptUnlock(tls *libc.TLS, pFile uintptr, lock int32) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, int32) int32](&f.methods().FxUnlock)(tls, f.base, lock)
+}
+
+func cryptCheckReservedLock(tls *libc.TLS, pFile, pResOut uintptr) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, uintptr) int32](&f.methods().FxCheckReservedLock)(tls, f.base, pResOut)
+}
+
+func cryptFileControl(tls *libc.TLS, pFile uintptr, op int32, pArg uintptr) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, int32, uintptr) int32](&f.methods().FxFileControl)(tls, f.base, op, pArg)
+}
+
+func cryptShmMap(tls *libc.TLS, pFile uintptr, region, size, extend int32, pp uintptr) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, int32, int32, int32, uintptr) int32](&f.methods().FxShmMap)(tls, f.base, region, size, extend, pp)
+}
+
+func cryptShmLock(tls *libc.TLS, pFile uintptr, offset, n, flags int32) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, int32, int32, int32) int32](&f.methods().FxShmLock)(tls, f.base, offset, n, flags)
+}
+
+func cryptShmBarrier(tls *libc.TLS, pFile uintptr) {
+	f := openFile(pFile)
+	fn[func(*libc.TLS, uintptr)](&f.methods().FxShmBarrier)(tls, f.base)
+}
+
+func cryptShmUnmap(tls *libc.TLS, pFile uintptr, deleteFlag int32) int32 {
+	f := openFile(pFile)
+	return fn[func(*libc.TLS, uintptr, int32) int32](&f.methods().FxShmUnmap)(tls, f.base, deleteFlag)
+}
@@ -28,6 +28,33 @@ type Event struct {
 	Raw       string                 `json:"raw"`
 	Fields    map[string]interface{} `json:"fields"`
 	Metadata  map[string]string      `json:"metadata"`
+	Tenant    string                 `json:"tenant,omitempty"` // assigned at ingest; "" is DefaultTenant
+}
+
+// DefaultTenant owns everything not assigned to another tenant, including
+// all data written before tenants existed.
+const DefaultTenant = "default"
+
+// TenantOrDefault returns tenant, or DefaultTenant when it is empty.
+func TenantOrDefault(tenant string) string {
+	if tenant == "" {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+// MetaRoute is the Metadata key naming the ingest route (the input that
+// received an event), e.g. "syslog" or "hec". Retention classes match on it.
+const MetaRoute = "route"
+
+// SetRoute records the ingest route of e unless one is already set.
+func (e *Event) SetRoute(route string) {
+	if e.Metadata == nil {
+		e.Metadata = make(map[string]string)
+	}
+	if e.Metadata[MetaRoute] == "" {
+		e.Metadata[MetaRoute] = route
+	}
 }
 
 // Alert represents a triggered detection rule
@@ -43,6 +70,10 @@ type Alert struct {
 	Assignee  string            `json:"assignee"`
 	Host      string            `json:"host"`
 	Metadata  map[string]string `json:"metadata"`
+	Tenant    string            `json:"tenant,omitempty"` // the triggering event's tenant
+	// EventIDs lists every event that contributed to a correlation alert,
+	// oldest first; EventID is the last of them.
+	EventIDs []string `json:"event_ids,omitempty"`
 }
 
 // SavedSearch represents a persisted hunting query.
@@ -52,4 +83,48 @@ type SavedSearch struct {
 	Query     string    `json:"query"`
 	CreatedBy string    `json:"created_by"`
 	CreatedAt time.Time `json:"created_at"`
+	Tenant    string    `json:"tenant,omitempty"`
+
+	Schedule *SearchSchedule `json:"schedule,omitempty"`
+}
+
+// SearchSchedule turns a saved search into a detection: it runs on a cron
+// expression or fixed interval over a trailing time window and raises an
+// alert when the trigger condition holds.
+type SearchSchedule struct {
+	Cron     string        `json:"cron,omitempty"`     // 5-field cron or @hourly/@daily/...; takes precedence over Interval
+	Interval int           `json:"interval,omitempty"` // seconds between runs
+	Window   int           `json:"window"`             // seconds of data searched, unless the query sets earliest
+	Trigger  SearchTrigger `json:"trigger"`
+	Throttle int           `json:"throttle"` // seconds after an alert during which further alerts are suppressed
+	Severity Severity      `json:"severity"`
+	Enabled  bool          `json:"enabled"`
+
+	UpdatedAt   time.Time `json:"updated_at"`
+	LastRun     time.Time `json:"last_run"`
+	LastAlertAt time.Time `json:"last_alert_at"`
+}
+
+// SearchTrigger decides whether a scheduled run raises an alert. "count"
+// compares the number of result rows; "field" fires when any row's numeric
+// Field compares true, e.g. stats count by host with field=count op=gt 10.
+type SearchTrigger struct {
+	Type      string  `json:"type"`            // count | field
+	Field     string  `json:"field,omitempty"` // for type field
+	Op        string  `json:"op"`              // gt, gte, lt, lte, eq, ne
+	Threshold float64 `json:"threshold"`
+}
+
+// SearchRun records one execution of a scheduled search.
+type SearchRun struct {
+	ID         string    `json:"id"`
+	SearchID   string    `json:"search_id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Hits       int       `json:"hits"`
+	Scanned    int       `json:"scanned"`
+	Triggered  bool      `json:"triggered"`
+	Suppressed bool      `json:"suppressed"` // triggered but throttled
+	AlertID    string    `json:"alert_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
 }
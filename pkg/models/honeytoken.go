@@ -20,4 +20,5 @@ type Honeytoken struct {
 	Value       string         `json:"value"`
 	Description string         `json:"description"`
 	CreatedAt   time.Time      `json:"created_at"`
+	Tenant      string         `json:"tenant,omitempty"` // "" watches the events of every tenant
 }